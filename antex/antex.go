@@ -0,0 +1,545 @@
+// Package antex reads antenna phase center calibrations in the ANTEX
+// 1.4 format, such as the IGS igs14.atx and igs20.atx files, and
+// computes phase center corrections from them.
+//
+// Receiver antennas are identified by the 20-character antenna and
+// radome string from a RINEX ANT # / TYPE header (and, for individual
+// calibrations, by serial number).  Satellite antennas are identified
+// by satellite ID and time, because a satellite ID is reused when a
+// satellite is replaced.
+package antex
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency holds the calibration of one antenna for one frequency.
+type Frequency struct {
+	// Offset is the phase center offset (m).  For receiver antennas,
+	// it is north, east and up from the antenna reference point; for
+	// satellite antennas, it is x, y and z in the satellite body
+	// frame, from the center of mass.
+	Offset [3]float64
+
+	// NoAzi holds the phase center variations (m) that do not depend
+	// on azimuth, for zenith (or nadir) angles from Antenna.Zen1 to
+	// Antenna.Zen2 in steps of Antenna.DZen.
+	NoAzi []float64
+
+	// Grid holds the azimuth-dependent phase center variations (m),
+	// indexed first by azimuth (from 0 to 360 degrees in steps of
+	// Antenna.DAzi) and then like NoAzi.  It is nil if the calibration
+	// does not depend on azimuth.
+	Grid [][]float64
+}
+
+// Antenna holds the calibration of one antenna, or of one type of
+// antenna.
+type Antenna struct {
+	// Type is the antenna type.  For receiver antennas, it is the
+	// antenna model in the first 16 characters and the radome in the
+	// last 4, as in RINEX.  For satellite antennas, it describes the
+	// satellite, such as "BLOCK IIF".
+	Type string
+
+	// Serial is the serial number of a receiver antenna, or blank for
+	// a type mean.  For satellite antennas, it is the satellite ID.
+	Serial string
+
+	// SVN is the space vehicle number of a satellite antenna, such as
+	// "G063", and COSPAR is its COSPAR ID.
+	SVN, COSPAR string
+
+	// DAzi is the azimuth step of the calibration (degrees), or 0 if
+	// it does not depend on azimuth.
+	DAzi float64
+
+	// Zen1, Zen2 and DZen give the range and step of zenith angles
+	// (nadir angles for satellites) of the calibration (degrees).
+	Zen1, Zen2, DZen float64
+
+	// ValidFrom and ValidUntil bound the time when the calibration is
+	// valid.  They are zero if the file did not give them.
+	ValidFrom, ValidUntil time.Time
+
+	// SINEXCode names the calibration model in SINEX files.
+	SINEXCode string
+
+	// Frequencies maps a frequency code, such as "G01" or "E05", to
+	// the calibration for that frequency.
+	Frequencies map[string]*Frequency
+}
+
+// Calibrations holds the contents of an ANTEX file.
+type Calibrations struct {
+	// System is the satellite system code from the ANTEX VERSION /
+	// SYST header.
+	System byte
+
+	// PCVType is 'A' for absolute or 'R' for relative calibrations.
+	PCVType byte
+
+	// RefAntenna names the reference antenna of relative calibrations.
+	RefAntenna string
+
+	// Antennas lists the antennas in the order they appear in the
+	// file.
+	Antennas []*Antenna
+}
+
+// ErrNoFrequency indicates that an antenna has no calibration for the
+// requested frequency.
+var ErrNoFrequency = errors.New("No calibration for frequency")
+
+/************************ TOP LEVEL FUNCTIONS ************************/
+
+// Read reads an ANTEX file from r.
+func Read(r io.Reader) (*Calibrations, error) {
+	p := &parser{cal: &Calibrations{}}
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		p.lineNum++
+		line := s.Text()
+		if len(line) < 80 {
+			line += strings.Repeat(" ", 80-len(line))
+		}
+		if err := p.parseLine(line); err != nil {
+			return nil, fmt.Errorf("line %d: %w", p.lineNum, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if p.ant != nil {
+		return nil, errors.New("ANTEX file ends inside an antenna")
+	}
+	if p.version == 0 {
+		return nil, errors.New("ANTEX file has no version")
+	}
+	return p.cal, nil
+}
+
+// normalizeType converts a receiver antenna type to the form used in
+// ANTEX files: 20 characters, with "NONE" for a blank radome.
+func normalizeType(antType string) string {
+	if len(antType) < 20 {
+		antType += strings.Repeat(" ", 20-len(antType))
+	}
+	model := strings.TrimSpace(antType[:16])
+	radome := strings.TrimSpace(antType[16:])
+	if radome == "" {
+		radome = "NONE"
+	}
+	return fmt.Sprintf("%-16s%4s", model, radome)
+}
+
+// Receiver returns the calibration of a receiver antenna with the given
+// type (as in ObsHeader.AntennaType) and serial number.  It prefers an
+// individual calibration for that serial number, and otherwise returns
+// the type mean.  It returns nil if there is neither.  A blank radome
+// matches "NONE".
+func (c *Calibrations) Receiver(antType, serial string) *Antenna {
+	antType = normalizeType(antType)
+	serial = strings.TrimSpace(serial)
+	var mean *Antenna
+	for _, ant := range c.Antennas {
+		if ant.Type != antType || ant.SVN != "" {
+			continue
+		}
+		if serial != "" && ant.Serial == serial {
+			return ant
+		}
+		if ant.Serial == "" && mean == nil {
+			mean = ant
+		}
+	}
+	return mean
+}
+
+// Satellite returns the calibration of the antenna of the satellite
+// with ID prn at time t, or nil if there is none.
+func (c *Calibrations) Satellite(prn [3]byte, t time.Time) *Antenna {
+	for _, ant := range c.Antennas {
+		if ant.Serial != string(prn[:]) || ant.SVN == "" {
+			continue
+		}
+		if !ant.ValidFrom.IsZero() && t.Before(ant.ValidFrom) {
+			continue
+		}
+		if !ant.ValidUntil.IsZero() && t.After(ant.ValidUntil) {
+			continue
+		}
+		return ant
+	}
+	return nil
+}
+
+/********************** PHASE CENTER CORRECTIONS **********************/
+
+// PCV returns the phase center variation (m) of ant for a frequency at
+// the given azimuth and zenith angle (nadir angle for satellites), in
+// degrees.  It interpolates linearly between calibration points, and
+// uses the nearest calibrated angle for angles outside the calibrated
+// range.
+func (ant *Antenna) PCV(freq string, azimuth, zenith float64) (float64, error) {
+	f := ant.Frequencies[freq]
+	if f == nil {
+		return 0, ErrNoFrequency
+	}
+	if f.Grid == nil || ant.DAzi <= 0 {
+		return interpolate(f.NoAzi, (zenith-ant.Zen1)/ant.DZen), nil
+	}
+
+	azimuth = math.Mod(azimuth, 360)
+	if azimuth < 0 {
+		azimuth += 360
+	}
+	x := azimuth / ant.DAzi
+	i := int(x)
+	if i >= len(f.Grid)-1 {
+		i = len(f.Grid) - 2
+	}
+	y := (zenith - ant.Zen1) / ant.DZen
+	a := interpolate(f.Grid[i], y)
+	b := interpolate(f.Grid[i+1], y)
+	return a + (x-float64(i))*(b-a), nil
+}
+
+// interpolate interpolates linearly in values at index x, clamping x to
+// the valid range.
+func interpolate(values []float64, x float64) float64 {
+	if x <= 0 {
+		return values[0]
+	}
+	i := int(x)
+	if i >= len(values)-1 {
+		return values[len(values)-1]
+	}
+	return values[i] + (x-float64(i))*(values[i+1]-values[i])
+}
+
+// RangeCorrection returns the phase center correction (m) of a receiver
+// antenna for a signal from a satellite at the given azimuth and
+// elevation (degrees).  It is the phase center variation less the
+// projection of the phase center offset onto the line of sight; add it
+// to a range computed from the antenna reference point, or subtract it
+// from the observed range.
+func (ant *Antenna) RangeCorrection(freq string, azimuth, elevation float64) (float64, error) {
+	f := ant.Frequencies[freq]
+	if f == nil {
+		return 0, ErrNoFrequency
+	}
+	pcv, err := ant.PCV(freq, azimuth, 90-elevation)
+	if err != nil {
+		return 0, err
+	}
+	sinAz, cosAz := math.Sincos(azimuth * math.Pi / 180)
+	sinEl, cosEl := math.Sincos(elevation * math.Pi / 180)
+	los := [3]float64{cosEl * cosAz, cosEl * sinAz, sinEl}
+	dot := f.Offset[0]*los[0] + f.Offset[1]*los[1] + f.Offset[2]*los[2]
+	return pcv - dot, nil
+}
+
+/**************************** FILE PARSING ****************************/
+
+// parser holds the state of an ANTEX file being read.
+type parser struct {
+	// cal holds the calibrations read so far.
+	cal *Calibrations
+
+	// version is the ANTEX version number.
+	version float64
+
+	// lineNum is the one-based number of the current line.
+	lineNum int
+
+	// ant is the antenna being read, or nil between antennas.
+	ant *Antenna
+
+	// freq is the frequency being read, or nil outside a frequency.
+	freq *Frequency
+
+	// inRMS is true inside a block of RMS values.
+	inRMS bool
+}
+
+// antexHeaders maps each label that the parser handles to its handler.
+var antexHeaders = map[string]func(*parser, string) error{
+	"ANTEX VERSION / SYST": (*parser).handleVersion,
+	"PCV TYPE / REFANT   ": (*parser).handlePCVType,
+	"START OF ANTENNA    ": (*parser).handleStartOfAntenna,
+	"END OF ANTENNA      ": (*parser).handleEndOfAntenna,
+	"TYPE / SERIAL NO    ": (*parser).handleTypeSerial,
+	"DAZI                ": (*parser).handleDAzi,
+	"ZEN1 / ZEN2 / DZEN  ": (*parser).handleZen,
+	"VALID FROM          ": (*parser).handleValidFrom,
+	"VALID UNTIL         ": (*parser).handleValidUntil,
+	"SINEX CODE          ": (*parser).handleSINEXCode,
+	"START OF FREQUENCY  ": (*parser).handleStartOfFrequency,
+	"END OF FREQUENCY    ": (*parser).handleEndOfFrequency,
+	"NORTH / EAST / UP   ": (*parser).handleNorthEastUp,
+	"START OF FREQ RMS   ": (*parser).handleStartOfRMS,
+	"END OF FREQ RMS     ": (*parser).handleEndOfRMS,
+}
+
+// parseLine parses one line of an ANTEX file.
+func (p *parser) parseLine(line string) error {
+	if handler := antexHeaders[line[60:80]]; handler != nil {
+		return handler(p, line[:60])
+	}
+	if p.freq != nil && !p.inRMS && strings.TrimSpace(line) != "" &&
+		line[60:80] != "COMMENT             " {
+		return p.parsePattern(line)
+	}
+	return nil
+}
+
+// parseFloat parses a possibly blank floating-point field.
+func parseFloat(text string) (float64, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(text, 64)
+}
+
+// parsePattern parses a NOAZI or azimuth-dependent line of phase
+// center variations.
+func (p *parser) parsePattern(line string) error {
+	count := int(math.Round((p.ant.Zen2-p.ant.Zen1)/p.ant.DZen)) + 1
+	line = strings.TrimRight(line, " ")
+	if len(line) < 8+8*count {
+		return errors.New("Short phase center variation line")
+	}
+	values := make([]float64, count)
+	for i := range values {
+		mm, err := parseFloat(line[8+8*i : 16+8*i])
+		if err != nil {
+			return err
+		}
+		values[i] = mm / 1e3
+	}
+
+	if strings.TrimSpace(line[:8]) == "NOAZI" {
+		p.freq.NoAzi = values
+		return nil
+	}
+	azimuth, err := parseFloat(line[:8])
+	if err != nil {
+		return err
+	}
+	if p.ant.DAzi <= 0 || azimuth != float64(len(p.freq.Grid))*p.ant.DAzi {
+		return errors.New("Unexpected azimuth " + strings.TrimSpace(line[:8]))
+	}
+	p.freq.Grid = append(p.freq.Grid, values)
+	return nil
+}
+
+// handleVersion handles an ANTEX VERSION / SYST header.
+func (p *parser) handleVersion(value string) error {
+	v, err := parseFloat(value[0:8])
+	if err != nil {
+		return err
+	}
+	if v < 1.4 || v >= 2 {
+		return errors.New("Unsupported ANTEX version " + strings.TrimSpace(value[0:8]))
+	}
+	p.version = v
+	p.cal.System = value[20]
+	return nil
+}
+
+// handlePCVType handles a PCV TYPE / REFANT header.
+func (p *parser) handlePCVType(value string) error {
+	p.cal.PCVType = value[0]
+	p.cal.RefAntenna = strings.TrimSpace(value[20:40])
+	return nil
+}
+
+// handleStartOfAntenna handles a START OF ANTENNA header.
+func (p *parser) handleStartOfAntenna(_ string) error {
+	if p.ant != nil {
+		return errors.New("START OF ANTENNA inside an antenna")
+	}
+	p.ant = &Antenna{Frequencies: make(map[string]*Frequency)}
+	return nil
+}
+
+// handleEndOfAntenna handles an END OF ANTENNA header.
+func (p *parser) handleEndOfAntenna(_ string) error {
+	if p.ant == nil || p.freq != nil {
+		return errors.New("Misplaced END OF ANTENNA")
+	}
+	p.cal.Antennas = append(p.cal.Antennas, p.ant)
+	p.ant = nil
+	return nil
+}
+
+// antenna returns the antenna being read, or an error if there is none.
+func (p *parser) antenna() (*Antenna, error) {
+	if p.ant == nil {
+		return nil, errors.New("Antenna header outside an antenna")
+	}
+	return p.ant, nil
+}
+
+// handleTypeSerial handles a TYPE / SERIAL NO header.
+func (p *parser) handleTypeSerial(value string) error {
+	ant, err := p.antenna()
+	if err != nil {
+		return err
+	}
+	ant.Type = value[0:20]
+	ant.Serial = strings.TrimSpace(value[20:40])
+	ant.SVN = strings.TrimSpace(value[40:50])
+	ant.COSPAR = strings.TrimSpace(value[50:60])
+	if ant.SVN != "" {
+		// Satellite antenna types are not padded.
+		ant.Type = strings.TrimSpace(ant.Type)
+	}
+	return nil
+}
+
+// handleDAzi handles a DAZI header.
+func (p *parser) handleDAzi(value string) error {
+	ant, err := p.antenna()
+	if err != nil {
+		return err
+	}
+	ant.DAzi, err = parseFloat(value[2:8])
+	return err
+}
+
+// handleZen handles a ZEN1 / ZEN2 / DZEN header.
+func (p *parser) handleZen(value string) error {
+	ant, err := p.antenna()
+	if err != nil {
+		return err
+	}
+	var zen [3]float64
+	for i := range zen {
+		if zen[i], err = parseFloat(value[2+6*i : 8+6*i]); err != nil {
+			return err
+		}
+	}
+	if zen[2] <= 0 || zen[1] < zen[0] {
+		return errors.New("Invalid zenith angle range")
+	}
+	ant.Zen1, ant.Zen2, ant.DZen = zen[0], zen[1], zen[2]
+	return nil
+}
+
+// parseTime parses a VALID FROM or VALID UNTIL time.
+func parseTime(value string) (time.Time, error) {
+	var date [5]int
+	for i := range date {
+		var err error
+		if date[i], err = strconv.Atoi(strings.TrimSpace(value[6*i : 6*i+6])); err != nil {
+			return time.Time{}, err
+		}
+	}
+	sec, err := parseFloat(value[30:43])
+	if err != nil {
+		return time.Time{}, err
+	}
+	t := time.Date(date[0], time.Month(date[1]), date[2], date[3], date[4],
+		0, 0, time.UTC)
+	return t.Add(time.Duration(math.Round(sec*1e9)) * time.Nanosecond), nil
+}
+
+// handleValidFrom handles a VALID FROM header.
+func (p *parser) handleValidFrom(value string) error {
+	ant, err := p.antenna()
+	if err != nil {
+		return err
+	}
+	ant.ValidFrom, err = parseTime(value)
+	return err
+}
+
+// handleValidUntil handles a VALID UNTIL header.
+func (p *parser) handleValidUntil(value string) error {
+	ant, err := p.antenna()
+	if err != nil {
+		return err
+	}
+	ant.ValidUntil, err = parseTime(value)
+	return err
+}
+
+// handleSINEXCode handles a SINEX CODE header.
+func (p *parser) handleSINEXCode(value string) error {
+	ant, err := p.antenna()
+	if err != nil {
+		return err
+	}
+	ant.SINEXCode = strings.TrimSpace(value[0:10])
+	return nil
+}
+
+// handleStartOfFrequency handles a START OF FREQUENCY header.
+func (p *parser) handleStartOfFrequency(value string) error {
+	ant, err := p.antenna()
+	if err != nil {
+		return err
+	}
+	if p.freq != nil || ant.DZen <= 0 {
+		return errors.New("Misplaced START OF FREQUENCY")
+	}
+	p.freq = &Frequency{}
+	ant.Frequencies[value[3:6]] = p.freq
+	return nil
+}
+
+// handleEndOfFrequency handles an END OF FREQUENCY header.
+func (p *parser) handleEndOfFrequency(_ string) error {
+	if p.freq == nil {
+		return errors.New("Misplaced END OF FREQUENCY")
+	}
+	if p.freq.NoAzi == nil {
+		return errors.New("Frequency has no NOAZI values")
+	}
+	if p.freq.Grid != nil && len(p.freq.Grid) != int(math.Round(360/p.ant.DAzi))+1 {
+		return errors.New("Incomplete azimuth-dependent values")
+	}
+	p.freq = nil
+	return nil
+}
+
+// handleNorthEastUp handles a NORTH / EAST / UP header.
+func (p *parser) handleNorthEastUp(value string) error {
+	if p.inRMS {
+		return nil
+	}
+	if p.freq == nil {
+		return errors.New("NORTH / EAST / UP outside a frequency")
+	}
+	for i := range p.freq.Offset {
+		mm, err := parseFloat(value[10*i : 10*i+10])
+		if err != nil {
+			return err
+		}
+		p.freq.Offset[i] = mm / 1e3
+	}
+	return nil
+}
+
+// handleStartOfRMS handles a START OF FREQ RMS header.
+func (p *parser) handleStartOfRMS(_ string) error {
+	p.inRMS = true
+	return nil
+}
+
+// handleEndOfRMS handles an END OF FREQ RMS header.
+func (p *parser) handleEndOfRMS(_ string) error {
+	p.inRMS = false
+	return nil
+}
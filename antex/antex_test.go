@@ -0,0 +1,237 @@
+package antex
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// antexExample has entries based on igs14.atx, with shorter patterns
+// for the receiver antennas.
+const antexExample = `     1.4            M                                       ANTEX VERSION / SYST
+A                                                           PCV TYPE / REFANT
+Example based on igs14.atx                                  COMMENT
+                                                            END OF HEADER
+                                                            START OF ANTENNA
+BLOCK IIA           G01                 G032      1992-079A TYPE / SERIAL NO
+                                             0    29-JAN-17 METH / BY / # / DATE
+     0.0                                                    DAZI
+     0.0  17.0   1.0                                        ZEN1 / ZEN2 / DZEN
+     2                                                      # OF FREQUENCIES
+  1992    11    22     0     0    0.0000000                 VALID FROM
+  2008    10    16    23    59   59.9999999                 VALID UNTIL
+IGS14_2062                                                  SINEX CODE
+   G01                                                      START OF FREQUENCY
+    279.00      0.00   2319.50                              NORTH / EAST / UP
+   NOAZI   -0.80    0.20    1.20    2.20    3.20    4.20    5.20    6.20    7.20    8.20    9.20   10.20   11.20   12.20   13.20   14.20   15.20   16.20
+   G01                                                      END OF FREQUENCY
+   G02                                                      START OF FREQUENCY
+    279.00      0.00   2319.50                              NORTH / EAST / UP
+   NOAZI   -0.80    0.20    1.20    2.20    3.20    4.20    5.20    6.20    7.20    8.20    9.20   10.20   11.20   12.20   13.20   14.20   15.20   16.20
+   G02                                                      END OF FREQUENCY
+                                                            END OF ANTENNA
+                                                            START OF ANTENNA
+BLOCK IIR-M         G01                 G049      2009-014A TYPE / SERIAL NO
+                                             0    29-JAN-17 METH / BY / # / DATE
+     0.0                                                    DAZI
+     0.0  17.0   1.0                                        ZEN1 / ZEN2 / DZEN
+     1                                                      # OF FREQUENCIES
+  2009     3    24     0     0    0.0000000                 VALID FROM
+IGS14_2062                                                  SINEX CODE
+   G01                                                      START OF FREQUENCY
+      0.00      0.00    990.00                              NORTH / EAST / UP
+   NOAZI    1.00    2.00    3.00    4.00    5.00    6.00    7.00    8.00    9.00   10.00   11.00   12.00   13.00   14.00   15.00   16.00   17.00   18.00
+   G01                                                      END OF FREQUENCY
+                                                            END OF ANTENNA
+                                                            START OF ANTENNA
+TRM59800.00     NONE                                        TYPE / SERIAL NO
+ROBOT               Geo++ GmbH               5    10-APR-06 METH / BY / # / DATE
+   120.0                                                    DAZI
+     0.0  90.0  30.0                                        ZEN1 / ZEN2 / DZEN
+     2                                                      # OF FREQUENCIES
+IGS14_2062                                                  SINEX CODE
+   G01                                                      START OF FREQUENCY
+      1.28     -0.54     66.93                              NORTH / EAST / UP
+   NOAZI    0.00   -2.00   -4.00   -6.00
+     0.0    0.00   -1.00   -2.00   -3.00
+   120.0    0.00   -2.00   -4.00   -6.00
+   240.0    0.00   -3.00   -6.00   -9.00
+   360.0    0.00   -1.00   -2.00   -3.00
+   G01                                                      END OF FREQUENCY
+   G01                                                      START OF FREQ RMS
+      0.10      0.10      0.20                              NORTH / EAST / UP
+   NOAZI    0.05    0.05    0.05    0.05
+   G01                                                      END OF FREQ RMS
+   G02                                                      START OF FREQUENCY
+     -0.37      0.28     57.52                              NORTH / EAST / UP
+   NOAZI    0.00    1.00    2.00    3.00
+     0.0    0.00   -1.00   -2.00   -3.00
+   120.0    0.00   -2.00   -4.00   -6.00
+   240.0    0.00   -3.00   -6.00   -9.00
+   360.0    0.00   -1.00   -2.00   -3.00
+   G02                                                      END OF FREQUENCY
+   G02                                                      START OF FREQ RMS
+      0.10      0.10      0.20                              NORTH / EAST / UP
+   NOAZI    0.05    0.05    0.05    0.05
+   G02                                                      END OF FREQ RMS
+                                                            END OF ANTENNA
+                                                            START OF ANTENNA
+TRM59800.00     NONE12345                                   TYPE / SERIAL NO
+ROBOT               Geo++ GmbH               5    10-APR-06 METH / BY / # / DATE
+   120.0                                                    DAZI
+     0.0  90.0  30.0                                        ZEN1 / ZEN2 / DZEN
+     1                                                      # OF FREQUENCIES
+IGS14_2062                                                  SINEX CODE
+   G01                                                      START OF FREQUENCY
+      1.00      0.00     66.00                              NORTH / EAST / UP
+   NOAZI    0.00   -1.00   -2.00   -3.00
+     0.0    0.00   -1.00   -2.00   -3.00
+   120.0    0.00   -2.00   -4.00   -6.00
+   240.0    0.00   -3.00   -6.00   -9.00
+   360.0    0.00   -1.00   -2.00   -3.00
+   G01                                                      END OF FREQUENCY
+                                                            END OF ANTENNA
+                                                            START OF ANTENNA
+TRM59800.00     SCIS                                        TYPE / SERIAL NO
+ROBOT               Geo++ GmbH               5    10-APR-06 METH / BY / # / DATE
+   120.0                                                    DAZI
+     0.0  90.0  30.0                                        ZEN1 / ZEN2 / DZEN
+     1                                                      # OF FREQUENCIES
+IGS14_2062                                                  SINEX CODE
+   G01                                                      START OF FREQUENCY
+      1.00      0.00     70.00                              NORTH / EAST / UP
+   NOAZI    0.00    0.00    0.00    0.00
+   G01                                                      END OF FREQUENCY
+                                                            END OF ANTENNA
+`
+
+func TestRead(t *testing.T) {
+	cal, err := Read(strings.NewReader(antexExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cal.System != 'M' || cal.PCVType != 'A' || len(cal.Antennas) != 5 {
+		t.Fatalf("Bad calibrations %+v", cal)
+	}
+
+	ant := cal.Antennas[0]
+	if ant.Type != "BLOCK IIA" || ant.Serial != "G01" || ant.SVN != "G032" ||
+		ant.COSPAR != "1992-079A" || ant.Zen2 != 17 || ant.SINEXCode != "IGS14_2062" ||
+		!ant.ValidFrom.Equal(time.Date(1992, 11, 22, 0, 0, 0, 0, time.UTC)) ||
+		!ant.ValidUntil.Equal(time.Date(2008, 10, 16, 23, 59, 59, 999999900, time.UTC)) {
+		t.Errorf("Bad satellite antenna %+v", ant)
+	}
+	f := ant.Frequencies["G02"]
+	if f == nil || f.Offset != [3]float64{0.279, 0, 2.3195} || len(f.NoAzi) != 18 ||
+		f.Grid != nil {
+		t.Errorf("Bad satellite frequency %+v", f)
+	}
+
+	ant = cal.Antennas[2]
+	if ant.Type != "TRM59800.00     NONE" || ant.Serial != "" || ant.DAzi != 120 ||
+		len(ant.Frequencies) != 2 {
+		t.Errorf("Bad receiver antenna %+v", ant)
+	}
+	f = ant.Frequencies["G01"]
+	if f == nil || f.Offset[2] != 0.06693 || len(f.Grid) != 4 || f.Grid[2][3] != -0.009 {
+		t.Errorf("Bad receiver frequency %+v", f)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	cal, err := Read(strings.NewReader(antexExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prn := [3]byte{'G', '0', '1'}
+	sats := []struct {
+		t   time.Time
+		svn string
+	}{
+		{time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC), ""},
+		{time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), "G032"},
+		{time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), "G049"},
+	}
+	for _, test := range sats {
+		ant := cal.Satellite(prn, test.t)
+		if (ant == nil && test.svn != "") || (ant != nil && ant.SVN != test.svn) {
+			t.Errorf("Wrong antenna for G01 at %v: %+v", test.t, ant)
+		}
+	}
+
+	recs := []struct {
+		antType, serial string
+		expected        *Antenna
+	}{
+		{"TRM59800.00", "", cal.Antennas[2]},
+		{"TRM59800.00     NONE", "12345", cal.Antennas[3]},
+		{"TRM59800.00     NONE", "999", cal.Antennas[2]},
+		{"TRM59800.00     SCIS", "12345", cal.Antennas[4]},
+		{"TRM59800.00     SCIT", "", nil},
+	}
+	for _, test := range recs {
+		if ant := cal.Receiver(test.antType, test.serial); ant != test.expected {
+			t.Errorf("Wrong antenna for %q %q: %+v", test.antType, test.serial, ant)
+		}
+	}
+}
+
+func TestPCV(t *testing.T) {
+	cal, err := Read(strings.NewReader(antexExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sat, rec := cal.Antennas[0], cal.Antennas[2]
+
+	tests := []struct {
+		ant             *Antenna
+		azimuth, zenith float64
+		expected        float64
+	}{
+		{sat, 0, 5.5, 4.7e-3},
+		{sat, 0, 20, 16.2e-3},
+		{rec, 60, 45, -2.25e-3},
+		{rec, 300, 45, -3e-3},
+		{rec, -60, 45, -3e-3},
+		{rec, 120, 100, -6e-3},
+		{cal.Antennas[4], 10, 45, 0},
+	}
+	for _, test := range tests {
+		pcv, err := test.ant.PCV("G01", test.azimuth, test.zenith)
+		if err != nil || math.Abs(pcv-test.expected) > 1e-12 {
+			t.Errorf("%s at %g, %g: expected %g, got %g (%v)", test.ant.Type,
+				test.azimuth, test.zenith, test.expected, pcv, err)
+		}
+	}
+
+	// At the zenith, only the up offset matters.
+	if corr, err := rec.RangeCorrection("G01", 0, 90); err != nil ||
+		math.Abs(corr+0.06693) > 1e-12 {
+		t.Errorf("Bad zenith range correction %g (%v)", corr, err)
+	}
+	// At the horizon to the north, the north offset and the PCV do.
+	if corr, err := rec.RangeCorrection("G01", 0, 0); err != nil ||
+		math.Abs(corr+0.003+0.00128) > 1e-12 {
+		t.Errorf("Bad horizon range correction %g (%v)", corr, err)
+	}
+	if _, err := rec.RangeCorrection("E01", 0, 90); !errors.Is(err, ErrNoFrequency) {
+		t.Errorf("Expected ErrNoFrequency, got %v", err)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for _, text := range []string{
+		"",
+		strings.Replace(antexExample, "     1.4 ", "     1.3 ", 1),
+		strings.Replace(antexExample, "   240.0    0.00   -3.00", "   250.0    0.00   -3.00", 1),
+		strings.Replace(antexExample, "   -4.00   -6.00\n   240.0", "   -4.00\n   240.0", 1),
+		antexExample[:strings.LastIndex(antexExample, "END OF ANTENNA")-60],
+	} {
+		if _, err := Read(strings.NewReader(text)); err == nil {
+			t.Errorf("Expected an error")
+		}
+	}
+}
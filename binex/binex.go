@@ -0,0 +1,212 @@
+// Package binex decodes BINEX (binary exchange) streams.
+//
+// It checks the framing and checksum of each big-endian, forward
+// readable record (sync byte 0xE2), and decodes 0x7f-05 observation
+// records, as produced by Trimble NetR8 and later receivers, into the
+// same observation records that rinex.ObsReader produces.  Other
+// records are skipped, as are records longer than 4095 bytes, which
+// use CRC-32 or MD5 checks.
+package binex
+
+import (
+	"bufio"
+	"io"
+	"time"
+
+	"github.com/entrope/gnss/gnsstime"
+	"github.com/entrope/gnss/rinex"
+)
+
+// syncForward is the sync byte for a big-endian, forward readable
+// record with a regular checksum.
+const syncForward = 0xE2
+
+// recordObservations is the record ID for prototype observation
+// records, whose subrecord 0x05 holds GNSS observables.
+const recordObservations = 0x7F
+
+// Decoder decodes BINEX streams.
+type Decoder struct {
+	// ObsFunc is a function that is called for each 0x7f-05 record.
+	// If it returns non-nil, decoding stops.
+	ObsFunc func(rec rinex.ObservationRecord) error
+
+	// Header holds the observation header values that the stream
+	// implies: the time system, signal strength unit, first and last
+	// observation times, and GLONASS frequency channels.
+	Header rinex.ObsHeader
+
+	// Observations lists the types of observations for each GNSS, as
+	// in rinex.ObsReader.  The decoder adds types as it sees new
+	// signals, so an observation record may have fewer observations
+	// for a satellite than its GNSS has types; the missing ones are
+	// not present.
+	Observations map[byte][][3]byte
+
+	// obsIndex maps from a GNSS and observation type to its index in
+	// Observations.
+	obsIndex map[byte]map[[3]byte]int
+
+	// record holds the current record's message.
+	record []byte
+}
+
+/************************ TOP LEVEL FUNCTIONS ************************/
+
+// Parse reads BINEX records from r and runs the callback functions in
+// d.  It skips bytes that are not part of a record with a valid
+// checksum.
+func (d *Decoder) Parse(r io.Reader) error {
+	d.Observations = make(map[byte][][3]byte)
+	d.obsIndex = make(map[byte]map[[3]byte]int)
+	d.Header.TimeSystem = "GPS"
+	d.Header.SignalStrengthUnit = "DBHZ"
+	if d.Header.GLONASSSlots == nil {
+		d.Header.GLONASSSlots = make(map[[3]byte]int)
+	}
+
+	br := bufio.NewReaderSize(r, 8192)
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if b != syncForward {
+			continue
+		}
+
+		// The record ID and length are each up to four bytes.
+		head, err := br.Peek(8)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		id, n1, ok := ubnxi(head)
+		if !ok {
+			continue
+		}
+		length, n2, ok := ubnxi(head[n1:])
+		if !ok || length > 4095 {
+			continue
+		}
+		size := n1 + n2 + int(length)
+		ckLen := 1
+		if length > 127 {
+			ckLen = 2
+		}
+		rec, err := br.Peek(size + ckLen)
+		if err == io.EOF {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !checksumOK(rec[:size], rec[size:]) {
+			continue
+		}
+
+		d.record = append(d.record[:0], rec[n1+n2:size]...)
+		br.Discard(size + ckLen)
+		if err := d.handleRecord(id, d.record); err != nil {
+			return err
+		}
+	}
+}
+
+// Detect returns true if head holds a complete 0x7f record with a valid
+// checksum.
+func Detect(head []byte) bool {
+	for i := 0; i < len(head); i++ {
+		if head[i] != syncForward {
+			continue
+		}
+		id, n1, ok := ubnxi(head[i+1:])
+		if !ok || id != recordObservations {
+			continue
+		}
+		length, n2, ok := ubnxi(head[i+1+n1:])
+		if !ok || length == 0 || length > 4095 {
+			continue
+		}
+		size := n1 + n2 + int(length)
+		ckLen := 1
+		if length > 127 {
+			ckLen = 2
+		}
+		if i+1+size+ckLen > len(head) {
+			continue
+		}
+		if checksumOK(head[i+1:i+1+size], head[i+1+size:i+1+size+ckLen]) {
+			return true
+		}
+	}
+	return false
+}
+
+// ubnxi decodes a big-endian BINEX unsigned integer from the start of
+// buf, returning the value and its length in bytes, or false if buf
+// is too short.
+func ubnxi(buf []byte) (uint32, int, bool) {
+	var v uint32
+	for i := 0; i < 4; i++ {
+		if i >= len(buf) {
+			return 0, 0, false
+		}
+		if i == 3 {
+			return v<<8 | uint32(buf[i]), 4, true
+		}
+		v = v<<7 | uint32(buf[i]&0x7F)
+		if buf[i]&0x80 == 0 {
+			return v, i + 1, true
+		}
+	}
+	return 0, 0, false
+}
+
+// checksumOK returns true if ck is the correct checksum for data, which
+// is the record ID, length and message.  Messages up to 127 bytes long
+// use an exclusive-or checksum; longer ones use CRC-16-CCITT.
+func checksumOK(data, ck []byte) bool {
+	if len(ck) == 1 {
+		var x byte
+		for _, b := range data {
+			x ^= b
+		}
+		return x == ck[0]
+	}
+	crc := crc16(data)
+	return byte(crc>>8) == ck[0] && byte(crc) == ck[1]
+}
+
+// crc16 calculates the CRC-16-CCITT of data, with an initial value of
+// zero.
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// handleRecord decodes one record's message.
+func (d *Decoder) handleRecord(id uint32, msg []byte) error {
+	if id != recordObservations || len(msg) < 7 {
+		return nil
+	}
+	// Subrecord ID, then GPS minutes and milliseconds.
+	if msg[0] != 0x05 {
+		return nil
+	}
+	minutes := uint32(msg[1])<<24 | uint32(msg[2])<<16 | uint32(msg[3])<<8 | uint32(msg[4])
+	ms := uint32(msg[5])<<8 | uint32(msg[6])
+	t := gnsstime.GPSEpoch.Add(time.Duration(minutes)*time.Minute +
+		time.Duration(ms)*time.Millisecond)
+	return d.decodeObservations(t, msg[7:])
+}
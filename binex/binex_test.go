@@ -0,0 +1,204 @@
+package binex
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/entrope/gnss/gnsstime"
+	"github.com/entrope/gnss/rinex"
+)
+
+// speedOfLight is the speed of light in vacuum (m/s).
+const speedOfLight = 299792458.0
+
+// bitWriter builds record bodies for tests.
+type bitWriter struct {
+	buf []byte
+	n   int
+}
+
+func (bw *bitWriter) put(bits int, v int64) {
+	for i := bits - 1; i >= 0; i-- {
+		if bw.n%8 == 0 {
+			bw.buf = append(bw.buf, 0)
+		}
+		if v>>i&1 == 1 {
+			bw.buf[bw.n/8] |= 0x80 >> (bw.n % 8)
+		}
+		bw.n++
+	}
+}
+
+// makeRecord wraps a 0x7f-05 body in a BINEX record.
+func makeRecord(t time.Time, body []byte) []byte {
+	d := t.Sub(gnsstime.GPSEpoch)
+	minutes := uint32(d / time.Minute)
+	ms := uint16((d % time.Minute) / time.Millisecond)
+	msg := []byte{0x05, byte(minutes >> 24), byte(minutes >> 16),
+		byte(minutes >> 8), byte(minutes), byte(ms >> 8), byte(ms)}
+	msg = append(msg, body...)
+
+	rec := []byte{recordObservations}
+	if n := len(msg); n < 128 {
+		rec = append(rec, byte(n))
+	} else {
+		rec = append(rec, byte(n>>7)|0x80, byte(n&0x7F))
+	}
+	rec = append(rec, msg...)
+	if len(msg) < 128 {
+		var x byte
+		for _, b := range rec {
+			x ^= b
+		}
+		rec = append(rec, x)
+	} else {
+		crc := crc16(rec)
+		rec = append(rec, byte(crc>>8), byte(crc))
+	}
+	return append([]byte{syncForward}, rec...)
+}
+
+func obsBody(slip bool) []byte {
+	bw := &bitWriter{}
+	bw.put(8, 1) // two satellites
+
+	// G05: L1C with Doppler, then L2L.
+	bw.put(8, 4)
+	bw.put(1, 0)
+	bw.put(3, 2)
+	bw.put(4, 0)
+	bw.put(8, 0x80|1)
+	bw.put(8, 0x04)
+	bw.put(8, 112)
+	bw.put(2, 0)
+	bw.put(32, 331790123)
+	bw.put(6, 19)
+	bw.put(2, 0)
+	bw.put(22, 1000)
+	bw.put(24, -316032)
+	if slip {
+		bw.put(8, 0x20|14)
+	} else {
+		bw.put(8, 14)
+	}
+	bw.put(8, 95)
+	bw.put(16, 3500)
+	bw.put(2, 0)
+	bw.put(22, -2000)
+
+	// R07: L1C, with frequency channel -2.
+	bw.put(8, 6)
+	bw.put(1, 0)
+	bw.put(3, 1)
+	bw.put(4, 1)
+	bw.put(8, 0x80|0)
+	bw.put(8, 0x02|0xE<<2)
+	bw.put(8, 100)
+	bw.put(2, 0)
+	bw.put(32, 312500000)
+	bw.put(6, 0)
+	bw.put(2, 0)
+	bw.put(22, 0)
+	return bw.buf
+}
+
+func TestObservations(t *testing.T) {
+	t0 := time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC)
+	input := []byte{0xE2, 0x00}
+	input = append(input, makeRecord(t0, obsBody(false))...)
+	bad := makeRecord(t0.Add(time.Second/2), obsBody(false))
+	bad[len(bad)-1] ^= 0x10
+	input = append(input, bad...)
+	input = append(input, makeRecord(t0.Add(time.Second), obsBody(true))...)
+
+	if !Detect(input) || Detect(bad) {
+		t.Errorf("Detect gave wrong results")
+	}
+
+	var d Decoder
+	var recs []rinex.ObservationRecord
+	d.ObsFunc = func(rec rinex.ObservationRecord) error {
+		recs = append(recs, rec)
+		return nil
+	}
+	if err := d.Parse(bytes.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("got %d records, want 2", len(recs))
+	}
+	if tm := recs[1].Time(); !tm.Equal(t0.Add(time.Second)) {
+		t.Errorf("second record at %v", tm)
+	}
+
+	wantTypes := map[byte]string{
+		'G': "C1C L1C D1C S1C C2L L2L S2L ",
+		'R': "C1C L1C S1C ",
+	}
+	for sys, want := range wantTypes {
+		got := ""
+		for _, obsType := range d.Observations[sys] {
+			got += string(obsType[:]) + " "
+		}
+		if got != want {
+			t.Errorf("Observations[%c] = %q, want %q", sys, got, want)
+		}
+	}
+
+	sv := recs[0].Sat
+	if len(sv) != 2 || string(sv[0].PRN[:]) != "G05" || string(sv[1].PRN[:]) != "R07" {
+		t.Fatalf("got satellites %v", sv)
+	}
+	round := func(v float64) float64 {
+		return math.Round(v*1000) / 1000
+	}
+	l1 := speedOfLight / 1575.42e6
+	l2 := speedOfLight / 1227.60e6
+	want := []rinex.Observation{
+		{Value: 21234567.891, SignalStrength: 7},
+		{Value: round((21234567.891 + 0.02) / l1), SignalStrength: 7},
+		{Value: -1234.5},
+		{Value: 44.8},
+		{Value: 21234571.391, SignalStrength: 6},
+		{Value: round((21234571.391 - 0.04) / l2), SignalStrength: 6},
+		{Value: 38},
+	}
+	for i := range want {
+		if got := sv[0].Obs[i]; math.Abs(got.Value-want[i].Value) > 0.0015 ||
+			got.LLI != want[i].LLI || got.SignalStrength != want[i].SignalStrength {
+			t.Errorf("G05 obs %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+	lr := speedOfLight / (1602e6 - 2*562.5e3)
+	if got := sv[1].Obs[1].Value; got != round(20000000/lr) {
+		t.Errorf("R07 L1C = %v, want %v", got, round(20000000/lr))
+	}
+
+	if lli := recs[1].Sat[0].Obs[5].LLI; lli != 1 {
+		t.Errorf("G05 L2L LLI after slip = %d, want 1", lli)
+	}
+}
+
+func TestUbnxi(t *testing.T) {
+	cases := []struct {
+		in   []byte
+		v    uint32
+		size int
+	}{
+		{[]byte{0x7F}, 0x7F, 1},
+		{[]byte{0x81, 0x00}, 0x80, 2},
+		{[]byte{0xFF, 0xFF, 0x7F}, 0x1FFFFF, 3},
+		{[]byte{0x80, 0x80, 0x81, 0x01}, 0x101, 4},
+	}
+	for _, c := range cases {
+		v, size, ok := ubnxi(c.in)
+		if !ok || v != c.v || size != c.size {
+			t.Errorf("ubnxi(%x) = %x, %d, %v", c.in, v, size, ok)
+		}
+	}
+	if _, _, ok := ubnxi([]byte{0x80}); ok {
+		t.Errorf("ubnxi accepted a truncated value")
+	}
+}
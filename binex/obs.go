@@ -0,0 +1,290 @@
+package binex
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/entrope/gnss/rinex"
+)
+
+// errTruncated indicates that a record is shorter than its contents
+// require.
+var errTruncated = errors.New("Truncated BINEX 0x7f-05 record")
+
+// systems maps from a 0x7f-05 GNSS number to the RINEX system
+// character.
+var systems = map[byte]byte{
+	0: 'G',
+	1: 'R',
+	2: 'S',
+	3: 'E',
+	4: 'C',
+	5: 'J',
+}
+
+// obsCodes maps from a GNSS and 0x7f-05 observable code to the RINEX
+// band and attribute.
+var obsCodes = map[byte]map[int]string{
+	'G': {
+		0: "1C", 1: "1C", 2: "1P", 3: "1W", 4: "1Y", 5: "1M", 6: "1X",
+		7: "1N", 10: "2W", 11: "2C", 12: "2D", 13: "2S", 14: "2L",
+		15: "2X", 16: "2P", 17: "2W", 18: "2Y", 19: "2M", 20: "2N",
+		23: "5X", 24: "5I", 25: "5Q", 26: "5X",
+	},
+	'R': {
+		0: "1C", 1: "1C", 2: "1P", 10: "2C", 11: "2C", 12: "2P",
+		13: "3X", 14: "3I", 15: "3Q", 16: "3X",
+	},
+	'S': {
+		0: "1C", 1: "1C", 6: "5X", 7: "5I", 8: "5Q", 9: "5X",
+	},
+	'E': {
+		0: "1C", 1: "1A", 2: "1B", 3: "1C", 4: "1X", 5: "1Z", 6: "5X",
+		7: "5I", 8: "5Q", 9: "5X", 10: "7X", 11: "7I", 12: "7Q",
+		13: "7X", 14: "8X", 15: "8I", 16: "8Q", 17: "8X", 18: "6X",
+		19: "6A", 20: "6B", 21: "6C", 22: "6X", 23: "6Z",
+	},
+	'C': {
+		0: "2X", 1: "2I", 2: "2Q", 3: "2X", 4: "7X", 5: "7I", 6: "7Q",
+		7: "7X", 8: "6X", 9: "6I", 10: "6Q", 11: "6X",
+	},
+	'J': {
+		0: "1C", 1: "1C", 2: "1S", 3: "1L", 4: "1X", 7: "2X", 8: "2S",
+		9: "2L", 10: "2X", 13: "5X", 14: "5I", 15: "5Q", 16: "5X",
+		19: "6X", 20: "6S", 21: "6L", 22: "6X", 30: "1Z",
+	},
+}
+
+// cellObs is one observation for a satellite in the current epoch.
+type cellObs struct {
+	obsType [3]byte
+	obs     rinex.Observation
+}
+
+// getBits returns the n-bit unsigned field starting at bit pos of buf.
+func getBits(buf []byte, pos, n int) uint64 {
+	var v uint64
+	for i := pos; i < pos+n; i++ {
+		v = v<<1 | uint64(buf[i/8]>>(7-i%8))&1
+	}
+	return v
+}
+
+// getSigned returns the n-bit two's complement field starting at bit
+// pos of buf.
+func getSigned(buf []byte, pos, n int) float64 {
+	return float64(int64(getBits(buf, pos, n)<<(64-n)) >> (64 - n))
+}
+
+// signalStrength maps a CNR (dB-Hz) to a RINEX signal strength
+// indicator.
+func signalStrength(cnr float64) byte {
+	ssi := int(cnr / 6)
+	if ssi < 1 {
+		ssi = 1
+	} else if ssi > 9 {
+		ssi = 9
+	}
+	return byte(ssi)
+}
+
+// decodeObservations decodes the body of a 0x7f-05 record for time t.
+func (d *Decoder) decodeObservations(t time.Time, p []byte) error {
+	pos := 0
+	need := func(n int) bool {
+		return pos+n <= len(p)
+	}
+	if !need(1) {
+		return errTruncated
+	}
+	flag := p[0]
+	pos++
+	nsat := int(flag&0x3F) + 1
+
+	// Skip the receiver clock offset and time system offsets.
+	if flag&0x80 != 0 {
+		pos += 3
+	}
+	if flag&0x40 != 0 {
+		if !need(1) {
+			return errTruncated
+		}
+		pos += 1 + 3*int(p[pos]&0x0F)
+	}
+
+	sats := make(map[[3]byte][]cellObs)
+	for i := 0; i < nsat; i++ {
+		if !need(2) {
+			return errTruncated
+		}
+		n := int(p[pos]) + 1
+		nobs := int(p[pos+1] >> 4 & 7)
+		sys, known := systems[p[pos+1]&0x0F]
+		pos += 2
+		if sys == 'S' && n >= 100 {
+			n -= 100
+		}
+		prn := [3]byte{sys, byte('0' + n/10%10), byte('0' + n%10)}
+
+		var rng0 float64
+		for j := 0; j < nobs; j++ {
+			if !need(1) {
+				return errTruncated
+			}
+			more := p[pos]&0x80 != 0
+			slip := p[pos]&0x20 != 0
+			code := int(p[pos] & 0x1F)
+			pos++
+			var flags [4]byte
+			for k := 0; more && k < 4; k++ {
+				if !need(1) {
+					return errTruncated
+				}
+				f := p[pos]
+				pos++
+				flags[f&3] = f & 0x7F
+				more = f&0x80 != 0
+			}
+			if flags[2] != 0 && sys == 'R' {
+				d.Header.GLONASSSlots[prn] = int(int8(flags[2]<<2) >> 4)
+			}
+			acc := 0.00002
+			if flags[0]&0x20 != 0 {
+				acc = 0.0001
+			}
+
+			if !need(1) {
+				return errTruncated
+			}
+			cnr := float64(p[pos]) * 0.4
+			pos++
+			var rng, phase, doppler float64
+			switch {
+			case j == 0:
+				if !need(5) {
+					return errTruncated
+				}
+				cnr += getSigned(p[pos:], 0, 2) * 0.1
+				rng = float64(getBits(p[pos:], 2, 32))*0.064 +
+					float64(getBits(p[pos:], 34, 6))*0.001
+				rng0 = rng
+				pos += 5
+			case flags[0]&0x40 != 0:
+				if !need(3) {
+					return errTruncated
+				}
+				cnr += getSigned(p[pos:], 0, 2) * 0.1
+				rng = rng0 + getSigned(p[pos:], 4, 20)*0.001
+				pos += 3
+			default:
+				if !need(2) {
+					return errTruncated
+				}
+				rng = rng0 + getSigned(p[pos:], 0, 16)*0.001
+				pos += 2
+			}
+			if !need(3) {
+				return errTruncated
+			}
+			if flags[0]&0x40 != 0 {
+				phase = rng + getSigned(p[pos:], 0, 24)*acc
+			} else {
+				cnr += getSigned(p[pos:], 0, 2) * 0.1
+				phase = rng + getSigned(p[pos:], 2, 22)*acc
+			}
+			pos += 3
+			hasDoppler := flags[0]&0x04 != 0
+			if hasDoppler {
+				if !need(3) {
+					return errTruncated
+				}
+				doppler = getSigned(p[pos:], 0, 24) / 256
+				pos += 3
+			}
+			if flags[0]&0x08 != 0 {
+				if flags[0]&0x10 != 0 {
+					pos += 2
+				} else {
+					pos++
+				}
+			}
+
+			rinexCode, ok := obsCodes[sys][code]
+			if !known || !ok {
+				continue
+			}
+			var lli byte
+			if slip {
+				lli = 1
+			}
+			ssi := signalStrength(cnr)
+			obs := sats[prn]
+			obs = d.addObs(obs, sys, 'C', rinexCode, rng, 0, ssi)
+			wl := d.Header.Wavelength(prn, rinex.ObsCode{'L', rinexCode[0], rinexCode[1]})
+			if wl > 0 {
+				obs = d.addObs(obs, sys, 'L', rinexCode, phase/wl, lli, ssi)
+			}
+			if hasDoppler {
+				obs = d.addObs(obs, sys, 'D', rinexCode, doppler, 0, 0)
+			}
+			obs = d.addObs(obs, sys, 'S', rinexCode, cnr, 0, 0)
+			sats[prn] = obs
+		}
+	}
+
+	var rec rinex.ObservationRecord
+	rec.SetTime(t)
+	for prn, cells := range sats {
+		sv := rinex.SVObservation{
+			PRN: prn,
+			Obs: make([]rinex.Observation, len(d.Observations[prn[0]])),
+		}
+		for _, c := range cells {
+			sv.Obs[d.obsIndex[prn[0]][c.obsType]] = c.obs
+		}
+		rec.Sat = append(rec.Sat, sv)
+	}
+	sort.Slice(rec.Sat, func(i, j int) bool {
+		return string(rec.Sat[i].PRN[:]) < string(rec.Sat[j].PRN[:])
+	})
+
+	if d.Header.FirstObs.IsZero() {
+		d.Header.FirstObs = t
+	}
+	d.Header.LastObs = t
+	if d.ObsFunc != nil {
+		return d.ObsFunc(rec)
+	}
+	return nil
+}
+
+// addObs appends an observation to obs, and registers its type for sys.
+// If obs already has an observation of the same type, it is kept.
+func (d *Decoder) addObs(obs []cellObs, sys, kind byte, code string, value float64, lli, ssi byte) []cellObs {
+	obsType := [3]byte{kind, code[0], code[1]}
+	for _, c := range obs {
+		if c.obsType == obsType {
+			return obs
+		}
+	}
+	index := d.obsIndex[sys]
+	if index == nil {
+		index = make(map[[3]byte]int)
+		d.obsIndex[sys] = index
+	}
+	if _, ok := index[obsType]; !ok {
+		index[obsType] = len(d.Observations[sys])
+		d.Observations[sys] = append(d.Observations[sys], obsType)
+	}
+	return append(obs, cellObs{
+		obsType: obsType,
+		obs: rinex.Observation{
+			Value:          math.Round(value*1000) / 1000,
+			Present:        true,
+			LLI:            lli,
+			SignalStrength: ssi,
+		},
+	})
+}
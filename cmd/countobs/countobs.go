@@ -1,15 +1,12 @@
 package main
 
 import (
-	"compress/gzip"
 	"flag"
 	"fmt"
-	"io"
-	"os"
 	"runtime"
-	"strings"
 	"sync"
 
+	"github.com/entrope/gnss/obsfile"
 	"github.com/entrope/gnss/rinex"
 )
 
@@ -45,20 +42,14 @@ func readFiles(wg *sync.WaitGroup, results chan<- *result, filenames <-chan stri
 		if !ok {
 			return
 		}
-		f, err := os.Open(filename)
+		r, _, err := rinex.Open(filename)
 		if err != nil {
 			fmt.Println(err)
 			continue
 		}
-		r := io.Reader(f)
-		if strings.HasSuffix(filename, ".gz") {
-			if r, err = gzip.NewReader(r); err != nil {
-				fmt.Println(filename, ":", err)
-			}
-		}
 
 		res := &result{filename: filename}
-		or := rinex.ObsReader{
+		or := obsfile.Reader{
 			ObsFunc: func(rec rinex.ObservationRecord) error {
 				if rec.Year != 0 && rec.Month != 0 && rec.Day != 0 {
 					res.nEpochs++
@@ -68,6 +59,7 @@ func readFiles(wg *sync.WaitGroup, results chan<- *result, filenames <-chan stri
 			},
 		}
 		res.err = or.Parse(r)
+		r.Close()
 		results <- res
 	}
 }
@@ -1,22 +1,20 @@
 package main
 
 import (
-	"compress/gzip"
 	"flag"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/png"
-	"io"
 	"math"
 	"os"
 	"regexp"
 	"runtime"
-	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/entrope/gnss/obsfile"
 	"github.com/entrope/gnss/rinex"
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/basicfont"
@@ -91,17 +89,12 @@ func makePalette() {
 }
 
 func loadDay(fname string) (*SiteDay, error) {
-	f, err := os.Open(fname)
+	r, _, err := rinex.Open(fname)
 	if err != nil {
 		return nil, err
 	}
-	var r io.Reader = f
+	defer r.Close()
 
-	if strings.HasSuffix(fname, ".gz") {
-		if r, err = gzip.NewReader(r); err != nil {
-			return nil, err
-		}
-	}
 	basename := suffix.ReplaceAllString(fname, "")
 	if idx := strings.LastIndexByte(basename, '/'); idx >= 0 {
 		basename = basename[idx+1:]
@@ -112,21 +105,14 @@ func loadDay(fname string) (*SiteDay, error) {
 		Sats:     make([]*SignalDay, 31),
 	}
 	last := -1
-	or := &rinex.ObsReader{}
-	or.HeaderFunc = func(label, value string) error {
-		if strings.TrimSpace(label) == "INTERVAL" {
-			flt, err := strconv.ParseFloat(strings.TrimSpace(value[:11]), 64)
-			if err != nil {
-				return err
-			}
-			res.Interval = int(math.Round(flt))
-		}
-		return nil
-	}
+	or := &obsfile.Reader{}
 	or.ObsFunc = func(rec rinex.ObservationRecord) error {
 		if rec.EpochFlag > 1 {
 			return nil
 		}
+		if res.Interval == 0 {
+			res.Interval = int(math.Round(or.Header.Interval))
+		}
 		if res.Day == 0 {
 			res.Year = int(rec.Year)
 			res.Month = int(rec.Month)
@@ -134,7 +120,8 @@ func loadDay(fname string) (*SiteDay, error) {
 		} else if res.Day != int(rec.Day) {
 			return nil
 		}
-		seconds := int(rec.Hour)*3600 + int(rec.Minute)*60 + int(rec.Second)
+		t := rec.Time()
+		seconds := t.Hour()*3600 + t.Minute()*60 + t.Second()
 		if last >= 0 {
 			interval := seconds - last
 			if res.Interval == 0 || interval < res.Interval {
@@ -144,21 +131,19 @@ func loadDay(fname string) (*SiteDay, error) {
 		last = seconds
 		horiz := seconds / 120
 		for _, sv := range rec.Sat {
-			if sv.PRN[0] != 'G' {
+			prn := sv.PRN.Number()
+			if sv.PRN.System() != rinex.GPS || prn < 0 || prn >= len(gpsIdx) {
 				continue
 			}
-			prn := (sv.PRN[1]-'0')*10 + sv.PRN[2] - '0'
 			idx := gpsIdx[prn]
 			if idx < 0 {
 				continue
 			}
-			obsCodes := or.Observations[sv.PRN[0]]
-			if obsCodes == nil {
-				obsCodes = or.Observations[' ']
-			}
+			obsCodes := rinex.ObsCodes(or.Observations, sv.PRN)
 			for j, o := range sv.Obs {
 				obsCode := obsCodes[j]
-				if obsCode[0] != 'S' || obsCode[1] != '1' {
+				if obsCode.Type() != 'S' || obsCode.Band() != '1' ||
+					(!o.Present && o.Value == 0) {
 					continue
 				}
 				s := res.Sats[idx]
@@ -305,7 +290,7 @@ func plotDays(wg *sync.WaitGroup, sitedays <-chan *SiteDay) {
 func main() {
 	flag.Parse()
 	makePalette()
-	suffix = regexp.MustCompile(`\.(rnx|\d\do)(\.gz)?$`)
+	suffix = regexp.MustCompile(`\.(rnx|crx|\d\d[od])(\.(gz|Z|bz2|zip))?$`)
 	link = '0' + byte(*linkFlag)
 
 	filenames := make(chan string, 8)
@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"compress/gzip"
 	"compress/zlib"
 	"encoding/binary"
 	"fmt"
@@ -14,6 +13,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/entrope/gnss/obsfile"
 	"github.com/entrope/gnss/rinex"
 )
 
@@ -223,47 +223,36 @@ func save(varname string, series map[[4]byte][]observation) error {
 func main() {
 	var series map[[4]byte][]observation
 
-	suffix := regexp.MustCompile(`\.(rnx|\d\do)(\.gz)?$`)
+	suffix := regexp.MustCompile(`\.(rnx|crx|\d\d[od])(\.(gz|Z|bz2|zip))?$`)
 
 	for _, fname := range os.Args[1:] {
-		f, err := os.Open(fname)
+		r, _, err := rinex.Open(fname)
 		if err != nil {
 			fmt.Println(err)
 			continue
 		}
-		var r io.Reader = f
-
-		if strings.HasSuffix(fname, ".gz") {
-			if r, err = gzip.NewReader(r); err != nil {
-				fmt.Println("Creating gzip reader: ", err)
-				continue
-			}
-		}
-		or := &rinex.ObsReader{}
+		or := &obsfile.Reader{}
 		or.ObsFunc = func(rec rinex.ObservationRecord) error {
 			if rec.EpochFlag > 1 {
 				return nil
 			}
-			time := float32(rec.Hour)*3600 + float32(rec.Minute)*60 + rec.Second
+			time := float32(rec.Hour)*3600 + float32(rec.Minute)*60 + rec.Second()
 			for _, sv := range rec.Sat {
 				var key [4]byte
 				copy(key[0:3], sv.PRN[:])
-				obsCodes := or.Observations[sv.PRN[0]]
-				if obsCodes == nil {
-					obsCodes = or.Observations[' ']
-				}
+				obsCodes := rinex.ObsCodes(or.Observations, sv.PRN)
 				for j, o := range sv.Obs {
-					if o.Value == 0 {
+					if !o.Present && o.Value == 0 {
 						continue
 					}
 					obsCode := obsCodes[j]
-					key[3] = obsCode[1]
+					key[3] = obsCode.Band()
 					s := series[key]
 					if len(s) == 0 || s[len(s)-1].time != time {
 						s = append(s, observation{time: time})
 					}
 					idx := len(s) - 1
-					switch obsCode[0] {
+					switch obsCode.Type() {
 					case 'L':
 						s[idx].carrier = o.Value
 					case 'C':
@@ -273,7 +262,7 @@ func main() {
 					case 'D', 'P':
 						continue
 					default:
-						panic("unexpected observation code " + string(obsCode[:]))
+						panic("unexpected observation code " + obsCode.String())
 					}
 					series[key] = s
 				}
@@ -282,7 +271,9 @@ func main() {
 		}
 
 		series = make(map[[4]byte][]observation, 256)
-		if err = or.Parse(r); err != nil {
+		err = or.Parse(r)
+		r.Close()
+		if err != nil {
 			fmt.Println(fname, ":", err)
 			continue
 		}
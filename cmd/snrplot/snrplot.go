@@ -2,23 +2,21 @@ package main
 
 import (
 	"bytes"
-	"compress/gzip"
 	"encoding/base64"
 	"flag"
 	"fmt"
 	"image"
 	"image/color"
 	"image/png"
-	"io"
 	"math"
 	"os"
 	"regexp"
 	"runtime"
-	"strconv"
 	"strings"
 	"sync"
 	"text/template"
 
+	"github.com/entrope/gnss/obsfile"
 	"github.com/entrope/gnss/rinex"
 )
 
@@ -100,17 +98,12 @@ func makePalettes() {
 }
 
 func loadDay(fname string) (*SiteDay, error) {
-	f, err := os.Open(fname)
+	r, _, err := rinex.Open(fname)
 	if err != nil {
 		return nil, err
 	}
-	var r io.Reader = f
+	defer r.Close()
 
-	if strings.HasSuffix(fname, ".gz") {
-		if r, err = gzip.NewReader(r); err != nil {
-			return nil, err
-		}
-	}
 	basename := suffix.ReplaceAllString(fname, "")
 	if idx := strings.LastIndexByte(basename, '/'); idx >= 0 {
 		basename = basename[idx+1:]
@@ -122,28 +115,22 @@ func loadDay(fname string) (*SiteDay, error) {
 	}
 	var day byte
 	first := 0
-	or := &rinex.ObsReader{}
-	or.HeaderFunc = func(label, value string) error {
-		if strings.TrimSpace(label) == "INTERVAL" {
-			flt, err := strconv.ParseFloat(strings.TrimSpace(value[:11]), 64)
-			if err != nil {
-				return err
-			}
-			res.Interval = int(math.Round(flt))
-		}
-		return nil
-	}
+	or := &obsfile.Reader{}
 	or.ObsFunc = func(rec rinex.ObservationRecord) error {
 		if rec.EpochFlag > 1 {
 			return nil
 		}
+		if res.Interval == 0 {
+			res.Interval = int(math.Round(or.Header.Interval))
+		}
 		if day == 0 {
 			day = rec.Day
 		} else if day != rec.Day {
 			return nil
 		}
 		if res.Interval == 0 {
-			seconds := int(rec.Hour)*3600 + int(rec.Minute)*60 + int(rec.Second)
+			t := rec.Time()
+			seconds := t.Hour()*3600 + t.Minute()*60 + t.Second()
 			if first == 0 {
 				first = seconds
 			} else {
@@ -152,21 +139,18 @@ func loadDay(fname string) (*SiteDay, error) {
 		}
 		horiz := (int(rec.Hour)*60 + int(rec.Minute)) / 2
 		for _, sv := range rec.Sat {
-			if sv.PRN[0] != 'G' && sv.PRN[0] != 'E' {
+			if sys := sv.PRN.System(); sys != rinex.GPS && sys != rinex.Galileo {
 				continue
 			}
 			var key [4]byte
 			copy(key[1:4], sv.PRN[:])
-			obsCodes := or.Observations[sv.PRN[0]]
-			if obsCodes == nil {
-				obsCodes = or.Observations[' ']
-			}
+			obsCodes := rinex.ObsCodes(or.Observations, sv.PRN)
 			for j, o := range sv.Obs {
 				obsCode := obsCodes[j]
-				if obsCode[0] != 'S' || o.Value == 0 {
+				if obsCode.Type() != 'S' || o.Value == 0 {
 					continue
 				}
-				key[0] = obsCode[1]
+				key[0] = obsCode.Band()
 				s := res.Sats[key]
 				if s == nil {
 					s = new(SignalDay)
@@ -307,7 +291,7 @@ func plotDays(wg *sync.WaitGroup, sitedays <-chan *SiteDay) {
 func main() {
 	flag.Parse()
 	makePalettes()
-	suffix = regexp.MustCompile(`\.(rnx|\d\do)(\.gz)?$`)
+	suffix = regexp.MustCompile(`\.(rnx|crx|\d\d[od])(\.(gz|Z|bz2|zip))?$`)
 
 	filenames := make(chan string, 8)
 	sitedays := make(chan *SiteDay, 8)
@@ -0,0 +1,123 @@
+// Package ephemeris computes satellite positions, velocities and clock
+// corrections from the broadcast ephemerides that rinex.NavReader
+// produces.  GPS, QZSS, Galileo and BeiDou use the Keplerian orbit
+// model from their interface specifications; GLONASS and SBAS state
+// vectors are propagated to the requested time.
+//
+// Positions and velocities are Earth-centered, Earth-fixed, in meters
+// and meters per second, in the reference frame of the broadcast
+// message (WGS 84, GTRF, CGCS2000 or PZ-90).  This package does not
+// correct for signal travel time or for Earth rotation during signal
+// travel; callers that want the satellite position at transmission
+// time should pass that time.
+package ephemeris
+
+import (
+	"errors"
+	"time"
+
+	"github.com/entrope/gnss/gnsstime"
+	"github.com/entrope/gnss/rinex"
+)
+
+// State holds a satellite's computed position, velocity and clock
+// correction at one instant.
+type State struct {
+	// Position is the ECEF satellite position (m).
+	Position [3]float64
+
+	// Velocity is the ECEF satellite velocity (m/s).
+	Velocity [3]float64
+
+	// ClockBias is the satellite clock offset from the GNSS time scale
+	// (s), including the relativistic correction but not the group
+	// delay.
+	ClockBias float64
+
+	// ClockDrift is the rate of change of ClockBias (s/s).
+	ClockDrift float64
+
+	// Relativistic is the relativistic clock correction (s) that is
+	// included in ClockBias.  It is zero for GLONASS and SBAS, whose
+	// broadcast clock parameters already include it.
+	Relativistic float64
+
+	// GroupDelay is the broadcast group delay (s) for the reference
+	// single-frequency signal: TGD for GPS and QZSS L1 C/A (from LNAV
+	// or CNAV), BGD E5b/E1 or E5a/E1 (depending on the message type)
+	// for Galileo E1, and TGD1 for BeiDou B1I.  Single-frequency users
+	// of that signal should subtract it from ClockBias.  It is zero for
+	// GLONASS and SBAS.
+	GroupDelay float64
+}
+
+// errUnsupported is returned for unknown Ephemeris implementations.
+var errUnsupported = errors.New("Unsupported ephemeris type")
+
+// Compute evaluates eph at time t.  The date and time in t are in the
+// time scale of eph's GNSS (GPS time for GPS and SBAS, GST for Galileo,
+// BDT for BeiDou, QZSST for QZSS and UTC for GLONASS), labeled as UTC
+// in the same way as rinex.ObservationRecord.Time.
+func Compute(eph rinex.Ephemeris, t time.Time) (State, error) {
+	switch e := eph.(type) {
+	case *rinex.GPSEphemeris:
+		st := computeKepler(&e.KeplerEphemeris, t)
+		st.GroupDelay = e.TGD
+		return st, nil
+	case *rinex.CNAVEphemeris:
+		st := computeKepler(&e.KeplerEphemeris, t)
+		st.GroupDelay = e.TGD
+		return st, nil
+	case *rinex.GalileoEphemeris:
+		st := computeKepler(&e.KeplerEphemeris, t)
+		if e.DataSources&2 != 0 {
+			st.GroupDelay = e.BGDE5aE1
+		} else {
+			st.GroupDelay = e.BGDE5bE1
+		}
+		return st, nil
+	case *rinex.BeiDouEphemeris:
+		st := computeKepler(&e.KeplerEphemeris, t)
+		st.GroupDelay = e.TGD1
+		return st, nil
+	case *rinex.GLONASSEphemeris:
+		return computeGLONASS(e, t), nil
+	case *rinex.SBASEphemeris:
+		return computeSBAS(e, t), nil
+	}
+	return State{}, errUnsupported
+}
+
+// seconds returns the difference t - ref in seconds.
+func seconds(t, ref time.Time) float64 {
+	return t.Sub(ref).Seconds()
+}
+
+// timeOfEphemeris returns the reference time of eph's orbit in the
+// GNSS's own time scale.
+func timeOfEphemeris(eph rinex.Ephemeris) time.Time {
+	var k *rinex.KeplerEphemeris
+	switch e := eph.(type) {
+	case *rinex.GPSEphemeris:
+		k = &e.KeplerEphemeris
+	case *rinex.CNAVEphemeris:
+		k = &e.KeplerEphemeris
+	case *rinex.GalileoEphemeris:
+		k = &e.KeplerEphemeris
+	case *rinex.BeiDouEphemeris:
+		k = &e.KeplerEphemeris
+	default:
+		return eph.ClockEpoch()
+	}
+	return keplerToe(k)
+}
+
+// keplerToe converts the week number and Toe of k to a time.  RINEX
+// counts Galileo weeks from the GPS epoch, so only BeiDou differs.
+func keplerToe(k *rinex.KeplerEphemeris) time.Time {
+	scale := gnsstime.GPS
+	if k.PRN[0] == 'C' {
+		scale = gnsstime.BDT
+	}
+	return scale.FromWeek(k.Week, time.Duration(k.Toe*float64(time.Second)))
+}
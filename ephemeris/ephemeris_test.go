@@ -0,0 +1,376 @@
+package ephemeris
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/entrope/gnss/gnsstime"
+	"github.com/entrope/gnss/rinex"
+)
+
+// navExample holds a GPS and a GLONASS record from the RINEX 2.11
+// specification examples, reformatted as a RINEX 3 file.
+const navExample = `     3.04           N: GNSS NAV DATA    M: MIXED            RINEX VERSION / TYPE
+    13                                                      LEAP SECONDS
+                                                            END OF HEADER
+G06 1999 09 02 17 51 44-0.839701388031D-03-0.165982783074D-10 0.000000000000D+00
+     0.910000000000D+02 0.934062500000D+02 0.116040547840D-08 0.162092304801D+00
+     0.484101474285D-05 0.626740418375D-02 0.652112066746D-05 0.515365489006D+04
+     0.409904000000D+06-0.242143869400D-07 0.329237003460D+00-0.596046447754D-07
+     0.111541663136D+01 0.326593750000D+03 0.206958726335D+01-0.638312302555D-08
+     0.307155651409D-09 0.000000000000D+00 0.102500000000D+04 0.000000000000D+00
+     0.000000000000D+00 0.000000000000D+00 0.000000000000D+00 0.910000000000D+02
+     0.406800000000D+06 0.000000000000D+00
+R03 1998 02 15 00 15 00 0.163525342941D-03 0.363797880709D-11 0.108000000000D+05
+     0.106275903320D+05-0.348924636841D+00 0.931322574615D-09 0.000000000000D+00
+    -0.944422070313D+04 0.288163375854D+01 0.931322574615D-09 0.210000000000D+02
+     0.212257280273D+05 0.144599342346D+01-0.186264514923D-08 0.300000000000D+01
+`
+
+func loadExample(t *testing.T) *Store {
+	s := NewStore()
+	nr := rinex.NavReader{EphemerisFunc: s.Add}
+	if err := nr.Parse(strings.NewReader(navExample)); err != nil {
+		t.Fatal(err)
+	}
+	s.LeapSeconds = nr.LeapSeconds
+	return s
+}
+
+func norm(v [3]float64) float64 {
+	return math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+}
+
+// checkVelocity compares the velocity that Compute reports against a
+// numerical derivative of the position.
+func checkVelocity(t *testing.T, eph rinex.Ephemeris, at time.Time, tol float64) State {
+	st, err := Compute(eph, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := Compute(eph, at.Add(-time.Second))
+	after, _ := Compute(eph, at.Add(time.Second))
+	for i := range st.Velocity {
+		numeric := (after.Position[i] - before.Position[i]) / 2
+		if math.Abs(numeric-st.Velocity[i]) > tol {
+			t.Errorf("%s velocity[%d]: computed %f, numeric %f",
+				eph.SatelliteID(), i, st.Velocity[i], numeric)
+		}
+	}
+	return st
+}
+
+func TestKeplerCircular(t *testing.T) {
+	// An equatorial circular orbit at the time of ephemeris.
+	eph := &rinex.GPSEphemeris{
+		KeplerEphemeris: rinex.KeplerEphemeris{
+			PRN:   [3]byte{'G', '0', '1'},
+			TOC:   gnsstime.GPS.FromWeek(1000, 0),
+			SqrtA: 5153.6,
+			Week:  1000,
+		},
+	}
+
+	st, err := Compute(eph, eph.TOC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	A := eph.SqrtA * eph.SqrtA
+	n := math.Sqrt(gpsConstants.mu / (A * A * A))
+	if math.Abs(st.Position[0]-A) > 1e-6 || st.Position[1] != 0 || st.Position[2] != 0 {
+		t.Errorf("Bad position %v", st.Position)
+	}
+	if math.Abs(st.Velocity[1]-A*(n-gpsConstants.omegaE)) > 1e-6 ||
+		math.Abs(st.Velocity[0]) > 1e-6 || st.Velocity[2] != 0 {
+		t.Errorf("Bad velocity %v", st.Velocity)
+	}
+	if st.ClockBias != 0 || st.Relativistic != 0 {
+		t.Errorf("Bad clock %g, %g", st.ClockBias, st.Relativistic)
+	}
+}
+
+func TestGPS(t *testing.T) {
+	s := loadExample(t)
+	prn := [3]byte{'G', '0', '6'}
+	eph := s.Select(prn, time.Date(1999, 9, 2, 19, 0, 0, 0, time.UTC))
+	if eph == nil {
+		t.Fatal("No ephemeris for G06")
+	}
+
+	at := time.Date(1999, 9, 2, 18, 0, 0, 0, time.UTC)
+	st := checkVelocity(t, eph, at, 1e-3)
+	if r := norm(st.Position); math.Abs(r-26.56e6) > 5e5 {
+		t.Errorf("Implausible orbit radius %f", r)
+	}
+
+	// Check the clock correction against its definition.
+	gps := eph.(*rinex.GPSEphemeris)
+	dt := at.Sub(gps.TOC).Seconds()
+	clock := gps.ClockBias + gps.ClockDrift*dt + st.Relativistic
+	if math.Abs(st.ClockBias-clock) > 1e-15 {
+		t.Errorf("Bad clock bias %g, expected %g", st.ClockBias, clock)
+	}
+	if st.Relativistic == 0 || math.Abs(st.Relativistic) > 5e-8 {
+		t.Errorf("Implausible relativistic correction %g", st.Relativistic)
+	}
+
+	// Store.Compute should agree with Compute for GPS.
+	st2, err := s.Compute(prn, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st2 != st {
+		t.Errorf("Store.Compute disagrees: %v vs %v", st2, st)
+	}
+
+	// The ephemeris is not valid long after its time of ephemeris.
+	if _, err := s.Compute(prn, at.Add(6*time.Hour)); err != ErrNoEphemeris {
+		t.Errorf("Expected ErrNoEphemeris, got %v", err)
+	}
+}
+
+func TestBeiDouGEO(t *testing.T) {
+	eph := &rinex.BeiDouEphemeris{
+		KeplerEphemeris: rinex.KeplerEphemeris{
+			PRN:      [3]byte{'C', '0', '1'},
+			TOC:      gnsstime.BDT.FromWeek(679, 345600*time.Second),
+			SqrtA:    6493.4,
+			Ecc:      4.5e-4,
+			I0:       0.08,
+			Omega0:   -2.9,
+			Omega:    1.3,
+			M0:       0.4,
+			OmegaDot: -5e-10,
+			Toe:      345600,
+			Week:     679,
+		},
+	}
+
+	// A geostationary satellite barely moves in the ECEF frame.
+	at := eph.TOC.Add(20 * time.Minute)
+	st := checkVelocity(t, eph, at, 1e-3)
+	if r := norm(st.Position); math.Abs(r-42.164e6) > 1e5 {
+		t.Errorf("Implausible orbit radius %f", r)
+	}
+	if v := norm(st.Velocity); v > 500 {
+		t.Errorf("GEO velocity %f m/s is too large", v)
+	}
+}
+
+func TestGLONASS(t *testing.T) {
+	s := loadExample(t)
+	prn := [3]byte{'R', '0', '3'}
+
+	// R03's TOC is in UTC, and Store takes GPS time.
+	toc := time.Date(1998, 2, 15, 0, 15, 0, 0, time.UTC)
+	gpsTime := toc.Add(13 * time.Second)
+	eph := s.Select(prn, gpsTime.Add(10*time.Minute))
+	if eph == nil {
+		t.Fatal("No ephemeris for R03")
+	}
+	st, err := s.Compute(prn, gpsTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	glo := eph.(*rinex.GLONASSEphemeris)
+	for i := range st.Position {
+		if math.Abs(st.Position[i]-glo.Position[i]*1000) > 1e-6 {
+			t.Errorf("Position[%d] = %f at TOC", i, st.Position[i])
+		}
+	}
+
+	// Propagating forward and then back should return to the start.
+	later := checkVelocity(t, eph, toc.Add(15*time.Minute), 1e-2)
+	if r := norm(later.Position); math.Abs(r-25.5e6) > 1e5 {
+		t.Errorf("Implausible orbit radius %f", r)
+	}
+	back := computeGLONASS(&rinex.GLONASSEphemeris{
+		PRN:          glo.PRN,
+		TOC:          toc.Add(15 * time.Minute),
+		Position:     [3]float64{later.Position[0] / 1000, later.Position[1] / 1000, later.Position[2] / 1000},
+		Velocity:     [3]float64{later.Velocity[0] / 1000, later.Velocity[1] / 1000, later.Velocity[2] / 1000},
+		Acceleration: glo.Acceleration,
+	}, toc)
+	for i := range back.Position {
+		if math.Abs(back.Position[i]-glo.Position[i]*1000) > 0.01 {
+			t.Errorf("Round trip position[%d] = %f, expected %f", i,
+				back.Position[i], glo.Position[i]*1000)
+		}
+	}
+
+	if st.ClockBias != glo.ClockBias {
+		t.Errorf("Bad clock bias %g", st.ClockBias)
+	}
+
+	// Without LeapSeconds, Store uses the 12 leap seconds of 1998.
+	s.LeapSeconds = 0
+	if st2, err := s.Compute(prn, toc.Add(12*time.Second)); err != nil || st2 != st {
+		t.Errorf("Store.Compute without LeapSeconds gave %v, %v", st2, err)
+	}
+}
+
+func TestGLONASSReference(t *testing.T) {
+	// This is the worked example in Appendix A.3.1.2 of the GLONASS
+	// ICD (edition 5.1): the state at 11700 s on 7 September 2012,
+	// propagated to 12300 s.
+	toc := time.Date(2012, 9, 7, 0, 0, 0, 0, time.UTC).Add(11700 * time.Second)
+	eph := &rinex.GLONASSEphemeris{
+		PRN:          [3]byte{'R', '0', '1'},
+		TOC:          toc,
+		Position:     [3]float64{7003.008789, -12206.626953, 21280.765625},
+		Velocity:     [3]float64{0.7835417, 2.8042530, 1.3525150},
+		Acceleration: [3]float64{0, 1.7e-9, -5.41e-9},
+	}
+	st := computeGLONASS(eph, toc.Add(600*time.Second))
+
+	position := [3]float64{7523.174819, -10506.961965, 21999.239413}
+	velocity := [3]float64{0.950126007, 2.855687825, 1.040679862}
+	for i := range position {
+		if d := st.Position[i] - position[i]*kilometer; math.Abs(d) > 1 {
+			t.Errorf("Position[%d] = %f, %f m from the ICD", i, st.Position[i], d)
+		}
+		if d := st.Velocity[i] - velocity[i]*kilometer; math.Abs(d) > 5e-3 {
+			t.Errorf("Velocity[%d] = %f, %f m/s from the ICD", i, st.Velocity[i], d)
+		}
+	}
+}
+
+func TestGPSReference(t *testing.T) {
+	// At its time of ephemeris, with zero mean anomaly and argument of
+	// perigee, a satellite is at perigee on the ascending node.  With
+	// Omega0 = pi/2 and Toe = 0, IS-GPS-200 Table 20-IV puts the node
+	// on the ECEF Y axis and reduces the harmonic corrections to Crc.
+	eph := &rinex.GPSEphemeris{
+		KeplerEphemeris: rinex.KeplerEphemeris{
+			PRN:    [3]byte{'G', '0', '2'},
+			TOC:    gnsstime.GPS.FromWeek(1000, 0),
+			SqrtA:  5153.7,
+			Ecc:    0.01,
+			I0:     0.3,
+			Omega0: math.Pi / 2,
+			Crc:    100,
+			Week:   1000,
+		},
+	}
+	st, err := Compute(eph, eph.TOC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	A := eph.SqrtA * eph.SqrtA
+	r := A*(1-eph.Ecc) + eph.Crc
+	n := math.Sqrt(gpsConstants.mu / (A * A * A))
+	dudt := n * math.Sqrt(1-eph.Ecc*eph.Ecc) / ((1 - eph.Ecc) * (1 - eph.Ecc))
+	position := [3]float64{0, r, 0}
+	velocity := [3]float64{
+		r * (gpsConstants.omegaE - dudt*math.Cos(eph.I0)),
+		0,
+		r * dudt * math.Sin(eph.I0),
+	}
+	for i := range position {
+		if math.Abs(st.Position[i]-position[i]) > 1e-6 {
+			t.Errorf("Position[%d] = %f, expected %f", i, st.Position[i], position[i])
+		}
+		if math.Abs(st.Velocity[i]-velocity[i]) > 1e-6 {
+			t.Errorf("Velocity[%d] = %f, expected %f", i, st.Velocity[i], velocity[i])
+		}
+	}
+}
+
+func TestBeiDouGEOReference(t *testing.T) {
+	// A circular equatorial orbit with Omega0 = pi/2, at its time of
+	// ephemeris at the start of a BDT week, is on the Y axis of the
+	// BeiDou ICD's intermediate frame.  Its -5 degree rotation about
+	// the X axis then puts the satellite at 5 degrees north.
+	eph := &rinex.BeiDouEphemeris{
+		KeplerEphemeris: rinex.KeplerEphemeris{
+			PRN:    [3]byte{'C', '0', '3'},
+			TOC:    gnsstime.BDT.FromWeek(679, 0),
+			SqrtA:  6493.4,
+			Omega0: math.Pi / 2,
+			Week:   679,
+		},
+	}
+	st, err := Compute(eph, eph.TOC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	A := eph.SqrtA * eph.SqrtA
+	sin5, cos5 := math.Sincos(5 * math.Pi / 180)
+	position := [3]float64{0, A * cos5, A * sin5}
+	for i := range position {
+		if math.Abs(st.Position[i]-position[i]) > 1e-6 {
+			t.Errorf("Position[%d] = %f, expected %f", i, st.Position[i], position[i])
+		}
+	}
+}
+
+func TestSelectHealth(t *testing.T) {
+	week := 2035
+	mk := func(toe float64, health int) *rinex.GPSEphemeris {
+		start := gnsstime.GPS.FromWeek(week, 0)
+		return &rinex.GPSEphemeris{KeplerEphemeris: rinex.KeplerEphemeris{
+			PRN:    [3]byte{'G', '1', '0'},
+			TOC:    start.Add(time.Duration(toe) * time.Second),
+			SqrtA:  5153.6,
+			Toe:    toe,
+			Week:   week,
+			Health: health,
+		}}
+	}
+	s := NewStore()
+	e1 := mk(7200, 0)
+	e2 := mk(14400, 1)
+	e3 := mk(21600, 0)
+	s.Add(e1)
+	s.Add(e2)
+	s.Add(e3)
+
+	at := e2.TOC
+	if eph := s.Select(e2.PRN, at); eph != e1 {
+		t.Errorf("Expected first ephemeris, got %v", eph)
+	}
+	if eph := s.Select(e2.PRN, at.Add(time.Minute)); eph != e3 {
+		t.Errorf("Expected third ephemeris, got %v", eph)
+	}
+	if eph := s.Select([3]byte{'G', '1', '1'}, at); eph != nil {
+		t.Errorf("Expected no ephemeris, got %v", eph)
+	}
+}
+
+func TestCNAV(t *testing.T) {
+	week := 2191
+	toc := gnsstime.GPS.FromWeek(week, 7200*time.Second)
+	eph := &rinex.CNAVEphemeris{
+		KeplerEphemeris: rinex.KeplerEphemeris{
+			PRN:       [3]byte{'G', '1', '0'},
+			TOC:       toc,
+			SqrtA:     5153.7,
+			Ecc:       0.01,
+			I0:        0.95,
+			Omega0:    -2.5,
+			Omega:     0.7,
+			M0:        1.2,
+			DeltaN:    4.5e-9,
+			DeltaNDot: 2e-13,
+			ADot:      0.5,
+			Toe:       7200,
+			Week:      week,
+		},
+		MessageType: "CNAV",
+	}
+
+	// The velocity must account for the changing semi-major axis and
+	// mean motion.
+	at := toc.Add(90 * time.Minute)
+	st := checkVelocity(t, eph, at, 1e-3)
+	lnav := eph.KeplerEphemeris
+	lnav.ADot, lnav.DeltaNDot = 0, 0
+	plain := computeKepler(&lnav, at)
+	if norm(st.Position) <= norm(plain.Position) {
+		t.Errorf("ADot had no effect: %f vs %f", norm(st.Position), norm(plain.Position))
+	}
+}
@@ -0,0 +1,114 @@
+package ephemeris
+
+import (
+	"math"
+	"time"
+
+	"github.com/entrope/gnss/rinex"
+)
+
+// Constants from the GLONASS interface control document, for the PZ-90
+// reference frame.
+const (
+	gloMu     = 3.9860044e14 // gravitational constant (m^3/s^2)
+	gloAe     = 6378136.0    // semi-major axis of the Earth (m)
+	gloJ2     = 1.0826257e-3 // second zonal harmonic
+	gloOmegaE = 7.292115e-5  // Earth rotation rate (rad/s)
+	gloStep   = 60.0         // maximum integration step (s)
+)
+
+// kilometer converts the km-based GLONASS and SBAS ephemerides to m.
+const kilometer = 1000.0
+
+// computeGLONASS propagates a GLONASS ephemeris to time t by
+// integrating the satellite's equations of motion with a fourth-order
+// Runge-Kutta method.
+func computeGLONASS(eph *rinex.GLONASSEphemeris, t time.Time) State {
+	var y [6]float64
+	for i := 0; i < 3; i++ {
+		y[i] = eph.Position[i] * kilometer
+		y[i+3] = eph.Velocity[i] * kilometer
+	}
+	acc := [3]float64{
+		eph.Acceleration[0] * kilometer,
+		eph.Acceleration[1] * kilometer,
+		eph.Acceleration[2] * kilometer,
+	}
+
+	dt := seconds(t, eph.TOC)
+	for remaining := dt; remaining != 0; {
+		h := remaining
+		if h > gloStep {
+			h = gloStep
+		} else if h < -gloStep {
+			h = -gloStep
+		}
+		y = rungeKutta(y, acc, h)
+		remaining -= h
+	}
+
+	var st State
+	copy(st.Position[:], y[0:3])
+	copy(st.Velocity[:], y[3:6])
+	st.ClockBias = eph.ClockBias + eph.RelFreqBias*dt
+	st.ClockDrift = eph.RelFreqBias
+	return st
+}
+
+// rungeKutta advances the state y by h seconds.
+func rungeKutta(y [6]float64, acc [3]float64, h float64) [6]float64 {
+	k1 := gloDerivative(y, acc)
+	k2 := gloDerivative(addScaled(y, k1, h/2), acc)
+	k3 := gloDerivative(addScaled(y, k2, h/2), acc)
+	k4 := gloDerivative(addScaled(y, k3, h), acc)
+	for i := range y {
+		y[i] += h / 6 * (k1[i] + 2*k2[i] + 2*k3[i] + k4[i])
+	}
+	return y
+}
+
+// addScaled returns y + h*dy.
+func addScaled(y, dy [6]float64, h float64) [6]float64 {
+	for i := range y {
+		y[i] += h * dy[i]
+	}
+	return y
+}
+
+// gloDerivative evaluates the GLONASS equations of motion for the state
+// y (position and velocity) with the lunisolar acceleration acc.
+func gloDerivative(y [6]float64, acc [3]float64) [6]float64 {
+	x, yy, z := y[0], y[1], y[2]
+	vx, vy := y[3], y[4]
+	r2 := x*x + yy*yy + z*z
+	r := math.Sqrt(r2)
+	mr3 := gloMu / (r2 * r)
+	j2 := 1.5 * gloJ2 * gloMu * gloAe * gloAe / (r2 * r2 * r)
+	z2r2 := 5 * z * z / r2
+	w2 := gloOmegaE * gloOmegaE
+
+	return [6]float64{
+		vx,
+		vy,
+		y[5],
+		-mr3*x - j2*x*(1-z2r2) + w2*x + 2*gloOmegaE*vy + acc[0],
+		-mr3*yy - j2*yy*(1-z2r2) + w2*yy - 2*gloOmegaE*vx + acc[1],
+		-mr3*z - j2*z*(3-z2r2) + acc[2],
+	}
+}
+
+// computeSBAS extrapolates an SBAS ephemeris to time t.
+func computeSBAS(eph *rinex.SBASEphemeris, t time.Time) State {
+	var st State
+	dt := seconds(t, eph.TOC)
+	for i := 0; i < 3; i++ {
+		p := eph.Position[i] * kilometer
+		v := eph.Velocity[i] * kilometer
+		a := eph.Acceleration[i] * kilometer
+		st.Position[i] = p + dt*(v+dt*a/2)
+		st.Velocity[i] = v + dt*a
+	}
+	st.ClockBias = eph.ClockBias + eph.ClockDrift*dt
+	st.ClockDrift = eph.ClockDrift
+	return st
+}
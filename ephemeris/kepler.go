@@ -0,0 +1,191 @@
+package ephemeris
+
+import (
+	"math"
+	"time"
+
+	"github.com/entrope/gnss/rinex"
+)
+
+// keplerConstants holds the physical constants that a GNSS's interface
+// specification uses for its Keplerian orbit model.
+type keplerConstants struct {
+	// mu is the Earth's gravitational constant (m^3/s^2).
+	mu float64
+
+	// omegaE is the Earth's rotation rate (rad/s).
+	omegaE float64
+
+	// f is the relativistic clock correction constant (s/m^0.5).
+	f float64
+}
+
+var (
+	gpsConstants = keplerConstants{
+		mu:     3.986005e14,
+		omegaE: 7.2921151467e-5,
+		f:      -4.442807633e-10,
+	}
+	galConstants = keplerConstants{
+		mu:     3.986004418e14,
+		omegaE: 7.2921151467e-5,
+		f:      -4.442807309e-10,
+	}
+	bdsConstants = keplerConstants{
+		mu:     3.986004418e14,
+		omegaE: 7.2921150e-5,
+		f:      -4.442807309e-10,
+	}
+)
+
+// geoInclination is the -5 degree rotation that BeiDou GEO satellites
+// use to go from their orbit frame to the ECEF frame.
+var geoInclination = -5 * math.Pi / 180
+
+// isBeiDouGEO returns true if prn identifies a BeiDou geostationary
+// satellite (C01 to C05, or C59 to C63).
+func isBeiDouGEO(prn [3]byte) bool {
+	if prn[0] != 'C' {
+		return false
+	}
+	n := (prn[1]-'0')*10 + prn[2] - '0'
+	return n <= 5 || (n >= 59 && n <= 63)
+}
+
+// computeKepler evaluates a Keplerian ephemeris at time t.
+func computeKepler(k *rinex.KeplerEphemeris, t time.Time) State {
+	c := gpsConstants
+	switch k.PRN[0] {
+	case 'E':
+		c = galConstants
+	case 'C':
+		c = bdsConstants
+	}
+
+	var st State
+	tk := seconds(t, keplerToe(k))
+	if isBeiDouGEO(k.PRN) {
+		// Differentiate the GEO position numerically; the extra
+		// rotations make the analytic derivative unwieldy.
+		const h = 0.5
+		var before, after [3]float64
+		st.Position, _ = keplerPosition(k, c, tk, true)
+		before, _ = keplerPosition(k, c, tk-h, true)
+		after, _ = keplerPosition(k, c, tk+h, true)
+		for i := range st.Velocity {
+			st.Velocity[i] = (after[i] - before[i]) / (2 * h)
+		}
+	} else {
+		st.Position, st.Velocity = keplerPosition(k, c, tk, false)
+	}
+
+	// Compute the clock correction.
+	A, n, dMdt := meanMotion(k, c, tk)
+	sqrtA := math.Sqrt(A)
+	E := eccentricAnomaly(k.M0+n*tk, k.Ecc)
+	sinE, cosE := math.Sincos(E)
+	dEdt := dMdt / (1 - k.Ecc*cosE)
+	dt := seconds(t, k.TOC)
+	st.Relativistic = c.f * k.Ecc * sqrtA * sinE
+	st.ClockBias = k.ClockBias + dt*(k.ClockDrift+dt*k.ClockDriftRate) +
+		st.Relativistic
+	st.ClockDrift = k.ClockDrift + 2*dt*k.ClockDriftRate +
+		c.f*k.Ecc*sqrtA*cosE*dEdt
+
+	return st
+}
+
+// meanMotion returns the semi-major axis of k at tk seconds after its
+// time of ephemeris, the average mean motion n such that the mean
+// anomaly is M0 + n*tk, and the rate of change of the mean anomaly.
+// CNAV ephemerides let the semi-major axis and mean motion correction
+// change with time; for other ephemerides, n is also the rate.
+func meanMotion(k *rinex.KeplerEphemeris, c keplerConstants, tk float64) (A, n, dMdt float64) {
+	A0 := k.SqrtA * k.SqrtA
+	n0 := math.Sqrt(c.mu / (A0 * A0 * A0))
+	A = A0 + k.ADot*tk
+	n = n0 + k.DeltaN + k.DeltaNDot*tk/2
+	dMdt = n0 + k.DeltaN + k.DeltaNDot*tk
+	return A, n, dMdt
+}
+
+// eccentricAnomaly solves Kepler's equation M = E - e sin E for E.
+func eccentricAnomaly(M, e float64) float64 {
+	E := M
+	for i := 0; i < 20; i++ {
+		dE := (M - E + e*math.Sin(E)) / (1 - e*math.Cos(E))
+		E += dE
+		if math.Abs(dE) < 1e-14 {
+			break
+		}
+	}
+	return E
+}
+
+// keplerPosition computes the ECEF position and velocity for k at tk
+// seconds after its time of ephemeris.  If geo is true, it applies the
+// BeiDou GEO transformation and does not compute velocity.
+func keplerPosition(k *rinex.KeplerEphemeris, c keplerConstants, tk float64, geo bool) ([3]float64, [3]float64) {
+	var pos, vel [3]float64
+
+	// Compute the anomalies.
+	A, n, dMdt := meanMotion(k, c, tk)
+	E := eccentricAnomaly(k.M0+n*tk, k.Ecc)
+	sinE, cosE := math.Sincos(E)
+	sqrt1e2 := math.Sqrt(1 - k.Ecc*k.Ecc)
+	v := math.Atan2(sqrt1e2*sinE, cosE-k.Ecc)
+
+	// Apply the second harmonic perturbations.
+	phi := v + k.Omega
+	sin2p, cos2p := math.Sincos(2 * phi)
+	u := phi + k.Cus*sin2p + k.Cuc*cos2p
+	r := A*(1-k.Ecc*cosE) + k.Crs*sin2p + k.Crc*cos2p
+	i := k.I0 + k.IDot*tk + k.Cis*sin2p + k.Cic*cos2p
+
+	// Compute the position in the orbital plane.
+	sinU, cosU := math.Sincos(u)
+	xp := r * cosU
+	yp := r * sinU
+	sinI, cosI := math.Sincos(i)
+
+	if geo {
+		// BeiDou GEO satellites define their orbit in an inertial
+		// frame, which is then rotated into ECEF.
+		omega := k.Omega0 + k.OmegaDot*tk - c.omegaE*k.Toe
+		sinO, cosO := math.Sincos(omega)
+		xg := xp*cosO - yp*cosI*sinO
+		yg := xp*sinO + yp*cosI*cosO
+		zg := yp * sinI
+
+		sinX, cosX := math.Sincos(geoInclination)
+		sinZ, cosZ := math.Sincos(c.omegaE * tk)
+		y1 := cosX*yg + sinX*zg
+		z1 := -sinX*yg + cosX*zg
+		pos[0] = cosZ*xg + sinZ*y1
+		pos[1] = -sinZ*xg + cosZ*y1
+		pos[2] = z1
+		return pos, vel
+	}
+
+	omegaDot := k.OmegaDot - c.omegaE
+	omega := k.Omega0 + omegaDot*tk - c.omegaE*k.Toe
+	sinO, cosO := math.Sincos(omega)
+	pos[0] = xp*cosO - yp*cosI*sinO
+	pos[1] = xp*sinO + yp*cosI*cosO
+	pos[2] = yp * sinI
+
+	// Differentiate each of the steps above with respect to time.
+	dEdt := dMdt / (1 - k.Ecc*cosE)
+	dvdt := sqrt1e2 * dEdt / (1 - k.Ecc*cosE)
+	dudt := dvdt * (1 + 2*(k.Cus*cos2p-k.Cuc*sin2p))
+	drdt := k.ADot*(1-k.Ecc*cosE) + A*k.Ecc*sinE*dEdt +
+		2*dvdt*(k.Crs*cos2p-k.Crc*sin2p)
+	didt := k.IDot + 2*dvdt*(k.Cis*cos2p-k.Cic*sin2p)
+	dxp := drdt*cosU - r*dudt*sinU
+	dyp := drdt*sinU + r*dudt*cosU
+	vel[0] = dxp*cosO - dyp*cosI*sinO + yp*sinI*sinO*didt - pos[1]*omegaDot
+	vel[1] = dxp*sinO + dyp*cosI*cosO - yp*sinI*cosO*didt + pos[0]*omegaDot
+	vel[2] = dyp*sinI + yp*cosI*didt
+
+	return pos, vel
+}
@@ -0,0 +1,132 @@
+package ephemeris
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/entrope/gnss/gnsstime"
+	"github.com/entrope/gnss/rinex"
+)
+
+// maxAge is how far from its reference time an ephemeris may be used,
+// by GNSS.  GPS instead uses half the ephemeris's fit interval when it
+// is given.  QZSS navigation files only give a fit interval flag, so
+// QZSS always uses the value here.
+var maxAge = map[byte]time.Duration{
+	'G': 2 * time.Hour,
+	'J': 1 * time.Hour,
+	'E': 3 * time.Hour,
+	'C': 1 * time.Hour,
+	'R': 30 * time.Minute,
+	'S': 10 * time.Minute,
+}
+
+// ErrNoEphemeris indicates that a Store has no usable ephemeris for the
+// requested satellite and time.
+var ErrNoEphemeris = errors.New("No usable ephemeris")
+
+// Store holds broadcast ephemerides for any number of satellites, and
+// selects the best one to evaluate for a given satellite and time.
+//
+// Store methods take times in GPS time, which is what most RINEX
+// observation files use, and convert them to BDT for BeiDou and to
+// UTC for GLONASS.
+type Store struct {
+	// LeapSeconds, if not zero, is the difference between GPS time and
+	// UTC to use for GLONASS ephemerides; rinex.NavReader reports it
+	// as NavReader.LeapSeconds.  If it is zero, Store uses the leap
+	// seconds in effect at the requested time.
+	LeapSeconds int
+
+	// ephs maps from satellite ID to its ephemerides.
+	ephs map[[3]byte][]rinex.Ephemeris
+}
+
+// NewStore creates an empty Store.
+func NewStore() *Store {
+	return &Store{ephs: make(map[[3]byte][]rinex.Ephemeris)}
+}
+
+// Add adds eph to the store.  It is suitable for use as (or in) a
+// rinex.NavReader EphemerisFunc.
+func (s *Store) Add(eph rinex.Ephemeris) error {
+	prn := eph.SatelliteID()
+	s.ephs[prn] = append(s.ephs[prn], eph)
+	return nil
+}
+
+// systemTime converts t from GPS time to prn's GNSS time scale.
+func (s *Store) systemTime(prn [3]byte, t time.Time) time.Time {
+	switch prn[0] {
+	case 'C':
+		return gnsstime.BDT.FromGPS(t)
+	case 'R':
+		if s.LeapSeconds == 0 {
+			return gnsstime.UTC.FromGPS(t)
+		}
+		return t.Add(-time.Duration(s.LeapSeconds) * time.Second)
+	}
+	return t
+}
+
+// healthy returns true if eph does not flag its satellite as unhealthy.
+func healthy(eph rinex.Ephemeris) bool {
+	switch e := eph.(type) {
+	case *rinex.GPSEphemeris:
+		return e.Health == 0
+	case *rinex.CNAVEphemeris:
+		return e.Health == 0
+	case *rinex.GalileoEphemeris:
+		return e.Health == 0
+	case *rinex.BeiDouEphemeris:
+		return e.Health == 0
+	case *rinex.GLONASSEphemeris:
+		return e.Health == 0
+	case *rinex.SBASEphemeris:
+		return true
+	}
+	return false
+}
+
+// validity returns how far from its reference time eph may be used.
+func validity(eph rinex.Ephemeris) time.Duration {
+	if e, ok := eph.(*rinex.GPSEphemeris); ok && e.PRN[0] == 'G' && e.FitInterval > 0 {
+		return time.Duration(e.FitInterval * float64(time.Hour) / 2)
+	}
+	return maxAge[eph.SatelliteID()[0]]
+}
+
+// Select returns the healthy ephemeris for prn whose reference time is
+// closest to t (in GPS time), or nil if there is none within its
+// validity interval.  When two ephemerides are equally close, Select
+// prefers the one that was added first.
+func (s *Store) Select(prn [3]byte, t time.Time) rinex.Ephemeris {
+	st := s.systemTime(prn, t)
+	var best rinex.Ephemeris
+	bestAge := time.Duration(math.MaxInt64)
+	for _, eph := range s.ephs[prn] {
+		if !healthy(eph) {
+			continue
+		}
+		age := st.Sub(timeOfEphemeris(eph))
+		if age < 0 {
+			age = -age
+		}
+		if age <= validity(eph) && age < bestAge {
+			best = eph
+			bestAge = age
+		}
+	}
+	return best
+}
+
+// Compute selects the best ephemeris for prn at t (in GPS time) and
+// evaluates it.
+func (s *Store) Compute(prn [3]byte, t time.Time) (State, error) {
+	eph := s.Select(prn, t)
+	if eph == nil {
+		return State{}, ErrNoEphemeris
+	}
+	return Compute(eph, s.systemTime(prn, t))
+}
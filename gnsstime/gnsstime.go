@@ -0,0 +1,292 @@
+// Package gnsstime converts between the time scales that GNSS use, and
+// between the ways that they count time: calendar dates, weeks and
+// time of week, days of year and modified Julian dates.
+//
+// Like the rest of this module, it represents a reading of a GNSS
+// clock as a time.Time whose location is UTC, although the reading is
+// not in UTC.  Go's time package ignores leap seconds, so a Time holds
+// an exact reading of any one scale, and differences between readings
+// of the same scale are exact as long as that scale has no leap
+// seconds.  Absolute instants are GPS time readings, which is what
+// sp3.Orbit and ephemeris.Store take.
+package gnsstime
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Scale identifies a time scale.
+type Scale int
+
+const (
+	// GPS is GPS time, which was equal to UTC at GPSEpoch and has no
+	// leap seconds.
+	GPS Scale = iota
+
+	// GAL is Galileo System Time, which is kept equal to GPS time.
+	GAL
+
+	// GLO is GLONASS time as RINEX uses it: UTC(SU), which follows
+	// UTC and its leap seconds.  GLST is GLONASS system time itself.
+	GLO
+
+	// BDT is BeiDou time, which was equal to UTC at 1 January 2006 and
+	// has no leap seconds.
+	BDT
+
+	// QZS is QZSS time, which is kept equal to GPS time.
+	QZS
+
+	// IRN is NavIC (IRNSS) time, which is kept equal to GPS time.
+	IRN
+
+	// UTC is Coordinated Universal Time.
+	UTC
+
+	// TAI is International Atomic Time.
+	TAI
+
+	// GLST is GLONASS system time, as the satellites broadcast it:
+	// UTC(SU) plus three hours, with the same leap seconds.  It has no
+	// RINEX name, so ParseScale does not return it.
+	GLST
+)
+
+var (
+	// GPSEpoch is the start of GPS week 0.
+	GPSEpoch = time.Date(1980, 1, 6, 0, 0, 0, 0, time.UTC)
+
+	// GSTEpoch is the start of Galileo (and NavIC) week 0, which is
+	// GPS week 1024.
+	GSTEpoch = time.Date(1999, 8, 22, 0, 0, 0, 0, time.UTC)
+
+	// BDTEpoch is the start of BeiDou week 0, in BDT.
+	BDTEpoch = time.Date(2006, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// mjdEpoch is the start of modified Julian date 0.
+	mjdEpoch = time.Date(1858, 11, 17, 0, 0, 0, 0, time.UTC)
+)
+
+const (
+	// Week is the length of a GNSS week.
+	Week = 7 * 24 * time.Hour
+
+	// bdtOffset is how far GPS time is ahead of BDT.
+	bdtOffset = 14 * time.Second
+
+	// taiOffset is how far TAI is ahead of GPS time.
+	taiOffset = 19 * time.Second
+
+	// glstOffset is how far GLONASS system time is ahead of UTC(SU).
+	glstOffset = 3 * time.Hour
+)
+
+// scaleNames holds the name of each Scale.  All but GLST are RINEX
+// names.
+var scaleNames = [...]string{
+	GPS:  "GPS",
+	GAL:  "GAL",
+	GLO:  "GLO",
+	BDT:  "BDT",
+	QZS:  "QZS",
+	IRN:  "IRN",
+	UTC:  "UTC",
+	TAI:  "TAI",
+	GLST: "GLST",
+}
+
+// leapSeconds lists the UTC dates when GPS time moved one more second
+// ahead of UTC.
+var leapSeconds = []time.Time{
+	time.Date(1981, 7, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1982, 7, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1983, 7, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1985, 7, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1988, 1, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1991, 1, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1992, 7, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1993, 7, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1994, 7, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1996, 1, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1997, 7, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(2006, 1, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(2009, 1, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(2012, 7, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(2015, 7, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+}
+
+/************************ TOP LEVEL FUNCTIONS ************************/
+
+// ParseScale returns the Scale with the given RINEX name, such as
+// "GPS" or "GLO".  Surrounding blanks are ignored.
+func ParseScale(name string) (Scale, error) {
+	for s, n := range scaleNames {
+		if n == strings.TrimSpace(name) && Scale(s) != GLST {
+			return Scale(s), nil
+		}
+	}
+	return GPS, fmt.Errorf("Unsupported time system %q", name)
+}
+
+// String returns the name of s.
+func (s Scale) String() string {
+	if s < 0 || int(s) >= len(scaleNames) {
+		return fmt.Sprintf("Scale(%d)", int(s))
+	}
+	return scaleNames[s]
+}
+
+// GPSMinusUTC returns how far GPS time was ahead of UTC at utc.  It
+// is 0 before GPSEpoch.
+func GPSMinusUTC(utc time.Time) time.Duration {
+	n := 0
+	for _, leap := range leapSeconds {
+		if !utc.Before(leap) {
+			n++
+		}
+	}
+	return time.Duration(n) * time.Second
+}
+
+// ToGPS converts t from a reading of s to a reading of GPS time.
+func (s Scale) ToGPS(t time.Time) time.Time {
+	switch s {
+	case BDT:
+		return t.Add(bdtOffset)
+	case TAI:
+		return t.Add(-taiOffset)
+	case UTC, GLO:
+		return t.Add(GPSMinusUTC(t))
+	case GLST:
+		t = t.Add(-glstOffset)
+		return t.Add(GPSMinusUTC(t))
+	}
+	return t
+}
+
+// FromGPS converts t from a reading of GPS time to a reading of s.
+// During a leap second, which UTC and the GLONASS time scales cannot
+// represent, it returns the start of the next second.
+func (s Scale) FromGPS(t time.Time) time.Time {
+	switch s {
+	case BDT:
+		return t.Add(-bdtOffset)
+	case TAI:
+		return t.Add(taiOffset)
+	case UTC, GLO:
+		return toUTC(t)
+	case GLST:
+		return toUTC(t).Add(glstOffset)
+	}
+	return t
+}
+
+// Convert converts t from a reading of scale from to a reading of
+// scale to.
+func Convert(t time.Time, from, to Scale) time.Time {
+	return to.FromGPS(from.ToGPS(t))
+}
+
+// Epoch returns the start of week 0 of s, as a reading of s.  GLONASS
+// time, UTC and TAI do not count weeks; for them, it returns
+// GPSEpoch.
+func (s Scale) Epoch() time.Time {
+	switch s {
+	case GAL, IRN:
+		return GSTEpoch
+	case BDT:
+		return BDTEpoch
+	}
+	return GPSEpoch
+}
+
+// Week returns the week number and time of week of t, a reading of s.
+// The week number is not truncated, so it does not roll over.
+func (s Scale) Week(t time.Time) (week int, tow time.Duration) {
+	d := t.Sub(s.Epoch())
+	week = int(d / Week)
+	tow = d % Week
+	if tow < 0 {
+		week, tow = week-1, tow+Week
+	}
+	return week, tow
+}
+
+// FromWeek returns the reading of s at week and tow.
+func (s Scale) FromWeek(week int, tow time.Duration) time.Time {
+	return s.Epoch().Add(time.Duration(week)*Week + tow)
+}
+
+// ResolveWeek returns the full week number whose low bits bits are
+// week, and that is nearest to the week of ref, a reading of s.  For
+// example, GPS LNAV messages give week numbers with 10 bits, Galileo
+// I/NAV with 12 and BeiDou D1 with 13.
+func (s Scale) ResolveWeek(week, bits int, ref time.Time) int {
+	period := 1 << uint(bits)
+	refWeek, _ := s.Week(ref)
+	full := refWeek - mod(refWeek, period) + mod(week, period)
+	if full-refWeek > period/2 {
+		full -= period
+	} else if refWeek-full > period/2 {
+		full += period
+	}
+	return full
+}
+
+// ResolveTOW returns the reading of s with time of week tow that is
+// nearest to ref, another reading of s.
+func (s Scale) ResolveTOW(tow time.Duration, ref time.Time) time.Time {
+	week, _ := s.Week(ref)
+	t := s.FromWeek(week, tow)
+	if d := t.Sub(ref); d > Week/2 {
+		t = t.Add(-Week)
+	} else if d < -Week/2 {
+		t = t.Add(Week)
+	}
+	return t
+}
+
+// DayOfYear returns the year, the one-based day of year and the time
+// of day of t.
+func DayOfYear(t time.Time) (year, doy int, tod time.Duration) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return t.Year(), t.YearDay(), t.Sub(midnight)
+}
+
+// FromDayOfYear returns the time at tod on the one-based day doy of
+// year.
+func FromDayOfYear(year, doy int, tod time.Duration) time.Time {
+	return time.Date(year, 1, doy, 0, 0, 0, 0, time.UTC).Add(tod)
+}
+
+// MJD returns the modified Julian date and the time of day of t.
+func MJD(t time.Time) (mjd int, tod time.Duration) {
+	year, doy, tod := DayOfYear(t)
+	midnight := time.Date(year, 1, doy, 0, 0, 0, 0, time.UTC)
+	return int((midnight.Unix() - mjdEpoch.Unix()) / 86400), tod
+}
+
+// FromMJD returns the time at tod on modified Julian date mjd.
+func FromMJD(mjd int, tod time.Duration) time.Time {
+	return mjdEpoch.AddDate(0, 0, mjd).Add(tod)
+}
+
+/************************** HELPER FUNCTIONS **************************/
+
+// toUTC converts t from a reading of GPS time to a reading of UTC.
+func toUTC(t time.Time) time.Time {
+	return t.Add(-GPSMinusUTC(t.Add(-GPSMinusUTC(t))))
+}
+
+// mod returns a modulo b, in the range [0, b).
+func mod(a, b int) int {
+	if a %= b; a < 0 {
+		a += b
+	}
+	return a
+}
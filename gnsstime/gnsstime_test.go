@@ -0,0 +1,141 @@
+package gnsstime
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScales(t *testing.T) {
+	utc := time.Date(2019, 1, 10, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		scale  Scale
+		offset time.Duration
+	}{
+		{GPS, 0},
+		{GAL, 0},
+		{QZS, 0},
+		{BDT, 14 * time.Second},
+		{TAI, -19 * time.Second},
+		{UTC, 18 * time.Second},
+		{GLO, 18 * time.Second},
+		{GLST, 18*time.Second - 3*time.Hour},
+	}
+	for _, test := range tests {
+		s, err := ParseScale(" " + test.scale.String())
+		if test.scale == GLST {
+			if err == nil {
+				t.Errorf("ParseScale(%s) = %v, expected an error", test.scale, s)
+			}
+		} else if err != nil || s != test.scale {
+			t.Errorf("ParseScale(%s) = %v, %v", test.scale, s, err)
+		}
+		gps := test.scale.ToGPS(utc)
+		if gps.Sub(utc) != test.offset {
+			t.Errorf("%s: expected offset %v, got %v", test.scale,
+				test.offset, gps.Sub(utc))
+		}
+		if back := test.scale.FromGPS(gps); !back.Equal(utc) {
+			t.Errorf("%s: round trip gave %v", test.scale, back)
+		}
+	}
+	if _, err := ParseScale("XYZ"); err == nil {
+		t.Errorf("Expected an error for an unknown time scale")
+	}
+
+	if GPSMinusUTC(time.Date(2016, 12, 31, 23, 59, 59, 0, time.UTC)) != 17*time.Second {
+		t.Errorf("Bad leap seconds before 2017")
+	}
+	if GPSMinusUTC(GPSEpoch) != 0 {
+		t.Errorf("Bad leap seconds at the GPS epoch")
+	}
+
+	// A UTC minute that spans a leap second lasts 61 seconds.
+	before := UTC.ToGPS(time.Date(2016, 12, 31, 23, 59, 0, 0, time.UTC))
+	after := UTC.ToGPS(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC))
+	if d := after.Sub(before); d != 61*time.Second {
+		t.Errorf("Leap minute lasted %v", d)
+	}
+	for _, sec := range []int{16, 17, 18} {
+		gps := time.Date(2017, 1, 1, 0, 0, sec, 0, time.UTC)
+		want := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+		if sec == 16 {
+			want = want.Add(-time.Second)
+		}
+		if got := UTC.FromGPS(gps); !got.Equal(want) {
+			t.Errorf("UTC.FromGPS(%v) = %v", gps, got)
+		}
+	}
+
+	if got := Convert(utc, GLO, BDT); !got.Equal(utc.Add(4 * time.Second)) {
+		t.Errorf("GLO to BDT gave %v", got)
+	}
+	if got := Convert(utc, GLO, GLST); !got.Equal(utc.Add(3 * time.Hour)) {
+		t.Errorf("GLO to GLST gave %v", got)
+	}
+
+	// GLONASS system time has its leap second at 03:00 on 1 January.
+	gps := UTC.ToGPS(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC))
+	if got := GLST.FromGPS(gps); !got.Equal(time.Date(2017, 1, 1, 3, 0, 0, 0, time.UTC)) {
+		t.Errorf("GLST.FromGPS(%v) = %v", gps, got)
+	}
+}
+
+func TestWeeks(t *testing.T) {
+	tm := time.Date(2019, 4, 6, 23, 59, 42, 0, time.UTC)
+	week, tow := GPS.Week(tm)
+	if week != 2047 || tow != 6*24*time.Hour+23*time.Hour+59*time.Minute+42*time.Second {
+		t.Errorf("GPS week = %d, %v", week, tow)
+	}
+	if !GPS.FromWeek(week, tow).Equal(tm) {
+		t.Errorf("GPS week did not round trip")
+	}
+	if week, _ = GAL.Week(tm); week != 1023 {
+		t.Errorf("Galileo week = %d", week)
+	}
+	if week, _ = BDT.Week(tm); week != 691 {
+		t.Errorf("BeiDou week = %d", week)
+	}
+	if week, tow = GPS.Week(GPSEpoch.Add(-time.Second)); week != -1 || tow != Week-time.Second {
+		t.Errorf("Week before epoch = %d, %v", week, tow)
+	}
+
+	// The 10-bit GPS week rolled over from 1023 to 0 at week 2048.
+	ref := time.Date(2019, 4, 8, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct{ week, want int }{
+		{0, 2048}, {1023, 2047}, {1, 2049}, {511, 2559},
+	} {
+		if got := GPS.ResolveWeek(test.week, 10, ref); got != test.want {
+			t.Errorf("ResolveWeek(%d) = %d, want %d", test.week, got, test.want)
+		}
+	}
+
+	start := GPS.FromWeek(2048, 0)
+	if got := GPS.ResolveTOW(Week-time.Second, start.Add(time.Hour)); !got.Equal(start.Add(-time.Second)) {
+		t.Errorf("ResolveTOW gave %v", got)
+	}
+	if got := GPS.ResolveTOW(time.Hour, start.Add(-time.Second)); !got.Equal(start.Add(time.Hour)) {
+		t.Errorf("ResolveTOW gave %v", got)
+	}
+}
+
+func TestDays(t *testing.T) {
+	tm := time.Date(2020, 12, 31, 12, 30, 0, 0, time.UTC)
+	year, doy, tod := DayOfYear(tm)
+	if year != 2020 || doy != 366 || tod != 12*time.Hour+30*time.Minute {
+		t.Errorf("DayOfYear = %d, %d, %v", year, doy, tod)
+	}
+	if !FromDayOfYear(year, doy, tod).Equal(tm) {
+		t.Errorf("Day of year did not round trip")
+	}
+
+	mjd, tod := MJD(tm)
+	if mjd != 59214 || tod != 12*time.Hour+30*time.Minute {
+		t.Errorf("MJD = %d, %v", mjd, tod)
+	}
+	if !FromMJD(mjd, tod).Equal(tm) {
+		t.Errorf("MJD did not round trip")
+	}
+	if mjd, _ = MJD(GPSEpoch); mjd != 44244 {
+		t.Errorf("MJD of GPS epoch = %d", mjd)
+	}
+}
@@ -0,0 +1,588 @@
+// Package nmea parses NMEA 0183 sentences from receiver monitoring
+// logs.
+//
+// It checks the checksum of each sentence and decodes GGA, RMC, GSA,
+// GSV and GST sentences into typed structures with full timestamps.
+// Reader also turns the carrier-to-noise densities in GSV sentences
+// into observation records with one S observation per signal, in the
+// same form that rinex.ObsReader produces, so that tools which plot
+// signal strength can use NMEA logs.
+package nmea
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrChecksum indicates that a sentence's checksum does not match
+	// its contents.
+	ErrChecksum = errors.New("Invalid NMEA checksum")
+
+	// ErrMalformed indicates that a sentence does not have the form
+	// that its type requires.
+	ErrMalformed = errors.New("Malformed NMEA sentence")
+
+	// ErrUnsupported indicates that a sentence has a valid checksum
+	// but a type that this package does not decode.
+	ErrUnsupported = errors.New("Unsupported NMEA sentence type")
+)
+
+// GGA holds a GGA (fix data) sentence.
+type GGA struct {
+	// Talker is the two-character talker identifier, such as "GP" or
+	// "GN".
+	Talker string
+
+	// Time is the UTC time of the fix.
+	Time time.Time
+
+	// Latitude is the latitude (degrees, positive north).
+	Latitude float64
+
+	// Longitude is the longitude (degrees, positive east).
+	Longitude float64
+
+	// Quality is the fix quality indicator: 0 for no fix, 1 for an
+	// autonomous fix, 2 for differential, 4 for RTK fixed, 5 for RTK
+	// float, and so forth.
+	Quality int
+
+	// NumSats is the number of satellites used in the fix.
+	NumSats int
+
+	// HDOP is the horizontal dilution of precision.
+	HDOP float64
+
+	// Altitude is the height above mean sea level (m).
+	Altitude float64
+
+	// GeoidSeparation is the height of the geoid above the WGS-84
+	// ellipsoid (m).
+	GeoidSeparation float64
+
+	// DGPSAge is the age of differential corrections (s), or 0 if
+	// there are none.
+	DGPSAge float64
+
+	// DGPSStation is the differential reference station ID, or 0 if
+	// there is none.
+	DGPSStation int
+}
+
+// RMC holds an RMC (recommended minimum data) sentence.
+type RMC struct {
+	// Talker is the two-character talker identifier.
+	Talker string
+
+	// Time is the UTC date and time of the fix.
+	Time time.Time
+
+	// Valid is true if the receiver reported the data as valid.
+	Valid bool
+
+	// Latitude is the latitude (degrees, positive north).
+	Latitude float64
+
+	// Longitude is the longitude (degrees, positive east).
+	Longitude float64
+
+	// Speed is the speed over ground (knots).
+	Speed float64
+
+	// Course is the course over ground (degrees from true north).
+	Course float64
+
+	// MagneticVariation is the magnetic variation (degrees, positive
+	// east).
+	MagneticVariation float64
+
+	// Mode is the positioning mode indicator, such as 'A' for an
+	// autonomous fix or 'D' for differential, or 0 if the sentence
+	// does not have one.
+	Mode byte
+}
+
+// GSA holds a GSA (DOP and active satellites) sentence.
+type GSA struct {
+	// Talker is the two-character talker identifier.
+	Talker string
+
+	// Automatic is true if the receiver chooses between 2-D and 3-D
+	// fixes automatically.
+	Automatic bool
+
+	// FixType is 1 for no fix, 2 for a 2-D fix, or 3 for a 3-D fix.
+	FixType int
+
+	// Sats lists the satellites used in the fix, as in
+	// rinex.SVObservation.PRN.
+	Sats [][3]byte
+
+	// PDOP is the position dilution of precision.
+	PDOP float64
+
+	// HDOP is the horizontal dilution of precision.
+	HDOP float64
+
+	// VDOP is the vertical dilution of precision.
+	VDOP float64
+
+	// SystemID is the NMEA 4.11 GNSS system ID, or 0 if the sentence
+	// does not have one.
+	SystemID int
+}
+
+// SatInView describes one satellite in a GSV sentence.
+type SatInView struct {
+	// PRN identifies the satellite, as in rinex.SVObservation.PRN.
+	PRN [3]byte
+
+	// Elevation is the elevation (degrees).
+	Elevation int
+
+	// Azimuth is the azimuth (degrees from true north).
+	Azimuth int
+
+	// CN0 is the carrier-to-noise density (dB-Hz), or 0 if the
+	// satellite is not being tracked.
+	CN0 int
+}
+
+// GSV holds a GSV (satellites in view) sentence.
+type GSV struct {
+	// Talker is the two-character talker identifier.
+	Talker string
+
+	// Count is the number of GSV sentences in this group.
+	Count int
+
+	// Index is the (one-based) index of this sentence in its group.
+	Index int
+
+	// InView is the total number of satellites in view.
+	InView int
+
+	// Sats describes up to four satellites in view.
+	Sats []SatInView
+
+	// SignalID is the NMEA 4.10 signal ID, or 0 if the sentence does
+	// not have one.
+	SignalID int
+}
+
+// GST holds a GST (pseudorange error statistics) sentence.
+type GST struct {
+	// Talker is the two-character talker identifier.
+	Talker string
+
+	// Time is the UTC time of the fix.
+	Time time.Time
+
+	// RMS is the RMS of the pseudorange residuals (m).
+	RMS float64
+
+	// SemiMajor is the standard deviation of the semi-major axis of
+	// the error ellipse (m).
+	SemiMajor float64
+
+	// SemiMinor is the standard deviation of the semi-minor axis of
+	// the error ellipse (m).
+	SemiMinor float64
+
+	// Orientation is the orientation of the semi-major axis (degrees
+	// from true north).
+	Orientation float64
+
+	// LatitudeError is the standard deviation of latitude error (m).
+	LatitudeError float64
+
+	// LongitudeError is the standard deviation of longitude error (m).
+	LongitudeError float64
+
+	// AltitudeError is the standard deviation of altitude error (m).
+	AltitudeError float64
+}
+
+/************************** HELPER FUNCTIONS **************************/
+
+// systems maps from a talker identifier to the RINEX system character.
+var systems = map[string]byte{
+	"GP": 'G',
+	"GL": 'R',
+	"GA": 'E',
+	"GB": 'C',
+	"BD": 'C',
+	"GQ": 'J',
+	"QZ": 'J',
+	"GI": 'I',
+}
+
+// splitSentence checks the framing and checksum of line and returns
+// the talker, sentence type and fields.  The checksum is optional.
+func splitSentence(line string) (talker, kind string, fields []string, err error) {
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) < 7 || (line[0] != '$' && line[0] != '!') {
+		return "", "", nil, ErrMalformed
+	}
+	body := line[1:]
+	if idx := strings.LastIndexByte(body, '*'); idx >= 0 {
+		want, err := strconv.ParseUint(body[idx+1:], 16, 8)
+		if err != nil || len(body)-idx != 3 {
+			return "", "", nil, ErrMalformed
+		}
+		body = body[:idx]
+		var sum byte
+		for i := 0; i < len(body); i++ {
+			sum ^= body[i]
+		}
+		if sum != byte(want) {
+			return "", "", nil, ErrChecksum
+		}
+	}
+	fields = strings.Split(body, ",")
+	if len(fields[0]) != 5 {
+		return "", "", nil, ErrMalformed
+	}
+	return fields[0][:2], fields[0][2:], fields[1:], nil
+}
+
+// parseFloat parses s as a number, treating an empty field as 0.
+func parseFloat(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// parseInt parses s as a decimal integer, treating an empty field as 0.
+func parseInt(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
+
+// parseTimeOfDay parses an hhmmss.ss field.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	if len(s) < 6 {
+		return 0, ErrMalformed
+	}
+	h, err1 := strconv.Atoi(s[0:2])
+	m, err2 := strconv.Atoi(s[2:4])
+	sec, err3 := strconv.ParseFloat(s[4:], 64)
+	if err1 != nil || err2 != nil || err3 != nil || h > 23 || m > 59 || sec >= 61 {
+		return 0, ErrMalformed
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute +
+		time.Duration(sec*1e9+0.5), nil
+}
+
+// parseDate parses a ddmmyy field as a UTC date.  Two-digit years
+// before 80 are taken to be in the 2000s.
+func parseDate(s string) (time.Time, error) {
+	if len(s) != 6 {
+		return time.Time{}, ErrMalformed
+	}
+	d, err1 := strconv.Atoi(s[0:2])
+	m, err2 := strconv.Atoi(s[2:4])
+	y, err3 := strconv.Atoi(s[4:6])
+	if err1 != nil || err2 != nil || err3 != nil || d < 1 || d > 31 || m < 1 || m > 12 {
+		return time.Time{}, ErrMalformed
+	}
+	if y < 80 {
+		y += 2000
+	} else {
+		y += 1900
+	}
+	return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC), nil
+}
+
+// parseLatLon parses a ddmm.mmmm or dddmm.mmmm field and its hemisphere
+// indicator into degrees.
+func parseLatLon(s, hemi string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	idx := strings.IndexByte(s, '.')
+	if idx < 0 {
+		idx = len(s)
+	}
+	if idx < 3 {
+		return 0, ErrMalformed
+	}
+	deg, err1 := strconv.Atoi(s[:idx-2])
+	min, err2 := strconv.ParseFloat(s[idx-2:], 64)
+	if err1 != nil || err2 != nil {
+		return 0, ErrMalformed
+	}
+	v := float64(deg) + min/60
+	switch hemi {
+	case "N", "E":
+		return v, nil
+	case "S", "W":
+		return -v, nil
+	}
+	return 0, ErrMalformed
+}
+
+// satID converts an NMEA satellite number from a talker into a RINEX
+// satellite identifier.  It returns false if the number is not valid.
+func satID(talker string, n int) ([3]byte, bool) {
+	sys, ok := systems[talker]
+	switch {
+	case n >= 33 && n <= 64 && (talker == "GP" || talker == "GN"):
+		// SBAS, as PRN - 87.
+		sys, n = 'S', n+87-100
+	case n >= 65 && n <= 96 && (talker == "GL" || talker == "GP" || talker == "GN"):
+		sys, n = 'R', n-64
+	case n >= 193 && n <= 202 && (talker == "GQ" || talker == "QZ" || talker == "GP" || talker == "GN"):
+		sys, n = 'J', n-192
+	case n >= 201 && n <= 263 && (sys == 'C' || talker == "GN"):
+		sys, n = 'C', n-200
+	case n >= 301 && n <= 336 && (sys == 'E' || talker == "GN"):
+		sys, n = 'E', n-300
+	case talker == "GN" && n <= 32:
+		sys = 'G'
+	case !ok || talker == "GN":
+		return [3]byte{}, false
+	}
+	if n < 1 || n > 99 {
+		return [3]byte{}, false
+	}
+	return [3]byte{sys, byte('0' + n/10), byte('0' + n%10)}, true
+}
+
+/************************ TOP LEVEL FUNCTIONS ************************/
+
+// ParseSentence parses one NMEA sentence.  It returns a *GGA, *RMC,
+// *GSA, *GSV or *GST.  The Time fields of the result use date (which
+// should be midnight UTC) for the date, except that an RMC sentence
+// supplies its own date.
+func ParseSentence(line string, date time.Time) (interface{}, error) {
+	talker, kind, f, err := splitSentence(line)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "GGA":
+		return parseGGA(talker, f, date)
+	case "RMC":
+		return parseRMC(talker, f)
+	case "GSA":
+		return parseGSA(talker, f)
+	case "GSV":
+		return parseGSV(talker, f)
+	case "GST":
+		return parseGST(talker, f, date)
+	}
+	return nil, ErrUnsupported
+}
+
+// Detect reports whether head contains a complete NMEA sentence with a
+// checksum.
+func Detect(head []byte) bool {
+	for _, line := range strings.Split(string(head), "\n") {
+		idx := strings.IndexByte(line, '$')
+		if idx < 0 || !strings.Contains(line[idx:], "*") {
+			continue
+		}
+		if _, _, _, err := splitSentence(line[idx:]); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func parseGGA(talker string, f []string, date time.Time) (*GGA, error) {
+	if len(f) < 14 {
+		return nil, ErrMalformed
+	}
+	tod, err := parseTimeOfDay(f[0])
+	if err != nil {
+		return nil, err
+	}
+	res := &GGA{Talker: talker, Time: date.Add(tod)}
+	var errs [9]error
+	res.Latitude, errs[0] = parseLatLon(f[1], f[2])
+	res.Longitude, errs[1] = parseLatLon(f[3], f[4])
+	res.Quality, errs[2] = parseInt(f[5])
+	res.NumSats, errs[3] = parseInt(f[6])
+	res.HDOP, errs[4] = parseFloat(f[7])
+	res.Altitude, errs[5] = parseFloat(f[8])
+	res.GeoidSeparation, errs[6] = parseFloat(f[10])
+	res.DGPSAge, errs[7] = parseFloat(f[12])
+	res.DGPSStation, errs[8] = parseInt(f[13])
+	for _, err := range errs {
+		if err != nil {
+			return nil, ErrMalformed
+		}
+	}
+	return res, nil
+}
+
+func parseRMC(talker string, f []string) (*RMC, error) {
+	if len(f) < 11 {
+		return nil, ErrMalformed
+	}
+	tod, err := parseTimeOfDay(f[0])
+	if err != nil {
+		return nil, err
+	}
+	date, err := parseDate(f[8])
+	if err != nil {
+		return nil, err
+	}
+	res := &RMC{Talker: talker, Time: date.Add(tod), Valid: f[1] == "A"}
+	var errs [5]error
+	res.Latitude, errs[0] = parseLatLon(f[2], f[3])
+	res.Longitude, errs[1] = parseLatLon(f[4], f[5])
+	res.Speed, errs[2] = parseFloat(f[6])
+	res.Course, errs[3] = parseFloat(f[7])
+	res.MagneticVariation, errs[4] = parseFloat(f[9])
+	for _, err := range errs {
+		if err != nil {
+			return nil, ErrMalformed
+		}
+	}
+	if f[10] == "W" {
+		res.MagneticVariation = -res.MagneticVariation
+	}
+	if len(f) > 11 && len(f[11]) > 0 {
+		res.Mode = f[11][0]
+	}
+	return res, nil
+}
+
+func parseGSA(talker string, f []string) (*GSA, error) {
+	if len(f) < 17 {
+		return nil, ErrMalformed
+	}
+	res := &GSA{Talker: talker, Automatic: f[0] == "A"}
+	var err error
+	if res.FixType, err = parseInt(f[1]); err != nil {
+		return nil, ErrMalformed
+	}
+	if len(f) > 17 {
+		if res.SystemID, err = parseInt(f[17]); err != nil {
+			return nil, ErrMalformed
+		}
+	}
+	// NMEA 4.11 identifies the GNSS by system ID rather than talker.
+	satTalker := talker
+	switch res.SystemID {
+	case 1:
+		satTalker = "GP"
+	case 2:
+		satTalker = "GL"
+	case 3:
+		satTalker = "GA"
+	case 4:
+		satTalker = "GB"
+	case 5:
+		satTalker = "GQ"
+	case 6:
+		satTalker = "GI"
+	}
+	for _, s := range f[2:14] {
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		if prn, ok := satID(satTalker, n); ok {
+			res.Sats = append(res.Sats, prn)
+		}
+	}
+	var errs [3]error
+	res.PDOP, errs[0] = parseFloat(f[14])
+	res.HDOP, errs[1] = parseFloat(f[15])
+	res.VDOP, errs[2] = parseFloat(f[16])
+	for _, err := range errs {
+		if err != nil {
+			return nil, ErrMalformed
+		}
+	}
+	return res, nil
+}
+
+func parseGSV(talker string, f []string) (*GSV, error) {
+	if len(f) < 3 {
+		return nil, ErrMalformed
+	}
+	res := &GSV{Talker: talker}
+	var errs [3]error
+	res.Count, errs[0] = parseInt(f[0])
+	res.Index, errs[1] = parseInt(f[1])
+	res.InView, errs[2] = parseInt(f[2])
+	for _, err := range errs {
+		if err != nil {
+			return nil, ErrMalformed
+		}
+	}
+	f = f[3:]
+	if len(f)%4 == 1 {
+		id, err := strconv.ParseUint(f[len(f)-1], 16, 8)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		res.SignalID = int(id)
+		f = f[:len(f)-1]
+	}
+	if len(f)%4 != 0 {
+		return nil, ErrMalformed
+	}
+	for ; len(f) > 0; f = f[4:] {
+		if f[0] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(f[0])
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		prn, ok := satID(talker, n)
+		if !ok {
+			continue
+		}
+		sv := SatInView{PRN: prn}
+		sv.Elevation, errs[0] = parseInt(f[1])
+		sv.Azimuth, errs[1] = parseInt(f[2])
+		sv.CN0, errs[2] = parseInt(f[3])
+		for _, err := range errs {
+			if err != nil {
+				return nil, ErrMalformed
+			}
+		}
+		res.Sats = append(res.Sats, sv)
+	}
+	return res, nil
+}
+
+func parseGST(talker string, f []string, date time.Time) (*GST, error) {
+	if len(f) < 8 {
+		return nil, ErrMalformed
+	}
+	tod, err := parseTimeOfDay(f[0])
+	if err != nil {
+		return nil, err
+	}
+	res := &GST{Talker: talker, Time: date.Add(tod)}
+	var errs [7]error
+	res.RMS, errs[0] = parseFloat(f[1])
+	res.SemiMajor, errs[1] = parseFloat(f[2])
+	res.SemiMinor, errs[2] = parseFloat(f[3])
+	res.Orientation, errs[3] = parseFloat(f[4])
+	res.LatitudeError, errs[4] = parseFloat(f[5])
+	res.LongitudeError, errs[5] = parseFloat(f[6])
+	res.AltitudeError, errs[6] = parseFloat(f[7])
+	for _, err := range errs {
+		if err != nil {
+			return nil, ErrMalformed
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,159 @@
+package nmea
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/entrope/gnss/rinex"
+)
+
+// sentence adds the framing and checksum to body.
+func sentence(body string) string {
+	var sum byte
+	for i := 0; i < len(body); i++ {
+		sum ^= body[i]
+	}
+	return fmt.Sprintf("$%s*%02X", body, sum)
+}
+
+func TestParseSentence(t *testing.T) {
+	date := time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC)
+	s, err := ParseSentence(sentence("GPGGA,123519.50,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,"), date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gga := s.(*GGA)
+	if !gga.Time.Equal(date.Add(12*time.Hour + 35*time.Minute + 19500*time.Millisecond)) {
+		t.Errorf("GGA time = %v", gga.Time)
+	}
+	if math.Abs(gga.Latitude-48.1173) > 1e-9 || math.Abs(gga.Longitude-11.516667) > 1e-6 ||
+		gga.Quality != 1 || gga.NumSats != 8 || gga.Altitude != 545.4 {
+		t.Errorf("GGA = %+v", gga)
+	}
+
+	s, err = ParseSentence(sentence("GPRMC,235959,A,4807.038,S,01131.000,W,022.4,084.4,230394,003.1,W"), date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rmc := s.(*RMC)
+	if !rmc.Time.Equal(time.Date(1994, 3, 23, 23, 59, 59, 0, time.UTC)) ||
+		!rmc.Valid || rmc.Latitude >= 0 || rmc.Longitude >= 0 ||
+		rmc.MagneticVariation != -3.1 {
+		t.Errorf("RMC = %+v", rmc)
+	}
+
+	s, err = ParseSentence(sentence("GNGSA,A,3,80,71,73,79,69,,,,,,,,1.83,1.09,1.47,2"), date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gsa := s.(*GSA)
+	if gsa.FixType != 3 || gsa.SystemID != 2 || len(gsa.Sats) != 5 ||
+		string(gsa.Sats[0][:]) != "R16" {
+		t.Errorf("GSA = %+v", gsa)
+	}
+
+	s, err = ParseSentence(sentence("GPGSV,3,1,11,03,03,111,00,04,15,270,00,06,01,010,00,46,06,292,32,6"), date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gsv := s.(*GSV)
+	if gsv.Count != 3 || gsv.Index != 1 || gsv.InView != 11 || gsv.SignalID != 6 ||
+		len(gsv.Sats) != 4 || string(gsv.Sats[3].PRN[:]) != "S33" || gsv.Sats[3].CN0 != 32 {
+		t.Errorf("GSV = %+v", gsv)
+	}
+
+	s, err = ParseSentence(sentence("GPGST,172814.0,0.006,0.023,0.020,273.6,0.023,0.020,0.031"), date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gst := s.(*GST); gst.RMS != 0.006 || gst.AltitudeError != 0.031 {
+		t.Errorf("GST = %+v", gst)
+	}
+
+	bad := sentence("GPGST,172814.0,0.006,0.023,0.020,273.6,0.023,0.020,0.031")
+	bad = strings.Replace(bad, "273.6", "274.6", 1)
+	if _, err = ParseSentence(bad, date); err != ErrChecksum {
+		t.Errorf("bad checksum gave %v", err)
+	}
+	if _, err = ParseSentence(sentence("GPVTG,,T,,M,0.0,N,0.0,K,A"), date); err != ErrUnsupported {
+		t.Errorf("VTG gave %v", err)
+	}
+}
+
+func TestReader(t *testing.T) {
+	lines := []string{
+		sentence("GPGSV,1,1,01,05,40,083,46"),
+		sentence("GPRMC,235959.00,A,4807.038,N,01131.000,E,0.0,0.0,110124,,,A"),
+		sentence("GPGGA,235959.00,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,"),
+		sentence("GPGSV,2,1,05,05,40,083,46,12,62,210,,13,10,045,38,46,06,292,32,1"),
+		sentence("GPGSV,2,2,05,25,33,300,41,1"),
+		sentence("GPGSV,1,1,02,05,40,083,40,13,10,045,30,6"),
+		"2024-01-11T23:59:59 garbage line",
+		sentence("GLGSV,1,1,01,70,20,100,44,1"),
+		sentence("GPGGA,000000.00,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,"),
+		sentence("GPGSV,1,1,01,05,40,083,47,1"),
+	}
+	var nr Reader
+	var recs []rinex.ObservationRecord
+	var ggaTimes []time.Time
+	nr.ObsFunc = func(rec rinex.ObservationRecord) error {
+		recs = append(recs, rec)
+		return nil
+	}
+	nr.GGAFunc = func(s *GGA) error {
+		ggaTimes = append(ggaTimes, s.Time)
+		return nil
+	}
+	if err := nr.Parse(strings.NewReader(strings.Join(lines, "\r\n"))); err != nil {
+		t.Fatal(err)
+	}
+
+	wantTime := time.Date(2024, 1, 12, 0, 0, 0, 0, time.UTC)
+	if len(ggaTimes) != 2 || !ggaTimes[1].Equal(wantTime) {
+		t.Errorf("GGA times = %v", ggaTimes)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("got %d records, want 2", len(recs))
+	}
+	if !recs[1].Time().Equal(wantTime) || !nr.Header.LastObs.Equal(wantTime) {
+		t.Errorf("second record at %v", recs[1].Time())
+	}
+
+	wantTypes := map[byte]string{
+		'G': "S1C S2L ",
+		'R': "S1C ",
+		'S': "S1C ",
+	}
+	for sys, want := range wantTypes {
+		got := ""
+		for _, obsType := range nr.Observations[sys] {
+			got += string(obsType[:]) + " "
+		}
+		if got != want {
+			t.Errorf("Observations[%c] = %q, want %q", sys, got, want)
+		}
+	}
+
+	sv := recs[0].Sat
+	want := map[string][]float64{
+		"G05": {46, 40},
+		"G13": {38, 30},
+		"G25": {41},
+		"R06": {44},
+		"S33": {32},
+	}
+	if len(sv) != len(want) {
+		t.Fatalf("got satellites %v", sv)
+	}
+	for _, s := range sv {
+		w := want[string(s.PRN[:])]
+		for i, v := range w {
+			if s.Obs[i].Value != v {
+				t.Errorf("%s obs %d = %v, want %v", s.PRN, i, s.Obs[i].Value, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package nmea
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/entrope/gnss/rinex"
+)
+
+// signalCodes maps from a GNSS and NMEA 4.10 signal ID to the RINEX
+// band and attribute.  Signal ID 0 means the sentence does not say, and
+// maps to the usual civil signal.
+var signalCodes = map[byte]map[int]string{
+	'G': {0: "1C", 1: "1C", 2: "1W", 3: "1M", 4: "2W", 5: "2S", 6: "2L",
+		7: "5I", 8: "5Q"},
+	'R': {0: "1C", 1: "1C", 2: "1P", 3: "2C", 4: "2P"},
+	'E': {0: "1X", 1: "5X", 2: "7X", 3: "8X", 4: "6A", 5: "6X", 6: "1A",
+		7: "1X"},
+	'C': {0: "2I", 1: "2I", 2: "2Q", 3: "1X", 4: "1A", 5: "5X", 6: "7D",
+		7: "8X", 8: "6I", 9: "6Q", 10: "6A", 11: "7I", 12: "7Q"},
+	'J': {0: "1C", 1: "1C", 2: "1S", 3: "1L", 5: "2S", 6: "2L", 7: "5I",
+		8: "5Q", 10: "6S", 11: "6E"},
+	'S': {0: "1C", 1: "1C"},
+	'I': {0: "5A", 1: "5A", 2: "9A"},
+}
+
+// Reader reads NMEA sentences from a log.
+type Reader struct {
+	// GGAFunc, if not nil, is called for each GGA sentence.  If it
+	// returns non-nil, parsing stops.
+	GGAFunc func(s *GGA) error
+
+	// RMCFunc, if not nil, is called for each RMC sentence.  If it
+	// returns non-nil, parsing stops.
+	RMCFunc func(s *RMC) error
+
+	// GSAFunc, if not nil, is called for each GSA sentence.  If it
+	// returns non-nil, parsing stops.
+	GSAFunc func(s *GSA) error
+
+	// GSVFunc, if not nil, is called for each GSV sentence.  If it
+	// returns non-nil, parsing stops.
+	GSVFunc func(s *GSV) error
+
+	// GSTFunc, if not nil, is called for each GST sentence.  If it
+	// returns non-nil, parsing stops.
+	GSTFunc func(s *GST) error
+
+	// ObsFunc, if not nil, is called once per epoch with the C/N0
+	// values from that epoch's GSV sentences, as S observations.  GSV
+	// sentences belong to the epoch of the latest GGA, RMC or GST
+	// sentence before them.  If it returns non-nil, parsing stops.
+	ObsFunc func(rec rinex.ObservationRecord) error
+
+	// Date is the UTC date (at midnight) of the sentences being read.
+	// RMC sentences update it, and it advances when the time of day
+	// wraps around.  Callers may set it before calling Parse for logs
+	// that have no RMC sentences; otherwise, sentences before the
+	// first RMC sentence have times on January 1 of year 1, and their
+	// epochs are not passed to ObsFunc.
+	Date time.Time
+
+	// Header holds the observation header values that the log implies:
+	// the time system, signal strength unit, and first and last
+	// observation times.
+	Header rinex.ObsHeader
+
+	// Observations lists the types of observations for each GNSS, as
+	// in rinex.ObsReader.  Types are added as new signals are seen.
+	Observations map[byte][][3]byte
+
+	// obsIndex maps from a GNSS and observation type to its index in
+	// Observations.
+	obsIndex map[byte]map[[3]byte]int
+
+	// tod is the time of day of the latest timed sentence, or -1.
+	tod time.Duration
+
+	// epoch is the time of the current epoch.
+	epoch time.Time
+
+	// cn0 holds the C/N0 values for the current epoch.
+	cn0 map[[3]byte][]cellObs
+}
+
+// cellObs is one observation for a satellite in the current epoch.
+type cellObs struct {
+	obsType [3]byte
+	value   float64
+}
+
+/************************ TOP LEVEL FUNCTIONS ************************/
+
+// Parse reads NMEA sentences from r and runs the callback functions in
+// nr.  Lines that are not sentences, or that have bad checksums, are
+// skipped; text before the '$' of a sentence, such as a logger's
+// timestamp, is ignored.
+func (nr *Reader) Parse(r io.Reader) error {
+	nr.Observations = make(map[byte][][3]byte)
+	nr.obsIndex = make(map[byte]map[[3]byte]int)
+	nr.cn0 = make(map[[3]byte][]cellObs)
+	nr.tod = -1
+	nr.epoch = time.Time{}
+	nr.Header.TimeSystem = "UTC"
+	nr.Header.SignalStrengthUnit = "DBHZ"
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		idx := strings.IndexByte(line, '$')
+		if idx < 0 {
+			continue
+		}
+		s, err := ParseSentence(line[idx:], nr.Date)
+		if err != nil {
+			continue
+		}
+		if err = nr.handle(s); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return nr.flush()
+}
+
+/************************** HELPER FUNCTIONS **************************/
+
+// handle processes one parsed sentence.
+func (nr *Reader) handle(s interface{}) error {
+	switch s := s.(type) {
+	case *GGA:
+		s.Time = nr.setTime(s.Time, false)
+		if err := nr.nextEpoch(s.Time); err != nil {
+			return err
+		}
+		if nr.GGAFunc != nil {
+			return nr.GGAFunc(s)
+		}
+	case *RMC:
+		s.Time = nr.setTime(s.Time, true)
+		if err := nr.nextEpoch(s.Time); err != nil {
+			return err
+		}
+		if nr.RMCFunc != nil {
+			return nr.RMCFunc(s)
+		}
+	case *GST:
+		s.Time = nr.setTime(s.Time, false)
+		if err := nr.nextEpoch(s.Time); err != nil {
+			return err
+		}
+		if nr.GSTFunc != nil {
+			return nr.GSTFunc(s)
+		}
+	case *GSA:
+		if nr.GSAFunc != nil {
+			return nr.GSAFunc(s)
+		}
+	case *GSV:
+		nr.addGSV(s)
+		if nr.GSVFunc != nil {
+			return nr.GSVFunc(s)
+		}
+	}
+	return nil
+}
+
+// setTime tracks the date across sentences.  t is a sentence's time,
+// using nr.Date for the date unless hasDate is true.  It returns the
+// corrected time.
+func (nr *Reader) setTime(t time.Time, hasDate bool) time.Time {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	tod := t.Sub(midnight)
+	if hasDate {
+		nr.Date = midnight
+	} else if nr.tod >= 0 && tod < nr.tod-12*time.Hour {
+		// The time of day wrapped around midnight.
+		if !nr.Date.IsZero() {
+			nr.Date = nr.Date.AddDate(0, 0, 1)
+		}
+		t = nr.Date.Add(tod)
+	}
+	nr.tod = tod
+	return t
+}
+
+// nextEpoch starts a new epoch at t, flushing the previous one if t is
+// a different time.
+func (nr *Reader) nextEpoch(t time.Time) error {
+	if t.Equal(nr.epoch) {
+		return nil
+	}
+	err := nr.flush()
+	nr.epoch = t
+	return err
+}
+
+// addGSV adds the C/N0 values from s to the current epoch.
+func (nr *Reader) addGSV(s *GSV) {
+	for _, sv := range s.Sats {
+		if sv.CN0 == 0 {
+			continue
+		}
+		sys := sv.PRN[0]
+		code, ok := signalCodes[sys][s.SignalID]
+		if !ok {
+			continue
+		}
+		obsType := [3]byte{'S', code[0], code[1]}
+		dup := false
+		for _, c := range nr.cn0[sv.PRN] {
+			dup = dup || c.obsType == obsType
+		}
+		if dup {
+			continue
+		}
+		index := nr.obsIndex[sys]
+		if index == nil {
+			index = make(map[[3]byte]int)
+			nr.obsIndex[sys] = index
+		}
+		if _, ok := index[obsType]; !ok {
+			index[obsType] = len(nr.Observations[sys])
+			nr.Observations[sys] = append(nr.Observations[sys], obsType)
+		}
+		nr.cn0[sv.PRN] = append(nr.cn0[sv.PRN], cellObs{obsType, float64(sv.CN0)})
+	}
+}
+
+// flush reports the current epoch's C/N0 values, if there are any and
+// the date is known.
+func (nr *Reader) flush() error {
+	if len(nr.cn0) == 0 {
+		return nil
+	}
+	t, sats := nr.epoch, nr.cn0
+	nr.cn0 = make(map[[3]byte][]cellObs)
+	if t.Year() < 1980 {
+		return nil
+	}
+
+	var rec rinex.ObservationRecord
+	rec.SetTime(t)
+	for prn, cells := range sats {
+		sv := rinex.SVObservation{
+			PRN: prn,
+			Obs: make([]rinex.Observation, len(nr.Observations[prn[0]])),
+		}
+		for _, c := range cells {
+			sv.Obs[nr.obsIndex[prn[0]][c.obsType]] = rinex.Observation{
+				Value:   c.value,
+				Present: true,
+			}
+		}
+		rec.Sat = append(rec.Sat, sv)
+	}
+	sort.Slice(rec.Sat, func(i, j int) bool {
+		return string(rec.Sat[i].PRN[:]) < string(rec.Sat[j].PRN[:])
+	})
+
+	if nr.Header.FirstObs.IsZero() {
+		nr.Header.FirstObs = t
+	}
+	nr.Header.LastObs = t
+	if nr.ObsFunc != nil {
+		return nr.ObsFunc(rec)
+	}
+	return nil
+}
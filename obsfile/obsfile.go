@@ -0,0 +1,185 @@
+// Package obsfile reads GNSS observations from RINEX observation files
+// or from raw receiver logs, choosing the decoder from the contents of
+// the stream rather than its name.
+//
+// It recognizes RINEX (and, through rinex.Open, Compact RINEX), RTCM
+// 3, u-blox UBX, Septentrio SBF, BINEX, and NMEA 0183 logs (which
+// only provide C/N0 values).
+package obsfile
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/entrope/gnss/binex"
+	"github.com/entrope/gnss/nmea"
+	"github.com/entrope/gnss/rinex"
+	"github.com/entrope/gnss/rtcm3"
+	"github.com/entrope/gnss/sbf"
+	"github.com/entrope/gnss/ubx"
+)
+
+// Format identifies the encoding of an observation stream.
+type Format int
+
+const (
+	// RINEX is a RINEX observation file.
+	RINEX Format = iota
+
+	// RTCM3 is an RTCM 3 message stream.
+	RTCM3
+
+	// UBX is a u-blox UBX log.
+	UBX
+
+	// SBF is a Septentrio Binary Format log.
+	SBF
+
+	// BINEX is a BINEX log.
+	BINEX
+
+	// NMEA is an NMEA 0183 log.
+	NMEA
+)
+
+// String returns the usual name of f.
+func (f Format) String() string {
+	switch f {
+	case RINEX:
+		return "RINEX"
+	case RTCM3:
+		return "RTCM3"
+	case UBX:
+		return "UBX"
+	case SBF:
+		return "SBF"
+	case BINEX:
+		return "BINEX"
+	case NMEA:
+		return "NMEA"
+	}
+	return "unknown"
+}
+
+// sniffSize is how many bytes of a stream Parse looks at to choose the
+// decoder.  It is enough to hold several messages of any format.
+const sniffSize = 16384
+
+// Sniff returns the format of a stream that starts with head.  Binary
+// formats are recognized by a complete message with a valid checksum,
+// checking the formats with stronger checks first; NMEA, which raw
+// logs often include, is checked last.  If head does not look like any
+// of these formats, Sniff returns RINEX.
+func Sniff(head []byte) Format {
+	line := head
+	if idx := bytes.IndexByte(line, '\n'); idx >= 0 {
+		line = line[:idx]
+	}
+	if bytes.Contains(line, []byte("RINEX VERSION / TYPE")) {
+		return RINEX
+	}
+	switch {
+	case sbf.Detect(head):
+		return SBF
+	case ubx.Detect(head):
+		return UBX
+	case rtcm3.Detect(head):
+		return RTCM3
+	case binex.Detect(head):
+		return BINEX
+	case nmea.Detect(head):
+		return NMEA
+	}
+	return RINEX
+}
+
+// Reader reads observations from a stream in any supported format.
+type Reader struct {
+	// ObsFunc is a function that is called for each observation
+	// record.  If it returns non-nil, parsing stops.
+	ObsFunc func(rec rinex.ObservationRecord) error
+
+	// EventFunc, if not nil, is called for each special event record
+	// in RINEX input, as rinex.ObsReader.EventFunc.  Raw receiver logs
+	// do not have special event records.
+	EventFunc func(ev rinex.Event) error
+
+	// Header holds the observation header.  For raw receiver logs, it
+	// holds what the decoder has learned so far; see, for example,
+	// rtcm3.Decoder.Header.
+	Header rinex.ObsHeader
+
+	// Observations lists the types of observations for each GNSS, as
+	// in rinex.ObsReader.  For raw receiver logs, types are added as
+	// new signals are seen.
+	Observations map[byte][][3]byte
+
+	// Format is the format that Parse found.
+	Format Format
+}
+
+/************************ TOP LEVEL FUNCTIONS ************************/
+
+// Parse detects the format of r, decodes it, and runs the callback
+// functions in or.
+func (or *Reader) Parse(r io.Reader) error {
+	br := bufio.NewReaderSize(r, sniffSize)
+	head, err := br.Peek(sniffSize)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	or.Format = Sniff(head)
+
+	switch or.Format {
+	case RTCM3:
+		d := &rtcm3.Decoder{}
+		d.ObsFunc = or.relay(&d.Header, &d.Observations)
+		err = d.Parse(br)
+		or.Header, or.Observations = d.Header, d.Observations
+	case UBX:
+		d := &ubx.Decoder{}
+		d.ObsFunc = or.relay(&d.Header, &d.Observations)
+		err = d.Parse(br)
+		or.Header, or.Observations = d.Header, d.Observations
+	case SBF:
+		d := &sbf.Decoder{}
+		d.ObsFunc = or.relay(&d.Header, &d.Observations)
+		err = d.Parse(br)
+		or.Header, or.Observations = d.Header, d.Observations
+	case BINEX:
+		d := &binex.Decoder{}
+		d.ObsFunc = or.relay(&d.Header, &d.Observations)
+		err = d.Parse(br)
+		or.Header, or.Observations = d.Header, d.Observations
+	case NMEA:
+		d := &nmea.Reader{}
+		d.ObsFunc = or.relay(&d.Header, &d.Observations)
+		err = d.Parse(br)
+		or.Header, or.Observations = d.Header, d.Observations
+	default:
+		d := &rinex.ObsReader{}
+		d.ObsFunc = or.relay(&d.Header, &d.Observations)
+		if or.EventFunc != nil {
+			d.EventFunc = func(ev rinex.Event) error {
+				or.Header, or.Observations = d.Header, d.Observations
+				return or.EventFunc(ev)
+			}
+		}
+		err = d.Parse(br)
+		or.Header, or.Observations = d.Header, d.Observations
+	}
+	return err
+}
+
+// relay returns an ObsFunc for a decoder that copies the decoder's
+// header and observation types to or before calling or.ObsFunc.
+func (or *Reader) relay(header *rinex.ObsHeader, obs *map[byte][][3]byte) func(rinex.ObservationRecord) error {
+	return func(rec rinex.ObservationRecord) error {
+		or.Header, or.Observations = *header, *obs
+		if or.ObsFunc == nil {
+			return nil
+		}
+		return or.ObsFunc(rec)
+	}
+}
@@ -0,0 +1,59 @@
+package obsfile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/entrope/gnss/rinex"
+)
+
+func TestSniff(t *testing.T) {
+	cases := []struct {
+		head []byte
+		want Format
+	}{
+		{[]byte("     3.04           OBSERVATION DATA    M                   RINEX VERSION / TYPE\n"), RINEX},
+		// The RTCM 1005 example message from RTCM 10403.
+		{[]byte("\x00\x11\xd3\x00\x13\x3e\xd7\xd3\x02\x02\x98\x0e\xde\xef\x34\xb4\xbd\x62\xac\x09\x41\x98\x6f\x33\x36\x0b\x98"), RTCM3},
+		// A UBX-NAV-CLOCK poll request.
+		{[]byte("\xb5\x62\x01\x22\x00\x00\x23\x6a"), UBX},
+		{[]byte("unknown"), RINEX},
+	}
+	for i, c := range cases {
+		if got := Sniff(c.head); got != c.want {
+			t.Errorf("case %d: Sniff() = %v, want %v", i, got, c.want)
+		}
+	}
+}
+
+func TestParseRINEX(t *testing.T) {
+	input := strings.Join([]string{
+		"     3.04           OBSERVATION DATA    G                   RINEX VERSION / TYPE",
+		"G    2 C1C S1C                                              SYS / # / OBS TYPES ",
+		"                                                            END OF HEADER       ",
+		"> 2024 01 11 00 00  0.0000000  0  1",
+		"G05  21234567.891          45.000  ",
+		"",
+	}, "\n")
+	var or Reader
+	var recs []rinex.ObservationRecord
+	or.ObsFunc = func(rec rinex.ObservationRecord) error {
+		recs = append(recs, rec)
+		return nil
+	}
+	if err := or.Parse(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if or.Format != RINEX {
+		t.Errorf("Format = %v, want RINEX", or.Format)
+	}
+	if len(recs) != 1 || len(recs[0].Sat) != 1 {
+		t.Fatalf("got records %+v", recs)
+	}
+	if got := recs[0].Sat[0].Obs[1].Value; got != 45 {
+		t.Errorf("G05 S1C = %v, want 45", got)
+	}
+	if len(or.Observations['G']) != 2 {
+		t.Errorf("Observations = %q", or.Observations)
+	}
+}
@@ -0,0 +1,485 @@
+package rinex
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClockRecord holds one clock data record.
+type ClockRecord struct {
+	// Type is the record type: "AR" for a receiver (station) clock,
+	// "AS" for a satellite clock, "CR" for a calibration, "DR" for a
+	// discontinuity or "MS" for a monitor measurement.
+	Type string
+
+	// Name is the station name or satellite ID.
+	Name string
+
+	// Time is the epoch of the record, in the file's time system (see
+	// ClockReader.TimeSystem).
+	Time time.Time
+
+	// Values holds the clock bias (s), its sigma (s), and optionally
+	// the clock rate (s/s), its sigma, the clock acceleration (1/s) and
+	// its sigma.  The reader reuses Values for the next record.
+	Values []float64
+}
+
+// ClockReference describes one reference clock from an ANALYSIS CLK REF
+// header.
+type ClockReference struct {
+	// Name is the station name or satellite ID of the clock.
+	Name string
+
+	// ID is the clock's identifier, such as a DOMES number.
+	ID string
+
+	// Constraint is the a priori clock constraint (s), or zero if
+	// none was given.
+	Constraint float64
+
+	// Start and End bound the time when the clock was a reference
+	// clock.  They are zero if the preceding # OF CLK REF header did
+	// not give them.
+	Start, End time.Time
+}
+
+// ClockStation describes a station from a SOLN STA NAME / NUM header.
+type ClockStation struct {
+	// Name is the station name.
+	Name string
+
+	// ID is the station's identifier, such as a DOMES number.
+	ID string
+
+	// Position is the ECEF position of the station (m).
+	Position [3]float64
+}
+
+// ClockReader reads RINEX clock files, such as the IGS .clk products,
+// in RINEX clock 2 and 3.0x formats.
+type ClockReader struct {
+	// HeaderFunc is a function that is called for each header line.
+	// label starts at the 61st column (the 66th for RINEX clock 3.04
+	// SOLN STA NAME / NUM headers), and is always 20 bytes long.  If
+	// HeaderFunc returns non-nil, parsing stops.
+	HeaderFunc func(label, value string) error
+
+	// RecordFunc is a function that is called for each data record.
+	// If it returns non-nil, parsing stops.
+	RecordFunc func(rec ClockRecord) error
+
+	// TimeSystem is the time system of the file's epochs, from the
+	// TIME SYSTEM ID header, or "GPS" if there is no such header.
+	TimeSystem string
+
+	// LeapSeconds is the number of leap seconds since 6 January 1980,
+	// if the header gave it.
+	LeapSeconds int
+
+	// DataTypes lists the types of data records in the file, from the
+	// # / TYPES OF DATA header.
+	DataTypes []string
+
+	// AnalysisCenter is the three-character ID of the analysis center,
+	// and AnalysisCenterName is its full name.
+	AnalysisCenter, AnalysisCenterName string
+
+	// References lists the reference clocks.
+	References []ClockReference
+
+	// Frame names the terrestrial reference frame of Stations.
+	Frame string
+
+	// Stations lists the stations in the clock solution.
+	Stations []ClockStation
+
+	// version is the RINEX version number for the stream.
+	version int
+
+	// inHeader is true when we are parsing the RINEX header.
+	inHeader bool
+
+	// refStart and refEnd hold the epochs from the most recent
+	// # OF CLK REF header.
+	refStart, refEnd time.Time
+
+	// rec holds the record that is currently being read.
+	rec ClockRecord
+
+	// count is the number of values in rec.
+	count int
+}
+
+/************************ TOP LEVEL FUNCTIONS ************************/
+
+// Parse reads RINEX clock data from r and runs the callback functions
+// in cr.
+func (cr *ClockReader) Parse(r io.Reader) error {
+	cr.inHeader = true
+	cr.version = 0
+	cr.count = 0
+	cr.rec.Values = cr.rec.Values[:0]
+	cr.TimeSystem = "GPS"
+	cr.DataTypes = nil
+	cr.References = nil
+	cr.Stations = nil
+	s := bufio.NewScanner(r)
+
+	for s.Scan() {
+		// Space-pad the input to 80 characters.
+		line := s.Text()
+		if len(line) < 80 {
+			line += strings.Repeat(" ", 80-len(line))
+		}
+
+		if cr.inHeader {
+			if err := cr.handleHeader(line); err != nil {
+				return err
+			}
+		} else if err := cr.parseData(line); err != nil {
+			return err
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if len(cr.rec.Values) < cr.count {
+		return errors.New("Clock record is truncated")
+	}
+	return nil
+}
+
+// handleHeader parses a RINEX clock header line.
+func (cr *ClockReader) handleHeader(line string) error {
+	var err error
+	value := line[:60]
+	label := line[60:80]
+	if len(line) > 80 {
+		// RINEX clock 3.04 moves this label to make room for longer
+		// station names.
+		if len(line) > 85 || !strings.HasPrefix(line[65:], "SOLN STA NAME / NUM") {
+			return errors.New("Oversized input line")
+		}
+		value = line[:65]
+		label = line[65:] + strings.Repeat(" ", 85-len(line))
+	}
+
+	if handler := clockSpecialHeaders[label]; handler != nil {
+		err = handler(cr, value)
+	}
+
+	if err == nil && cr.HeaderFunc != nil {
+		err = cr.HeaderFunc(label, value)
+	}
+
+	return err
+}
+
+/*************************** RECORD PARSING ***************************/
+
+// parseData parses the first or a continuation line of a data record.
+// The fields of data records are separated by spaces, but RINEX clock
+// 3.04 puts them in different columns than earlier versions, so this
+// splits the line into fields instead of using fixed columns.
+func (cr *ClockReader) parseData(line string) error {
+	fields := strings.Fields(line)
+	if len(cr.rec.Values) == cr.count {
+		// This is the first line of a record.
+		if len(fields) == 0 {
+			return nil
+		}
+		if len(fields) < 10 {
+			return errors.New("Short clock data record")
+		}
+		t, err := clockTime(fields[2:8])
+		if err != nil {
+			return err
+		}
+		count, err := strconv.Atoi(fields[8])
+		if err != nil {
+			return err
+		}
+		if count < 1 || count > 6 {
+			return errors.New("Invalid clock data value count " + fields[8])
+		}
+		cr.rec.Type = fields[0]
+		cr.rec.Name = fields[1]
+		cr.rec.Time = t
+		cr.rec.Values = cr.rec.Values[:0]
+		cr.count = count
+		fields = fields[9:]
+	}
+
+	for _, field := range fields {
+		if len(cr.rec.Values) == cr.count {
+			return errors.New("Too many values in clock data record")
+		}
+		v, err := parseNavFloat(field)
+		if err != nil {
+			return err
+		}
+		cr.rec.Values = append(cr.rec.Values, v)
+	}
+
+	if len(cr.rec.Values) < cr.count || cr.RecordFunc == nil {
+		return nil
+	}
+	return cr.RecordFunc(cr.rec)
+}
+
+// clockTime parses the year, month, day, hour, minute and second in
+// fields.
+func clockTime(fields []string) (time.Time, error) {
+	var date [5]int
+	for i := range date {
+		var err error
+		if date[i], err = strconv.Atoi(fields[i]); err != nil {
+			return time.Time{}, err
+		}
+	}
+	sec, err := parseNavFloat(fields[5])
+	if err != nil {
+		return time.Time{}, err
+	}
+	return navTime(date, sec), nil
+}
+
+/********************** HEADER PARSING FUNCTIONS **********************/
+
+// clockSpecialHeaders lists the headers that ClockReader treats
+// specially.
+var clockSpecialHeaders = map[string]func(*ClockReader, string) error{
+	"RINEX VERSION / TYPE": (*ClockReader).handleRINEXVersion,
+	"END OF HEADER       ": (*ClockReader).handleEndOfHeader,
+	"TIME SYSTEM ID      ": (*ClockReader).handleTimeSystemID,
+	"LEAP SECONDS        ": (*ClockReader).handleLeapSeconds,
+	"# / TYPES OF DATA   ": (*ClockReader).handleTypesOfData,
+	"ANALYSIS CENTER     ": (*ClockReader).handleAnalysisCenter,
+	"# OF CLK REF        ": (*ClockReader).handleNumClkRef,
+	"ANALYSIS CLK REF    ": (*ClockReader).handleAnalysisClkRef,
+	"# OF SOLN STA / TRF ": (*ClockReader).handleNumSolnSta,
+	"SOLN STA NAME / NUM ": (*ClockReader).handleSolnStaName,
+}
+
+// handleRINEXVersion handles a RINEX VERSION / TYPE header.
+func (cr *ClockReader) handleRINEXVersion(value string) error {
+	fields := strings.Fields(value)
+	if len(fields) < 2 {
+		return errors.New("Invalid RINEX VERSION / TYPE header")
+	}
+	fltVersion, err := parseFloat(fields[0], 32)
+	if err != nil {
+		return err
+	}
+	cr.version = int(fltVersion)
+	if cr.version < 2 || cr.version > 3 {
+		return errors.New("Invalid RINEX clock version " + fields[0])
+	}
+
+	if fields[1][0] != 'C' {
+		return errors.New("Expected clock file, but got " + fields[1])
+	}
+
+	return nil
+}
+
+// handleEndOfHeader handles a END OF HEADER header.
+func (cr *ClockReader) handleEndOfHeader(_ string) error {
+	if cr.version == 0 {
+		return errors.New("RINEX header did not declare its version")
+	}
+	cr.inHeader = false
+	return nil
+}
+
+// handleTimeSystemID handles a TIME SYSTEM ID header.
+func (cr *ClockReader) handleTimeSystemID(value string) error {
+	cr.TimeSystem = strings.TrimSpace(value[0:6])
+	return nil
+}
+
+// handleLeapSeconds handles a LEAP SECONDS header.
+func (cr *ClockReader) handleLeapSeconds(value string) error {
+	n, err := parseNavInt(value[0:6])
+	if err != nil {
+		return err
+	}
+	cr.LeapSeconds = n
+	return nil
+}
+
+// handleTypesOfData handles a # / TYPES OF DATA header.
+func (cr *ClockReader) handleTypesOfData(value string) error {
+	count, err := parseNavInt(value[0:6])
+	if err != nil {
+		return err
+	}
+	if count < 0 || count > 9 {
+		return errors.New("Invalid count of clock data types")
+	}
+	cr.DataTypes = make([]string, count)
+	for i := range cr.DataTypes {
+		cr.DataTypes[i] = value[10+6*i : 12+6*i]
+	}
+	return nil
+}
+
+// handleAnalysisCenter handles an ANALYSIS CENTER header.
+func (cr *ClockReader) handleAnalysisCenter(value string) error {
+	cr.AnalysisCenter = strings.TrimSpace(value[0:3])
+	cr.AnalysisCenterName = strings.TrimSpace(value[5:60])
+	return nil
+}
+
+// handleNumClkRef handles a # OF CLK REF header, which starts a group
+// of ANALYSIS CLK REF headers.
+func (cr *ClockReader) handleNumClkRef(value string) error {
+	cr.refStart = time.Time{}
+	cr.refEnd = time.Time{}
+	fields := strings.Fields(value[6:])
+	if len(fields) < 12 {
+		return nil
+	}
+	var err error
+	if cr.refStart, err = clockTime(fields[0:6]); err != nil {
+		return err
+	}
+	cr.refEnd, err = clockTime(fields[6:12])
+	return err
+}
+
+// handleAnalysisClkRef handles an ANALYSIS CLK REF header.
+func (cr *ClockReader) handleAnalysisClkRef(value string) error {
+	constraint, err := parseNavFloat(value[40:60])
+	if err != nil {
+		return err
+	}
+	// The name is four characters before RINEX clock 3.04, and nine
+	// after, so split the name and identifier at the first space.
+	fields := strings.SplitN(strings.TrimSpace(value[:40]), " ", 2)
+	ref := ClockReference{
+		Name:       fields[0],
+		Constraint: constraint,
+		Start:      cr.refStart,
+		End:        cr.refEnd,
+	}
+	if len(fields) > 1 {
+		ref.ID = strings.TrimSpace(fields[1])
+	}
+	cr.References = append(cr.References, ref)
+	return nil
+}
+
+// handleNumSolnSta handles a # OF SOLN STA / TRF header.
+func (cr *ClockReader) handleNumSolnSta(value string) error {
+	cr.Frame = strings.TrimSpace(value[10:60])
+	return nil
+}
+
+// handleSolnStaName handles a SOLN STA NAME / NUM header.
+func (cr *ClockReader) handleSolnStaName(value string) error {
+	fields := strings.Fields(value)
+	if len(fields) < 4 {
+		return errors.New("Short SOLN STA NAME / NUM header")
+	}
+	n := len(fields) - 3
+	sta := ClockStation{
+		Name: fields[0],
+		ID:   strings.Join(fields[1:n], " "),
+	}
+	for i := range sta.Position {
+		mm, err := strconv.ParseInt(fields[n+i], 10, 64)
+		if err != nil {
+			return err
+		}
+		sta.Position[i] = float64(mm) * 1e-3
+	}
+	cr.Stations = append(cr.Stations, sta)
+	return nil
+}
+
+/************************ CLOCK INTERPOLATION *************************/
+
+// ErrNoClock indicates that a ClockStore has no clock biases for the
+// requested clock around the requested time.
+var ErrNoClock = errors.New("No clock data for clock and time")
+
+// clockSample is one clock bias from a clock file.
+type clockSample struct {
+	t    time.Time
+	bias float64
+}
+
+// ClockStore holds the receiver and satellite clock biases from one or
+// more clock files, and interpolates them.
+type ClockStore struct {
+	// MaxGap is the longest time between two clock biases that Bias
+	// will interpolate across.  If it is zero, there is no limit.
+	MaxGap time.Duration
+
+	// clocks maps from a station name or satellite ID to its clock
+	// biases, in time order.
+	clocks map[string][]clockSample
+}
+
+// NewClockStore creates an empty ClockStore.
+func NewClockStore() *ClockStore {
+	return &ClockStore{clocks: make(map[string][]clockSample)}
+}
+
+// Add adds the clock bias from rec to the store, if rec is an "AR" or
+// "AS" record.  If the store already has a bias for that clock and
+// time, it keeps the old one.  Add is suitable for use as (or in) a
+// ClockReader RecordFunc.
+func (cs *ClockStore) Add(rec ClockRecord) error {
+	if rec.Type != "AR" && rec.Type != "AS" {
+		return nil
+	}
+	samples := cs.clocks[rec.Name]
+	i := sort.Search(len(samples), func(i int) bool {
+		return !samples[i].t.Before(rec.Time)
+	})
+	if i < len(samples) && samples[i].t.Equal(rec.Time) {
+		return nil
+	}
+	samples = append(samples, clockSample{})
+	copy(samples[i+1:], samples[i:])
+	samples[i] = clockSample{t: rec.Time, bias: rec.Values[0]}
+	cs.clocks[rec.Name] = samples
+	return nil
+}
+
+// Bias returns the clock bias (s) of the named station or satellite at
+// t, interpolating linearly between the biases on either side of t.
+// t must be in the clock files' time system.
+func (cs *ClockStore) Bias(name string, t time.Time) (float64, error) {
+	samples := cs.clocks[name]
+	i := sort.Search(len(samples), func(i int) bool {
+		return !samples[i].t.Before(t)
+	})
+	if i == len(samples) {
+		return math.NaN(), ErrNoClock
+	}
+	if samples[i].t.Equal(t) {
+		return samples[i].bias, nil
+	}
+	if i == 0 {
+		return math.NaN(), ErrNoClock
+	}
+
+	a, b := samples[i-1], samples[i]
+	gap := b.t.Sub(a.t)
+	if cs.MaxGap > 0 && gap > cs.MaxGap {
+		return math.NaN(), ErrNoClock
+	}
+	frac := float64(t.Sub(a.t)) / float64(gap)
+	return a.bias + frac*(b.bias-a.bias), nil
+}
@@ -0,0 +1,183 @@
+package rinex
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rinexClockV3Example is based on an IGS final clock file.
+const rinexClockV3Example = `     3.00           C                   G                   RINEX VERSION / TYPE
+CCLOCK              IGSACC @ GA & MIT   20190117 120000 UTC PGM / RUN BY / DATE
+   GPS                                                      TIME SYSTEM ID
+    18                                                      LEAP SECONDS
+     2    AR    AS                                          # / TYPES OF DATA
+IGS  IGS-ACC @ GA and MIT                                   ANALYSIS CENTER
+     1    2019 01 10 00 00  0.0000 2019 01 10 23 59 30.0000 # OF CLK REF
+AMC2 40472S004                           1.000000000000E-09 ANALYSIS CLK REF
+     2    IGS14                                             # OF SOLN STA / TRF
+AMC2 40472S004         -1248596252 -4819428284  3976506132  SOLN STA NAME / NUM
+AREQ 42202M005          1942826231 -5804070311 -1796894286  SOLN STA NAME / NUM
+                                                            END OF HEADER
+AR AMC2 2019 01 10 00 00  0.000000  2    1.234567890123E-09  1.000000000000E-11
+AR AREQ 2019 01 10 00 00  0.000000  2   -2.500000000000E-06  2.000000000000E-11
+AS G01  2019 01 10 00 00  0.000000  2   -3.601546345760E-05  1.080000000000E-11
+AS G02  2019 01 10 00 00  0.000000  4    4.500000000000E-04  2.000000000000E-11
+ 1.000000000000E-12  3.000000000000E-14
+AS G01  2019 01 10 00 00 30.000000  2   -3.601546645760E-05  1.080000000000E-11
+AS G02  2019 01 10 00 00 30.000000  4    4.500300000000E-04  2.000000000000E-11
+ 1.000000000000E-12  3.000000000000E-14
+AS G01  2019 01 10 00 01  0.000000  2   -3.601546945760E-05  1.080000000000E-11
+`
+
+// rinexClockV304Example uses the wider station names of RINEX clock
+// 3.04.
+const rinexClockV304Example = `3.04           C                   M                        RINEX VERSION / TYPE
+CCLOCK              IGSACC @ GA & MIT   20200117 120000 UTC PGM / RUN BY / DATE
+   GPS                                                      TIME SYSTEM ID
+     1    AS                                                # / TYPES OF DATA
+IGS  IGS-ACC @ GA and MIT                                   ANALYSIS CENTER
+     1                                                      # OF SOLN STA / TRF
+AMC200USA 40472S004           -1248596252 -4819428284  3976506132SOLN STA NAME / NUM
+                                                            END OF HEADER
+AR AMC200USA 2020 01 10 00 00  0.000000  2    1.500000000000E-09  1.000000000000E-11
+AS E11       2020 01 10 00 00  0.000000  1    2.500000000000E-04
+`
+
+func parseClock(t *testing.T, text string) (*ClockReader, []ClockRecord) {
+	var recs []ClockRecord
+	cr := &ClockReader{
+		RecordFunc: func(rec ClockRecord) error {
+			rec.Values = append([]float64(nil), rec.Values...)
+			recs = append(recs, rec)
+			return nil
+		},
+	}
+	if err := cr.Parse(strings.NewReader(text)); err != nil {
+		t.Fatal(err)
+	}
+	return cr, recs
+}
+
+func TestParseClockV3(t *testing.T) {
+	var labels []string
+	cr, recs := parseClock(t, rinexClockV3Example)
+	cr.HeaderFunc = func(label, value string) error {
+		labels = append(labels, label)
+		return nil
+	}
+	if err := cr.Parse(strings.NewReader(rinexClockV3Example)); err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 12 || labels[11] != "END OF HEADER       " {
+		t.Errorf("Bad header labels %q", labels)
+	}
+
+	if cr.TimeSystem != "GPS" || cr.LeapSeconds != 18 ||
+		len(cr.DataTypes) != 2 || cr.DataTypes[1] != "AS" ||
+		cr.AnalysisCenter != "IGS" || cr.AnalysisCenterName != "IGS-ACC @ GA and MIT" ||
+		cr.Frame != "IGS14" {
+		t.Errorf("Bad header fields %+v", cr)
+	}
+	start := time.Date(2019, 1, 10, 0, 0, 0, 0, time.UTC)
+	if len(cr.References) != 1 || cr.References[0].Name != "AMC2" ||
+		cr.References[0].ID != "40472S004" || cr.References[0].Constraint != 1e-9 ||
+		!cr.References[0].Start.Equal(start) ||
+		!cr.References[0].End.Equal(start.Add(86370*time.Second)) {
+		t.Errorf("Bad reference clocks %+v", cr.References)
+	}
+	if len(cr.Stations) != 2 || cr.Stations[1].Name != "AREQ" ||
+		cr.Stations[1].ID != "42202M005" || cr.Stations[1].Position[2] != -1796894.286 {
+		t.Errorf("Bad stations %+v", cr.Stations)
+	}
+
+	if len(recs) != 7 {
+		t.Fatalf("Expected 7 records, got %d", len(recs))
+	}
+	if recs[0].Type != "AR" || recs[0].Name != "AMC2" || !recs[0].Time.Equal(start) ||
+		recs[0].Values[0] != 1.234567890123e-9 {
+		t.Errorf("Bad first record %+v", recs[0])
+	}
+	if recs[5].Name != "G02" || len(recs[5].Values) != 4 || recs[5].Values[3] != 3e-14 ||
+		!recs[5].Time.Equal(start.Add(30*time.Second)) {
+		t.Errorf("Bad record with continuation %+v", recs[5])
+	}
+}
+
+func TestParseClockV304(t *testing.T) {
+	cr, recs := parseClock(t, rinexClockV304Example)
+	if len(cr.Stations) != 1 || cr.Stations[0].Name != "AMC200USA" ||
+		cr.Stations[0].ID != "40472S004" || cr.Stations[0].Position[0] != -1248596.252 {
+		t.Errorf("Bad stations %+v", cr.Stations)
+	}
+	if len(recs) != 2 || recs[0].Name != "AMC200USA" || recs[1].Name != "E11" ||
+		len(recs[1].Values) != 1 || recs[1].Values[0] != 2.5e-4 {
+		t.Errorf("Bad records %+v", recs)
+	}
+}
+
+func TestParseClockErrors(t *testing.T) {
+	for _, text := range []string{
+		strings.Replace(rinexClockV3Example, "C                   G", "O                   G", 1),
+		strings.Replace(rinexClockV3Example, "01  0.000000  2", "01  0.000000  x", 1),
+		strings.Replace(rinexClockV3Example, "     2    AR    AS", "    -1    AR    AS", 1),
+		strings.Replace(rinexClockV3Example, "     2    AR    AS", "    10    AR    AS", 1),
+		strings.Replace(rinexClockV3Example, "-3.601546945760E-05", "-3.60154694576xE-05", 1),
+		rinexClockV3Example[:strings.LastIndex(rinexClockV3Example, " 1.000000000000E-12")],
+	} {
+		cr := &ClockReader{}
+		if err := cr.Parse(strings.NewReader(text)); err == nil {
+			t.Errorf("Expected an error")
+		}
+	}
+}
+
+func TestClockStore(t *testing.T) {
+	cs := NewClockStore()
+	cr := &ClockReader{RecordFunc: cs.Add}
+	if err := cr.Parse(strings.NewReader(rinexClockV3Example)); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2019, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		offset time.Duration
+		bias   float64
+	}{
+		{"G01", 0, -3.601546345760e-05},
+		{"G01", 15 * time.Second, -3.601546495760e-05},
+		{"G01", 50 * time.Second, -3.601546845760e-05},
+		{"G01", time.Minute, -3.601546945760e-05},
+		{"G02", 10 * time.Second, 4.5001e-4},
+		{"AREQ", 0, -2.5e-6},
+	}
+	for _, test := range tests {
+		bias, err := cs.Bias(test.name, start.Add(test.offset))
+		if err != nil || math.Abs(bias-test.bias) > 1e-18 {
+			t.Errorf("%s at %v: expected %g, got %g (%v)", test.name,
+				test.offset, test.bias, bias, err)
+		}
+	}
+
+	for _, offset := range []time.Duration{-time.Second, 61 * time.Second} {
+		if _, err := cs.Bias("G01", start.Add(offset)); err != ErrNoClock {
+			t.Errorf("Expected ErrNoClock at %v, got %v", offset, err)
+		}
+	}
+	if _, err := cs.Bias("AREQ", start.Add(time.Second)); err != ErrNoClock {
+		t.Errorf("Expected ErrNoClock after last AREQ bias, got %v", err)
+	}
+
+	// Limit the gap, and add an out-of-order record.
+	cs.MaxGap = 20 * time.Second
+	if _, err := cs.Bias("G01", start.Add(15*time.Second)); err != ErrNoClock {
+		t.Errorf("Expected ErrNoClock across a gap, got %v", err)
+	}
+	cs.Add(ClockRecord{Type: "AS", Name: "G01", Time: start.Add(15 * time.Second),
+		Values: []float64{1}})
+	if bias, err := cs.Bias("G01", start.Add(15*time.Second)); err != nil || bias != 1 {
+		t.Errorf("Inserted record was not used: %g, %v", bias, err)
+	}
+}
@@ -0,0 +1,78 @@
+package rinex
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseError describes a problem with one line of RINEX input.  The
+// readers in this package return it so that callers can use errors.As
+// to report where the problem is.
+type ParseError struct {
+	// Line is the one-based line number in the input.  For Compact
+	// RINEX input, errors in the compressed text, which HatanakaReader
+	// reports, use compressed line numbers; other errors use line
+	// numbers in the decompressed text.
+	Line int
+
+	// Column and EndColumn are the one-based, inclusive range of
+	// columns that hold the offending field, as numbered in the RINEX
+	// specifications.  They are 0 if the error applies to the whole
+	// line.
+	Column, EndColumn int
+
+	// Field describes what was being parsed, such as "epoch second" or
+	// "G12 C1C observation".
+	Field string
+
+	// Text is the offending text.
+	Text string
+
+	// Err is the underlying error.
+	Err error
+}
+
+// Error formats e as a single line, such as `line 42, columns 4-17:
+// G12 C1C observation "2361909x.450": invalid syntax`.
+func (e *ParseError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "line %d", e.Line)
+	if e.Column > 0 {
+		fmt.Fprintf(&sb, ", columns %d-%d", e.Column, e.EndColumn)
+	}
+	sb.WriteString(": ")
+	if e.Field != "" {
+		sb.WriteString(e.Field)
+		sb.WriteByte(' ')
+	}
+	fmt.Fprintf(&sb, "%q: ", e.Text)
+
+	// strconv errors repeat the text, so only use their reason.
+	var numErr *strconv.NumError
+	if errors.As(e.Err, &numErr) {
+		sb.WriteString(numErr.Err.Error())
+	} else {
+		sb.WriteString(e.Err.Error())
+	}
+	return sb.String()
+}
+
+// Unwrap returns the underlying error.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Diagnostic describes a region of input that a reader skipped because
+// of an error, when it is in lenient mode.
+type Diagnostic struct {
+	// Err describes the problem that made the reader skip the region.
+	// It is usually a *ParseError.
+	Err error
+
+	// FirstLine and LastLine are the one-based line numbers of the
+	// first and last skipped lines.  Regions that HatanakaReader skips
+	// are numbered by compressed line.
+	FirstLine, LastLine int
+}
@@ -0,0 +1,150 @@
+package rinex
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// lineOf returns the one-based line number of the first occurrence of
+// substr in text.
+func lineOf(text, substr string) int {
+	return strings.Count(text[:strings.Index(text, substr)], "\n") + 1
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		text, old, new string
+		column, end    int
+		field          string
+	}{
+		{rinexV2Example, "23619095.450", "2361909x.450", 1, 14, "G12 P1 observation"},
+		{rinexV2Example, "13 10 54.0000000", "13 10 5x.0000000", 16, 26, "epoch second"},
+		{rinexV2Example, "0  6G12G09", "0  xG12G09", 30, 32, "satellite count"},
+		{rinexV3Example, "S37  36925330.673", "S37  3692533x.673", 4, 17, "S37 C1C observation"},
+		{rinexV3Example, "G21  25068559.482 5 131736161.07005", "G21  25068559.482 5 13173616x.07005", 20, 33, "G21 L1C observation"},
+		{rinexV3Example, "> 2019 01 10", "> 2019 0x 10", 8, 9, "epoch month"},
+		{rinexV3Example, "  2019     1    10     0     0    0.0000000     GPS",
+			"  2019     x    10     0     0    0.0000000     GPS", 1, 60, "TIME OF FIRST OBS header"},
+	}
+
+	for _, test := range tests {
+		text := strings.Replace(test.text, test.old, test.new, 1)
+		_, _, err := collect(text)
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Errorf("Expected ParseError for %q, got %v", test.new, err)
+			continue
+		}
+		if pe.Line != lineOf(text, test.new) || pe.Column != test.column ||
+			pe.EndColumn != test.end || pe.Field != test.field {
+			t.Errorf("Wrong position for %q: %v", test.new, pe)
+		}
+		if !strings.Contains(pe.Text, "x") {
+			t.Errorf("Wrong text for %q: %q", test.new, pe.Text)
+		}
+	}
+}
+
+func TestParseErrorCallback(t *testing.T) {
+	// Errors from callbacks are returned as they are.
+	stop := errors.New("stop")
+	or := &ObsReader{HeaderFunc: func(label, value string) error {
+		return stop
+	}}
+	if err := or.Parse(strings.NewReader(rinexV2Example)); err != stop {
+		t.Errorf("Expected callback error, got %v", err)
+	}
+}
+
+func TestParseErrorString(t *testing.T) {
+	text := strings.Replace(rinexV2Example, "23619095.450", "2361909x.450", 1)
+	_, _, err := collect(text)
+	expected := `line 30, columns 1-14: G12 P1 observation "  2361909x.450": invalid syntax`
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected %s, got %v", expected, err)
+	}
+}
+
+// parseLenient parses text in lenient mode and returns the records and
+// diagnostics.
+func parseLenient(t *testing.T, text string) (*collector, []Diagnostic) {
+	var diags []Diagnostic
+	c := &collector{}
+	or := &ObsReader{
+		HeaderFunc: c.onHeader,
+		ObsFunc:    c.onObs,
+		Lenient:    true,
+		DiagFunc: func(d Diagnostic) error {
+			diags = append(diags, d)
+			return nil
+		},
+	}
+	if err := or.Parse(strings.NewReader(text)); err != nil {
+		t.Fatal(err)
+	}
+	return c, diags
+}
+
+func TestLenient(t *testing.T) {
+	tests := []struct {
+		text, old, new string
+		lost           int
+		first, last    int
+	}{
+		// A bad observation value loses its whole epoch.
+		{rinexV2Example, "23619095.450", "2361909x.450", 1, 29, 35},
+		// So does a bad PRN list.
+		{rinexV2Example, "0  6G12G09", "0  xG12G09", 1, 29, 35},
+		// A bad header line loses only that line.
+		{rinexV2Example, "    18.000  ", "    1x.000  ", 0, 16, 16},
+		// A bad satellite line in RINEX 3.
+		{rinexV3Example, "S37  36925330.673", "S37  3692533x.673", 1, 46, 71},
+	}
+
+	for _, test := range tests {
+		good, _, err := collect(test.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		text := strings.Replace(test.text, test.old, test.new, 1)
+		c, diags := parseLenient(t, text)
+		if len(c.records) != len(good.records)-test.lost {
+			t.Errorf("%q: expected %d records, got %d", test.new,
+				len(good.records)-test.lost, len(c.records))
+		}
+		if len(diags) != 1 || diags[0].FirstLine != test.first ||
+			diags[0].LastLine != test.last {
+			t.Errorf("%q: bad diagnostics %v", test.new, diags)
+		}
+	}
+}
+
+func TestLenientTruncated(t *testing.T) {
+	// Drop the last satellite line of the RINEX 3 example.
+	text := rinexV3Example[:strings.LastIndexByte(rinexV3Example, '\n')]
+	if _, _, err := collect(text); err == nil {
+		t.Errorf("Expected an error for a truncated record")
+	}
+	c, diags := parseLenient(t, text)
+	if len(c.records) != 0 || len(diags) != 1 || diags[0].FirstLine != 46 {
+		t.Errorf("Bad lenient parse: %d records, diagnostics %v",
+			len(c.records), diags)
+	}
+}
+
+func TestLenientFatal(t *testing.T) {
+	// Errors in the version line are still fatal.
+	text := strings.Replace(rinexV2Example, "     2.11 ", "     x.11 ", 1)
+	or := &ObsReader{Lenient: true}
+	if err := or.Parse(strings.NewReader(text)); err == nil {
+		t.Errorf("Expected an error for a bad version")
+	}
+
+	// A header without a version is an error, not a panic.
+	or = &ObsReader{Lenient: true}
+	text = "                                                            END OF HEADER\n"
+	if err := or.Parse(strings.NewReader(text)); err == nil {
+		t.Errorf("Expected an error for a missing version")
+	}
+}
@@ -0,0 +1,192 @@
+package rinex
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fixed is an observation value as an integer number of thousandths of
+// its unit, which is exactly the precision of a RINEX observation
+// field.  Sums and differences of Fixed values are exact.
+type Fixed int64
+
+// FixedObservation is an Observation with a Fixed value.
+type FixedObservation struct {
+	// Value is the value of the observation (thousandths).
+	Value Fixed
+
+	// Present, LLI, LLIPresent and SignalStrength are as in
+	// Observation.
+	Present        bool
+	LLI            byte
+	LLIPresent     bool
+	SignalStrength byte
+}
+
+// FixedSVObservation is an SVObservation with Fixed values.
+type FixedSVObservation struct {
+	// PRN identifies the satellite, as in SVObservation.
+	PRN SatID
+
+	// Obs contains the observations from this satellite, as in
+	// SVObservation.
+	Obs []FixedObservation
+}
+
+// FixedRecord is an ObservationRecord that holds its epoch, receiver
+// clock offset and observation values as integers, so that they can be
+// compared, differenced and written back without rounding errors.
+type FixedRecord struct {
+	// Epoch is the time of the record, as nanoseconds since 1 January
+	// 1970 in the record's time scale, counted the same way as
+	// ObservationRecord.Time.  Special event records that have no
+	// epoch use 0.
+	Epoch int64
+
+	// EpochFlag is as in ObservationRecord.
+	EpochFlag byte
+
+	// Offset is the receiver clock offset (ps), or 0 if none was given.
+	Offset int64
+
+	// Sat holds the satellite observations.
+	Sat []FixedSVObservation
+}
+
+/************************ TOP LEVEL FUNCTIONS ************************/
+
+// FixedFromFloat returns the Fixed value that is nearest to v.  For
+// values that ObsReader parsed from a RINEX file, this recovers the
+// digits of the field exactly.
+func FixedFromFloat(v float64) Fixed {
+	return Fixed(math.Round(v * 1000))
+}
+
+// Float64 returns f as a float64, in the same form that ObsReader
+// stores in Observation.Value.
+func (f Fixed) Float64() float64 {
+	return float64(f) / 1000
+}
+
+// String formats f with three decimal places.
+func (f Fixed) String() string {
+	v := int64(f)
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	frac := strconv.FormatInt(1000+v%1000, 10)[1:]
+	return sign + strconv.FormatInt(v/1000, 10) + "." + frac
+}
+
+// Fixed converts o to a FixedObservation.
+func (o Observation) Fixed() FixedObservation {
+	return FixedObservation{
+		Value:          FixedFromFloat(o.Value),
+		Present:        o.Present,
+		LLI:            o.LLI,
+		LLIPresent:     o.LLIPresent,
+		SignalStrength: o.SignalStrength,
+	}
+}
+
+// Observation converts fo back to an Observation.
+func (fo FixedObservation) Observation() Observation {
+	return Observation{
+		Value:          fo.Value.Float64(),
+		Present:        fo.Present,
+		LLI:            fo.LLI,
+		LLIPresent:     fo.LLIPresent,
+		SignalStrength: fo.SignalStrength,
+	}
+}
+
+// Fixed converts rec to a FixedRecord.  The result does not share
+// memory with rec.
+func (rec ObservationRecord) Fixed() FixedRecord {
+	fr := FixedRecord{
+		EpochFlag: rec.EpochFlag,
+		Offset:    int64(math.Round(rec.Offset * 1e12)),
+		Sat:       make([]FixedSVObservation, len(rec.Sat)),
+	}
+	if rec.Year != 0 {
+		fr.Epoch = rec.Time().UnixNano()
+	}
+	for i, sv := range rec.Sat {
+		fr.Sat[i].PRN = sv.PRN
+		fr.Sat[i].Obs = make([]FixedObservation, len(sv.Obs))
+		for j, o := range sv.Obs {
+			fr.Sat[i].Obs[j] = o.Fixed()
+		}
+	}
+	return fr
+}
+
+// Record converts fr back to an ObservationRecord.
+func (fr FixedRecord) Record() ObservationRecord {
+	rec := ObservationRecord{
+		EpochFlag: fr.EpochFlag,
+		Offset:    float64(fr.Offset) / 1e12,
+		Sat:       make([]SVObservation, len(fr.Sat)),
+	}
+	if fr.Epoch != 0 {
+		rec.SetTime(time.Unix(0, fr.Epoch).UTC())
+	}
+	for i, sv := range fr.Sat {
+		rec.Sat[i].PRN = sv.PRN
+		rec.Sat[i].Obs = make([]Observation, len(sv.Obs))
+		for j, o := range sv.Obs {
+			rec.Sat[i].Obs[j] = o.Observation()
+		}
+	}
+	return rec
+}
+
+// SetTime sets the date and time fields of rec from t.
+func (rec *ObservationRecord) SetTime(t time.Time) {
+	rec.Year = uint16(t.Year())
+	rec.Month = byte(t.Month())
+	rec.Day = byte(t.Day())
+	rec.Hour = byte(t.Hour())
+	rec.Minute = byte(t.Minute())
+	rec.Nanoseconds = int64(t.Second())*1e9 + int64(t.Nanosecond())
+}
+
+/************************** HELPER FUNCTIONS **************************/
+
+// parseNanos parses an epoch seconds field, such as " 30.0000000",
+// exactly into nanoseconds.  It returns false if text is not a plain
+// decimal number with at most nine fractional digits.
+func parseNanos(text string) (int64, bool) {
+	text = strings.TrimSpace(text)
+	whole, frac := text, ""
+	if idx := strings.IndexByte(text, '.'); idx >= 0 {
+		whole, frac = text[:idx], text[idx+1:]
+	}
+	if whole == "" || len(frac) > 9 {
+		return 0, false
+	}
+	sec, err := strconv.ParseUint(whole, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	ns := int64(0)
+	if frac != "" {
+		n, err := strconv.ParseUint(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		ns = int64(n)
+	}
+	return int64(sec)*1e9 + ns, true
+}
+
+// formatSecond formats ns as an F11.7 epoch seconds field, rounding to
+// the nearest 100 ns.
+func formatSecond(ns int64) string {
+	units := (ns + 50) / 100
+	return fmt.Sprintf("%3d.%07d", units/1e7, units%1e7)
+}
@@ -0,0 +1,114 @@
+package rinex
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rinexHighRateExample has epoch seconds that float32 cannot hold.
+const rinexHighRateExample = `     3.04           OBSERVATION DATA    G                   RINEX VERSION / TYPE
+G    2 C1C L1C                                              SYS / # / OBS TYPES
+  2019     1    10     0     0   59.9999999     GPS         TIME OF FIRST OBS
+                                                            END OF HEADER
+> 2019 01 10 00 00 59.9999999  0  1       0.000000000123
+G05  21557855.617 8 113287291.649 8
+> 2019 01 10 00 01  0.0000001  0  1
+G05 -21557855.618 8      1234.001 8
+`
+
+func TestFixed(t *testing.T) {
+	for _, text := range []string{"0.000", "-0.001", "1234.567", "-9999999999.999"} {
+		f, err := parseFloat(text, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := FixedFromFloat(f).String(); got != text {
+			t.Errorf("FixedFromFloat(%s).String() = %s", text, got)
+		}
+		if FixedFromFloat(f).Float64() != f {
+			t.Errorf("%s did not round trip through Fixed", text)
+		}
+	}
+
+	for text, want := range map[string]int64{
+		" 59.9999999": 59999999900,
+		"  0.0000001": 100,
+		" 30":         30000000000,
+		"  1.5":       1500000000,
+	} {
+		ns, ok := parseNanos(text)
+		if !ok || ns != want {
+			t.Errorf("parseNanos(%q) = %d, %v", text, ns, ok)
+		}
+	}
+	for _, text := range []string{"", " -1.0", "1.0000000001", "1e1"} {
+		if _, ok := parseNanos(text); ok {
+			t.Errorf("parseNanos(%q) succeeded", text)
+		}
+	}
+	if s := formatSecond(59999999949); s != " 59.9999999" {
+		t.Errorf("formatSecond gave %q", s)
+	}
+}
+
+func TestFixedRecord(t *testing.T) {
+	c, or, err := collect(rinexHighRateExample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.records) != 2 {
+		t.Fatalf("Got %d records", len(c.records))
+	}
+
+	when := time.Date(2019, 1, 10, 0, 0, 59, 999999900, time.UTC)
+	if !c.records[0].Time().Equal(when) {
+		t.Errorf("Bad epoch %v", c.records[0].Time())
+	}
+	f0, f1 := c.records[0].Fixed(), c.records[1].Fixed()
+	if f1.Epoch-f0.Epoch != 200 {
+		t.Errorf("Epoch difference is %d ns", f1.Epoch-f0.Epoch)
+	}
+	if f0.Offset != 123 {
+		t.Errorf("Offset = %d ps", f0.Offset)
+	}
+	if d := f0.Sat[0].Obs[0].Value + f1.Sat[0].Obs[0].Value; d != -1 {
+		t.Errorf("C1C sum = %d", d)
+	}
+	if !reflect.DeepEqual(f0.Record().Fixed(), f0) {
+		t.Errorf("FixedRecord did not round trip")
+	}
+
+	// The writer reproduces the input exactly.
+	out, err := rewrite(c, or, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := rinexHighRateExample[strings.Index(rinexHighRateExample, ">"):]
+	got := out[strings.Index(out, ">"):]
+	if got != want {
+		t.Errorf("Output mismatch:\n%s\n%s", got, want)
+	}
+
+	// Second follows Nanoseconds, and so does the written epoch.
+	rec := c.records[0]
+	rec.Nanoseconds = 1500000000
+	if rec.Second() != 1.5 || !rec.Time().Equal(time.Date(2019, 1, 10, 0, 0, 1, 5e8, time.UTC)) {
+		t.Errorf("Second gave %g, Time gave %v", rec.Second(), rec.Time())
+	}
+	bb := &bytes.Buffer{}
+	ow := NewObsWriter(bb)
+	ow.Version = 3
+	ow.Observations = or.Observations
+	if err = ow.WriteRecord(rec); err != nil {
+		t.Fatal(err)
+	}
+	if err = ow.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if line := bb.String(); !strings.HasPrefix(line, "> 2019 01 10 00 00  1.5000000") {
+		t.Errorf("Bad epoch line %q", line)
+	}
+}
@@ -0,0 +1,671 @@
+package rinex
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Compact RINEX ("Hatanaka compression", after its author) reduces the
+// size of RINEX observation files by replacing each observation value
+// with a high-order time difference, and each epoch line and set of
+// LLI/signal strength flags with a textual difference from the
+// previous one.  CRINEX 1.0 encodes RINEX 2 files and CRINEX 3.0
+// encodes RINEX 3 files.
+//
+// A CRINEX file starts with CRINEX VERS / TYPE and CRINEX PROG / DATE
+// header lines, followed by the original RINEX header.  Each epoch is
+// then encoded as:
+//
+//   - An epoch line, holding the RINEX epoch line (without the receiver
+//     clock offset) with the whole satellite list appended.  A line that
+//     starts with '&' (CRINEX 1) or '>' (CRINEX 3) is given in full;
+//     otherwise it is a text difference from the previous epoch line.
+//     In a text difference, ' ' means the character is unchanged, '&'
+//     means it changed to a space, and anything else is the new value.
+//   - For special events (epoch flags 2 through 5), the header lines
+//     that follow the epoch line are copied verbatim, and there are no
+//     other lines for that epoch.
+//   - A clock offset line, which is empty if there is no receiver
+//     clock offset.
+//   - One line per satellite, with one space-separated field per
+//     observation type, followed by the text difference of that
+//     satellite's LLI and signal strength flags.  An empty field means
+//     the observation is missing.  A field of the form "3&12345" starts
+//     a new arc of differences of order 3 with the value 12345;
+//     otherwise the field is the next difference in the arc.  Values
+//     are integers in units of the last decimal place of the RINEX
+//     field (0.001 for observations, 1e-9 or 1e-12 s for clock
+//     offsets).
+
+// maxDiffOrder is the largest difference order that CRINEX allows.
+const maxDiffOrder = 9
+
+// crinexLabel is the header label that identifies a CRINEX file.
+const crinexLabel = "CRINEX VERS   / TYPE"
+
+// diffArc holds the state of one sequence of differenced values.
+type diffArc struct {
+	// order is the maximum difference order for the arc.
+	order int
+
+	// level is the number of differences accumulated so far; it grows
+	// from 0 to order as the arc starts.
+	level int
+
+	// valid is true when the arc has been initialized.
+	valid bool
+
+	// y[0] is the most recent value, and y[i] is its i'th difference.
+	y [maxDiffOrder + 1]int64
+}
+
+// start begins a new arc with the given order and initial value.
+func (a *diffArc) start(order int, value int64) {
+	a.order = order
+	a.level = 0
+	a.valid = true
+	a.y[0] = value
+}
+
+// diff records the next value x in the arc, and returns its
+// difference of the arc's current order.  It is the inverse of undiff.
+func (a *diffArc) diff(x int64) int64 {
+	if a.level < a.order {
+		a.level++
+	}
+	prev := a.y
+	a.y[0] = x
+	for i := 1; i <= a.level; i++ {
+		a.y[i] = a.y[i-1] - prev[i-1]
+	}
+	return a.y[a.level]
+}
+
+// undiff integrates the next difference d, and returns the new value.
+func (a *diffArc) undiff(d int64) int64 {
+	if a.level < a.order {
+		a.level++
+	}
+	a.y[a.level] = d
+	for i := a.level - 1; i >= 0; i-- {
+		a.y[i] += a.y[i+1]
+	}
+	return a.y[0]
+}
+
+// crinexSat holds the decoding state for one satellite.
+type crinexSat struct {
+	// arcs holds one difference arc per observation type.
+	arcs []diffArc
+
+	// flags holds the LLI and signal strength characters for each
+	// observation type.
+	flags []byte
+}
+
+// HatanakaReader decompresses a Compact RINEX (CRINEX 1.0 or 3.0)
+// stream into standard RINEX observation text.  ObsReader.Parse uses it
+// automatically when its input starts with a CRINEX header, so most
+// programs do not need to use it directly.  Errors in the compressed
+// input are reported as *ParseError, numbered by compressed line.
+type HatanakaReader struct {
+	// Lenient makes the reader recover from errors in the compressed
+	// epochs.  It drops the epoch that has the error, along with any
+	// later epochs that are differenced from it, and resumes at the
+	// next epoch line that is given in full.  Errors in the CRINEX
+	// header and read errors still stop decompression.
+	Lenient bool
+
+	// DiagFunc, if not nil, is called in lenient mode for each region
+	// of the compressed input that the reader skipped.  If it returns
+	// non-nil, decompression stops with that error.
+	DiagFunc func(d Diagnostic) error
+
+	// s reads the compressed input.
+	s *bufio.Scanner
+
+	// lineNum is the number of compressed lines read so far.
+	lineNum int
+
+	// epochStart is the line number of the current epoch line.
+	epochStart int
+
+	// version is the CRINEX version: 1 or 3.
+	version int
+
+	// inHeader is true while copying the RINEX header.
+	inHeader bool
+
+	// numTypes is the number of observation types for each GNSS,
+	// indexed as for ObsReader.Observations.
+	numTypes map[byte]int
+
+	// epoch is the current (decompressed) CRINEX epoch line.
+	epoch []byte
+
+	// clock is the receiver clock offset arc.
+	clock diffArc
+
+	// sats holds the state for each satellite in the last epoch.
+	sats map[[3]byte]*crinexSat
+
+	// out holds decompressed text that has not yet been read.
+	out bytes.Buffer
+
+	// err is the error, if any, that stopped decompression.
+	err error
+}
+
+// NewHatanakaReader returns an io.Reader that decompresses the CRINEX
+// data in r.
+func NewHatanakaReader(r io.Reader) *HatanakaReader {
+	return &HatanakaReader{
+		s:        bufio.NewScanner(r),
+		numTypes: make(map[byte]int),
+		sats:     make(map[[3]byte]*crinexSat),
+	}
+}
+
+// isCRINEX returns true if head, the start of a file, looks like a
+// CRINEX header.
+func isCRINEX(head []byte) bool {
+	if i := bytes.IndexByte(head, '\n'); i >= 0 {
+		head = head[:i]
+	}
+	return bytes.Contains(head, []byte(crinexLabel))
+}
+
+/************************ TOP LEVEL FUNCTIONS ************************/
+
+// Read implements the io.Reader interface.
+func (hr *HatanakaReader) Read(p []byte) (int, error) {
+	for hr.out.Len() == 0 && hr.err == nil {
+		hr.err = hr.decodeNext()
+	}
+	if hr.out.Len() > 0 {
+		return hr.out.Read(p)
+	}
+	return 0, hr.err
+}
+
+// decodeNext decodes the next piece of the input into hr.out.
+func (hr *HatanakaReader) decodeNext() error {
+	if hr.version == 0 {
+		return hr.readCRINEXHeader()
+	}
+
+	line, err := hr.readLine()
+	if err != nil {
+		return err
+	}
+
+	if hr.inHeader {
+		hr.handleHeader(line)
+		hr.writeLine(line)
+		return nil
+	}
+
+	hr.epochStart = hr.lineNum
+	if err = hr.decodeEpoch(line); err != nil {
+		return hr.recover(err)
+	}
+	return nil
+}
+
+// recover decides whether decompression can continue after err.  If
+// so, it drops the current epoch, skips to the next epoch line that is
+// given in full, and decodes from there.
+func (hr *HatanakaReader) recover(err error) error {
+	for {
+		if _, ok := err.(*ParseError); !ok || !hr.Lenient {
+			return err
+		}
+
+		// Forget everything that later differences could build on.
+		hr.out.Reset()
+		hr.epoch = hr.epoch[:0]
+		hr.clock.valid = false
+		hr.sats = make(map[[3]byte]*crinexSat)
+
+		// A full epoch line may be what cut the bad epoch short.
+		first := hr.epochStart
+		line := strings.TrimSuffix(hr.s.Text(), "\r")
+		found := hr.lineNum > first && hr.isFullEpoch(line)
+		for !found {
+			var readErr error
+			if line, readErr = hr.readLine(); readErr == io.EOF {
+				break
+			} else if readErr != nil {
+				return readErr
+			}
+			found = hr.isFullEpoch(line)
+		}
+
+		last := hr.lineNum
+		if found {
+			last--
+		}
+		d := Diagnostic{Err: err, FirstLine: first, LastLine: last}
+		if hr.DiagFunc != nil {
+			if diagErr := hr.DiagFunc(d); diagErr != nil {
+				return diagErr
+			}
+		}
+		if !found {
+			return io.EOF
+		}
+
+		hr.epochStart = hr.lineNum
+		if err = hr.decodeEpoch(line); err == nil {
+			return nil
+		}
+	}
+}
+
+// readLine returns the next input line, or io.EOF.
+func (hr *HatanakaReader) readLine() (string, error) {
+	if !hr.s.Scan() {
+		if err := hr.s.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	hr.lineNum++
+	return strings.TrimSuffix(hr.s.Text(), "\r"), nil
+}
+
+// readContinuation returns the next input line; running out of input
+// is an error rather than io.EOF.
+func (hr *HatanakaReader) readContinuation() (string, error) {
+	line, err := hr.readLine()
+	if err == io.EOF {
+		err = hr.lineError("compressed record", "",
+			errors.New("Record is truncated"))
+	}
+	return line, err
+}
+
+// lineError returns a ParseError for field, whose text is text, on the
+// current compressed input line.
+func (hr *HatanakaReader) lineError(field, text string, err error) error {
+	return &ParseError{Line: hr.lineNum, Field: field, Text: text, Err: err}
+}
+
+// writeLine appends line and a newline to hr.out.
+func (hr *HatanakaReader) writeLine(line string) {
+	hr.out.WriteString(line)
+	hr.out.WriteByte('\n')
+}
+
+// writeTrimmed appends line, without trailing blanks, to hr.out.
+func (hr *HatanakaReader) writeTrimmed(line []byte) {
+	hr.out.Write(bytes.TrimRight(line, " "))
+	hr.out.WriteByte('\n')
+}
+
+// readCRINEXHeader reads the two CRINEX-specific header lines.
+func (hr *HatanakaReader) readCRINEXHeader() error {
+	line, err := hr.readLine()
+	if err != nil {
+		if err == io.EOF {
+			err = &ParseError{Line: 1, Field: "CRINEX header",
+				Err: errors.New("Missing CRINEX header")}
+		}
+		return err
+	}
+	if len(line) < 80 || line[60:80] != crinexLabel {
+		return hr.lineError("CRINEX header", line,
+			errors.New("Expected CRINEX VERS / TYPE"))
+	}
+	switch strings.TrimSpace(line[0:20]) {
+	case "1.0":
+		hr.version = 1
+	case "3.0":
+		hr.version = 3
+	default:
+		return hr.lineError("CRINEX version", line[0:20],
+			errors.New("Unsupported CRINEX version"))
+	}
+
+	if line, err = hr.readContinuation(); err != nil {
+		return err
+	}
+	if len(line) < 78 || !strings.HasPrefix(line[60:], "CRINEX PROG / DATE") {
+		return hr.lineError("CRINEX header", line,
+			errors.New("Expected CRINEX PROG / DATE"))
+	}
+
+	hr.inHeader = true
+	return nil
+}
+
+// handleHeader tracks the number of observation types from a RINEX
+// header line, and notices the end of the header.
+func (hr *HatanakaReader) handleHeader(line string) {
+	if len(line) < 61 {
+		return
+	}
+	label := strings.TrimSpace(line[60:])
+	value := line[:60]
+
+	switch label {
+	case "END OF HEADER":
+		hr.inHeader = false
+	case "# / TYPES OF OBSERV":
+		if n, err := parseUint(value[0:6], 16); err == nil && value[5] != ' ' {
+			hr.numTypes[' '] = int(n)
+		}
+	case "SYS / # / OBS TYPES":
+		if value[0] != ' ' {
+			if n, err := parseUint(value[3:6], 16); err == nil {
+				hr.numTypes[value[0]] = int(n)
+			}
+		}
+	}
+}
+
+/*************************** EPOCH DECODING ***************************/
+
+// repairText applies the text difference diff to old, and returns the
+// updated text.
+func repairText(old []byte, diff string) []byte {
+	for i := 0; i < len(diff); i++ {
+		if i >= len(old) {
+			old = append(old, ' ')
+		}
+		switch diff[i] {
+		case ' ':
+		case '&':
+			old[i] = ' '
+		default:
+			old[i] = diff[i]
+		}
+	}
+	return old
+}
+
+// isFullEpoch returns true if line is an epoch line that is given in
+// full rather than as a difference.
+func (hr *HatanakaReader) isFullEpoch(line string) bool {
+	if hr.version == 1 {
+		return strings.HasPrefix(line, "&")
+	}
+	return strings.HasPrefix(line, ">")
+}
+
+// epochLayout returns the length of the fixed part of a CRINEX epoch
+// line, the column of the epoch flag and the column of the satellite
+// count.
+func (hr *HatanakaReader) epochLayout() (fixed, flag, count int) {
+	if hr.version == 1 {
+		return 32, 28, 29
+	}
+	return 41, 31, 32
+}
+
+// decodeEpoch decodes one epoch, starting with its epoch line.
+func (hr *HatanakaReader) decodeEpoch(line string) error {
+	// Reconstruct the epoch line.
+	if hr.version == 1 && strings.HasPrefix(line, "&") {
+		hr.epoch = append(hr.epoch[:0], ' ')
+		hr.epoch = append(hr.epoch, line[1:]...)
+	} else if hr.version == 3 && strings.HasPrefix(line, ">") {
+		hr.epoch = append(hr.epoch[:0], line...)
+	} else if len(hr.epoch) == 0 {
+		return hr.lineError("compressed epoch line", line,
+			errors.New("Epoch difference without initial epoch"))
+	} else {
+		hr.epoch = repairText(hr.epoch, line)
+	}
+	fixed, flagCol, countCol := hr.epochLayout()
+	for len(hr.epoch) < fixed {
+		hr.epoch = append(hr.epoch, ' ')
+	}
+
+	flag := hr.epoch[flagCol]
+	count, err := parseUint(string(hr.epoch[countCol:countCol+3]), 16)
+	if err != nil {
+		return hr.lineError("epoch satellite count",
+			string(hr.epoch[countCol:countCol+3]), err)
+	}
+
+	// Special events are followed by verbatim header lines.
+	if flag >= '2' && flag <= '5' {
+		hr.writeTrimmed(hr.epoch[:fixed])
+		for i := uint64(0); i < count; i++ {
+			text, err := hr.readContinuation()
+			if err != nil {
+				return err
+			}
+			hr.handleHeader(text)
+			hr.writeLine(text)
+		}
+		hr.inHeader = false
+		if flag == '3' || flag == '4' {
+			// The observation types may have changed.
+			hr.sats = make(map[[3]byte]*crinexSat)
+		}
+		return nil
+	}
+
+	// Decode the receiver clock offset.
+	clockLine, err := hr.readContinuation()
+	if err != nil {
+		return err
+	}
+	clock, hasClock, err := hr.decodeClock(clockLine)
+	if err != nil {
+		return err
+	}
+
+	// Write the RINEX epoch line(s).
+	end := fixed + 3*int(count)
+	for len(hr.epoch) < end {
+		hr.epoch = append(hr.epoch, ' ')
+	}
+	prns := hr.epoch[fixed:end]
+	hr.writeEpoch(prns, clock, hasClock)
+
+	// Decode each satellite's observations.
+	sats := make(map[[3]byte]*crinexSat, count)
+	for i := 0; i < int(count); i++ {
+		var prn [3]byte
+		copy(prn[:], prns[3*i:3*i+3])
+		text, err := hr.readContinuation()
+		if err != nil {
+			return err
+		}
+		sat, err := hr.decodeSat(prn, text)
+		if err != nil {
+			return err
+		}
+		sats[prn] = sat
+	}
+	hr.sats = sats
+
+	return nil
+}
+
+// decodeClock decodes a clock offset line.
+func (hr *HatanakaReader) decodeClock(line string) (int64, bool, error) {
+	if line == "" {
+		hr.clock.valid = false
+		return 0, false, nil
+	}
+
+	if len(line) > 1 && line[1] == '&' {
+		order, v, err := parseArcStart(line)
+		if err != nil {
+			return 0, false, hr.lineError("receiver clock offset", line, err)
+		}
+		hr.clock.start(order, v)
+		return v, true, nil
+	}
+
+	if !hr.clock.valid {
+		return 0, false, hr.lineError("receiver clock offset", line,
+			errors.New("Difference without initial value"))
+	}
+	d, err := strconv.ParseInt(line, 10, 64)
+	if err != nil {
+		return 0, false, hr.lineError("receiver clock offset", line, err)
+	}
+	return hr.clock.undiff(d), true, nil
+}
+
+// parseArcStart parses a field of the form "3&12345", which starts a
+// new arc, and returns its difference order and initial value.
+func parseArcStart(field string) (int, int64, error) {
+	order := int(field[0] - '0')
+	if order < 0 || order > maxDiffOrder {
+		return 0, 0, errors.New("Invalid difference order")
+	}
+	v, err := strconv.ParseInt(field[2:], 10, 64)
+	return order, v, err
+}
+
+// writeEpoch writes the RINEX epoch line(s) for a regular epoch.
+func (hr *HatanakaReader) writeEpoch(prns []byte, clock int64, hasClock bool) {
+	line := make([]byte, 0, 80)
+	if hr.version == 1 {
+		line = append(line, hr.epoch[:32]...)
+		for i := 0; i < len(prns); i += 3 {
+			if i > 0 && i%36 == 0 {
+				hr.writeTrimmed(line)
+				line = append(line[:0], "                                "...)
+			}
+			line = append(line, prns[i:i+3]...)
+			if i == 33 && hasClock {
+				line = appendFixed(line, clock, 12, 9)
+			}
+		}
+		if len(prns) < 36 && hasClock {
+			for len(line) < 68 {
+				line = append(line, ' ')
+			}
+			line = appendFixed(line, clock, 12, 9)
+		}
+	} else {
+		line = append(line, hr.epoch[:35]...)
+		if hasClock {
+			line = append(line, "      "...)
+			line = appendFixed(line, clock, 15, 12)
+		}
+	}
+	hr.writeTrimmed(line)
+}
+
+// decodeSat decodes one satellite's data line and writes the RINEX
+// observation line(s) for it.
+func (hr *HatanakaReader) decodeSat(prn [3]byte, text string) (*crinexSat, error) {
+	system := prn[0]
+	if hr.version == 1 {
+		system = ' '
+	}
+	ntypes, ok := hr.numTypes[system]
+	if !ok {
+		return nil, hr.lineError("satellite "+string(prn[:]), text,
+			errors.New("No observation types for its system"))
+	}
+
+	sat := hr.sats[prn]
+	if sat == nil || len(sat.arcs) != ntypes {
+		sat = &crinexSat{
+			arcs:  make([]diffArc, ntypes),
+			flags: make([]byte, 0, 2*ntypes),
+		}
+	}
+
+	values := make([]int64, ntypes)
+	present := make([]bool, ntypes)
+	for j := 0; j < ntypes && text != ""; j++ {
+		field := text
+		if i := strings.IndexByte(text, ' '); i >= 0 {
+			field, text = text[:i], text[i+1:]
+		} else {
+			text = ""
+		}
+		arc := &sat.arcs[j]
+		if field == "" {
+			arc.valid = false
+			continue
+		}
+
+		name := string(prn[:]) + " observation " + strconv.Itoa(j+1)
+		if len(field) > 1 && field[1] == '&' {
+			order, v, err := parseArcStart(field)
+			if err != nil {
+				return nil, hr.lineError(name, field, err)
+			}
+			arc.start(order, v)
+			values[j] = v
+		} else if !arc.valid {
+			return nil, hr.lineError(name, field,
+				errors.New("Difference without initial value"))
+		} else {
+			d, err := strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				return nil, hr.lineError(name, field, err)
+			}
+			values[j] = arc.undiff(d)
+		}
+		present[j] = true
+	}
+	for j := range present {
+		if !present[j] {
+			sat.arcs[j].valid = false
+		}
+	}
+
+	// The rest of the line is the flag difference.
+	sat.flags = repairText(sat.flags, text)
+	for len(sat.flags) < 2*ntypes {
+		sat.flags = append(sat.flags, ' ')
+	}
+
+	// Write the RINEX observation line(s).
+	line := make([]byte, 0, 80)
+	if hr.version != 1 {
+		line = append(line, prn[:]...)
+	}
+	for j := 0; j < ntypes; j++ {
+		if hr.version == 1 && j > 0 && j%5 == 0 {
+			hr.writeTrimmed(line)
+			line = line[:0]
+		}
+		if present[j] {
+			line = appendFixed(line, values[j], 14, 3)
+		} else {
+			line = append(line, "              "...)
+		}
+		line = append(line, sat.flags[2*j:2*j+2]...)
+	}
+	hr.writeTrimmed(line)
+
+	return sat, nil
+}
+
+// appendFixed appends v * 10^-prec, formatted in a field of the given
+// width with prec decimal places, to line.
+func appendFixed(line []byte, v int64, width, prec int) []byte {
+	digits := strconv.FormatInt(v, 10)
+	neg := v < 0
+	if neg {
+		digits = digits[1:]
+	}
+	for len(digits) <= prec {
+		digits = "0" + digits
+	}
+	text := digits[:len(digits)-prec] + "." + digits[len(digits)-prec:]
+	if neg {
+		text = "-" + text
+	}
+	for i := len(text); i < width; i++ {
+		line = append(line, ' ')
+	}
+	return append(line, text...)
+}
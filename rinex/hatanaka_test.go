@@ -0,0 +1,217 @@
+package rinex
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// crinexV1Example is a CRINEX 1.0 file with satellites that come and
+// go, missing observations, changing flags and a clock offset that
+// disappears.
+const crinexV1Example = `1.0                 COMPACT RINEX FORMAT                    CRINEX VERS   / TYPE
+RNX2CRX ver.4.0.7                       16-Oct-26 00:00     CRINEX PROG / DATE
+     2.11           OBSERVATION DATA    M (MIXED)           RINEX VERSION / TYPE
+     3    C1    L1    S1                                    # / TYPES OF OBSERV
+  2005     3    24    13    10   36.0000000     GPS        TIME OF FIRST OBS
+                                                            END OF HEADER
+&05  3 24 13 10 36.0000000  0  3G12G09E11
+3&123456789
+3&23629347915 3&-353 3&45000  817
+3&20891534648 3&-120
+3&20607600189 3&-4034 3&39250  5
+                50             2   E11&&&
+100
+-10252465 -53875279 250   &
+3472500 18251823 250
+              1 &              3   G09E11
+
+359138 1845790 0
+3&20892137000 3&3000 3&12000     4
+1 -4034   &
+                1
+
+-359137 -1845790 0
+603001 3001 250     &
+-2 4034 3&40000
+`
+
+// crinexV1Expanded is the RINEX text that crinexV1Example expands to.
+const crinexV1Expanded = `     2.11           OBSERVATION DATA    M (MIXED)           RINEX VERSION / TYPE
+     3    C1    L1    S1                                    # / TYPES OF OBSERV
+  2005     3    24    13    10   36.0000000     GPS        TIME OF FIRST OBS
+                                                            END OF HEADER
+ 05  3 24 13 10 36.0000000  0  3G12G09E11                            0.123456789
+  23629347.915 8        -0.35317        45.000
+  20891534.648          -0.120
+  20607600.189 5        -4.034          39.250
+ 05  3 24 13 10 50.0000000  0  2G12E11                               0.123456889
+  23619095.450 8    -53875.632 7        45.250
+  20611072.689 5     18247.789          39.500
+ 05  3 24 13 11  0.0000000  0  3G12G09E11
+  23609202.123 8   -105905.121 7        45.500
+  20892137.000           3.000          12.0004
+  20614545.190       36495.578
+ 05  3 24 13 11 10.0000000  0  3G12G09E11
+  23599308.797 8   -157934.610 7        45.750
+  20892740.001           6.001          12.250
+  20618017.690       54743.367          40.000
+`
+
+// crinexV3Example is a CRINEX 3.0 file with a clock offset that appears
+// partway through.
+const crinexV3Example = `3.0                 COMPACT RINEX FORMAT                    CRINEX VERS   / TYPE
+RNX2CRX ver.4.0.7                       16-Oct-26 00:00     CRINEX PROG / DATE
+     3.04           OBSERVATION DATA    M                   RINEX VERSION / TYPE
+G    2 C1C L1C                                              SYS / # / OBS TYPES
+R    3 C1C L1C S1C                                          SYS / # / OBS TYPES
+                                                            END OF HEADER
+> 2019 01 10 00 00  0.0000000  0  2      G05R20
+
+3&22093514170 3&116100955288  6 6
+3&21476155371 3&114869002563 3&48250
+                   3
+3&987654321
+-2310160 -12140288   1
+3844629 20548437 250
+                 1 &
+-21
+270 2609   &
+362  0
+`
+
+// crinexV3Expanded is the RINEX text that crinexV3Example expands to.
+const crinexV3Expanded = `     3.04           OBSERVATION DATA    M                   RINEX VERSION / TYPE
+G    2 C1C L1C                                              SYS / # / OBS TYPES
+R    3 C1C L1C S1C                                          SYS / # / OBS TYPES
+                                                            END OF HEADER
+> 2019 01 10 00 00  0.0000000  0  2
+G05  22093514.170 6 116100955.288 6
+R20  21476155.371   114869002.563          48.250
+> 2019 01 10 00 00 30.0000000  0  2       0.000987654321
+G05  22091204.010 6 116088815.00016
+R20  21480000.000   114889551.000          48.500
+> 2019 01 10 00 01  0.0000000  0  2       0.000987654300
+G05  22088894.120 6 116076677.321 6
+R20  21483844.991                          48.750
+`
+
+func testHatanaka(t *testing.T, compact, expanded string) {
+	text, err := io.ReadAll(NewHatanakaReader(strings.NewReader(compact)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(text) != expanded {
+		t.Errorf("Decompressed text differs:\n%s\nexpected:\n%s", text, expanded)
+	}
+
+	// ObsReader should decompress transparently.
+	want, _, err := collect(expanded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := collect(compact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.records, want.records) {
+		t.Errorf("Parsed records differ: %v vs %v", got.records, want.records)
+	}
+}
+
+func TestHatanakaV1(t *testing.T) {
+	testHatanaka(t, crinexV1Example, crinexV1Expanded)
+}
+
+func TestHatanakaV3(t *testing.T) {
+	testHatanaka(t, crinexV3Example, crinexV3Expanded)
+}
+
+func TestHatanakaErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		line int
+	}{
+		{"", 1},
+		{crinexV1Example[:81] + "oops\n", 2},
+		{strings.Replace(crinexV1Example, "&05", " 05", 1), 7},
+		{strings.Replace(crinexV3Example, "3&987654321", "-12", 1), 12},
+		{strings.TrimSuffix(crinexV3Example, "362  0\n"), 17},
+		{strings.Replace(crinexV3Example, "3&22093514170", "A&100", 1), 9},
+		{strings.Replace(crinexV3Example, "3&987654321", "A&100", 1), 12},
+		{strings.Replace(crinexV3Example, "3844629", "38446x9", 1), 14},
+	}
+	for _, test := range tests {
+		_, err := io.ReadAll(NewHatanakaReader(strings.NewReader(test.text)))
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Line != test.line {
+			t.Errorf("Expected a ParseError on line %d, got %v for:\n%s",
+				test.line, err, test.text)
+		}
+	}
+}
+
+func TestHatanakaBadOrder(t *testing.T) {
+	// A difference order beyond maxDiffOrder used to index past the
+	// end of diffArc.y, even when parsing leniently.
+	text := strings.Replace(crinexV1Example, "3&-353", "A&100", 1)
+	or := &ObsReader{}
+	var pe *ParseError
+	if err := or.Parse(strings.NewReader(text)); !errors.As(err, &pe) || pe.Line != 9 {
+		t.Errorf("Expected a ParseError on line 9, got %v", err)
+	}
+
+	// There is no later full epoch line to resume at.
+	c, diags := parseLenient(t, text)
+	if len(c.records) != 0 || len(diags) != 1 || diags[0].FirstLine != 7 ||
+		diags[0].LastLine != 25 {
+		t.Errorf("Bad lenient parse: %d records, diagnostics %v",
+			len(c.records), diags)
+	}
+}
+
+// crinexV3Restart is a full epoch line, and its data, to append to
+// crinexV3Example.
+const crinexV3Restart = `> 2019 01 10 00 01 30.0000000  0  1      G05
+
+3&22086584230 3&116064539642  6 6
+`
+
+func TestHatanakaLenient(t *testing.T) {
+	tests := []struct {
+		old, new    string
+		records     int
+		first, last int
+	}{
+		// A bad value loses its epoch and the one differenced from it.
+		{"3844629", "38446x9", 2, 11, 18},
+		// A bad clock difference loses only the last epoch.
+		{"\n-21\n", "\n-2x\n", 3, 15, 18},
+		// An epoch cut short by a full epoch line resumes there.
+		{"362  0\n", "", 3, 15, 17},
+	}
+	for _, test := range tests {
+		text := strings.Replace(crinexV3Example+crinexV3Restart, test.old, test.new, 1)
+		if _, _, err := collect(text); err == nil {
+			t.Errorf("%q: expected an error", test.new)
+		}
+		c, diags := parseLenient(t, text)
+		if len(c.records) != test.records || len(diags) != 1 ||
+			diags[0].FirstLine != test.first || diags[0].LastLine != test.last {
+			t.Errorf("%q: bad lenient parse: %d records, diagnostics %v",
+				test.new, len(c.records), diags)
+			continue
+		}
+		last := c.records[len(c.records)-1]
+		if last.Minute != 1 || last.Second() != 30 || len(last.Sat) != 1 ||
+			last.Sat[0].Obs[0].Value != 22086584.230 {
+			t.Errorf("%q: bad last record %v", test.new, last)
+		}
+		if _, ok := diags[0].Err.(*ParseError); !ok {
+			t.Errorf("%q: diagnostic error %v is not a ParseError", test.new,
+				diags[0].Err)
+		}
+	}
+}
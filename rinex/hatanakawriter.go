@@ -0,0 +1,253 @@
+package rinex
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultDiffOrder is the difference order that rnx2crx uses by
+// default for observations and clock offsets.
+const defaultDiffOrder = 3
+
+// HatanakaWriter writes observation data in the Compact RINEX format
+// (CRINEX 1.0 for RINEX 2.11 output, or CRINEX 3.0 for RINEX 3.04
+// output).  It is used the same way as ObsWriter, and decompressing its
+// output gives exactly the text that ObsWriter would have written for
+// the same header and records.
+type HatanakaWriter struct {
+	// ObsWriter formats the header and epoch lines.  Its Version,
+	// System and Observations fields select the output format in the
+	// same way as for plain RINEX output.
+	*ObsWriter
+
+	// epoch is the previous CRINEX epoch line, or nil if the next
+	// epoch line must be written in full.
+	epoch []byte
+
+	// clock is the receiver clock offset arc.
+	clock diffArc
+
+	// sats holds the state for each satellite in the last epoch.
+	sats map[[3]byte]*crinexSat
+}
+
+// NewHatanakaWriter creates a HatanakaWriter that writes to w.  The
+// caller must set Version and Observations before calling WriteHeader.
+func NewHatanakaWriter(w io.Writer) *HatanakaWriter {
+	return &HatanakaWriter{
+		ObsWriter: NewObsWriter(w),
+		sats:      make(map[[3]byte]*crinexSat),
+	}
+}
+
+/************************ TOP LEVEL FUNCTIONS ************************/
+
+// WriteHeader writes the CRINEX header lines followed by a complete
+// RINEX header, as described for ObsWriter.WriteHeader.
+func (hw *HatanakaWriter) WriteHeader(lines []HeaderLine) error {
+	var version string
+	switch hw.Version {
+	case 2:
+		version = "1.0"
+	case 3:
+		version = "3.0"
+	default:
+		return fmt.Errorf("Invalid RINEX version %d", hw.Version)
+	}
+
+	hw.writeHeaderLine(crinexLabel, fmt.Sprintf("%-20s%-40s", version,
+		"COMPACT RINEX FORMAT"))
+	hw.writeHeaderLine("CRINEX PROG / DATE", fmt.Sprintf("%-40s%-20s",
+		"gnss/rinex", time.Now().UTC().Format("02-Jan-06 15:04")))
+
+	hw.epoch = nil
+	hw.clock.valid = false
+	hw.sats = make(map[[3]byte]*crinexSat)
+	return hw.ObsWriter.WriteHeader(lines)
+}
+
+// WriteRecord writes an observation record.  It has the same
+// requirements as ObsWriter.WriteRecord.  If rec cannot be written,
+// WriteRecord returns an error without writing any of it or changing
+// the compression state.
+func (hw *HatanakaWriter) WriteRecord(rec ObservationRecord) error {
+	switch rec.EpochFlag {
+	case 0, 1, 6:
+	default:
+		return fmt.Errorf("Epoch flag %d requires WriteEvent", rec.EpochFlag)
+	}
+	if err := hw.checkRecord(rec); err != nil {
+		return err
+	}
+
+	// Write the epoch line, with the full satellite list.
+	if hw.Version == 2 {
+		hw.formatV2Epoch(rec, len(rec.Sat))
+	} else {
+		hw.formatV3Epoch(rec, len(rec.Sat))
+		hw.lineBuf = append(hw.lineBuf, "      "...)
+	}
+	for _, sv := range rec.Sat {
+		hw.lineBuf = append(hw.lineBuf, sv.PRN[:]...)
+	}
+	epoch := append([]byte(nil), hw.lineBuf...)
+	if hw.epoch == nil {
+		if hw.Version == 2 {
+			hw.lineBuf[0] = '&'
+		}
+	} else {
+		hw.lineBuf = appendTextDiff(hw.lineBuf[:0], hw.epoch, epoch)
+	}
+	hw.epoch = epoch
+	hw.writeLine()
+
+	// Write the clock offset line.
+	if rec.Offset != 0 {
+		width, prec := 12, 9
+		if hw.Version == 3 {
+			width, prec = 15, 12
+		}
+		v, _ := fixedPoint(rec.Offset, width, prec)
+		hw.lineBuf = appendDiff(hw.lineBuf, &hw.clock, v)
+	} else {
+		hw.clock.valid = false
+	}
+	hw.writeLine()
+
+	// Write each satellite's observations.
+	sats := make(map[[3]byte]*crinexSat, len(rec.Sat))
+	for _, sv := range rec.Sat {
+		sats[sv.PRN] = hw.writeSat(sv)
+	}
+	hw.sats = sats
+
+	return nil
+}
+
+// WriteEvent writes a special event record, as described for
+// ObsWriter.WriteEvent.
+func (hw *HatanakaWriter) WriteEvent(rec ObservationRecord, lines []HeaderLine) error {
+	if rec.EpochFlag < 2 || rec.EpochFlag > 5 {
+		return fmt.Errorf("Epoch flag %d is not a special event", rec.EpochFlag)
+	}
+	if len(lines) > 999 {
+		return errors.New("Too many header lines for one event")
+	}
+
+	// Event lines are always written in full, and so is the next
+	// epoch line.
+	if hw.Version == 2 {
+		hw.formatV2Epoch(rec, len(lines))
+		hw.lineBuf[0] = '&'
+	} else {
+		hw.formatV3Epoch(rec, len(lines))
+	}
+	hw.writeLine()
+	hw.epoch = nil
+
+	for _, line := range lines {
+		hw.writeHeaderLine(line.Label, line.Value)
+	}
+
+	if rec.EpochFlag == 3 || rec.EpochFlag == 4 {
+		// The observation types may have changed.
+		hw.sats = make(map[[3]byte]*crinexSat)
+	}
+	return nil
+}
+
+/************************** HELPER FUNCTIONS **************************/
+
+// fixedPoint converts v to an integer number of 10^-prec units,
+// rounding it the same way as a %width.precf format would, and checks
+// that it fits in width characters.
+func fixedPoint(v float64, width, prec int) (int64, error) {
+	text := strconv.FormatFloat(v, 'f', prec, 64)
+	if len(text) > width {
+		return 0, errors.New("Value is too wide")
+	}
+	return strconv.ParseInt(strings.Replace(text, ".", "", 1), 10, 64)
+}
+
+// appendDiff appends the CRINEX encoding of v, the next value in arc,
+// to line.  It starts a new arc if arc is not already valid.
+func appendDiff(line []byte, arc *diffArc, v int64) []byte {
+	if !arc.valid {
+		arc.start(defaultDiffOrder, v)
+		line = strconv.AppendInt(line, defaultDiffOrder, 10)
+		line = append(line, '&')
+		return strconv.AppendInt(line, v, 10)
+	}
+	return strconv.AppendInt(line, arc.diff(v), 10)
+}
+
+// appendTextDiff appends the CRINEX text difference from old to text
+// onto line, without trailing blanks.
+func appendTextDiff(line, old, text []byte) []byte {
+	n := len(text)
+	if len(old) > n {
+		n = len(old)
+	}
+	for i := 0; i < n; i++ {
+		o, c := byte(' '), byte(' ')
+		if i < len(old) {
+			o = old[i]
+		}
+		if i < len(text) {
+			c = text[i]
+		}
+		if c == o {
+			line = append(line, ' ')
+		} else if c == ' ' {
+			line = append(line, '&')
+		} else {
+			line = append(line, c)
+		}
+	}
+	for len(line) > 0 && line[len(line)-1] == ' ' {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// writeSat writes the data line for one satellite that checkRecord
+// has accepted, and returns its new encoding state.
+func (hw *HatanakaWriter) writeSat(sv SVObservation) *crinexSat {
+	obsList, _ := hw.obsTypes(sv.PRN)
+
+	sat := hw.sats[sv.PRN]
+	if sat == nil || len(sat.arcs) != len(obsList) {
+		sat = &crinexSat{arcs: make([]diffArc, len(obsList))}
+	}
+
+	flags := make([]byte, 0, 2*len(obsList))
+	for i := range obsList {
+		if i > 0 {
+			hw.lineBuf = append(hw.lineBuf, ' ')
+		}
+		var o Observation
+		if i < len(sv.Obs) {
+			o = sv.Obs[i]
+		}
+		if o.Value == 0 && !o.Present {
+			sat.arcs[i].valid = false
+			flags = append(flags, ' ', ' ')
+			continue
+		}
+		v, _ := fixedPoint(o.Value, 14, 3)
+		hw.lineBuf = appendDiff(hw.lineBuf, &sat.arcs[i], v)
+		flags = append(flags, lliChar(o), flagChar(o.SignalStrength))
+	}
+
+	// The flag difference follows the last observation.
+	hw.lineBuf = append(hw.lineBuf, ' ')
+	hw.lineBuf = appendTextDiff(hw.lineBuf, sat.flags, flags)
+	sat.flags = flags
+	hw.writeLine()
+
+	return sat
+}
@@ -0,0 +1,140 @@
+package rinex
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func compress(c *collector, or *ObsReader, version int) (string, error) {
+	bb := &bytes.Buffer{}
+	hw := NewHatanakaWriter(bb)
+	hw.Version = version
+	hw.Observations = or.Observations
+	if err := writeAll(hw, c); err != nil {
+		return "", err
+	}
+	return bb.String(), nil
+}
+
+// testHatanakaRoundTrip checks that compressing and then decompressing
+// text gives the same output as ObsWriter.
+func testHatanakaRoundTrip(t *testing.T, text string, version int) {
+	c, or, err := collect(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.records) == 0 {
+		t.Fatal("No records to compress")
+	}
+	plain, err := rewrite(c, or, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compact, err := compress(c, or, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expanded, err := io.ReadAll(NewHatanakaReader(strings.NewReader(compact)))
+	if err != nil {
+		t.Fatalf("%s\n%s", err, compact)
+	}
+	if string(expanded) != plain {
+		t.Errorf("Round trip differs:\n%s\nexpected:\n%s", expanded, plain)
+	}
+}
+
+func TestHatanakaWriterRoundTripV2(t *testing.T) {
+	testHatanakaRoundTrip(t, rinexV2Example, 2)
+}
+
+func TestHatanakaWriterRoundTripV3(t *testing.T) {
+	testHatanakaRoundTrip(t, rinexV3Example, 3)
+}
+
+// body returns the part of text after its END OF HEADER line.
+func body(text string) string {
+	return text[strings.Index(text, "END OF HEADER\n")+14:]
+}
+
+func TestHatanakaWriterFormat(t *testing.T) {
+	for _, x := range []struct {
+		compact, expanded string
+		version           int
+	}{
+		{crinexV1Example, crinexV1Expanded, 2},
+		{crinexV3Example, crinexV3Expanded, 3},
+	} {
+		c, or, err := collect(x.expanded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compact, err := compress(c, or, x.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body(compact) != body(x.compact) {
+			t.Errorf("Compressed text differs:\n%s\nexpected:\n%s", compact, x.compact)
+		}
+	}
+}
+
+func TestHatanakaWriterRecordError(t *testing.T) {
+	obs := map[byte][][3]byte{'G': {{'C', '1', 'C'}, {'L', '1', 'C'}}}
+	record := func(second float64, value float64) ObservationRecord {
+		return ObservationRecord{
+			Year: 2019, Month: 1, Day: 10, Nanoseconds: int64(second * 1e9), Offset: second * 1e-9,
+			Sat: []SVObservation{
+				{PRN: [3]byte{'G', '0', '5'}, Obs: []Observation{
+					{Value: 21557855.617 + second}, {Value: 113287291.649 + second}}},
+				{PRN: [3]byte{'G', '0', '7'}, Obs: []Observation{{Value: value}}},
+			},
+		}
+	}
+	good := []ObservationRecord{record(0, 1), record(60, 2)}
+
+	bb := &bytes.Buffer{}
+	ow := NewObsWriter(bb)
+	ow.Version = 3
+	ow.Observations = obs
+	cb := &bytes.Buffer{}
+	hw := NewHatanakaWriter(cb)
+	hw.Version = 3
+	hw.Observations = obs
+	if err := ow.WriteHeader(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := hw.WriteHeader(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed record must leave neither output nor changed arcs.
+	for i, rec := range good {
+		if err := ow.WriteRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+		if err := hw.WriteRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			if err := hw.WriteRecord(record(30, 1e12)); err == nil {
+				t.Error("Expected an error for a value that is too large")
+			}
+		}
+	}
+	if err := ow.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	expanded, err := io.ReadAll(NewHatanakaReader(bytes.NewReader(cb.Bytes())))
+	if err != nil {
+		t.Fatalf("%s\n%s", err, cb.String())
+	}
+	if body(string(expanded)) != body(bb.String()) {
+		t.Errorf("Output differs:\n%s\nexpected:\n%s", expanded, bb.String())
+	}
+}
@@ -0,0 +1,215 @@
+package rinex
+
+import (
+	"errors"
+	"io"
+)
+
+// lzwClear is the code that resets the string table in block mode.
+const lzwClear = 256
+
+// lzwReader decompresses data in the Unix compress (.Z) format.  The
+// standard compress/lzw package cannot read it: compress grows its
+// code width up to a limit given in the header, and pads the input to
+// a multiple of the old code width whenever the width changes.
+type lzwReader struct {
+	// r is the compressed input.
+	r io.ByteReader
+
+	// maxBits is the largest code width.
+	maxBits uint
+
+	// blockMode is true if lzwClear resets the string table.
+	blockMode bool
+
+	// nBits is the current code width.
+	nBits uint
+
+	// maxCode is the largest code that fits in nBits.
+	maxCode int
+
+	// freeEnt is the next free entry in the string table.
+	freeEnt int
+
+	// oldCode is the previous code, or -1 at the start of the input.
+	oldCode int
+
+	// finChar is the first byte of the previous code's string.
+	finChar byte
+
+	// bits holds nBuf bits of input that have not been used yet.
+	bits uint32
+	nBuf uint
+
+	// segBits counts the bits used since the code width last changed.
+	segBits uint
+
+	// prefix and suffix define the string table: the string for code
+	// c is the string for prefix[c] followed by suffix[c].
+	prefix []uint16
+	suffix []byte
+
+	// out holds decoded bytes that have not been returned yet.
+	out []byte
+
+	// err is the error that ends the output.
+	err error
+}
+
+// newLZWReader reads the compress header from r and returns a reader
+// for the decompressed data.
+func newLZWReader(r io.ByteReader) (*lzwReader, error) {
+	var head [3]byte
+	for i := range head {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, errors.New("Truncated compress header")
+		}
+		head[i] = b
+	}
+	if head[0] != 0x1f || head[1] != 0x9d {
+		return nil, errors.New("Not in compress format")
+	}
+
+	maxBits := uint(head[2] & 0x1f)
+	if maxBits < 9 || maxBits > 16 {
+		return nil, errors.New("Unsupported compress code width")
+	}
+	lr := &lzwReader{
+		r:         r,
+		maxBits:   maxBits,
+		blockMode: head[2]&0x80 != 0,
+		nBits:     9,
+		maxCode:   1<<9 - 1,
+		freeEnt:   256,
+		oldCode:   -1,
+		prefix:    make([]uint16, 1<<maxBits),
+		suffix:    make([]byte, 1<<maxBits),
+	}
+	if lr.blockMode {
+		lr.freeEnt = lzwClear + 1
+	}
+	for i := 0; i < 256; i++ {
+		lr.suffix[i] = byte(i)
+	}
+	return lr, nil
+}
+
+// Read implements io.Reader.
+func (lr *lzwReader) Read(p []byte) (int, error) {
+	for len(lr.out) == 0 && lr.err == nil {
+		lr.err = lr.decode()
+	}
+	n := copy(p, lr.out)
+	lr.out = lr.out[n:]
+	if len(lr.out) == 0 && lr.err != nil {
+		return n, lr.err
+	}
+	return n, nil
+}
+
+// readBits reads n (at most 16) bits of input, least significant first.
+func (lr *lzwReader) readBits(n uint) (int, error) {
+	for lr.nBuf < n {
+		b, err := lr.r.ReadByte()
+		if err != nil {
+			// Leftover bits at the end of the input are padding.
+			return 0, err
+		}
+		lr.bits |= uint32(b) << lr.nBuf
+		lr.nBuf += 8
+	}
+	v := int(lr.bits & (1<<n - 1))
+	lr.bits >>= n
+	lr.nBuf -= n
+	lr.segBits += n
+	return v, nil
+}
+
+// align skips the padding that compress writes before it changes the
+// code width: the rest of the current group of eight codes.
+func (lr *lzwReader) align() error {
+	group := lr.nBits * 8
+	for skip := (group - lr.segBits%group) % group; skip > 0; {
+		n := skip
+		if n > 16 {
+			n = 16
+		}
+		if _, err := lr.readBits(n); err != nil {
+			return err
+		}
+		skip -= n
+	}
+	lr.segBits = 0
+	return nil
+}
+
+// decode reads one code and appends its string to lr.out.
+func (lr *lzwReader) decode() error {
+	if lr.freeEnt > lr.maxCode && lr.nBits < lr.maxBits {
+		if err := lr.align(); err != nil {
+			return err
+		}
+		lr.nBits++
+		lr.maxCode = 1<<lr.nBits - 1
+	}
+
+	code, err := lr.readBits(lr.nBits)
+	if err != nil {
+		return err
+	}
+
+	// The first code is always a literal byte.
+	if lr.oldCode < 0 {
+		if code >= 256 {
+			return errors.New("Corrupt compress data")
+		}
+		lr.oldCode = code
+		lr.finChar = byte(code)
+		lr.out = append(lr.out[:0], lr.finChar)
+		return nil
+	}
+
+	if code == lzwClear && lr.blockMode {
+		// The entry after the clear code gets a meaningless string,
+		// but only codes above lzwClear are used after this.
+		lr.freeEnt = lzwClear
+		if err := lr.align(); err != nil {
+			return err
+		}
+		lr.nBits = 9
+		lr.maxCode = 1<<9 - 1
+		return nil
+	}
+
+	// Build the string for code backwards, then reverse it.
+	inCode := code
+	stack := lr.out[:0]
+	if code >= lr.freeEnt {
+		// This is the "KwKwK" case, where the code is being defined.
+		if code > lr.freeEnt {
+			return errors.New("Corrupt compress data")
+		}
+		stack = append(stack, lr.finChar)
+		code = lr.oldCode
+	}
+	for code >= 256 {
+		stack = append(stack, lr.suffix[code])
+		code = int(lr.prefix[code])
+	}
+	lr.finChar = byte(code)
+	stack = append(stack, lr.finChar)
+	for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+		stack[i], stack[j] = stack[j], stack[i]
+	}
+	lr.out = stack
+
+	// Add the new string to the table.
+	if lr.freeEnt < 1<<lr.maxBits {
+		lr.prefix[lr.freeEnt] = uint16(lr.oldCode)
+		lr.suffix[lr.freeEnt] = lr.finChar
+		lr.freeEnt++
+	}
+	lr.oldCode = inCode
+	return nil
+}
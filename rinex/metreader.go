@@ -0,0 +1,325 @@
+package rinex
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// MetSensor describes the sensor for one type of meteorological
+// observation, as given by SENSOR MOD/TYPE/ACC and SENSOR POS XYZ/H
+// headers.
+type MetSensor struct {
+	// Model and Type describe the sensor.
+	Model, Type string
+
+	// Accuracy is the sensor accuracy, in the units of the
+	// observation.
+	Accuracy float64
+
+	// Position is the approximate ECEF position of the sensor (m), or
+	// all zeros if the header did not give it.
+	Position [3]float64
+
+	// Height is the ellipsoidal height of the sensor (m).
+	Height float64
+}
+
+// MetRecord holds one epoch of meteorological observations.
+type MetRecord struct {
+	// Time is the time of the observations, in GPS time, reported as
+	// if it were in UTC (see ObservationRecord.Time).
+	Time time.Time
+
+	// Values holds the observations, in the same order as
+	// MetReader.Observations.  Observations that are not present in
+	// the file are NaN.
+	Values []float64
+}
+
+// maxMetTypes is the largest count that a # / TYPES OF OBSERV header
+// may declare.  RINEX defines about a dozen meteorological observation
+// types, so this allows for ten continuation lines and then some.
+const maxMetTypes = 99
+
+// MetReader reads RINEX meteorological data files, such as RINEX 2.11
+// .yym files and RINEX 3 or 4 _MM.rnx files.
+type MetReader struct {
+	// HeaderFunc is a function that is called for each header line.
+	// label starts at the 61st column, and is always 20 bytes long.
+	// If HeaderFunc returns non-nil, parsing stops.
+	HeaderFunc func(label, value string) error
+
+	// RecordFunc is a function that is called for each data record.
+	// If it returns non-nil, parsing stops.  The reader reuses
+	// rec.Values for the next record.
+	RecordFunc func(rec MetRecord) error
+
+	// Observations lists the two-character types of observations in
+	// the file, such as "PR" (pressure, mbar), "TD" (dry temperature,
+	// degrees Celsius), "HR" (relative humidity, percent) and "ZW"
+	// (wet zenith path delay, mm).
+	Observations []string
+
+	// Sensors maps an observation type to the sensor that made it.
+	Sensors map[string]*MetSensor
+
+	// version is the RINEX version number for the stream.
+	version int
+
+	// inHeader is true when we are parsing the RINEX header.
+	inHeader bool
+
+	// numTypes is the count from the first # / TYPES OF OBSERV line.
+	numTypes int
+
+	// rec holds the record that is currently being read.
+	rec MetRecord
+
+	// count is the number of values read into rec so far.
+	count int
+
+	// lineBuf holds the line currently being processed.
+	lineBuf [80]byte
+}
+
+/************************ TOP LEVEL FUNCTIONS ************************/
+
+// Parse reads RINEX meteorological data from r and runs the callback
+// functions in mr.
+func (mr *MetReader) Parse(r io.Reader) error {
+	mr.inHeader = true
+	mr.version = 0
+	mr.numTypes = 0
+	mr.count = 0
+	mr.Observations = nil
+	mr.Sensors = make(map[string]*MetSensor)
+	s := bufio.NewScanner(r)
+
+	for s.Scan() {
+		// Space-pad the input to 80 characters.
+		b := s.Bytes()
+		if len(b) > 80 {
+			return errors.New("Oversized input line")
+		}
+		for i := copy(mr.lineBuf[:], b); i < 80; i++ {
+			mr.lineBuf[i] = ' '
+		}
+		line := string(mr.lineBuf[:])
+
+		if mr.inHeader {
+			if err := mr.handleHeader(line); err != nil {
+				return err
+			}
+		} else if err := mr.parseData(line); err != nil {
+			return err
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if mr.count > 0 {
+		return errors.New("Meteorological record is truncated")
+	}
+	return nil
+}
+
+// handleHeader parses a RINEX meteorological header line.
+func (mr *MetReader) handleHeader(line string) error {
+	var err error
+	value := line[:60]
+	label := line[60:]
+
+	if handler := metSpecialHeaders[label]; handler != nil {
+		err = handler(mr, value)
+	}
+
+	if err == nil && mr.HeaderFunc != nil {
+		err = mr.HeaderFunc(label, value)
+	}
+
+	return err
+}
+
+/*************************** RECORD PARSING ***************************/
+
+// parseData parses the first or a continuation line of a data record.
+func (mr *MetReader) parseData(line string) error {
+	if mr.count == 0 && strings.TrimSpace(line) == "" {
+		return nil
+	}
+
+	start, max := 4, 10
+	if mr.count == 0 {
+		// This is the first line of a record.
+		t, err := mr.parseEpoch(line)
+		if err != nil {
+			return err
+		}
+		mr.rec.Time = t
+		mr.rec.Values = mr.rec.Values[:0]
+		start, max = 18, 8
+		if mr.version > 2 {
+			start = 20
+		}
+	}
+
+	for i := 0; i < max && mr.count < len(mr.Observations); i++ {
+		pos := start + 7*i
+		v := math.NaN()
+		if field := strings.TrimSpace(line[pos : pos+7]); field != "" {
+			var err error
+			if v, err = parseFloat(field, 64); err != nil {
+				return err
+			}
+		}
+		mr.rec.Values = append(mr.rec.Values, v)
+		mr.count++
+	}
+
+	if mr.count < len(mr.Observations) {
+		return nil
+	}
+	mr.count = 0
+	if mr.RecordFunc != nil {
+		return mr.RecordFunc(mr.rec)
+	}
+	return nil
+}
+
+// parseEpoch parses the time at the start of a data record.
+func (mr *MetReader) parseEpoch(line string) (time.Time, error) {
+	var date [6]int
+	var pos int
+	var err error
+	if mr.version == 2 {
+		if date[0], err = parseNavInt(line[1:3]); err != nil {
+			return time.Time{}, err
+		}
+		if date[0] < 80 {
+			date[0] += 2000
+		} else {
+			date[0] += 1900
+		}
+		pos = 3
+	} else {
+		if date[0], err = parseNavInt(line[1:5]); err != nil {
+			return time.Time{}, err
+		}
+		pos = 5
+	}
+	for i := 1; i < 6; i++ {
+		if date[i], err = parseNavInt(line[pos+1 : pos+3]); err != nil {
+			return time.Time{}, err
+		}
+		pos += 3
+	}
+	return time.Date(date[0], time.Month(date[1]), date[2], date[3],
+		date[4], date[5], 0, time.UTC), nil
+}
+
+/********************** HEADER PARSING FUNCTIONS **********************/
+
+// metSpecialHeaders lists the headers that MetReader treats specially.
+var metSpecialHeaders = map[string]func(*MetReader, string) error{
+	"RINEX VERSION / TYPE": (*MetReader).handleRINEXVersion,
+	"END OF HEADER       ": (*MetReader).handleEndOfHeader,
+	"# / TYPES OF OBSERV ": (*MetReader).handleNumTypesOfObserv,
+	"SENSOR MOD/TYPE/ACC ": (*MetReader).handleSensorModTypeAcc,
+	"SENSOR POS XYZ/H    ": (*MetReader).handleSensorPosXYZH,
+}
+
+// handleRINEXVersion handles a RINEX VERSION / TYPE header.
+func (mr *MetReader) handleRINEXVersion(value string) error {
+	fltVersion, err := parseFloat(value[0:9], 32)
+	if err != nil {
+		return err
+	}
+	mr.version = int(math.Round(fltVersion))
+	if mr.version < 2 || mr.version > 4 {
+		return errors.New("Invalid RINEX version " + value[0:9])
+	}
+
+	if value[20] != 'M' {
+		return errors.New("Expected meteorological file, but got " + value[20:21])
+	}
+
+	return nil
+}
+
+// handleEndOfHeader handles a END OF HEADER header.
+func (mr *MetReader) handleEndOfHeader(_ string) error {
+	if mr.version == 0 {
+		return errors.New("RINEX header did not declare its version")
+	}
+	if len(mr.Observations) != mr.numTypes {
+		return errors.New("Missing meteorological observation types")
+	}
+	mr.inHeader = false
+	return nil
+}
+
+// handleNumTypesOfObserv handles a # / TYPES OF OBSERV header.
+func (mr *MetReader) handleNumTypesOfObserv(value string) error {
+	// Is this the first line?
+	if mr.Observations == nil {
+		count, err := parseNavInt(value[0:6])
+		if err != nil {
+			return err
+		}
+		if count < 0 || count > maxMetTypes {
+			return errors.New("Invalid count of meteorological observation types")
+		}
+		mr.numTypes = count
+		mr.Observations = make([]string, 0, count)
+	}
+
+	// Read up to 9 observation types from this line.
+	for i := 0; i < 9 && len(mr.Observations) < mr.numTypes; i++ {
+		mr.Observations = append(mr.Observations, value[10+6*i:12+6*i])
+	}
+
+	return nil
+}
+
+// sensor returns the MetSensor for an observation type.
+func (mr *MetReader) sensor(obsType string) *MetSensor {
+	s := mr.Sensors[obsType]
+	if s == nil {
+		s = &MetSensor{}
+		mr.Sensors[obsType] = s
+	}
+	return s
+}
+
+// handleSensorModTypeAcc handles a SENSOR MOD/TYPE/ACC header.
+func (mr *MetReader) handleSensorModTypeAcc(value string) error {
+	acc, err := parseNavFloat(value[46:53])
+	if err != nil {
+		return err
+	}
+	s := mr.sensor(value[57:59])
+	s.Model = strings.TrimSpace(value[0:20])
+	s.Type = strings.TrimSpace(value[20:40])
+	s.Accuracy = acc
+	return nil
+}
+
+// handleSensorPosXYZH handles a SENSOR POS XYZ/H header.
+func (mr *MetReader) handleSensorPosXYZH(value string) error {
+	var f [4]float64
+	for i := range f {
+		var err error
+		if f[i], err = parseNavFloat(value[14*i : 14*i+14]); err != nil {
+			return err
+		}
+	}
+	s := mr.sensor(value[57:59])
+	copy(s.Position[:], f[:3])
+	s.Height = f[3]
+	return nil
+}
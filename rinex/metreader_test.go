@@ -0,0 +1,107 @@
+package rinex
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rinexV2MetExample is based on the example meteorological file in the
+// RINEX 2.11 specification, with a sensor position and a record that
+// needs a continuation line.
+const rinexV2MetExample = `     2.11           METEOROLOGICAL DATA                     RINEX VERSION / TYPE
+XXRINEXM V9.9       AIUB                22-APR-93 12:43     PGM / RUN BY / DATE
+EXAMPLE OF A MET DATA FILE                                  COMMENT
+A 9080                                                      MARKER NAME
+    10    PR    TD    HR    ZW    ZD    ZT    WD    WS    RI# / TYPES OF OBSERV
+          HI                                                # / TYPES OF OBSERV
+PAROSCIENTIFIC      740-16B                       0.2    PR SENSOR MOD/TYPE/ACC
+HAENNI                                            0.1    TD SENSOR MOD/TYPE/ACC
+ROTRONIC            I-240W                        5.0    HR SENSOR MOD/TYPE/ACC
+  4331297.3480   567555.6390  4633133.7280      214.7000 PR SENSOR POS XYZ/H
+                                                            END OF HEADER
+ 96  4  1  0  0 15  987.1   10.6   89.5  123.4 2000.1 2123.5  180.0    3.5
+        0.0    1.0
+ 96  4  1  0  0 30  987.2   -0.9   90.0        2000.2 2123.6  181.0    3.4
+        0.0    0.0
+`
+
+// rinexV3MetExample is a RINEX 3 meteorological file.
+const rinexV3MetExample = `     3.04           METEOROLOGICAL DATA                     RINEX VERSION / TYPE
+     3    PR    TD    HR                                    # / TYPES OF OBSERV
+                                                            END OF HEADER
+ 2019  1 10  0  0  0 1013.2   21.5   45.0
+ 2019  1 10  0  5  0 1013.1   21.7   44.5
+`
+
+func parseMet(t *testing.T, text string) (*MetReader, []MetRecord) {
+	var recs []MetRecord
+	mr := &MetReader{
+		RecordFunc: func(rec MetRecord) error {
+			rec.Values = append([]float64(nil), rec.Values...)
+			recs = append(recs, rec)
+			return nil
+		},
+	}
+	if err := mr.Parse(strings.NewReader(text)); err != nil {
+		t.Fatal(err)
+	}
+	return mr, recs
+}
+
+func TestParseMetV2(t *testing.T) {
+	mr, recs := parseMet(t, rinexV2MetExample)
+
+	if len(mr.Observations) != 10 || mr.Observations[0] != "PR" ||
+		mr.Observations[9] != "HI" {
+		t.Errorf("Bad observation types %q", mr.Observations)
+	}
+	pr := mr.Sensors["PR"]
+	if pr == nil || pr.Model != "PAROSCIENTIFIC" || pr.Type != "740-16B" ||
+		pr.Accuracy != 0.2 || pr.Position[0] != 4331297.348 || pr.Height != 214.7 {
+		t.Errorf("Bad PR sensor: %+v", pr)
+	}
+	if td := mr.Sensors["TD"]; td == nil || td.Type != "" || td.Accuracy != 0.1 {
+		t.Errorf("Bad TD sensor: %+v", td)
+	}
+
+	if len(recs) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(recs))
+	}
+	if !recs[0].Time.Equal(time.Date(1996, 4, 1, 0, 0, 15, 0, time.UTC)) {
+		t.Errorf("Bad time %v", recs[0].Time)
+	}
+	if recs[0].Values[0] != 987.1 || recs[0].Values[9] != 1 {
+		t.Errorf("Bad values %v", recs[0].Values)
+	}
+	if recs[1].Values[1] != -0.9 || !math.IsNaN(recs[1].Values[3]) {
+		t.Errorf("Bad values %v", recs[1].Values)
+	}
+}
+
+func TestParseMetV3(t *testing.T) {
+	_, recs := parseMet(t, rinexV3MetExample)
+
+	if len(recs) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(recs))
+	}
+	if !recs[1].Time.Equal(time.Date(2019, 1, 10, 0, 5, 0, 0, time.UTC)) {
+		t.Errorf("Bad time %v", recs[1].Time)
+	}
+	if recs[1].Values[0] != 1013.1 || recs[1].Values[2] != 44.5 {
+		t.Errorf("Bad values %v", recs[1].Values)
+	}
+}
+
+func TestParseMetErrors(t *testing.T) {
+	for _, text := range []string{
+		strings.Replace(rinexV3MetExample, "     3    PR", "    -3    PR", 1),
+		strings.Replace(rinexV2MetExample, "    10    PR", "999999    PR", 1),
+	} {
+		mr := &MetReader{}
+		if err := mr.Parse(strings.NewReader(text)); err == nil {
+			t.Errorf("Expected an error")
+		}
+	}
+}
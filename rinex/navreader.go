@@ -0,0 +1,1207 @@
+package rinex
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/entrope/gnss/gnsstime"
+)
+
+// Ephemeris is implemented by each of the per-GNSS broadcast ephemeris
+// types that NavReader produces: *GPSEphemeris and *CNAVEphemeris (for
+// GPS and QZSS), *GalileoEphemeris, *BeiDouEphemeris,
+// *GLONASSEphemeris and *SBASEphemeris.
+type Ephemeris interface {
+	// SatelliteID returns the RINEX satellite identifier, such as
+	// "G05" or "R21".
+	SatelliteID() [3]byte
+
+	// ClockEpoch returns the reference time of the satellite clock
+	// parameters (toc).  The date and time are in the GNSS's own time
+	// scale, except that GLONASS uses UTC, but are reported as if
+	// they were in UTC; see ObservationRecord.Time.
+	ClockEpoch() time.Time
+}
+
+// KeplerEphemeris holds the clock and orbit parameters that are shared
+// by the GPS, QZSS, Galileo and BeiDou broadcast navigation messages.
+// Angles are in radians and angular rates are in radians per second.
+type KeplerEphemeris struct {
+	// PRN identifies the satellite, as in SVObservation.PRN.
+	PRN [3]byte
+
+	// TOC is the reference time of the clock parameters.
+	TOC time.Time
+
+	// ClockBias, ClockDrift and ClockDriftRate are the clock
+	// polynomial coefficients af0 (s), af1 (s/s) and af2 (s/s^2).
+	ClockBias, ClockDrift, ClockDriftRate float64
+
+	// Crs and Crc are the orbit radius harmonic corrections (m).
+	Crs, Crc float64
+
+	// Cuc and Cus are the argument of latitude harmonic corrections.
+	Cuc, Cus float64
+
+	// Cic and Cis are the inclination harmonic corrections.
+	Cic, Cis float64
+
+	// DeltaN is the mean motion difference from the computed value.
+	DeltaN float64
+
+	// DeltaNDot is the rate of change of DeltaN.  Only CNAV messages
+	// provide it; it is zero for other messages.
+	DeltaNDot float64
+
+	// M0 is the mean anomaly at the reference time.
+	M0 float64
+
+	// Ecc is the orbit eccentricity.
+	Ecc float64
+
+	// SqrtA is the square root of the semi-major axis (m^0.5) at the
+	// reference time.
+	SqrtA float64
+
+	// ADot is the rate of change of the semi-major axis (m/s).  Only
+	// CNAV messages provide it; it is zero for other messages.
+	ADot float64
+
+	// Toe is the time of ephemeris, in seconds into Week.
+	Toe float64
+
+	// Omega0 is the longitude of the ascending node at the start of
+	// Week.
+	Omega0 float64
+
+	// I0 is the inclination angle at the reference time.
+	I0 float64
+
+	// Omega is the argument of perigee.
+	Omega float64
+
+	// OmegaDot is the rate of change of right ascension.
+	OmegaDot float64
+
+	// IDot is the rate of change of inclination.
+	IDot float64
+
+	// Week is the week number of Toe in the GNSS's own time scale.  It
+	// is continuous, not modulo 1024 (or 8192 for BeiDou).
+	Week int
+
+	// Health is the satellite health field.  Its interpretation
+	// depends on the GNSS, but zero always means healthy.
+	Health int
+
+	// TransmitTime is the transmission time of the message, in
+	// seconds into Week.
+	TransmitTime float64
+}
+
+// SatelliteID implements the Ephemeris interface.
+func (eph *KeplerEphemeris) SatelliteID() [3]byte {
+	return eph.PRN
+}
+
+// ClockEpoch implements the Ephemeris interface.
+func (eph *KeplerEphemeris) ClockEpoch() time.Time {
+	return eph.TOC
+}
+
+// GPSEphemeris is a GPS or QZSS LNAV broadcast ephemeris.
+type GPSEphemeris struct {
+	KeplerEphemeris
+
+	// IODE and IODC are the issue of data for the ephemeris and clock.
+	IODE, IODC int
+
+	// CodesOnL2 and L2PDataFlag are copied from the navigation message.
+	CodesOnL2, L2PDataFlag int
+
+	// Accuracy is the SV accuracy (URA) in meters.
+	Accuracy float64
+
+	// TGD is the L1/L2 group delay differential (s).
+	TGD float64
+
+	// FitInterval is the curve fit interval in hours.  (For QZSS, it
+	// is the fit interval flag.)
+	FitInterval float64
+}
+
+// CNAVEphemeris is a GPS or QZSS CNAV (L2C and L5) or CNAV-2 (L1C)
+// broadcast ephemeris, as found in RINEX 4 files.  The time of
+// ephemeris is the same as the clock reference time.
+type CNAVEphemeris struct {
+	KeplerEphemeris
+
+	// MessageType is "CNAV" or "CNV2".
+	MessageType string
+
+	// Top is the time of prediction, in seconds into Week.
+	Top float64
+
+	// URAIED is the elevation-dependent user range accuracy index, and
+	// URAINED0, URAINED1 and URAINED2 are the non-elevation-dependent
+	// user range accuracy terms.
+	URAIED, URAINED0, URAINED1, URAINED2 float64
+
+	// TGD is the L1/L2 group delay differential (s).
+	TGD float64
+
+	// ISCL1CA, ISCL2C, ISCL5I5 and ISCL5Q5 are the inter-signal
+	// corrections (s).
+	ISCL1CA, ISCL2C, ISCL5I5, ISCL5Q5 float64
+
+	// ISCL1CD and ISCL1CP are the L1C inter-signal corrections (s).
+	// Only CNAV-2 messages provide them.
+	ISCL1CD, ISCL1CP float64
+}
+
+// GalileoEphemeris is a Galileo I/NAV or F/NAV broadcast ephemeris.
+type GalileoEphemeris struct {
+	KeplerEphemeris
+
+	// IODNav is the issue of data of the navigation batch.
+	IODNav int
+
+	// DataSources is the bit mask that identifies the message type
+	// (I/NAV or F/NAV) and which clock parameters are referenced.
+	DataSources int
+
+	// SISA is the signal in space accuracy in meters.
+	SISA float64
+
+	// BGDE5aE1 and BGDE5bE1 are the broadcast group delays (s).
+	BGDE5aE1, BGDE5bE1 float64
+}
+
+// BeiDouEphemeris is a BeiDou D1 or D2 broadcast ephemeris.
+type BeiDouEphemeris struct {
+	KeplerEphemeris
+
+	// AODE and AODC are the age of data for the ephemeris and clock.
+	AODE, AODC int
+
+	// Accuracy is the SV accuracy in meters.
+	Accuracy float64
+
+	// TGD1 and TGD2 are the B1/B3 and B2/B3 group delays (s).
+	TGD1, TGD2 float64
+}
+
+// GLONASSEphemeris is a GLONASS broadcast ephemeris, which gives the
+// satellite state vector in the PZ-90 frame rather than a Keplerian
+// orbit.  Positions are in km, velocities in km/s and accelerations
+// in km/s^2.
+type GLONASSEphemeris struct {
+	// PRN identifies the satellite by its slot number.
+	PRN [3]byte
+
+	// TOC is the reference time of the ephemeris, in UTC.
+	TOC time.Time
+
+	// ClockBias is the SV clock bias, -TauN (s).
+	ClockBias float64
+
+	// RelFreqBias is the SV relative frequency bias, +GammaN.
+	RelFreqBias float64
+
+	// MessageFrameTime is the message frame time tk, in seconds into
+	// the UTC week (RINEX 3) or the UTC day (RINEX 2).
+	MessageFrameTime float64
+
+	// Position, Velocity and Acceleration are the X, Y and Z
+	// components of the satellite state at TOC.
+	Position, Velocity, Acceleration [3]float64
+
+	// Health is the health flag Bn; zero means healthy.
+	Health int
+
+	// FreqNum is the frequency channel number (-7 to +13).
+	FreqNum int
+
+	// AgeOfInfo is the age of operation information E (days).
+	AgeOfInfo float64
+}
+
+// SatelliteID implements the Ephemeris interface.
+func (eph *GLONASSEphemeris) SatelliteID() [3]byte {
+	return eph.PRN
+}
+
+// ClockEpoch implements the Ephemeris interface.
+func (eph *GLONASSEphemeris) ClockEpoch() time.Time {
+	return eph.TOC
+}
+
+// SBASEphemeris is an SBAS (geostationary satellite) broadcast
+// ephemeris.  It uses the same units as GLONASSEphemeris.
+type SBASEphemeris struct {
+	// PRN identifies the satellite.
+	PRN [3]byte
+
+	// TOC is the reference time of the ephemeris, in GPS time.
+	TOC time.Time
+
+	// ClockBias and ClockDrift are aGf0 (s) and aGf1 (s/s).
+	ClockBias, ClockDrift float64
+
+	// TransmitTime is the transmission time of the message, in
+	// seconds into the GPS week.
+	TransmitTime float64
+
+	// Position, Velocity and Acceleration are the X, Y and Z
+	// components of the satellite state at TOC.
+	Position, Velocity, Acceleration [3]float64
+
+	// Health is the SV health field.
+	Health int
+
+	// URA is the user range accuracy index.
+	URA float64
+
+	// IODN is the issue of data navigation.
+	IODN int
+}
+
+// SatelliteID implements the Ephemeris interface.
+func (eph *SBASEphemeris) SatelliteID() [3]byte {
+	return eph.PRN
+}
+
+// ClockEpoch implements the Ephemeris interface.
+func (eph *SBASEphemeris) ClockEpoch() time.Time {
+	return eph.TOC
+}
+
+// TimeSystemCorr describes a correction between two time scales, as
+// given by a RINEX 3 TIME SYSTEM CORR header or a RINEX 4 STO record:
+// CORR(s) = A0 + A1*dt + A2*dt^2, where
+// dt = t - (RefTime + 604800*RefWeek).
+type TimeSystemCorr struct {
+	// A0 (s), A1 (s/s) and A2 (s/s^2) are the polynomial
+	// coefficients.  Only RINEX 4 provides A2.
+	A0, A1, A2 float64
+
+	// RefTime is the reference time in seconds into RefWeek.
+	RefTime int
+
+	// RefWeek is the reference week number.
+	RefWeek int
+
+	// Source identifies the satellite or augmentation system that
+	// provided the correction, if known.
+	Source string
+
+	// UTCID identifies the UTC realization, if known, using the
+	// numbering from RINEX 3: 1 for UTC(NIST), 2 for UTC(USNO), 3 for
+	// UTC(SU), 4 for UTC(BIPM), 5 for UTC(Europe Lab), 6 for UTC(CRL)
+	// and 7 for UTC(NTSC).
+	UTCID int
+}
+
+// EarthOrientation holds the Earth orientation parameters from a RINEX
+// 4 EOP record.  Pole coordinates are in arcseconds and the UT1-UTC
+// difference is in seconds; their rates are per day and per day
+// squared.
+type EarthOrientation struct {
+	// Source identifies the satellite that broadcast the parameters.
+	Source [3]byte
+
+	// MessageType is the navigation message type, such as "CNVX".
+	MessageType string
+
+	// RefTime is the reference time of the parameters, in the GNSS's
+	// own time scale.
+	RefTime time.Time
+
+	// XP, XPRate and XPAccel describe the X pole coordinate.
+	XP, XPRate, XPAccel float64
+
+	// YP, YPRate and YPAccel describe the Y pole coordinate.
+	YP, YPRate, YPAccel float64
+
+	// DUT1, DUT1Rate and DUT1Accel describe UT1-UTC.
+	DUT1, DUT1Rate, DUT1Accel float64
+
+	// TransmitTime is the transmission time of the message, in seconds
+	// into the GNSS week.
+	TransmitTime float64
+}
+
+// NavReader reads RINEX navigation message files.  It handles RINEX
+// 2.11 GPS (.yyn) and GLONASS (.yyg) files, and RINEX 3.04 and 4.0x
+// files for any or all GNSSes.
+type NavReader struct {
+	// HeaderFunc is a function that is called for each header line.
+	// label starts at the 61st column, and is always 20 bytes long.
+	// If HeaderFunc returns non-nil, parsing stops.
+	HeaderFunc func(label, value string) error
+
+	// EphemerisFunc is a function that is called for each navigation
+	// record.  If it returns non-nil, parsing stops.  Records for
+	// GNSSes that this package does not support are skipped.
+	EphemerisFunc func(eph Ephemeris) error
+
+	// EOPFunc is a function that is called for each RINEX 4 EOP
+	// record.  If it returns non-nil, parsing stops.
+	EOPFunc func(eop EarthOrientation) error
+
+	// IonosphericCorr maps a correction type to its parameters.  The
+	// types are those from RINEX 3 IONOSPHERIC CORR headers ("GPSA",
+	// "GPSB", "GAL", "QZSA", "BDSA", etc.); RINEX 2 ION ALPHA and
+	// ION BETA are reported as "GPSA" and "GPSB".  Galileo uses only
+	// the first three parameters, except that a RINEX 4 ION record
+	// puts the ionospheric disturbance flags in the fourth.  RINEX 4
+	// ION records update this map as they are read, so it holds the
+	// most recent parameters.
+	IonosphericCorr map[string][4]float64
+
+	// TimeSystemCorr maps a correction type (such as "GPUT", "GAUT"
+	// or "GLGP") to its parameters.  A RINEX 2 DELTA-UTC header is
+	// reported as "GPUT", and a RINEX 2 CORR TO SYSTEM TIME header as
+	// "GLUT".  Like IonosphericCorr, RINEX 4 STO records update this
+	// map as they are read.
+	TimeSystemCorr map[string]TimeSystemCorr
+
+	// LeapSeconds is the number of leap seconds since 6 January 1980,
+	// if the header gave it.
+	LeapSeconds int
+
+	// version is the RINEX version number for the stream.
+	version int
+
+	// system is, for RINEX 2 streams, the satellite system character
+	// that the file type implies.
+	system byte
+
+	// inHeader is true when we are parsing the RINEX header.
+	inHeader bool
+
+	// lines holds the lines of the navigation record being read.
+	lines []string
+
+	// lineBuf holds the line currently being processed.
+	lineBuf [80]byte
+}
+
+/************************ TOP LEVEL FUNCTIONS ************************/
+
+// Parse reads RINEX navigation data from r and runs the callback
+// functions in nr.
+func (nr *NavReader) Parse(r io.Reader) error {
+	nr.inHeader = true
+	nr.version = 0
+	nr.system = 0
+	nr.lines = nr.lines[:0]
+	nr.IonosphericCorr = make(map[string][4]float64)
+	nr.TimeSystemCorr = make(map[string]TimeSystemCorr)
+	nr.LeapSeconds = 0
+	s := bufio.NewScanner(r)
+
+	for s.Scan() {
+		// Space-pad the input to 80 characters.
+		b := s.Bytes()
+		if len(b) > 80 {
+			return errors.New("Oversized input line")
+		}
+		for i := copy(nr.lineBuf[:], b); i < 80; i++ {
+			nr.lineBuf[i] = ' '
+		}
+		line := string(nr.lineBuf[:])
+
+		if nr.inHeader {
+			if err := nr.handleHeader(line); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Does this line start a new record?
+		if nr.isRecordStart(line) && len(nr.lines) > 0 {
+			if err := nr.flushRecord(); err != nil {
+				return err
+			}
+		}
+		if len(nr.lines) == 0 && strings.TrimSpace(line) == "" {
+			continue
+		}
+		nr.lines = append(nr.lines, line)
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if len(nr.lines) > 0 {
+		return nr.flushRecord()
+	}
+	return nil
+}
+
+// handleHeader parses a RINEX navigation header line.
+func (nr *NavReader) handleHeader(line string) error {
+	var err error
+	value := line[:60]
+	label := line[60:]
+
+	if handler := navSpecialHeaders[label]; handler != nil {
+		err = handler(nr, value)
+	}
+
+	if err == nil && nr.HeaderFunc != nil {
+		err = nr.HeaderFunc(label, value)
+	}
+
+	return err
+}
+
+// isRecordStart returns true if line is the first line of a record.
+func (nr *NavReader) isRecordStart(line string) bool {
+	switch nr.version {
+	case 2:
+		return line[1] != ' '
+	case 3:
+		return line[0] != ' '
+	}
+	return line[0] == '>'
+}
+
+/************************** HELPER FUNCTIONS **************************/
+
+// parseNavFloat parses a Fortran-style floating point field, which may
+// use 'D' as its exponent character.  A blank field is zero.
+func parseNavFloat(text string) (float64, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, nil
+	}
+	if i := strings.IndexAny(text, "Dd"); i >= 0 {
+		text = text[:i] + "E" + text[i+1:]
+	}
+	return strconv.ParseFloat(text, 64)
+}
+
+// parseNavInt parses a possibly blank integer field.
+func parseNavInt(text string) (int, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(text)
+}
+
+/*************************** RECORD PARSING ***************************/
+
+// flushRecord decodes the lines in nr.lines as one navigation record.
+func (nr *NavReader) flushRecord() error {
+	lines := nr.lines
+	nr.lines = nr.lines[:0]
+	if nr.version == 4 {
+		return nr.flushV4Record(lines)
+	}
+
+	var prn [3]byte
+	var toc time.Time
+	var fields []float64
+	var err error
+	if nr.version == 2 {
+		prn, toc, fields, err = nr.parseV2Record(lines)
+	} else {
+		prn, toc, fields, err = parseV3Record(lines)
+	}
+	if err != nil {
+		return err
+	}
+
+	var eph Ephemeris
+	switch prn[0] {
+	case 'G', 'J':
+		eph, err = newGPSEphemeris(prn, toc, fields)
+	case 'E':
+		eph, err = newGalileoEphemeris(prn, toc, fields)
+	case 'C':
+		eph, err = newBeiDouEphemeris(prn, toc, fields)
+	case 'R':
+		eph, err = newGLONASSEphemeris(prn, toc, fields)
+	case 'S':
+		eph, err = newSBASEphemeris(prn, toc, fields)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return nr.reportEphemeris(eph)
+}
+
+// reportEphemeris passes eph to nr.EphemerisFunc, if it is set.
+func (nr *NavReader) reportEphemeris(eph Ephemeris) error {
+	if nr.EphemerisFunc != nil {
+		return nr.EphemerisFunc(eph)
+	}
+	return nil
+}
+
+// parseV2Record parses the PRN / EPOCH / SV CLK line and BROADCAST
+// ORBIT lines of a RINEX 2 navigation record.
+func (nr *NavReader) parseV2Record(lines []string) ([3]byte, time.Time, []float64, error) {
+	var prn [3]byte
+	line := lines[0]
+
+	num, err := parseNavInt(line[0:2])
+	if err != nil {
+		return prn, time.Time{}, nil, err
+	}
+	prn = [3]byte{nr.system, byte('0' + num/10), byte('0' + num%10)}
+
+	var date [5]int
+	for i := range date {
+		if date[i], err = parseNavInt(line[2+3*i : 5+3*i]); err != nil {
+			return prn, time.Time{}, nil, err
+		}
+	}
+	if date[0] < 80 {
+		date[0] += 2000
+	} else {
+		date[0] += 1900
+	}
+	second, err := parseNavFloat(line[17:22])
+	if err != nil {
+		return prn, time.Time{}, nil, err
+	}
+	toc := navTime(date, second)
+
+	fields, err := parseNavFields(lines, 22, 3)
+	return prn, toc, fields, err
+}
+
+// parseV3Record parses the SV / EPOCH / SV CLK line and BROADCAST ORBIT
+// lines of a RINEX 3 navigation record.
+func parseV3Record(lines []string) ([3]byte, time.Time, []float64, error) {
+	var prn [3]byte
+	line := lines[0]
+	copy(prn[:], line[0:3])
+	if prn[1] == ' ' {
+		prn[1] = '0'
+	}
+
+	toc, err := parseV3Epoch(line)
+	if err != nil {
+		return prn, time.Time{}, nil, err
+	}
+
+	fields, err := parseNavFields(lines, 23, 4)
+	return prn, toc, fields, err
+}
+
+// parseV3Epoch parses the epoch in columns 5 through 23 of a RINEX 3
+// or 4 navigation record line.
+func parseV3Epoch(line string) (time.Time, error) {
+	var date [5]int
+	var err error
+	if date[0], err = parseNavInt(line[4:8]); err != nil {
+		return time.Time{}, err
+	}
+	for i := 1; i < 5; i++ {
+		if date[i], err = parseNavInt(line[6+3*i : 8+3*i]); err != nil {
+			return time.Time{}, err
+		}
+	}
+	second, err := parseNavInt(line[21:23])
+	if err != nil {
+		return time.Time{}, err
+	}
+	return navTime(date, float64(second)), nil
+}
+
+// parseNavFields parses the three D19.12 fields starting at column
+// first of lines[0], and the four D19.12 fields starting at column
+// orbit in each following line.
+func parseNavFields(lines []string, first, orbit int) ([]float64, error) {
+	fields := make([]float64, 0, 3+4*(len(lines)-1))
+	for i, line := range lines {
+		start, count := orbit, 4
+		if i == 0 {
+			start, count = first, 3
+		}
+		var err error
+		if fields, err = appendNavFloats(fields, line, start, count); err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+// appendNavFloats parses count D19.12 fields starting at column start
+// of line, and appends them to fields.
+func appendNavFloats(fields []float64, line string, start, count int) ([]float64, error) {
+	for j := 0; j < count; j++ {
+		pos := start + 19*j
+		v, err := parseNavFloat(line[pos : pos+19])
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, v)
+	}
+	return fields, nil
+}
+
+// navTime converts a year, month, day, hour and minute plus seconds to
+// a time.Time.
+func navTime(date [5]int, second float64) time.Time {
+	iSec := math.Floor(second)
+	nSec := int(math.Round(1e9 * (second - iSec)))
+	return time.Date(date[0], time.Month(date[1]), date[2], date[3],
+		date[4], int(iSec), nSec, time.UTC)
+}
+
+// checkNavFields returns an error if fields is shorter than count.
+func checkNavFields(prn [3]byte, fields []float64, count int) error {
+	if len(fields) < count {
+		return errors.New("Navigation record for " + string(prn[:]) +
+			" is truncated")
+	}
+	return nil
+}
+
+// newKeplerEphemeris fills in the fields of a KeplerEphemeris.
+func newKeplerEphemeris(prn [3]byte, toc time.Time, f []float64) KeplerEphemeris {
+	return KeplerEphemeris{
+		PRN:            prn,
+		TOC:            toc,
+		ClockBias:      f[0],
+		ClockDrift:     f[1],
+		ClockDriftRate: f[2],
+		Crs:            f[4],
+		DeltaN:         f[5],
+		M0:             f[6],
+		Cuc:            f[7],
+		Ecc:            f[8],
+		Cus:            f[9],
+		SqrtA:          f[10],
+		Toe:            f[11],
+		Cic:            f[12],
+		Omega0:         f[13],
+		Cis:            f[14],
+		I0:             f[15],
+		Crc:            f[16],
+		Omega:          f[17],
+		OmegaDot:       f[18],
+		IDot:           f[19],
+		Week:           int(f[21]),
+		Health:         int(f[24]),
+		TransmitTime:   f[27],
+	}
+}
+
+func newGPSEphemeris(prn [3]byte, toc time.Time, f []float64) (Ephemeris, error) {
+	if err := checkNavFields(prn, f, 28); err != nil {
+		return nil, err
+	}
+	eph := &GPSEphemeris{
+		KeplerEphemeris: newKeplerEphemeris(prn, toc, f),
+		IODE:            int(f[3]),
+		CodesOnL2:       int(f[20]),
+		L2PDataFlag:     int(f[22]),
+		Accuracy:        f[23],
+		TGD:             f[25],
+		IODC:            int(f[26]),
+	}
+	if len(f) > 28 {
+		eph.FitInterval = f[28]
+	}
+	return eph, nil
+}
+
+func newGalileoEphemeris(prn [3]byte, toc time.Time, f []float64) (Ephemeris, error) {
+	if err := checkNavFields(prn, f, 28); err != nil {
+		return nil, err
+	}
+	return &GalileoEphemeris{
+		KeplerEphemeris: newKeplerEphemeris(prn, toc, f),
+		IODNav:          int(f[3]),
+		DataSources:     int(f[20]),
+		SISA:            f[23],
+		BGDE5aE1:        f[25],
+		BGDE5bE1:        f[26],
+	}, nil
+}
+
+func newBeiDouEphemeris(prn [3]byte, toc time.Time, f []float64) (Ephemeris, error) {
+	if err := checkNavFields(prn, f, 28); err != nil {
+		return nil, err
+	}
+	eph := &BeiDouEphemeris{
+		KeplerEphemeris: newKeplerEphemeris(prn, toc, f),
+		AODE:            int(f[3]),
+		Accuracy:        f[23],
+		TGD1:            f[25],
+		TGD2:            f[26],
+	}
+	if len(f) > 28 {
+		eph.AODC = int(f[28])
+	}
+	return eph, nil
+}
+
+func newGLONASSEphemeris(prn [3]byte, toc time.Time, f []float64) (Ephemeris, error) {
+	if err := checkNavFields(prn, f, 15); err != nil {
+		return nil, err
+	}
+	return &GLONASSEphemeris{
+		PRN:              prn,
+		TOC:              toc,
+		ClockBias:        f[0],
+		RelFreqBias:      f[1],
+		MessageFrameTime: f[2],
+		Position:         [3]float64{f[3], f[7], f[11]},
+		Velocity:         [3]float64{f[4], f[8], f[12]},
+		Acceleration:     [3]float64{f[5], f[9], f[13]},
+		Health:           int(f[6]),
+		FreqNum:          int(f[10]),
+		AgeOfInfo:        f[14],
+	}, nil
+}
+
+func newSBASEphemeris(prn [3]byte, toc time.Time, f []float64) (Ephemeris, error) {
+	if err := checkNavFields(prn, f, 15); err != nil {
+		return nil, err
+	}
+	return &SBASEphemeris{
+		PRN:          prn,
+		TOC:          toc,
+		ClockBias:    f[0],
+		ClockDrift:   f[1],
+		TransmitTime: f[2],
+		Position:     [3]float64{f[3], f[7], f[11]},
+		Velocity:     [3]float64{f[4], f[8], f[12]},
+		Acceleration: [3]float64{f[5], f[9], f[13]},
+		Health:       int(f[6]),
+		URA:          f[10],
+		IODN:         int(f[14]),
+	}, nil
+}
+
+/************************* RINEX v4 FUNCTIONS *************************/
+
+// ionPrefixes maps a GNSS to the prefix of its Klobuchar parameters in
+// NavReader.IonosphericCorr.
+var ionPrefixes = map[byte]string{
+	'G': "GPS",
+	'J': "QZS",
+	'C': "BDS",
+	'I': "IRN",
+}
+
+// utcIDs maps RINEX 4 UTC identifiers to the RINEX 3 numbering that
+// TimeSystemCorr.UTCID uses.
+var utcIDs = map[string]int{
+	"UTC(NIST)": 1,
+	"UTC(USNO)": 2,
+	"UTC(SU)":   3,
+	"UTC(BIPM)": 4,
+	"UTCGAL":    5,
+	"UTC(NICT)": 6,
+	"UTC(NTSC)": 7,
+}
+
+// flushV4Record decodes a RINEX 4 navigation record, whose first line
+// gives the record type, satellite and message type.  Unknown record
+// and message types are skipped.
+func (nr *NavReader) flushV4Record(lines []string) error {
+	line := lines[0]
+	if line[0] != '>' {
+		return errors.New("Expected RINEX 4 record type line, got " + line)
+	}
+	if len(lines) < 2 {
+		return errors.New("RINEX 4 record has no data: " + line)
+	}
+	var prn [3]byte
+	copy(prn[:], line[6:9])
+	if prn[1] == ' ' {
+		prn[1] = '0'
+	}
+	msgType := strings.TrimSpace(line[10:15])
+
+	switch line[2:5] {
+	case "EPH":
+		return nr.parseV4Ephemeris(msgType, lines[1:])
+	case "STO":
+		return nr.parseV4STO(prn, lines[1:])
+	case "EOP":
+		return nr.parseV4EOP(prn, msgType, lines[1:])
+	case "ION":
+		return nr.parseV4ION(prn, msgType, lines[1:])
+	}
+	return nil
+}
+
+// parseV4Ephemeris parses the body of a RINEX 4 EPH record.
+func (nr *NavReader) parseV4Ephemeris(msgType string, lines []string) error {
+	prn, toc, fields, err := parseV3Record(lines)
+	if err != nil {
+		return err
+	}
+
+	var eph Ephemeris
+	switch prn[0] {
+	case 'G', 'J':
+		switch msgType {
+		case "LNAV":
+			eph, err = newGPSEphemeris(prn, toc, fields)
+		case "CNAV", "CNV2":
+			eph, err = newCNAVEphemeris(prn, toc, msgType, fields)
+		}
+	case 'E':
+		if msgType == "INAV" || msgType == "FNAV" {
+			eph, err = newGalileoEphemeris(prn, toc, fields)
+		}
+	case 'C':
+		if msgType == "D1" || msgType == "D2" {
+			eph, err = newBeiDouEphemeris(prn, toc, fields)
+		}
+	case 'R':
+		if msgType == "FDMA" {
+			eph, err = newGLONASSEphemeris(prn, toc, fields)
+		}
+	case 'S':
+		if msgType == "SBAS" {
+			eph, err = newSBASEphemeris(prn, toc, fields)
+		}
+	}
+	if err != nil || eph == nil {
+		return err
+	}
+
+	return nr.reportEphemeris(eph)
+}
+
+func newCNAVEphemeris(prn [3]byte, toc time.Time, msgType string, f []float64) (Ephemeris, error) {
+	// CNAV-2 has an extra line of inter-signal corrections before the
+	// transmission time.
+	tail := 31
+	if msgType == "CNV2" {
+		tail = 35
+	}
+	if err := checkNavFields(prn, f, tail+1); err != nil {
+		return nil, err
+	}
+
+	week, tow := gnsstime.GPS.Week(toc)
+	toe := tow.Seconds()
+	if len(f) > tail+1 && f[tail+1] != 0 {
+		week = int(f[tail+1])
+	}
+	eph := &CNAVEphemeris{
+		KeplerEphemeris: KeplerEphemeris{
+			PRN:            prn,
+			TOC:            toc,
+			ClockBias:      f[0],
+			ClockDrift:     f[1],
+			ClockDriftRate: f[2],
+			ADot:           f[3],
+			Crs:            f[4],
+			DeltaN:         f[5],
+			M0:             f[6],
+			Cuc:            f[7],
+			Ecc:            f[8],
+			Cus:            f[9],
+			SqrtA:          f[10],
+			Toe:            toe,
+			Cic:            f[12],
+			Omega0:         f[13],
+			Cis:            f[14],
+			I0:             f[15],
+			Crc:            f[16],
+			Omega:          f[17],
+			OmegaDot:       f[18],
+			IDot:           f[19],
+			DeltaNDot:      f[20],
+			Week:           week,
+			Health:         int(f[24]),
+			TransmitTime:   f[tail],
+		},
+		MessageType: msgType,
+		Top:         f[11],
+		URAINED0:    f[21],
+		URAINED1:    f[22],
+		URAIED:      f[23],
+		TGD:         f[25],
+		URAINED2:    f[26],
+		ISCL1CA:     f[27],
+		ISCL2C:      f[28],
+		ISCL5I5:     f[29],
+		ISCL5Q5:     f[30],
+	}
+	if msgType == "CNV2" {
+		eph.ISCL1CD = f[31]
+		eph.ISCL1CP = f[32]
+	}
+	return eph, nil
+}
+
+// parseV4STO parses the body of a RINEX 4 STO (system time offset)
+// record.
+func (nr *NavReader) parseV4STO(prn [3]byte, lines []string) error {
+	if len(lines) < 2 {
+		return errors.New("STO record for " + string(prn[:]) + " is truncated")
+	}
+	ref, err := parseV3Epoch(lines[0])
+	if err != nil {
+		return err
+	}
+	ids := strings.Fields(lines[0][23:])
+	if len(ids) == 0 {
+		return errors.New("STO record is missing its type: " + lines[0])
+	}
+	f, err := appendNavFloats(nil, lines[1], 4, 4)
+	if err != nil {
+		return err
+	}
+
+	corr := TimeSystemCorr{A0: f[1], A1: f[2], A2: f[3], Source: string(prn[:])}
+	scale := gnsstime.GPS
+	if strings.HasPrefix(ids[0], "BD") {
+		scale = gnsstime.BDT
+	}
+	week, tow := scale.Week(ref)
+	corr.RefWeek, corr.RefTime = week, int(tow.Seconds())
+	for _, id := range ids[1:] {
+		if n, ok := utcIDs[id]; ok {
+			corr.UTCID = n
+		} else if !strings.HasPrefix(id, "UTC") {
+			corr.Source = id
+		}
+	}
+	nr.TimeSystemCorr[ids[0]] = corr
+	return nil
+}
+
+// parseV4EOP parses the body of a RINEX 4 EOP (Earth orientation
+// parameter) record.
+func (nr *NavReader) parseV4EOP(prn [3]byte, msgType string, lines []string) error {
+	if len(lines) < 3 {
+		return errors.New("EOP record for " + string(prn[:]) + " is truncated")
+	}
+	ref, err := parseV3Epoch(lines[0])
+	if err != nil {
+		return err
+	}
+	f, err := parseNavFields(lines[:3], 23, 4)
+	if err != nil {
+		return err
+	}
+
+	// The second line has the same layout as the first.
+	eop := EarthOrientation{
+		Source:       prn,
+		MessageType:  msgType,
+		RefTime:      ref,
+		XP:           f[0],
+		XPRate:       f[1],
+		XPAccel:      f[2],
+		YP:           f[4],
+		YPRate:       f[5],
+		YPAccel:      f[6],
+		TransmitTime: f[7],
+		DUT1:         f[8],
+		DUT1Rate:     f[9],
+		DUT1Accel:    f[10],
+	}
+	if nr.EOPFunc != nil {
+		return nr.EOPFunc(eop)
+	}
+	return nil
+}
+
+// parseV4ION parses the body of a RINEX 4 ION (ionospheric model)
+// record.  It supports the Klobuchar and NeQuick-G models.
+func (nr *NavReader) parseV4ION(prn [3]byte, msgType string, lines []string) error {
+	if _, err := parseV3Epoch(lines[0]); err != nil {
+		return err
+	}
+	f, err := parseNavFields(lines, 23, 4)
+	if err != nil {
+		return err
+	}
+
+	if prn[0] == 'E' {
+		if err := checkNavFields(prn, f, 4); err != nil {
+			return err
+		}
+		nr.IonosphericCorr["GAL"] = [4]float64{f[0], f[1], f[2], f[3]}
+		return nil
+	}
+
+	prefix, ok := ionPrefixes[prn[0]]
+	if !ok || (prn[0] == 'C' && msgType != "D1D2") {
+		return nil
+	}
+	if err := checkNavFields(prn, f, 8); err != nil {
+		return err
+	}
+	nr.IonosphericCorr[prefix+"A"] = [4]float64{f[0], f[1], f[2], f[3]}
+	nr.IonosphericCorr[prefix+"B"] = [4]float64{f[4], f[5], f[6], f[7]}
+	return nil
+}
+
+/********************** HEADER PARSING FUNCTIONS **********************/
+
+// navSpecialHeaders lists the headers that NavReader treats specially.
+var navSpecialHeaders = map[string]func(*NavReader, string) error{
+	"RINEX VERSION / TYPE": (*NavReader).handleRINEXVersion,
+	"END OF HEADER       ": (*NavReader).handleEndOfHeader,
+	"ION ALPHA           ": (*NavReader).handleIonAlpha,
+	"ION BETA            ": (*NavReader).handleIonBeta,
+	"DELTA-UTC: A0,A1,T,W": (*NavReader).handleDeltaUTC,
+	"CORR TO SYSTEM TIME ": (*NavReader).handleCorrToSystemTime,
+	"IONOSPHERIC CORR    ": (*NavReader).handleIonosphericCorr,
+	"TIME SYSTEM CORR    ": (*NavReader).handleTimeSystemCorr,
+	"LEAP SECONDS        ": (*NavReader).handleLeapSeconds,
+}
+
+// handleRINEXVersion handles a RINEX VERSION / TYPE header.
+func (nr *NavReader) handleRINEXVersion(value string) error {
+	fltVersion, err := parseFloat(value[0:9], 32)
+	if err != nil {
+		return err
+	}
+	nr.version = int(math.Round(fltVersion))
+	if nr.version < 2 || nr.version > 4 {
+		return errors.New("Invalid RINEX version " + value[0:9])
+	}
+
+	switch value[20] {
+	case 'N':
+		nr.system = 'G'
+	case 'G':
+		nr.system = 'R'
+	case 'H':
+		nr.system = 'S'
+	default:
+		return errors.New("Expected navigation file, but got " + value[20:21])
+	}
+
+	return nil
+}
+
+// handleEndOfHeader handles a END OF HEADER header.
+func (nr *NavReader) handleEndOfHeader(_ string) error {
+	if nr.version == 0 {
+		return errors.New("RINEX header did not declare its version")
+	}
+	nr.inHeader = false
+	return nil
+}
+
+// handleIonAlpha handles a RINEX 2 ION ALPHA header.
+func (nr *NavReader) handleIonAlpha(value string) error {
+	return nr.parseIonParams("GPSA", value)
+}
+
+// handleIonBeta handles a RINEX 2 ION BETA header.
+func (nr *NavReader) handleIonBeta(value string) error {
+	return nr.parseIonParams("GPSB", value)
+}
+
+// parseIonParams parses the four D12.4 fields of a RINEX 2 ION ALPHA
+// or ION BETA header.
+func (nr *NavReader) parseIonParams(kind, value string) error {
+	var params [4]float64
+	for i := range params {
+		var err error
+		if params[i], err = parseNavFloat(value[2+12*i : 14+12*i]); err != nil {
+			return err
+		}
+	}
+	nr.IonosphericCorr[kind] = params
+	return nil
+}
+
+// handleDeltaUTC handles a RINEX 2 DELTA-UTC: A0,A1,T,W header.
+func (nr *NavReader) handleDeltaUTC(value string) error {
+	var corr TimeSystemCorr
+	var err error
+	if corr.A0, err = parseNavFloat(value[3:22]); err != nil {
+		return err
+	}
+	if corr.A1, err = parseNavFloat(value[22:41]); err != nil {
+		return err
+	}
+	if corr.RefTime, err = parseNavInt(value[41:50]); err != nil {
+		return err
+	}
+	if corr.RefWeek, err = parseNavInt(value[50:59]); err != nil {
+		return err
+	}
+	nr.TimeSystemCorr["GPUT"] = corr
+	return nil
+}
+
+// handleCorrToSystemTime handles a RINEX 2 CORR TO SYSTEM TIME header.
+// The reference date is not kept, since it is only the date of the
+// first observation.
+func (nr *NavReader) handleCorrToSystemTime(value string) error {
+	var corr TimeSystemCorr
+	var err error
+	if corr.A0, err = parseNavFloat(value[21:40]); err != nil {
+		return err
+	}
+	nr.TimeSystemCorr["GLUT"] = corr
+	return nil
+}
+
+// handleIonosphericCorr handles a RINEX 3 IONOSPHERIC CORR header.
+func (nr *NavReader) handleIonosphericCorr(value string) error {
+	var params [4]float64
+	for i := range params {
+		var err error
+		if params[i], err = parseNavFloat(value[5+12*i : 17+12*i]); err != nil {
+			return err
+		}
+	}
+	nr.IonosphericCorr[strings.TrimSpace(value[0:4])] = params
+	return nil
+}
+
+// handleTimeSystemCorr handles a RINEX 3 TIME SYSTEM CORR header.
+func (nr *NavReader) handleTimeSystemCorr(value string) error {
+	var corr TimeSystemCorr
+	var err error
+	if corr.A0, err = parseNavFloat(value[5:22]); err != nil {
+		return err
+	}
+	if corr.A1, err = parseNavFloat(value[22:38]); err != nil {
+		return err
+	}
+	if corr.RefTime, err = parseNavInt(value[38:45]); err != nil {
+		return err
+	}
+	if corr.RefWeek, err = parseNavInt(value[45:50]); err != nil {
+		return err
+	}
+	corr.Source = strings.TrimSpace(value[51:56])
+	if corr.UTCID, err = parseNavInt(value[57:59]); err != nil {
+		return err
+	}
+	nr.TimeSystemCorr[strings.TrimSpace(value[0:4])] = corr
+	return nil
+}
+
+// handleLeapSeconds handles a LEAP SECONDS header.
+func (nr *NavReader) handleLeapSeconds(value string) error {
+	leap, err := parseNavInt(value[0:6])
+	if err != nil {
+		return err
+	}
+	nr.LeapSeconds = leap
+	return nil
+}
@@ -0,0 +1,357 @@
+package rinex
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rinexV2GPSNavExample is the GPS navigation message example from the
+// RINEX 2.11 specification.
+const rinexV2GPSNavExample = `     2.10           N: GPS NAV DATA                         RINEX VERSION / TYPE
+XXRINEXN V2.10      AIUB                3-SEP-99 15:22      PGM / RUN BY / DATE
+EXAMPLE OF VERSION 2.10 FORMAT                              COMMENT
+     .1676D-07   .2235D-07  -.1192D-06  -.1192D-06          ION ALPHA
+     .1208D+06   .1310D+06  -.1310D+06  -.1966D+06          ION BETA
+     .133179128170D-06  .107469588780D-12   552960     1025 DELTA-UTC: A0,A1,T,W
+    13                                                      LEAP SECONDS
+                                                            END OF HEADER
+ 6 99  9  2 17 51 44.0 -.839701388031D-03 -.165982783074D-10  .000000000000D+00
+     .910000000000D+02  .934062500000D+02  .116040547840D-08  .162092304801D+00
+     .484101474285D-05  .626740418375D-02  .652112066746D-05  .515365489006D+04
+     .409904000000D+06 -.242143869400D-07  .329237003460D+00 -.596046447754D-07
+     .111541663136D+01  .326593750000D+03  .206958726335D+01 -.638312302555D-08
+     .307155651409D-09  .000000000000D+00  .102500000000D+04  .000000000000D+00
+     .000000000000D+00  .000000000000D+00  .000000000000D+00  .910000000000D+02
+     .406800000000D+06  .000000000000D+00
+13 99  9  2 19  0  0.0  .490025617182D-03  .204636307899D-11  .000000000000D+00
+     .133000000000D+03 -.963125000000D+02  .146970407622D-08  .292961152146D+01
+    -.498816370964D-05  .200239347760D-02  .928156077862D-05  .515328476143D+04
+     .414000000000D+06 -.279396772385D-07  .243031939942D+01 -.558793544769D-07
+     .110192796930D+01  .271187500000D+03 -.232757915425D+01 -.619632953057D-08
+    -.785747015231D-11  .100000000000D+01  .102500000000D+04  .000000000000D+00
+     .700000000000D+01  .000000000000D+00 -.279396772385D-08  .389000000000D+03
+     .410400000000D+06  .000000000000D+00
+`
+
+// rinexV2GLONASSNavExample is the GLONASS navigation message example
+// from the RINEX 2.11 specification.
+const rinexV2GLONASSNavExample = `     2.01           GLONASS NAV DATA                        RINEX VERSION / TYPE
+ASRINEXG V1.1.0 VM  AIUB                19-FEB-98 10:42     PGM / RUN BY / DATE
+STATION ZIMMERWALD                                          COMMENT
+  1998     2    16    0.379979610443D-06                    CORR TO SYSTEM TIME
+                                                            END OF HEADER
+ 3 98  2 15  0 15  0.0 0.163525342941D-03 0.363797880709D-11 0.108000000000D+05
+    0.106275903320D+05-0.348924636841D+00 0.931322574615D-09 0.000000000000D+00
+   -0.944422070313D+04 0.288163375854D+01 0.931322574615D-09 0.210000000000D+02
+    0.212257280273D+05 0.144599342346D+01-0.186264514923D-08 0.300000000000D+01
+ 4 98  2 15  0 15  0.0 0.179599039257D-03 0.636646291241D-11 0.122400000000D+05
+    0.562136621094D+04-0.289074897766D+00-0.931322574615D-09 0.000000000000D+00
+   -0.236819248047D+05 0.102263259888D+01 0.931322574615D-09 0.120000000000D+02
+    0.497463281250D+04 0.350091934204D+01-0.186264514923D-08 0.300000000000D+01
+`
+
+// rinexV3NavExample is a RINEX 3 mixed navigation file with one record
+// for each supported GNSS.
+const rinexV3NavExample = `     3.04           N: GNSS NAV DATA    M: MIXED            RINEX VERSION / TYPE
+XXRINEXN V3         AIUB                20190110 000123 UTC PGM / RUN BY / DATE
+GPSA   0.1676D-07  0.2235D-07 -0.1192D-06 -0.1192D-06       IONOSPHERIC CORR
+GPSB   0.1208D+06  0.1310D+06 -0.1310D+06 -0.1966D+06       IONOSPHERIC CORR
+GAL    0.1248D+03  0.5039D+00  0.2377D-01  0.0000D+00       IONOSPHERIC CORR
+GPUT  0.1331791282D-06 0.107469589D-12 552960 1025 EGNOS  2 TIME SYSTEM CORR
+GAUT  0.1862645149D-08 0.888178420D-15 345600 2035          TIME SYSTEM CORR
+    18    18  1929     7                                    LEAP SECONDS
+                                                            END OF HEADER
+G06 2019 01 10 00 00 00-0.839701388031D-03-0.165982783074D-10 0.000000000000D+00
+     0.910000000000D+02 0.934062500000D+02 0.116040547840D-08 0.162092304801D+00
+     0.484101474285D-05 0.626740418375D-02 0.652112066746D-05 0.515365489006D+04
+     0.345600000000D+06-0.242143869400D-07 0.329237003460D+00-0.596046447754D-07
+     0.111541663136D+01 0.326593750000D+03 0.206958726335D+01-0.638312302555D-08
+     0.307155651409D-09 0.100000000000D+01 0.203500000000D+04 0.000000000000D+00
+     0.200000000000D+01 0.000000000000D+00-0.102445483208D-07 0.910000000000D+02
+     0.338400000000D+06 0.400000000000D+01
+E11 2019 01 10 00 10 00-0.565418275073E-03-0.767386154295E-11 0.000000000000E+00
+     0.770000000000E+02-0.416875000000E+02 0.273654256566E-08-0.252530896291E+01
+    -0.181607902050E-05 0.200489815325E-03 0.984035432339E-05 0.544060834312E+04
+     0.346200000000E+06 0.447034835815E-07-0.106493289212E+01 0.223517417908E-07
+     0.973286390339E+00 0.122406250000E+03-0.294766432023E+01-0.536450630577E-08
+    -0.270011247191E-09 0.516000000000E+03 0.203500000000E+04
+     0.312000000000E+01 0.000000000000E+00-0.186264514923E-08-0.209547579288E-08
+     0.346794000000E+06
+C08 2019 01 10 00 00 00 0.341758737341D-03 0.541007771719D-10 0.000000000000D+00
+     0.100000000000D+01-0.360171875000D+03 0.123826586697D-08-0.982628704380D+00
+    -0.109970569611D-04 0.740693951957D-02 0.252481549978D-04 0.649354589081D+04
+     0.345600000000D+06-0.124797224998D-06-0.221960341185D+01 0.460445880890D-07
+     0.966716195569D+00-0.911406250000D+02-0.204521508468D+01 0.134826730359D-09
+    -0.318584412006D-09 0.000000000000D+00 0.679000000000D+03 0.000000000000D+00
+     0.200000000000D+01 0.000000000000D+00 0.280000000000D-08 0.220000000000D-08
+     0.345600500000D+06 0.100000000000D+01
+R05 2019 01 10 00 15 00-0.491514801979D-04 0.000000000000D+00 0.345600000000D+06
+    -0.106323310547D+05-0.344578742981D+00 0.000000000000D+00 0.000000000000D+00
+    -0.946606982422D+04 0.287840938568D+01-0.931322574615D-09 0.100000000000D+01
+     0.212042128906D+05 0.144837093353D+01-0.186264514923D-08 0.000000000000D+00
+I02 2019 01 10 00 00 00 0.000000000000D+00 0.000000000000D+00 0.000000000000D+00
+     0.000000000000D+00 0.000000000000D+00 0.000000000000D+00 0.000000000000D+00
+S20 2019 01 10 00 01 04 0.000000000000D+00 0.000000000000D+00 0.345664000000D+06
+     0.405760000000D+05 0.000000000000D+00 0.000000000000D+00 0.630000000000D+02
+    -0.136000000000D+04 0.000000000000D+00 0.000000000000D+00 0.409600000000D+04
+     0.000000000000D+00 0.000000000000D+00 0.000000000000D+00 0.840000000000D+02
+`
+
+// rinexV4NavExample has one record of each type that NavReader
+// supports in RINEX 4, plus a BeiDou CNAV-1 record that it skips.
+const rinexV4NavExample = `     4.01           N: GNSS NAV DATA    M: MIXED            RINEX VERSION / TYPE
+    18                                                      LEAP SECONDS
+                                                            END OF HEADER
+> EPH G06 LNAV
+G06 1999 09 02 17 51 44-0.839701388031D-03-0.165982783074D-10 0.000000000000D+00
+     0.910000000000D+02 0.934062500000D+02 0.116040547840D-08 0.162092304801D+00
+     0.484101474285D-05 0.626740418375D-02 0.652112066746D-05 0.515365489006D+04
+     0.409904000000D+06-0.242143869400D-07 0.329237003460D+00-0.596046447754D-07
+     0.111541663136D+01 0.326593750000D+03 0.206958726335D+01-0.638312302555D-08
+     0.307155651409D-09 0.000000000000D+00 0.102500000000D+04 0.000000000000D+00
+     0.000000000000D+00 0.000000000000D+00 0.000000000000D+00 0.910000000000D+02
+     0.406800000000D+06 0.000000000000D+00
+> EPH G10 CNAV
+G10 2022 01 02 02 00 00 0.150000000000D-03-0.200000000000D-11 0.000000000000D+00
+     0.500000000000D+00 0.200000000000D+02 0.450000000000D-08 0.120000000000D+01
+     0.100000000000D-05 0.100000000000D-01 0.500000000000D-05 0.515370000000D+04
+     0.700000000000D+04 0.100000000000D-07-0.250000000000D+01 0.200000000000D-07
+     0.950000000000D+00 0.250000000000D+03 0.700000000000D+00-0.800000000000D-08
+     0.100000000000D-09 0.200000000000D-13-0.300000000000D+01 0.100000000000D+01
+     0.200000000000D+01 0.000000000000D+00-0.500000000000D-08 0.300000000000D+01
+     0.100000000000D-08 0.200000000000D-08 0.300000000000D-08 0.400000000000D-08
+     0.603000000000D+06 0.219100000000D+04
+> EPH E11 INAV
+E11 2022 01 02 02 00 00-0.400000000000D-03-0.600000000000D-11 0.000000000000D+00
+     0.500000000000D+02 0.100000000000D+03 0.300000000000D-08 0.200000000000D+01
+     0.400000000000D-05 0.200000000000D-03 0.800000000000D-05 0.544060000000D+04
+     0.720000000000D+04 0.100000000000D-07 0.100000000000D+01-0.300000000000D-07
+     0.970000000000D+00 0.180000000000D+03-0.500000000000D+00-0.560000000000D-08
+    -0.200000000000D-09 0.517000000000D+03 0.219100000000D+04 0.000000000000D+00
+     0.312000000000D+01 0.000000000000D+00-0.120000000000D-07-0.140000000000D-07
+     0.730000000000D+04 0.000000000000D+00
+> EPH R03 FDMA
+R03 2022 01 02 01 45 00 0.163525342941D-03 0.363797880709D-11 0.108000000000D+05
+     0.106275903320D+05-0.348924636841D+00 0.931322574615D-09 0.000000000000D+00
+    -0.944422070313D+04 0.288163375854D+01 0.931322574615D-09 0.500000000000D+01
+     0.212257280273D+05 0.144599342346D+01-0.186264514923D-08 0.300000000000D+01
+     0.000000000000D+00 0.000000000000D+00 0.200000000000D+01 0.000000000000D+00
+> EPH C20 CNV1
+C20 2022 01 02 02 00 00 0.100000000000D-03 0.000000000000D+00 0.000000000000D+00
+     0.000000000000D+00 0.000000000000D+00 0.000000000000D+00 0.000000000000D+00
+     0.000000000000D+00 0.000000000000D+00 0.000000000000D+00 0.000000000000D+00
+     0.000000000000D+00 0.000000000000D+00 0.000000000000D+00 0.000000000000D+00
+     0.000000000000D+00 0.000000000000D+00 0.000000000000D+00 0.000000000000D+00
+     0.000000000000D+00 0.000000000000D+00 0.000000000000D+00 0.000000000000D+00
+     0.000000000000D+00 0.000000000000D+00 0.000000000000D+00 0.000000000000D+00
+     0.000000000000D+00 0.000000000000D+00 0.000000000000D+00 0.000000000000D+00
+     0.000000000000D+00 0.000000000000D+00 0.000000000000D+00 0.000000000000D+00
+     0.000000000000D+00 0.000000000000D+00 0.000000000000D+00 0.000000000000D+00
+> STO G01 LNAV
+    2022 01 02 00 00 00 GPUT                                UTC(USNO)
+     0.345600000000D+06 0.186264514923D-08 0.888178419700D-15 0.000000000000D+00
+> EOP G01 CNVX
+    2022 01 02 00 00 00 0.500000000000D-01 0.100000000000D-03 0.000000000000D+00
+                        0.300000000000D+00-0.200000000000D-03 0.000000000000D+00
+     0.345600000000D+06-0.100000000000D+00 0.300000000000D-03 0.000000000000D+00
+> ION G01 LNAV
+    2022 01 02 00 00 00 0.111760000000D-07 0.745060000000D-08-0.596050000000D-07
+    -0.596050000000D-07 0.901120000000D+05 0.000000000000D+00-0.196610000000D+06
+    -0.655360000000D+05 0.000000000000D+00
+> ION E11 IFNV
+    2022 01 02 00 00 00 0.305000000000D+02 0.250000000000D+00 0.100000000000D-01
+     0.000000000000D+00
+`
+
+func parseNav(t *testing.T, text string) (*NavReader, []Ephemeris) {
+	var ephs []Ephemeris
+	nr := &NavReader{
+		EphemerisFunc: func(eph Ephemeris) error {
+			ephs = append(ephs, eph)
+			return nil
+		},
+	}
+	if err := nr.Parse(strings.NewReader(text)); err != nil {
+		t.Fatal(err)
+	}
+	return nr, ephs
+}
+
+func TestParseNavV2GPS(t *testing.T) {
+	nr, ephs := parseNav(t, rinexV2GPSNavExample)
+
+	if nr.IonosphericCorr["GPSA"] != [4]float64{.1676e-7, .2235e-7, -.1192e-6, -.1192e-6} {
+		t.Errorf("Bad GPSA: %v", nr.IonosphericCorr["GPSA"])
+	}
+	if nr.IonosphericCorr["GPSB"][3] != -.1966e6 {
+		t.Errorf("Bad GPSB: %v", nr.IonosphericCorr["GPSB"])
+	}
+	utc := nr.TimeSystemCorr["GPUT"]
+	if utc.A0 != .133179128170e-6 || utc.RefTime != 552960 || utc.RefWeek != 1025 {
+		t.Errorf("Bad GPUT: %+v", utc)
+	}
+	if nr.LeapSeconds != 13 {
+		t.Errorf("Bad leap seconds: %d", nr.LeapSeconds)
+	}
+
+	if len(ephs) != 2 {
+		t.Fatalf("Expected 2 ephemerides, got %d", len(ephs))
+	}
+	gps, ok := ephs[0].(*GPSEphemeris)
+	if !ok {
+		t.Fatalf("Expected *GPSEphemeris, got %T", ephs[0])
+	}
+	if gps.SatelliteID() != [3]byte{'G', '0', '6'} {
+		t.Errorf("Bad PRN %s", gps.PRN[:])
+	}
+	if !gps.ClockEpoch().Equal(time.Date(1999, 9, 2, 17, 51, 44, 0, time.UTC)) {
+		t.Errorf("Bad TOC %v", gps.TOC)
+	}
+	if gps.ClockBias != -.839701388031e-3 || gps.IODE != 91 ||
+		gps.SqrtA != .515365489006e4 || gps.Toe != 409904 ||
+		gps.Week != 1025 || gps.IODC != 91 || gps.TransmitTime != 406800 {
+		t.Errorf("Bad GPS ephemeris: %+v", gps)
+	}
+	if ephs[1].SatelliteID() != [3]byte{'G', '1', '3'} {
+		t.Errorf("Bad second PRN %s", ephs[1].SatelliteID())
+	}
+}
+
+func TestParseNavV2GLONASS(t *testing.T) {
+	nr, ephs := parseNav(t, rinexV2GLONASSNavExample)
+
+	if nr.TimeSystemCorr["GLUT"].A0 != 0.379979610443e-6 {
+		t.Errorf("Bad GLUT: %+v", nr.TimeSystemCorr["GLUT"])
+	}
+	if len(ephs) != 2 {
+		t.Fatalf("Expected 2 ephemerides, got %d", len(ephs))
+	}
+	glo, ok := ephs[0].(*GLONASSEphemeris)
+	if !ok {
+		t.Fatalf("Expected *GLONASSEphemeris, got %T", ephs[0])
+	}
+	if glo.PRN != [3]byte{'R', '0', '3'} || glo.FreqNum != 21 ||
+		glo.Position[1] != -0.944422070313e4 ||
+		glo.Velocity[2] != 0.144599342346e1 ||
+		glo.MessageFrameTime != 10800 || glo.AgeOfInfo != 3 {
+		t.Errorf("Bad GLONASS ephemeris: %+v", glo)
+	}
+}
+
+func TestParseNavV3(t *testing.T) {
+	nr, ephs := parseNav(t, rinexV3NavExample)
+
+	if nr.IonosphericCorr["GAL"][1] != 0.5039 {
+		t.Errorf("Bad GAL: %v", nr.IonosphericCorr["GAL"])
+	}
+	gput := nr.TimeSystemCorr["GPUT"]
+	if gput.A1 != 0.107469589e-12 || gput.Source != "EGNOS" || gput.UTCID != 2 {
+		t.Errorf("Bad GPUT: %+v", gput)
+	}
+	if nr.TimeSystemCorr["GAUT"].RefWeek != 2035 {
+		t.Errorf("Bad GAUT: %+v", nr.TimeSystemCorr["GAUT"])
+	}
+	if nr.LeapSeconds != 18 {
+		t.Errorf("Bad leap seconds: %d", nr.LeapSeconds)
+	}
+
+	// The IRNSS record is skipped.
+	if len(ephs) != 5 {
+		t.Fatalf("Expected 5 ephemerides, got %d", len(ephs))
+	}
+
+	gps := ephs[0].(*GPSEphemeris)
+	if gps.Week != 2035 || gps.Accuracy != 2 || gps.FitInterval != 4 ||
+		gps.TGD != -0.102445483208e-7 || gps.CodesOnL2 != 1 {
+		t.Errorf("Bad GPS ephemeris: %+v", gps)
+	}
+
+	gal := ephs[1].(*GalileoEphemeris)
+	if gal.IODNav != 77 || gal.DataSources != 516 || gal.SISA != 3.12 ||
+		gal.BGDE5bE1 != -0.209547579288e-8 || gal.TransmitTime != 346794 ||
+		!gal.TOC.Equal(time.Date(2019, 1, 10, 0, 10, 0, 0, time.UTC)) {
+		t.Errorf("Bad Galileo ephemeris: %+v", gal)
+	}
+
+	bds := ephs[2].(*BeiDouEphemeris)
+	if bds.AODE != 1 || bds.AODC != 1 || bds.Week != 679 ||
+		bds.TGD2 != 0.22e-8 || bds.Omega != -0.204521508468e1 {
+		t.Errorf("Bad BeiDou ephemeris: %+v", bds)
+	}
+
+	glo := ephs[3].(*GLONASSEphemeris)
+	if glo.ClockBias != -0.491514801979e-4 || glo.FreqNum != 1 ||
+		glo.Acceleration[2] != -0.186264514923e-8 {
+		t.Errorf("Bad GLONASS ephemeris: %+v", glo)
+	}
+
+	sbas := ephs[4].(*SBASEphemeris)
+	if sbas.Position[0] != 40576 || sbas.Health != 63 ||
+		sbas.URA != 4096 || sbas.IODN != 84 {
+		t.Errorf("Bad SBAS ephemeris: %+v", sbas)
+	}
+}
+
+func TestParseNavV4(t *testing.T) {
+	var eops []EarthOrientation
+	var ephs []Ephemeris
+	nr := &NavReader{
+		EphemerisFunc: func(eph Ephemeris) error {
+			ephs = append(ephs, eph)
+			return nil
+		},
+		EOPFunc: func(eop EarthOrientation) error {
+			eops = append(eops, eop)
+			return nil
+		},
+	}
+	if err := nr.Parse(strings.NewReader(rinexV4NavExample)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ephs) != 4 {
+		t.Fatalf("Expected 4 ephemerides, got %d", len(ephs))
+	}
+	gps := ephs[0].(*GPSEphemeris)
+	if gps.PRN != [3]byte{'G', '0', '6'} || gps.IODE != 91 || gps.Week != 1025 {
+		t.Errorf("Bad GPS ephemeris: %+v", gps)
+	}
+
+	cnav := ephs[1].(*CNAVEphemeris)
+	if cnav.MessageType != "CNAV" || cnav.ADot != 0.5 || cnav.Top != 7000 ||
+		cnav.DeltaNDot != 2e-14 || cnav.URAINED0 != -3 || cnav.URAIED != 2 ||
+		cnav.TGD != -5e-9 || cnav.ISCL5Q5 != 4e-9 || cnav.Week != 2191 ||
+		cnav.Toe != 7200 || cnav.TransmitTime != 603000 {
+		t.Errorf("Bad CNAV ephemeris: %+v", cnav)
+	}
+
+	if gal := ephs[2].(*GalileoEphemeris); gal.DataSources != 517 {
+		t.Errorf("Bad Galileo ephemeris: %+v", gal)
+	}
+	if glo := ephs[3].(*GLONASSEphemeris); glo.FreqNum != 5 || glo.AgeOfInfo != 3 {
+		t.Errorf("Bad GLONASS ephemeris: %+v", glo)
+	}
+
+	gput := nr.TimeSystemCorr["GPUT"]
+	if gput.A0 != 0.186264514923e-8 || gput.A1 != 0.888178419700e-15 ||
+		gput.RefWeek != 2191 || gput.RefTime != 0 || gput.UTCID != 2 ||
+		gput.Source != "G01" {
+		t.Errorf("Bad GPUT: %+v", gput)
+	}
+
+	if len(eops) != 1 {
+		t.Fatalf("Expected 1 EOP record, got %d", len(eops))
+	}
+	if eop := eops[0]; eop.XP != 0.05 || eop.YP != 0.3 || eop.YPRate != -2e-4 ||
+		eop.DUT1 != -0.1 || eop.TransmitTime != 345600 {
+		t.Errorf("Bad EOP: %+v", eop)
+	}
+
+	if nr.IonosphericCorr["GPSB"] != [4]float64{90112, 0, -196610, -65536} {
+		t.Errorf("Bad GPSB: %v", nr.IonosphericCorr["GPSB"])
+	}
+	if nr.IonosphericCorr["GAL"][0] != 30.5 {
+		t.Errorf("Bad GAL: %v", nr.IonosphericCorr["GAL"])
+	}
+}
@@ -0,0 +1,349 @@
+package rinex
+
+import (
+	"strings"
+	"time"
+
+	"github.com/entrope/gnss/gnsstime"
+)
+
+// PhaseShift describes one SYS / PHASE SHIFT header: a phase shift
+// correction that was applied to one observation type.
+type PhaseShift struct {
+	// System identifies the GNSS, as in the first byte of a PRN.
+	System byte
+
+	// Code is the carrier phase observation type, such as "L1C".
+	Code [3]byte
+
+	// Correction is the phase shift that was applied (cycles).  It is
+	// 0 if the header left the correction blank.
+	Correction float64
+
+	// Sats lists the satellites that the correction applies to.  If
+	// it is empty, the correction applies to all satellites of System.
+	Sats [][3]byte
+}
+
+// ObsHeader holds the parsed values of the commonly used RINEX
+// observation header lines.  Fields for headers that were not present,
+// or whose values could not be parsed, hold their zero values.
+type ObsHeader struct {
+	// SatelliteSystem identifies the GNSS of the file, from the RINEX
+	// VERSION / TYPE header: 'G', 'R', 'E', 'J', 'C', 'I' or 'S' for
+	// a single GNSS, or 'M' for mixed.  It is 'G' if the header left
+	// it blank.
+	SatelliteSystem byte
+
+	// Program, RunBy and Date are from the PGM / RUN BY / DATE header.
+	Program, RunBy, Date string
+
+	// Comments holds the text of each COMMENT header line, without
+	// trailing blanks, including those from special event records.
+	Comments []string
+
+	// MarkerName, MarkerNumber and MarkerType describe the antenna
+	// marker.
+	MarkerName, MarkerNumber, MarkerType string
+
+	// Observer and Agency are from the OBSERVER / AGENCY header.
+	Observer, Agency string
+
+	// ReceiverNumber, ReceiverType and ReceiverVersion are from the
+	// REC # / TYPE / VERS header.
+	ReceiverNumber, ReceiverType, ReceiverVersion string
+
+	// AntennaNumber and AntennaType are from the ANT # / TYPE header.
+	AntennaNumber, AntennaType string
+
+	// ApproxPosition is the approximate ECEF position of the marker
+	// (m).
+	ApproxPosition [3]float64
+
+	// AntennaDelta is the height of the antenna reference point above
+	// the marker, followed by its eastern and northern eccentricities
+	// (m).
+	AntennaDelta [3]float64
+
+	// Interval is the observation interval (s).
+	Interval float64
+
+	// FirstObs and LastObs are the times of the first and last
+	// observation records, reported as if they were in UTC (see
+	// ObservationRecord.Time).
+	FirstObs, LastObs time.Time
+
+	// TimeSystem is the time system of the observations, such as "GPS"
+	// or "GLO", from the TIME OF FIRST OBS header.  It may be blank for
+	// RINEX 2 files.
+	TimeSystem string
+
+	// GLONASSSlots maps GLONASS satellite IDs to their frequency
+	// numbers, from the GLONASS SLOT / FRQ # header.
+	GLONASSSlots map[[3]byte]int
+
+	// GLONASSBiases maps GLONASS observation types to their code-phase
+	// bias corrections (m), from the GLONASS COD/PHS/BIS header.
+	GLONASSBiases map[[3]byte]float64
+
+	// PhaseShifts lists the SYS / PHASE SHIFT headers.
+	PhaseShifts []PhaseShift
+
+	// SignalStrengthUnit is the unit of the signal strength
+	// observations, such as "DBHZ".
+	SignalStrengthUnit string
+
+	// LeapSeconds is the number of leap seconds since 6 January 1980.
+	LeapSeconds int
+}
+
+// Scale returns the time scale of the observation epochs.  This is
+// TimeSystem if it is set, or else the default for SatelliteSystem:
+// the time scale of that GNSS, or GPS time for mixed and SBAS files.
+func (h *ObsHeader) Scale() (gnsstime.Scale, error) {
+	if h.TimeSystem != "" {
+		return gnsstime.ParseScale(h.TimeSystem)
+	}
+	switch h.SatelliteSystem {
+	case 'R':
+		return gnsstime.GLO, nil
+	case 'E':
+		return gnsstime.GAL, nil
+	case 'J':
+		return gnsstime.QZS, nil
+	case 'C':
+		return gnsstime.BDT, nil
+	case 'I':
+		return gnsstime.IRN, nil
+	}
+	return gnsstime.GPS, nil
+}
+
+/********************** HEADER PARSING FUNCTIONS **********************/
+
+// handlePgmRunByDate handles a PGM / RUN BY / DATE header.
+func (or *ObsReader) handlePgmRunByDate(value string) error {
+	or.Header.Program = strings.TrimSpace(value[0:20])
+	or.Header.RunBy = strings.TrimSpace(value[20:40])
+	or.Header.Date = strings.TrimSpace(value[40:60])
+	return nil
+}
+
+// handleComment handles a COMMENT header.
+func (or *ObsReader) handleComment(value string) error {
+	or.Header.Comments = append(or.Header.Comments,
+		strings.TrimRight(value, " "))
+	return nil
+}
+
+// handleMarkerName handles a MARKER NAME header.
+func (or *ObsReader) handleMarkerName(value string) error {
+	or.Header.MarkerName = strings.TrimSpace(value)
+	return nil
+}
+
+// handleMarkerNumber handles a MARKER NUMBER header.
+func (or *ObsReader) handleMarkerNumber(value string) error {
+	or.Header.MarkerNumber = strings.TrimSpace(value[0:20])
+	return nil
+}
+
+// handleMarkerType handles a MARKER TYPE header.
+func (or *ObsReader) handleMarkerType(value string) error {
+	or.Header.MarkerType = strings.TrimSpace(value[0:20])
+	return nil
+}
+
+// handleObserverAgency handles an OBSERVER / AGENCY header.
+func (or *ObsReader) handleObserverAgency(value string) error {
+	or.Header.Observer = strings.TrimSpace(value[0:20])
+	or.Header.Agency = strings.TrimSpace(value[20:60])
+	return nil
+}
+
+// handleRecTypeVers handles a REC # / TYPE / VERS header.
+func (or *ObsReader) handleRecTypeVers(value string) error {
+	or.Header.ReceiverNumber = strings.TrimSpace(value[0:20])
+	or.Header.ReceiverType = strings.TrimSpace(value[20:40])
+	or.Header.ReceiverVersion = strings.TrimSpace(value[40:60])
+	return nil
+}
+
+// handleAntType handles an ANT # / TYPE header.
+func (or *ObsReader) handleAntType(value string) error {
+	or.Header.AntennaNumber = strings.TrimSpace(value[0:20])
+	or.Header.AntennaType = strings.TrimSpace(value[20:40])
+	return nil
+}
+
+// parseTriple parses three F14.4 fields from the start of value.  If
+// any field is bad, it returns all zeros.
+func parseTriple(value string) ([3]float64, error) {
+	var res [3]float64
+	for i := range res {
+		var err error
+		if res[i], err = parseNavFloat(value[14*i : 14*i+14]); err != nil {
+			return [3]float64{}, err
+		}
+	}
+	return res, nil
+}
+
+// handleApproxPosition handles an APPROX POSITION XYZ header.
+func (or *ObsReader) handleApproxPosition(value string) (err error) {
+	or.Header.ApproxPosition, err = parseTriple(value)
+	return err
+}
+
+// handleAntennaDeltaHEN handles an ANTENNA: DELTA H/E/N header.
+func (or *ObsReader) handleAntennaDeltaHEN(value string) (err error) {
+	or.Header.AntennaDelta, err = parseTriple(value)
+	return err
+}
+
+// handleInterval handles an INTERVAL header.
+func (or *ObsReader) handleInterval(value string) (err error) {
+	if or.Header.Interval, err = parseNavFloat(value[0:10]); err != nil {
+		or.Header.Interval = 0
+	}
+	return err
+}
+
+// parseObsTime parses the time from a TIME OF FIRST OBS or TIME OF
+// LAST OBS header.
+func parseObsTime(value string) (time.Time, error) {
+	var date [5]int
+	for i := range date {
+		var err error
+		if date[i], err = parseNavInt(value[6*i : 6*i+6]); err != nil {
+			return time.Time{}, err
+		}
+	}
+	second, err := parseNavFloat(value[30:43])
+	if err != nil {
+		return time.Time{}, err
+	}
+	return navTime(date, second), nil
+}
+
+// handleTimeOfLastObs handles a TIME OF LAST OBS header.
+func (or *ObsReader) handleTimeOfLastObs(value string) (err error) {
+	or.Header.LastObs, err = parseObsTime(value)
+	return err
+}
+
+// handleGLONASSSlotFrq handles a GLONASS SLOT / FRQ # header.
+func (or *ObsReader) handleGLONASSSlotFrq(value string) error {
+	if or.Header.GLONASSSlots == nil {
+		or.Header.GLONASSSlots = make(map[[3]byte]int)
+	}
+
+	// Read up to 8 satellites from this line, and only keep them if
+	// they are all good.
+	slots := make(map[[3]byte]int, 8)
+	for i := 0; i < 8; i++ {
+		pos := 4 + 7*i
+		if value[pos] == ' ' {
+			break
+		}
+		frq, err := parseNavInt(value[pos+4 : pos+6])
+		if err != nil {
+			return err
+		}
+		var prn [3]byte
+		copy(prn[:], value[pos:pos+3])
+		slots[prn] = frq
+	}
+	for prn, frq := range slots {
+		or.Header.GLONASSSlots[prn] = frq
+	}
+
+	return nil
+}
+
+// handleGLONASSCodPhsBis handles a GLONASS COD/PHS/BIS header.
+func (or *ObsReader) handleGLONASSCodPhsBis(value string) error {
+	if or.Header.GLONASSBiases == nil {
+		or.Header.GLONASSBiases = make(map[[3]byte]float64)
+	}
+
+	biases := make(map[[3]byte]float64, 4)
+	for i := 0; i < 4; i++ {
+		pos := 13 * i
+		if value[pos+1] == ' ' {
+			continue
+		}
+		bias, err := parseNavFloat(value[pos+5 : pos+13])
+		if err != nil {
+			return err
+		}
+		var code [3]byte
+		copy(code[:], value[pos+1:pos+4])
+		biases[code] = bias
+	}
+	for code, bias := range biases {
+		or.Header.GLONASSBiases[code] = bias
+	}
+
+	return nil
+}
+
+// handleSysPhaseShift handles a SYS / PHASE SHIFT header.
+func (or *ObsReader) handleSysPhaseShift(value string) error {
+	// Is this the first line for the correction?  Continuation lines
+	// have a blank system, so a system letter always starts a new
+	// correction, even if the last one listed too few satellites.
+	if value[0] != ' ' {
+		or.phaseShiftSats = 0
+		corr, err := parseNavFloat(value[6:14])
+		if err != nil {
+			return err
+		}
+		count, err := parseNavInt(value[16:18])
+		if err != nil {
+			return err
+		}
+		if count < 0 {
+			count = 0
+		}
+		ps := PhaseShift{System: value[0], Correction: corr}
+		copy(ps.Code[:], value[2:5])
+		if count > 0 {
+			ps.Sats = make([][3]byte, 0, count)
+		}
+		or.Header.PhaseShifts = append(or.Header.PhaseShifts, ps)
+		or.phaseShiftSats = count
+	} else if or.phaseShiftSats == 0 {
+		return nil
+	}
+
+	// Read up to 10 satellites from this line.  A blank field means
+	// that the count was overstated.
+	ps := &or.Header.PhaseShifts[len(or.Header.PhaseShifts)-1]
+	for i := 0; i < 10 && or.phaseShiftSats > 0; i++ {
+		var prn [3]byte
+		copy(prn[:], value[19+4*i:22+4*i])
+		if prn == [3]byte{' ', ' ', ' '} {
+			or.phaseShiftSats = 0
+			break
+		}
+		ps.Sats = append(ps.Sats, prn)
+		or.phaseShiftSats--
+	}
+
+	return nil
+}
+
+// handleSignalStrengthUnit handles a SIGNAL STRENGTH UNIT header.
+func (or *ObsReader) handleSignalStrengthUnit(value string) error {
+	or.Header.SignalStrengthUnit = strings.TrimSpace(value[0:20])
+	return nil
+}
+
+// handleLeapSeconds handles a LEAP SECONDS header.
+func (or *ObsReader) handleLeapSeconds(value string) (err error) {
+	if or.Header.LeapSeconds, err = parseNavInt(value[0:6]); err != nil {
+		or.Header.LeapSeconds = 0
+	}
+	return err
+}
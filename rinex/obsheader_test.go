@@ -0,0 +1,211 @@
+package rinex
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rinexV3HeaderExample exercises the header lines that the Septentrio
+// example file does not use.
+const rinexV3HeaderExample = `     3.04           OBSERVATION DATA    M                   RINEX VERSION / TYPE
+G L2S  0.25000  12 G01 G02 G03 G04 G05 G06 G07 G08 G09 G10  SYS / PHASE SHIFT
+                   G11 G12                                  SYS / PHASE SHIFT
+G L1C                                                       SYS / PHASE SHIFT
+  2019     1    10     0     0    0.0000000     GPS         TIME OF FIRST OBS
+  2019     1    10    23    59   30.0000000     GPS         TIME OF LAST OBS
+    30.000                                                  INTERVAL
+ 10 R01  1 R02 -4 R03  5 R04  6 R05  1 R06 -4 R07  5 R08  6 GLONASS SLOT / FRQ #
+    R09 -2 R10 -7                                           GLONASS SLOT / FRQ #
+ C1C  -10.000 C1P   -9.750 C2C  -11.250 C2P  -10.500        GLONASS COD/PHS/BIS
+    18                                                      LEAP SECONDS
+                                                            END OF HEADER
+`
+
+func TestObsHeaderV2(t *testing.T) {
+	_, or, err := collect(rinexV2Example)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := or.Header
+	if h.Program != "XXRINEXO V9.9" || h.RunBy != "AIUB" ||
+		h.Date != "24-MAR-01 14:43" {
+		t.Errorf("Bad program/run by/date: %q %q %q", h.Program, h.RunBy, h.Date)
+	}
+
+	// The last event record changes the marker.
+	if h.MarkerName != "A 9080" || h.MarkerNumber != "9080.1.34" {
+		t.Errorf("Bad marker: %q %q", h.MarkerName, h.MarkerNumber)
+	}
+	if h.Observer != "BILL SMITH" || h.Agency != "ABC INSTITUTE" {
+		t.Errorf("Bad observer/agency: %q %q", h.Observer, h.Agency)
+	}
+	if h.ReceiverNumber != "X1234A123" || h.ReceiverType != "XX" ||
+		h.ReceiverVersion != "ZZZ" {
+		t.Errorf("Bad receiver: %q %q %q", h.ReceiverNumber,
+			h.ReceiverType, h.ReceiverVersion)
+	}
+	if h.AntennaNumber != "234" || h.AntennaType != "YY" {
+		t.Errorf("Bad antenna: %q %q", h.AntennaNumber, h.AntennaType)
+	}
+	if h.ApproxPosition != [3]float64{4375274, 587466, 4589095} {
+		t.Errorf("Bad approximate position %v", h.ApproxPosition)
+	}
+	if h.AntennaDelta != [3]float64{0.903, 0, 0} {
+		t.Errorf("Bad antenna delta %v", h.AntennaDelta)
+	}
+	if h.Interval != 18 {
+		t.Errorf("Bad interval %g", h.Interval)
+	}
+	first := time.Date(2005, 3, 24, 13, 10, 36, 0, time.UTC)
+	if !h.FirstObs.Equal(first) || h.TimeSystem != "" {
+		t.Errorf("Bad first observation %v %q", h.FirstObs, h.TimeSystem)
+	}
+
+	// Comments include those from event records.
+	if len(h.Comments) != 14 ||
+		h.Comments[0] != "BLANK OR G = GPS,  R = GLONASS,  E = GALILEO,  M = MIXED" ||
+		h.Comments[13] != "                (OPPOSITE TO PREVIOUS SETTINGS)" {
+		t.Errorf("Bad comments %q", h.Comments)
+	}
+}
+
+func TestObsHeaderV3(t *testing.T) {
+	_, or, err := collect(rinexV3Example)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := or.Header
+	if h.MarkerName != "TWTF" || h.MarkerNumber != "Septentrio" ||
+		h.MarkerType != "PolaRx4Pro" {
+		t.Errorf("Bad marker: %q %q %q", h.MarkerName, h.MarkerNumber,
+			h.MarkerType)
+	}
+	if h.ReceiverType != "SEPT POLARX4" || h.AntennaType != "ASH701945C_M    SCIS" {
+		t.Errorf("Bad receiver/antenna type: %q %q", h.ReceiverType,
+			h.AntennaType)
+	}
+	if h.ApproxPosition != [3]float64{-2994427.6478, 4951307.5755, 2674496.0997} {
+		t.Errorf("Bad approximate position %v", h.ApproxPosition)
+	}
+	if h.TimeSystem != "GPS" || h.SignalStrengthUnit != "DBHZ" {
+		t.Errorf("Bad time system or signal unit: %q %q", h.TimeSystem,
+			h.SignalStrengthUnit)
+	}
+	if len(h.PhaseShifts) != 19 || h.PhaseShifts[18].System != 'J' ||
+		string(h.PhaseShifts[18].Code[:]) != "5Q " {
+		t.Errorf("Bad phase shifts %v", h.PhaseShifts)
+	}
+	biases := map[[3]byte]float64{{'C', '1', 'C'}: 0, {'C', '2', 'C'}: 0,
+		{'C', '2', 'P'}: 0}
+	if !reflect.DeepEqual(h.GLONASSBiases, biases) {
+		t.Errorf("Bad GLONASS biases %v", h.GLONASSBiases)
+	}
+	if len(h.Comments) != 3 {
+		t.Errorf("Bad comments %q", h.Comments)
+	}
+}
+
+func TestObsHeaderFields(t *testing.T) {
+	_, or, err := collect(rinexV3HeaderExample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := or.Header
+
+	sats := make([][3]byte, 12)
+	for i := range sats {
+		sats[i] = [3]byte{'G', byte('0' + (i+1)/10), byte('0' + (i+1)%10)}
+	}
+	shifts := []PhaseShift{
+		{System: 'G', Code: [3]byte{'L', '2', 'S'}, Correction: 0.25, Sats: sats},
+		{System: 'G', Code: [3]byte{'L', '1', 'C'}},
+	}
+	if !reflect.DeepEqual(h.PhaseShifts, shifts) {
+		t.Errorf("Bad phase shifts %v", h.PhaseShifts)
+	}
+
+	last := time.Date(2019, 1, 10, 23, 59, 30, 0, time.UTC)
+	if !h.LastObs.Equal(last) || h.Interval != 30 {
+		t.Errorf("Bad last observation %v or interval %g", h.LastObs,
+			h.Interval)
+	}
+
+	slots := map[[3]byte]int{
+		{'R', '0', '1'}: 1, {'R', '0', '2'}: -4, {'R', '0', '3'}: 5,
+		{'R', '0', '4'}: 6, {'R', '0', '5'}: 1, {'R', '0', '6'}: -4,
+		{'R', '0', '7'}: 5, {'R', '0', '8'}: 6, {'R', '0', '9'}: -2,
+		{'R', '1', '0'}: -7,
+	}
+	if !reflect.DeepEqual(h.GLONASSSlots, slots) {
+		t.Errorf("Bad GLONASS slots %v", h.GLONASSSlots)
+	}
+
+	biases := map[[3]byte]float64{
+		{'C', '1', 'C'}: -10, {'C', '1', 'P'}: -9.75,
+		{'C', '2', 'C'}: -11.25, {'C', '2', 'P'}: -10.5,
+	}
+	if !reflect.DeepEqual(h.GLONASSBiases, biases) {
+		t.Errorf("Bad GLONASS biases %v", h.GLONASSBiases)
+	}
+
+	if h.LeapSeconds != 18 {
+		t.Errorf("Bad leap seconds %d", h.LeapSeconds)
+	}
+}
+
+func TestObsHeaderBadOptional(t *testing.T) {
+	// Bad optional headers do not stop a strict parse.
+	text := strings.NewReplacer(
+		"    30.000  ", "    3x.000  ",
+		"R09 -2", "R09 -x",
+		"    18      ", "    1x      ",
+	).Replace(rinexV3HeaderExample)
+	var diags []Diagnostic
+	or := &ObsReader{DiagFunc: func(d Diagnostic) error {
+		diags = append(diags, d)
+		return nil
+	}}
+	if err := or.Parse(strings.NewReader(text)); err != nil {
+		t.Fatal(err)
+	}
+
+	h := or.Header
+	if h.Interval != 0 || h.LeapSeconds != 0 || len(h.GLONASSSlots) != 8 {
+		t.Errorf("Bad fields: interval %g, leap seconds %d, slots %v",
+			h.Interval, h.LeapSeconds, h.GLONASSSlots)
+	}
+	if len(diags) != 3 || diags[0].FirstLine != 7 || diags[1].LastLine != 9 {
+		t.Errorf("Bad diagnostics %v", diags)
+	}
+	var pe *ParseError
+	if !errors.As(diags[2].Err, &pe) || pe.Field != "LEAP SECONDS header" {
+		t.Errorf("Bad leap seconds diagnostic %v", diags[2].Err)
+	}
+}
+
+func TestObsHeaderPhaseShiftCount(t *testing.T) {
+	// An overstated count must not swallow the next correction, and a
+	// negative count must not be carried over.
+	text := `     3.04           OBSERVATION DATA    M                   RINEX VERSION / TYPE
+G L2S  0.25000  14 G01 G02 G03 G04 G05 G06 G07 G08 G09 G10  SYS / PHASE SHIFT
+                   G11 G12                                  SYS / PHASE SHIFT
+G L1C  0.00000  -2                                          SYS / PHASE SHIFT
+G L5Q  0.00000   3 G01 G02                                  SYS / PHASE SHIFT
+                                                            END OF HEADER
+`
+	_, or, err := collect(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shifts := or.Header.PhaseShifts
+	if len(shifts) != 3 || len(shifts[0].Sats) != 12 || shifts[1].Sats != nil ||
+		len(shifts[2].Sats) != 2 || string(shifts[2].Code[:]) != "L5Q" {
+		t.Errorf("Bad phase shifts %v", shifts)
+	}
+	if or.phaseShiftSats != 0 {
+		t.Errorf("%d phase shift satellites left over", or.phaseShiftSats)
+	}
+}
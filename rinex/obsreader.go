@@ -1,7 +1,7 @@
-// Package rinex provides readers (and eventually writers) for the RINEX
-// v2.11 and v3.04 file formats.  Notably, it does not attempt to parse
-// all the defined header lines, but allows an application to receive
-// and process each header line separately.
+// Package rinex provides readers and writers for the RINEX v2.11,
+// v3.04 and v4.0x file formats.  It parses the commonly used header
+// lines, and also allows an application to receive and process each
+// header line separately.
 package rinex
 
 import (
@@ -12,20 +12,33 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/entrope/gnss/gnsstime"
 )
 
 // Observation describes a single RINEX-style observation.
 type Observation struct {
 	// Value is the value of the observation, with three decimal digits
 	// of fractional precision.  If the observation is not present in
-	// the file, Value is 0.
+	// the file, Value is 0 and Present is false.
 	Value float64
 
+	// Present is true if the observation was given in the input, so
+	// that a genuine zero can be told apart from a blank field.
+	// Writers treat an observation as present if Present is true or
+	// Value is non-zero, so code that only sets Value keeps working.
+	Present bool
+
 	// LLI is the "loss of lock indicator", which is a three-bit mask.
 	// This is the value of the field, not the ASCII code from the
 	// input; blanks are mapped to 0.
 	LLI byte
 
+	// LLIPresent is true if the LLI field was not blank in the input.
+	// Writers write the LLI field if LLIPresent is true or LLI is
+	// non-zero.
+	LLIPresent bool
+
 	// SignalStrength is a projection of signal strength onto the
 	// interval 1-9.  RINEX 2 did not specify the exact mapping from
 	// SNR or CNR to this value; RINEX 3 does.
@@ -37,7 +50,7 @@ type Observation struct {
 type SVObservation struct {
 	// PRN identifies the satellite. PRN[0] identifies the GNSS. PRN[1]
 	// and PRN[2] identify the satellite within the constellation.
-	PRN [3]byte
+	PRN SatID
 
 	// Obs contains the observations from this satellite during the
 	// current epoch.  This slice has the same length as the parent
@@ -68,9 +81,11 @@ type ObservationRecord struct {
 	// special events as described in the RINEX specification.
 	EpochFlag byte
 
-	// Second is the seconds-with-minute value for the measurement
-	// epoch. It has up to seven decimal digits of significant fraction.
-	Second float32
+	// Nanoseconds is the time within the minute of the measurement
+	// epoch (ns).  RINEX gives it with up to seven decimal digits of
+	// fraction, so it is normally a multiple of 100 ns.  The Second
+	// method gives the same time in seconds.
+	Nanoseconds int64
 
 	// Offset is the receiver clock offset.  If this value was not
 	// given in the input, Offset holds 0.
@@ -80,20 +95,92 @@ type ObservationRecord struct {
 	Sat []SVObservation
 }
 
+// Epoch flags for special event records.
+const (
+	// EventMoving marks the start of a moving antenna (kinematic
+	// data).
+	EventMoving byte = 2
+
+	// EventNewSite marks a new site occupation, at the end of a
+	// kinematic segment.  The header lines that follow it describe at
+	// least the new marker.
+	EventNewSite byte = 3
+
+	// EventHeader means that header lines follow, which may change
+	// (for example) the observation types.
+	EventHeader byte = 4
+
+	// EventExternal marks an external event, such as a camera shutter
+	// pulse.  The record's epoch is the time of the event.
+	EventExternal byte = 5
+)
+
+// Event describes a special event record: an observation record with
+// an epoch flag from 2 to 5, together with the header lines that
+// follow it.
+type Event struct {
+	// Flag is the epoch flag, from EventMoving to EventExternal.
+	Flag byte
+
+	// Time is the epoch of the event record.  RINEX lets event records
+	// other than EventExternal omit the epoch, in which case Time is
+	// the zero time.
+	Time time.Time
+
+	// Lines holds the header lines in the event record.  They have
+	// already been applied to the ObsReader's Header and Observations.
+	Lines []HeaderLine
+
+	// ObsTypesChanged is true if the header lines changed the types of
+	// observations for any GNSS.
+	ObsTypesChanged bool
+}
+
 // ObsReader reads RINEX data that contain satellite observable values.
 // In particular, it handles the RINEX 2.11 format that is associated
 // with file extensions .yyo (where yy is a two-digit year number) and
-// the RINEX 3.04 format that is associated with file extension .rnx.
+// the RINEX 3.04 and 4.0x formats that are associated with file
+// extension .rnx.
 type ObsReader struct {
-	// HeaderFunc is a function that is called for each header line.
-	// label starts at the 61st column, and is always 20 bytes long.
-	// If HeaderFunc returns non-nil, parsing stops.
+	// HeaderFunc is a function that is called for each header line,
+	// after the line's value (if any) is stored in Header, so it can
+	// handle labels that Header does not cover.  label starts at the
+	// 61st column, and is always 20 bytes long.  If HeaderFunc returns
+	// non-nil, parsing stops.
 	HeaderFunc func(label, value string) error
 
 	// ObsFunc is a function that is called for each observation record.
-	// If it returns non-nil, parsing stops.
+	// Special event records are passed to it with no satellites, before
+	// the header lines that follow them are read.  If it returns
+	// non-nil, parsing stops.
 	ObsFunc func(rec ObservationRecord) error
 
+	// EventFunc, if not nil, is called for each special event record
+	// (epoch flags 2 to 5), after the header lines in the record have
+	// been applied.  If it returns non-nil, parsing stops.
+	EventFunc func(ev Event) error
+
+	// Header holds the values of the header lines that have been read
+	// so far, including those from special event records.
+	Header ObsHeader
+
+	// Lenient makes the reader recover from errors in the input rather
+	// than stopping at the first one.  It skips header lines that it
+	// cannot parse.  When an observation record has an error, it drops
+	// that record and skips to the next line that looks like the start
+	// of an epoch.  For Compact RINEX input, errors in the compressed
+	// text are handled as described for HatanakaReader.Lenient.  Errors
+	// in the RINEX VERSION / TYPE or END OF HEADER lines, read errors
+	// and errors from the callback functions still stop parsing.
+	Lenient bool
+
+	// DiagFunc, if not nil, is called in lenient mode for each region
+	// of the input that the reader skipped.  It is also called, in
+	// either mode, for optional header lines (such as INTERVAL or
+	// LEAP SECONDS) whose values could not be parsed; those leave
+	// their Header fields zero.  If it returns non-nil, parsing stops.
+	DiagFunc func(d Diagnostic) error
+
 	// Observations lists the types of observations for a given GNSS.
 	// The map index is the first character of a satellite ID ('G' for
 	// GPS, 'R' for GLONASS, 'S' for SBAS, 'E' for Galileo, etc., as
@@ -107,6 +194,11 @@ type ObsReader struct {
 	// identifiers.)
 	Observations map[byte][][3]byte
 
+	// Moving is true while the antenna is moving: after an event
+	// record with EventMoving and before the next one with
+	// EventNewSite.
+	Moving bool
+
 	// version is the RINEX version number for the stream.
 	version int
 
@@ -133,9 +225,40 @@ type ObsReader struct {
 	// lines for "SYS / # / OBS TYPE" header lines.
 	lastSystem byte
 
+	// phaseShiftSats is the number of satellites still to be read for
+	// a "SYS / PHASE SHIFT" header that has continuation lines.
+	phaseShiftSats int
+
 	// obsRec holds the observation record that is currently being read.
 	obsRec ObservationRecord
 
+	// event holds the special event record that is currently being
+	// read.
+	event Event
+
+	// ready is set when obsRec holds a complete record.
+	ready bool
+
+	// scanner splits the input into lines.
+	scanner *bufio.Scanner
+
+	// lineNum is the one-based number of the line being processed.
+	lineNum int
+
+	// recordStart is the line number where the observation record that
+	// is being read started, or 0 if there is no partial record.
+	recordStart int
+
+	// skipStart is the first line of the region that is being skipped
+	// in lenient mode, or 0 if the reader is not skipping.
+	skipStart int
+
+	// skipErr is the error that started the skipped region.
+	skipErr error
+
+	// stopped is set when a callback function returns an error.
+	stopped bool
+
 	// lineBuf holds the line currently being processed.
 	lineBuf [80]byte
 }
@@ -143,57 +266,233 @@ type ObsReader struct {
 /************************ TOP LEVEL FUNCTIONS ************************/
 
 // Parse reads RINEX data from r and runs the callback functions in or.
+// If r holds Compact RINEX (Hatanaka-compressed) data, Parse
+// decompresses it.  Errors in the input, including a record that is
+// cut off by the end of the input, are reported as *ParseError; errors
+// from the callback functions are returned unchanged.  See Lenient for
+// how to recover from errors in the input.
 func (or *ObsReader) Parse(r io.Reader) error {
+	or.start(r)
+	for {
+		more, err := or.scanLine()
+		if err != nil || !more {
+			return err
+		}
+	}
+}
+
+// start resets or to read the beginning of r.
+func (or *ObsReader) start(r io.Reader) {
 	or.inHeader = true
 	or.version = 0
 	or.lastSystem = 0
+	or.phaseShiftSats = 0
+	or.lineNum = 0
+	or.recordStart = 0
+	or.skipStart = 0
+	or.stopped = false
+	or.count = 0
+	or.Moving = false
+	or.Header = ObsHeader{}
 	or.Observations = make(map[byte][][3]byte)
-	s := bufio.NewScanner(r)
-
-	for s.Scan() {
-		var line string
-		if or.inHeader || or.version == 2 {
-			// Space-pad the input to 80 characters.
-			b := s.Bytes()
-			if len(b) > 80 {
-				return errors.New("Oversized input line")
-			}
-			for i := copy(or.lineBuf[:], b); i < 80; i++ {
-				or.lineBuf[i] = ' '
-			}
-			line = string(or.lineBuf[:]) // yuck!
-		} else {
-			line = s.Text()
+
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(80)
+	if isCRINEX(head) {
+		hr := NewHatanakaReader(br)
+		hr.Lenient = or.Lenient
+		hr.DiagFunc = or.diagnose
+		r = hr
+	} else {
+		r = br
+	}
+	or.scanner = bufio.NewScanner(r)
+}
+
+// scanLine reads and handles one line of input.  It returns false if
+// there are no more lines, or if there was an error.
+func (or *ObsReader) scanLine() (bool, error) {
+	s := or.scanner
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return false, err
+		}
+		return false, or.finish()
+	}
+	or.lineNum++
+
+	var line string
+	if or.inHeader || or.version == 2 {
+		// Space-pad the input to 80 characters.
+		b := s.Bytes()
+		if len(b) > 80 {
+			line = string(b)
+			return or.recover(line, or.lineError(line, "",
+				errors.New("Oversized input line")))
 		}
+		for i := copy(or.lineBuf[:], b); i < 80; i++ {
+			or.lineBuf[i] = ' '
+		}
+		line = string(or.lineBuf[:]) // yuck!
+	} else {
+		line = s.Text()
+	}
 
-		// Handle the line depending on our format.
-		if or.inHeader {
-			if err := or.handleHeader(line); err != nil {
-				return err
-			}
-		} else if or.version == 2 {
-			if err := or.parseV2(line); err != nil {
-				return err
-			}
-		} else if or.version == 3 {
-			if err := or.parseV3(line); err != nil {
-				return err
-			}
-		} else {
-			panic("RINEX header did not declare its version")
+	// Are we looking for the start of the next epoch?
+	if or.skipStart != 0 {
+		if !or.isEpochLine(line) {
+			return true, nil
+		}
+		if err := or.endSkip(or.lineNum - 1); err != nil {
+			return false, err
 		}
 	}
 
-	return s.Err()
+	// Handle the line depending on our format.
+	var err error
+	if or.inHeader {
+		err = or.handleHeader(line)
+	} else if or.version == 2 {
+		err = or.parseV2(line)
+	} else if or.version >= 3 {
+		// RINEX 4 observation records use the RINEX 3 format.
+		err = or.parseV3(line)
+	} else {
+		err = errors.New("RINEX header did not declare its version")
+	}
+	if err != nil {
+		return or.recover(line, err)
+	}
+	return true, nil
 }
 
-// handleHeader parse a RINEX 2.11 or 3.04 format header line.
+// finish checks the reader's state at the end of the input.
+func (or *ObsReader) finish() error {
+	if or.skipStart != 0 {
+		return or.endSkip(or.lineNum)
+	}
+	if or.recordStart == 0 {
+		return nil
+	}
+
+	err := &ParseError{
+		Line:  or.recordStart,
+		Field: "observation record",
+		Err:   errors.New("Record is truncated"),
+	}
+	if !or.Lenient {
+		return err
+	}
+	return or.diagnose(Diagnostic{
+		Err:       err,
+		FirstLine: or.recordStart,
+		LastLine:  or.lineNum,
+	})
+}
+
+// recover decides whether parsing can continue after err occurred on
+// line.  If so, it starts skipping the bad part of the input.
+func (or *ObsReader) recover(line string, err error) (bool, error) {
+	if _, ok := err.(*ParseError); !ok || !or.Lenient || or.stopped {
+		return false, err
+	}
+
+	// Errors in the main header only lose one line.
+	if or.inHeader && or.recordStart == 0 {
+		var label string
+		if len(line) >= 80 {
+			label = line[60:80]
+		}
+		if label == "RINEX VERSION / TYPE" || label == "END OF HEADER       " {
+			return false, err
+		}
+		return true, or.diagnose(Diagnostic{
+			Err:       err,
+			FirstLine: or.lineNum,
+			LastLine:  or.lineNum,
+		})
+	}
+
+	// Otherwise, drop the current record.
+	or.skipStart = or.lineNum
+	if or.recordStart != 0 {
+		or.skipStart = or.recordStart
+	}
+	or.skipErr = err
+	or.inHeader = false
+	or.recordStart = 0
+	or.count = 0
+	or.lastSystem = 0
+	or.prnIndex = 0
+	or.obsIndex = 0
+	or.obsRec.Sat = or.obsRec.Sat[:0]
+	return true, nil
+}
+
+// endSkip ends the skipped region at line last and reports it.
+func (or *ObsReader) endSkip(last int) error {
+	d := Diagnostic{Err: or.skipErr, FirstLine: or.skipStart, LastLine: last}
+	or.skipStart = 0
+	or.skipErr = nil
+	return or.diagnose(d)
+}
+
+// diagnose passes d to DiagFunc.
+func (or *ObsReader) diagnose(d Diagnostic) error {
+	if or.DiagFunc == nil {
+		return nil
+	}
+	err := or.DiagFunc(d)
+	if err != nil {
+		or.stopped = true
+	}
+	return err
+}
+
+// isEpochLine returns true if line looks like the first line of an
+// observation record.
+func (or *ObsReader) isEpochLine(line string) bool {
+	if or.version != 2 {
+		return len(line) > 0 && line[0] == '>'
+	}
+
+	// The epoch flag follows two blanks; observation lines cannot have
+	// a digit in that column unless the preceding columns hold part of
+	// a value.
+	if line[26:28] != "  " || line[28] < '0' || line[28] > '6' {
+		return false
+	}
+	if strings.TrimSpace(line[:26]) == "" {
+		// Only event records may omit the epoch.
+		return line[28] >= '2' && line[28] <= '5'
+	}
+	return line[0] == ' ' && line[3] == ' ' && line[18] == '.'
+}
+
+// reportRecord marks or.obsRec as complete and passes it to ObsFunc.
+func (or *ObsReader) reportRecord() error {
+	or.ready = true
+	if !or.inHeader {
+		or.recordStart = 0
+	}
+	if or.ObsFunc != nil {
+		if err := or.ObsFunc(or.obsRec); err != nil {
+			or.stopped = true
+			return err
+		}
+	}
+	return nil
+}
+
+// handleHeader parse a RINEX 2.11, 3.04 or 4.0x format header line.
 func (or *ObsReader) handleHeader(line string) error {
-	// Is this an embedded header for epoch/event flag 4?
-	if or.count > 0 {
+	// Is this an embedded header for epoch/event flag 2-5?
+	inEvent := or.count > 0
+	if inEvent {
 		or.count--
 		if or.count == 0 {
 			or.inHeader = false
+			or.recordStart = 0
 		}
 	}
 
@@ -204,14 +503,75 @@ func (or *ObsReader) handleHeader(line string) error {
 
 	// Is it one of the known labels that we treat specially?
 	if handler := specialHeaders[label]; handler != nil {
-		err = handler(or, value)
+		if err = handler(or, value); err != nil {
+			err = or.headerError(label, value, err)
+			if !optionalHeaders[label] {
+				return err
+			}
+			// Optional headers do not matter enough to stop parsing.
+			err = or.diagnose(Diagnostic{
+				Err:       err,
+				FirstLine: or.lineNum,
+				LastLine:  or.lineNum,
+			})
+			if err != nil {
+				return err
+			}
+		}
 	}
 
-	if err == nil && or.HeaderFunc != nil {
-		err = or.HeaderFunc(label, value)
+	if or.HeaderFunc != nil {
+		if err = or.HeaderFunc(label, value); err != nil {
+			or.stopped = true
+			return err
+		}
 	}
 
-	return err
+	if inEvent {
+		or.event.Lines = append(or.event.Lines, HeaderLine{Label: label, Value: value})
+		if label == "# / TYPES OF OBSERV " || label == "SYS / # / OBS TYPES " {
+			or.event.ObsTypesChanged = true
+		}
+		if or.count == 0 {
+			return or.reportEvent()
+		}
+	}
+
+	return nil
+}
+
+// startEvent handles the first line of a special event record.
+func (or *ObsReader) startEvent() error {
+	or.inHeader = or.count > 0
+	or.event = Event{Flag: or.obsRec.EpochFlag}
+	if or.obsRec.Year != 0 {
+		or.event.Time = or.obsRec.Time()
+	}
+	if err := or.reportRecord(); err != nil {
+		return err
+	}
+	if or.count == 0 {
+		return or.reportEvent()
+	}
+	return nil
+}
+
+// reportEvent updates the reader's state for the completed special
+// event record in or.event and passes it to EventFunc.
+func (or *ObsReader) reportEvent() error {
+	switch or.event.Flag {
+	case EventMoving:
+		or.Moving = true
+	case EventNewSite:
+		or.Moving = false
+	}
+	if or.EventFunc != nil {
+		if err := or.EventFunc(or.event); err != nil {
+			or.stopped = true
+			return err
+		}
+	}
+	return nil
 }
 
 /************************** HELPER FUNCTIONS **************************/
@@ -224,6 +584,71 @@ func parseFloat(text string, bitSize int) (float64, error) {
 	return strconv.ParseFloat(strings.TrimSpace(text), bitSize)
 }
 
+// parseUintField parses line[start:end] as an unsigned integer field
+// and describes any error using field.
+func (or *ObsReader) parseUintField(line string, start, end, bitSize int, field string) (uint64, error) {
+	v, err := parseUint(line[start:end], bitSize)
+	if err != nil {
+		return 0, or.fieldError(line, start, end, field, err)
+	}
+	return v, nil
+}
+
+// parseFloatField parses line[start:end] as a floating-point field and
+// describes any error using field.
+func (or *ObsReader) parseFloatField(line string, start, end, bitSize int, field string) (float64, error) {
+	v, err := parseFloat(line[start:end], bitSize)
+	if err != nil {
+		return 0, or.fieldError(line, start, end, field, err)
+	}
+	return v, nil
+}
+
+// fieldError returns a ParseError for line[start:end] of the current
+// line.
+func (or *ObsReader) fieldError(line string, start, end int, field string, err error) error {
+	return &ParseError{
+		Line:      or.lineNum,
+		Column:    start + 1,
+		EndColumn: end,
+		Field:     field,
+		Text:      line[start:end],
+		Err:       err,
+	}
+}
+
+// lineError returns a ParseError for the whole of the current line.
+func (or *ObsReader) lineError(line, field string, err error) error {
+	return &ParseError{
+		Line:  or.lineNum,
+		Field: field,
+		Text:  strings.TrimRight(line, " "),
+		Err:   err,
+	}
+}
+
+// headerError returns a ParseError for a header line whose value could
+// not be parsed.
+func (or *ObsReader) headerError(label, value string, err error) error {
+	if _, ok := err.(*ParseError); ok {
+		return err
+	}
+	return &ParseError{
+		Line:      or.lineNum,
+		Column:    1,
+		EndColumn: 60,
+		Field:     strings.TrimSpace(label) + " header",
+		Text:      strings.TrimRight(value, " "),
+		Err:       err,
+	}
+}
+
+// obsField describes the observation of type code from satellite prn.
+func obsField(prn [3]byte, code [3]byte) string {
+	return string(prn[:]) + " " + strings.TrimRight(string(code[:]), " \x00") +
+		" observation"
+}
+
 /************************* RINEX v2 FUNCTIONS *************************/
 
 func (or *ObsReader) parseV2(line string) error {
@@ -233,14 +658,15 @@ func (or *ObsReader) parseV2(line string) error {
 	// mean mid-observation for that type.
 
 	if or.lastSystem == 0 {
+		if strings.TrimSpace(line) == "" {
+			return nil
+		}
 		if err := or.parseV2ObsIntro(line); err != nil {
 			return err
 		}
 
-		switch or.obsRec.EpochFlag {
-		case 0, 1, 6:
-			// continue to parse PRNs
-		default:
+		// Was it an event or an empty epoch?
+		if or.recordStart == 0 || or.inHeader {
 			return nil
 		}
 	}
@@ -257,28 +683,33 @@ func (or *ObsReader) parseV2(line string) error {
 func (or *ObsReader) parseV2Epoch(line string, flag byte) error {
 	var year, month, day, hour, minute uint64
 	var second float64
+	var nanos int64
 
 	if line[2] != ' ' {
 		// Parse the fields.
 		var err error
-		if year, err = parseUint(line[1:3], 16); err != nil {
+		if year, err = or.parseUintField(line, 1, 3, 16, "epoch year"); err != nil {
 			return err
 		}
-		if month, err = parseUint(line[4:6], 8); err != nil {
+		if month, err = or.parseUintField(line, 4, 6, 8, "epoch month"); err != nil {
 			return err
 		}
-		if day, err = parseUint(line[7:9], 8); err != nil {
+		if day, err = or.parseUintField(line, 7, 9, 8, "epoch day"); err != nil {
 			return err
 		}
-		if hour, err = parseUint(line[10:12], 8); err != nil {
+		if hour, err = or.parseUintField(line, 10, 12, 8, "epoch hour"); err != nil {
 			return err
 		}
-		if minute, err = parseUint(line[13:15], 8); err != nil {
+		if minute, err = or.parseUintField(line, 13, 15, 8, "epoch minute"); err != nil {
 			return err
 		}
-		if second, err = parseFloat(line[15:26], 32); err != nil {
+		if second, err = or.parseFloatField(line, 15, 26, 32, "epoch second"); err != nil {
 			return err
 		}
+		var ok bool
+		if nanos, ok = parseNanos(line[15:26]); !ok {
+			nanos = int64(math.Round(second * 1e9))
+		}
 
 		// Extend "year" to four digits.
 		if year < uint64(or.year%100) {
@@ -287,7 +718,7 @@ func (or *ObsReader) parseV2Epoch(line string, flag byte) error {
 		or.year = or.year/100*100 + uint16(year)
 		year = uint64(or.year)
 	} else if flag == '0' || flag == '1' {
-		return errors.New("RINEX 2 observation requires epoch: " + line)
+		return or.lineError(line, "epoch", errors.New("Observation requires epoch"))
 	} // else no epoch, but none is needed
 
 	or.obsRec.Year = uint16(year)
@@ -295,7 +726,7 @@ func (or *ObsReader) parseV2Epoch(line string, flag byte) error {
 	or.obsRec.Day = byte(day)
 	or.obsRec.Hour = byte(hour)
 	or.obsRec.Minute = byte(minute)
-	or.obsRec.Second = float32(second)
+	or.obsRec.Nanoseconds = nanos
 	return nil
 }
 
@@ -305,11 +736,16 @@ func (or *ObsReader) parseV2ObsIntro(line string) error {
 	var count uint64
 
 	// Parse epoch flag and "number of satellites" field.
+	or.recordStart = or.lineNum
 	flag := line[28]
+	if flag < '0' || flag > '6' {
+		return or.fieldError(line, 28, 29, "epoch flag",
+			errors.New("Invalid epoch flag"))
+	}
 	or.obsRec.EpochFlag = flag - '0'
 	or.obsRec.Offset = 0
 	or.obsRec.Sat = or.obsRec.Sat[:0]
-	count, err := parseUint(line[29:32], 16)
+	count, err := or.parseUintField(line, 29, 32, 16, "satellite count")
 	if err != nil {
 		return err
 	}
@@ -322,16 +758,12 @@ func (or *ObsReader) parseV2ObsIntro(line string) error {
 
 	// Is the epoch flag 2-5?
 	if flag != '0' && flag != '1' && flag != '6' {
-		or.inHeader = or.count > 0
-		if or.ObsFunc != nil {
-			return or.ObsFunc(or.obsRec)
-		}
-		return nil
+		return or.startEvent()
 	}
 
 	// Parse the receiver time offset.
 	if line[79] != ' ' {
-		offset, err := parseFloat(line[68:80], 64)
+		offset, err := or.parseFloatField(line, 68, 80, 64, "receiver clock offset")
 		if err != nil {
 			return err
 		}
@@ -339,6 +771,9 @@ func (or *ObsReader) parseV2ObsIntro(line string) error {
 	}
 
 	// Get ready to read PRNs.
+	if count == 0 {
+		return or.reportRecord()
+	}
 	if cap(or.obsRec.Sat) < int(count) {
 		or.obsRec.Sat = make([]SVObservation, 0, count)
 	}
@@ -350,12 +785,13 @@ func (or *ObsReader) parseV2ObsIntro(line string) error {
 // the EPOCH/SAT line or in a continuation line.
 func (or *ObsReader) parseV2PRNs(line string) error {
 	// Either epoch flag 0, 1, 5, or a continuation line: PRNs.
-	for i := 0; i < 12; i++ {
+	for i := 0; i < 12 && len(or.obsRec.Sat) < int(or.count); i++ {
 		prn := line[3*i+32 : 3*i+35]
 		idx := len(or.obsRec.Sat)
 		if prn[2] == ' ' {
 			if idx < int(or.count) {
-				return errors.New("PRN list terminated early")
+				return or.fieldError(line, 3*i+32, 3*i+35, "satellite list",
+					errors.New("PRN list terminated early"))
 			}
 			break
 		}
@@ -391,15 +827,20 @@ func (or *ObsReader) parseV2Observations(line string) error {
 
 		// Parse Observation field.
 		value := 0.0
-		if entry[10] == '.' {
+		present := entry[10] == '.'
+		if present {
 			if value, err = parseFloat(entry[0:14], 64); err != nil {
-				return err
+				sv := or.obsRec.Sat[or.prnIndex]
+				code := or.Observations[' '][int(or.obsIndex)+i]
+				return or.fieldError(line, i*16, i*16+14,
+					obsField(sv.PRN, code), err)
 			}
 		}
 
 		// Translate LLI field.
 		lli := byte(0)
-		if entry[14] != ' ' {
+		lliPresent := entry[14] != ' '
+		if lliPresent {
 			lli = entry[14] - '0'
 		}
 
@@ -416,7 +857,9 @@ func (or *ObsReader) parseV2Observations(line string) error {
 		}
 		s[int(or.obsIndex)+i] = Observation{
 			Value:          value,
+			Present:        present,
 			LLI:            lli,
+			LLIPresent:     lliPresent,
 			SignalStrength: signalStrength,
 		}
 		or.obsRec.Sat[or.prnIndex].Obs = s
@@ -432,9 +875,7 @@ func (or *ObsReader) parseV2Observations(line string) error {
 		if or.prnIndex == or.count {
 			or.prnIndex = 0
 			or.lastSystem = 0
-			if or.ObsFunc != nil {
-				return or.ObsFunc(or.obsRec)
-			}
+			return or.reportRecord()
 		}
 	}
 
@@ -444,13 +885,25 @@ func (or *ObsReader) parseV2Observations(line string) error {
 /************************* RINEX v3 FUNCTIONS *************************/
 
 func (or *ObsReader) parseV3(line string) error {
-	if line[0] == '>' {
+	if len(line) > 0 && line[0] == '>' {
 		return or.parseV3ObsIntro(line)
 	}
+	if or.recordStart == 0 {
+		if strings.TrimSpace(line) == "" {
+			return nil
+		}
+		return or.lineError(line, "satellite record",
+			errors.New("Satellite record is outside an epoch"))
+	}
+	if len(line) < 3 {
+		return or.lineError(line, "satellite record",
+			errors.New("Satellite record is too short"))
+	}
 
 	obslist, ok := or.Observations[line[0]]
 	if !ok {
-		return errors.New("Unexpected GNSS type: " + line)
+		return or.fieldError(line, 0, 1, "satellite system",
+			errors.New("Unexpected GNSS type"))
 	}
 	idx := len(or.obsRec.Sat)
 	or.obsRec.Sat = or.obsRec.Sat[:idx+1]
@@ -464,55 +917,67 @@ func (or *ObsReader) parseV3(line string) error {
 	for i := 0; i < len(obslist); i++ {
 		obs := Observation{}
 
-		if len(line) >= 17+16*i {
+		if len(line) >= 17+16*i && line[13+16*i] == '.' {
 			v, err := parseFloat(line[3+16*i:17+16*i], 64)
 			if err != nil {
-				return err
+				return or.fieldError(line, 3+16*i, 17+16*i,
+					obsField(svo.PRN, obslist[i]), err)
 			}
 			obs.Value = v
+			obs.Present = true
 		}
 
-		if len(line) >= 18+16*i {
-			obs.LLI = line[18+16*i] - '0'
+		if len(line) > 17+16*i && line[17+16*i] != ' ' {
+			obs.LLI = line[17+16*i] - '0'
+			obs.LLIPresent = true
 		}
 
-		if len(line) >= 19+16*i {
-			obs.SignalStrength = line[19+16*i] - '0'
+		if len(line) > 18+16*i && line[18+16*i] != ' ' {
+			obs.SignalStrength = line[18+16*i] - '0'
 		}
 
 		svo.Obs = append(svo.Obs, obs)
 	}
 	or.obsRec.Sat[idx] = svo
+
+	if len(or.obsRec.Sat) == int(or.count) {
+		return or.reportRecord()
+	}
 	return nil
 }
 
 func (or *ObsReader) parseV3Epoch(line string, flag byte) error {
 	var year, month, day, hour, minute uint64
 	var second float64
+	var nanos int64
 
 	if line[2] != ' ' {
 		// Parse the fields.
 		var err error
-		if year, err = parseUint(line[2:6], 16); err != nil {
+		if year, err = or.parseUintField(line, 2, 6, 16, "epoch year"); err != nil {
 			return err
 		}
-		if month, err = parseUint(line[7:9], 8); err != nil {
+		if month, err = or.parseUintField(line, 7, 9, 8, "epoch month"); err != nil {
 			return err
 		}
-		if day, err = parseUint(line[10:12], 8); err != nil {
+		if day, err = or.parseUintField(line, 10, 12, 8, "epoch day"); err != nil {
 			return err
 		}
-		if hour, err = parseUint(line[13:15], 8); err != nil {
+		if hour, err = or.parseUintField(line, 13, 15, 8, "epoch hour"); err != nil {
 			return err
 		}
-		if minute, err = parseUint(line[16:18], 8); err != nil {
+		if minute, err = or.parseUintField(line, 16, 18, 8, "epoch minute"); err != nil {
 			return err
 		}
-		if second, err = parseFloat(line[19:30], 32); err != nil {
+		if second, err = or.parseFloatField(line, 19, 30, 32, "epoch second"); err != nil {
 			return err
 		}
+		var ok bool
+		if nanos, ok = parseNanos(line[19:30]); !ok {
+			nanos = int64(math.Round(second * 1e9))
+		}
 	} else if flag == '0' || flag == '1' {
-		return errors.New("RINEX 3 observation requires epoch: " + line)
+		return or.lineError(line, "epoch", errors.New("Observation requires epoch"))
 	} // else no epoch, but none is needed
 
 	or.obsRec.Year = uint16(year)
@@ -520,16 +985,24 @@ func (or *ObsReader) parseV3Epoch(line string, flag byte) error {
 	or.obsRec.Day = byte(day)
 	or.obsRec.Hour = byte(hour)
 	or.obsRec.Minute = byte(minute)
-	or.obsRec.Second = float32(second)
+	or.obsRec.Nanoseconds = nanos
 	return nil
 }
 
 func (or *ObsReader) parseV3ObsIntro(line string) error {
-	flag := line[28]
+	if len(line) < 35 {
+		return or.lineError(line, "epoch", errors.New("Epoch record is too short"))
+	}
+	or.recordStart = or.lineNum
+	flag := line[31]
+	if flag < '0' || flag > '6' {
+		return or.fieldError(line, 31, 32, "epoch flag",
+			errors.New("Invalid epoch flag"))
+	}
 	or.obsRec.EpochFlag = flag - '0'
 	or.obsRec.Offset = 0
 	or.obsRec.Sat = or.obsRec.Sat[:0]
-	count, err := parseUint(line[32:35], 16)
+	count, err := or.parseUintField(line, 32, 35, 16, "satellite count")
 	if err != nil {
 		return err
 	}
@@ -541,16 +1014,12 @@ func (or *ObsReader) parseV3ObsIntro(line string) error {
 
 	// Does it declare a special event?
 	if flag != '0' && flag != '1' && flag != '6' {
-		or.inHeader = or.count > 0
-		if or.ObsFunc != nil {
-			return or.ObsFunc(or.obsRec)
-		}
-		return nil
+		return or.startEvent()
 	}
 
 	// Parse the receiver time offset.
 	if len(line) >= 56 {
-		offset, err := parseFloat(line[41:56], 64)
+		offset, err := or.parseFloatField(line, 41, 56, 64, "receiver clock offset")
 		if err != nil {
 			return err
 		}
@@ -558,6 +1027,9 @@ func (or *ObsReader) parseV3ObsIntro(line string) error {
 	}
 
 	// Make sure or.obsRec.Sat has enough capacity.
+	if or.count == 0 {
+		return or.reportRecord()
+	}
 	if cap(or.obsRec.Sat) < int(or.count) {
 		or.obsRec.Sat = make([]SVObservation, 0, int(or.count))
 	}
@@ -574,6 +1046,36 @@ var specialHeaders = map[string]func(*ObsReader, string) error{
 	"TIME OF FIRST OBS   ": (*ObsReader).handleTimeOfFirstObs,
 	"# / TYPES OF OBSERV ": (*ObsReader).handleNumTypesOfObserv,
 	"SYS / # / OBS TYPES ": (*ObsReader).handleSysNumObsTypes,
+	"PGM / RUN BY / DATE ": (*ObsReader).handlePgmRunByDate,
+	"COMMENT             ": (*ObsReader).handleComment,
+	"MARKER NAME         ": (*ObsReader).handleMarkerName,
+	"MARKER NUMBER       ": (*ObsReader).handleMarkerNumber,
+	"MARKER TYPE         ": (*ObsReader).handleMarkerType,
+	"OBSERVER / AGENCY   ": (*ObsReader).handleObserverAgency,
+	"REC # / TYPE / VERS ": (*ObsReader).handleRecTypeVers,
+	"ANT # / TYPE        ": (*ObsReader).handleAntType,
+	"APPROX POSITION XYZ ": (*ObsReader).handleApproxPosition,
+	"ANTENNA: DELTA H/E/N": (*ObsReader).handleAntennaDeltaHEN,
+	"INTERVAL            ": (*ObsReader).handleInterval,
+	"TIME OF LAST OBS    ": (*ObsReader).handleTimeOfLastObs,
+	"GLONASS SLOT / FRQ #": (*ObsReader).handleGLONASSSlotFrq,
+	"GLONASS COD/PHS/BIS ": (*ObsReader).handleGLONASSCodPhsBis,
+	"SYS / PHASE SHIFT   ": (*ObsReader).handleSysPhaseShift,
+	"SIGNAL STRENGTH UNIT": (*ObsReader).handleSignalStrengthUnit,
+	"LEAP SECONDS        ": (*ObsReader).handleLeapSeconds,
+}
+
+// optionalHeaders lists the special headers that only fill in
+// ObsHeader fields.  An error in one of them leaves its fields zero and
+// is reported to DiagFunc, even when the reader is not lenient.
+var optionalHeaders = map[string]bool{
+	"APPROX POSITION XYZ ": true,
+	"ANTENNA: DELTA H/E/N": true,
+	"INTERVAL            ": true,
+	"TIME OF LAST OBS    ": true,
+	"GLONASS SLOT / FRQ #": true,
+	"GLONASS COD/PHS/BIS ": true,
+	"LEAP SECONDS        ": true,
 }
 
 // handleRINEXVersion handles a RINEX VERSION / TYPE header.
@@ -583,12 +1085,17 @@ func (or *ObsReader) handleRINEXVersion(value string) error {
 		return err
 	}
 	or.version = int(math.Round(fltVersion))
-	if or.version != 2 && or.version != 3 {
+	if or.version < 2 || or.version > 4 {
 		return errors.New("Invalid RINEX version " + value[0:9])
 	}
 
 	if value[20] != 'O' {
-		return errors.New("Expected observation file, but got " + value[20:20])
+		return errors.New("Expected observation file, but got " + value[20:21])
+	}
+
+	or.Header.SatelliteSystem = value[40]
+	if or.Header.SatelliteSystem == ' ' {
+		or.Header.SatelliteSystem = 'G'
 	}
 
 	return nil
@@ -596,21 +1103,22 @@ func (or *ObsReader) handleRINEXVersion(value string) error {
 
 // handleEndOfHeader handles a END OF HEADER header.
 func (or *ObsReader) handleEndOfHeader(_ string) error {
+	if or.version == 0 {
+		return errors.New("RINEX header did not declare its version")
+	}
 	or.inHeader = false
 	return nil
 }
 
-// handleTimeOfFirstObs handles a RINEX 2 TIME OF FIRST OBS header.
+// handleTimeOfFirstObs handles a TIME OF FIRST OBS header.
 func (or *ObsReader) handleTimeOfFirstObs(value string) error {
-	if or.version != 2 {
-		return nil
-	}
-
-	year, err := parseUint(value[0:6], 16)
+	t, err := parseObsTime(value)
 	if err != nil {
 		return err
 	}
-	or.year = uint16(year)
+	or.Header.FirstObs = t
+	or.Header.TimeSystem = strings.TrimSpace(value[48:51])
+	or.year = uint16(t.Year())
 
 	return nil
 }
@@ -621,8 +1129,9 @@ func (or *ObsReader) handleNumTypesOfObserv(value string) error {
 		return nil
 	}
 
-	// Is this the first line?
-	if len(or.Observations) == 0 {
+	// Is this the first line?  Continuation lines leave the count
+	// blank, and a special event record may replace the list.
+	if strings.TrimSpace(value[0:6]) != "" || or.Observations[' '] == nil {
 		count, err := parseUint(value[0:6], 32)
 		if err != nil {
 			return err
@@ -646,7 +1155,7 @@ func (or *ObsReader) handleNumTypesOfObserv(value string) error {
 
 // handleSysNumObsTYpes handles a RINEX 3 SYS / # / OBS TYPES header.
 func (or *ObsReader) handleSysNumObsTypes(value string) error {
-	if or.version != 3 {
+	if or.version < 3 {
 		return nil
 	}
 	var s [][3]byte
@@ -678,12 +1187,24 @@ func (or *ObsReader) handleSysNumObsTypes(value string) error {
 	return nil
 }
 
-// Time converts the date and time in rec to a standard Go time.
-// This only works for time systems that (like Go) ignore leap seconds.
+// Time converts the date and time in rec to a standard Go time.  The
+// result is labeled as UTC, but is a reading of the file's time scale;
+// Instant converts it to an absolute time.
 func (rec ObservationRecord) Time() time.Time {
-	fSec := float64(rec.Second)
-	iSec := math.Floor(fSec)
-	nSec := int(math.Round(1e9 * (fSec - iSec)))
 	return time.Date(int(rec.Year), time.Month(rec.Month), int(rec.Day),
-		int(rec.Hour), int(rec.Minute), int(iSec), nSec, time.UTC)
+		int(rec.Hour), int(rec.Minute), 0, 0, time.UTC).Add(
+		time.Duration(rec.Nanoseconds))
+}
+
+// Second returns the seconds-within-minute value of the epoch of rec.
+func (rec ObservationRecord) Second() float32 {
+	return float32(float64(rec.Nanoseconds) / 1e9)
+}
+
+// Instant returns the time of rec as a reading of GPS time, given the
+// time scale of its epochs (normally from ObsHeader.Scale).  Unlike
+// Time, differences between the results are elapsed times even when
+// scale has leap seconds.
+func (rec ObservationRecord) Instant(scale gnsstime.Scale) time.Time {
+	return scale.ToGPS(rec.Time())
 }
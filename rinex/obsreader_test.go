@@ -4,8 +4,12 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/entrope/gnss/gnsstime"
 )
 
 type expectation interface {
@@ -17,9 +21,9 @@ type checker struct {
 	r []expectation
 }
 
-func TestParseV2(t *testing.T) {
-	r := bytes.NewReader([]byte(
-		`     2.11           OBSERVATION DATA    M (MIXED)           RINEX VERSION / TYPE
+// rinexV2Example is the mixed-GNSS example observation file from the
+// RINEX 2.11 specification, including its special event records.
+const rinexV2Example = `     2.11           OBSERVATION DATA    M (MIXED)           RINEX VERSION / TYPE
 BLANK OR G = GPS,  R = GLONASS,  E = GALILEO,  M = MIXED    COMMENT
 XXRINEXO V9.9       AIUB                24-MAR-01 14:43     PGM / RUN BY / DATE
 EXAMPLE OF A MIXED RINEX FILE (NO FEATURES OF V 2.11)       COMMENT
@@ -95,7 +99,84 @@ A 9080                                                      MARKER NAME
                             4  3
          ***   SATELLITE G 9   THIS EPOCH ON WLFACT 1 (L2)  COMMENT
          *** G 6 LOST LOCK AND THIS EPOCH ON WLFACT 2 (L2)  COMMENT
-                (OPPOSITE TO PREVIOUS SETTINGS)             COMMENT`))
+                (OPPOSITE TO PREVIOUS SETTINGS)             COMMENT`
+
+// rinexV3Example is the start of a RINEX 3.02 observation file from a
+// Septentrio receiver.
+const rinexV3Example = `     3.02           OBSERVATION DATA    M                   RINEX VERSION / TYPE
+ssrcrin-10.1.1x                         20190110 000000 LCL PGM / RUN BY / DATE 
+(0930225631113) Septentrio specific, please ignore.         COMMENT             
+TWTF                                                        MARKER NAME         
+Septentrio                                                  MARKER NUMBER       
+PolaRx4Pro                                                  MARKER TYPE         
+Pseudonym Doe       TL                                      OBSERVER / AGENCY   
+3008040             SEPT POLARX4        2.9.0               REC # / TYPE / VERS 
+CR620012101         ASH701945C_M    SCIS                    ANT # / TYPE        
+ -2994427.6478  4951307.5755  2674496.0997                  APPROX POSITION XYZ 
+        0.0000        0.0000        0.0000                  ANTENNA: DELTA H/E/N
+G   18 C1C L1C D1C S1C C1W S1W C2W L2W D2W S2W C2L L2L D2L  SYS / # / OBS TYPES 
+       S2L C5Q L5Q D5Q S5Q                                  SYS / # / OBS TYPES 
+E   16 C1C L1C D1C S1C C5Q L5Q D5Q S5Q C7Q L7Q D7Q S7Q C8Q  SYS / # / OBS TYPES 
+       L8Q D8Q S8Q                                          SYS / # / OBS TYPES 
+S    8 C1C L1C D1C S1C C5I L5I D5I S5I                      SYS / # / OBS TYPES 
+R   16 C1C L1C D1C S1C C2P L2P D2P S2P C2C L2C D2C S2C C3Q  SYS / # / OBS TYPES 
+       L3Q D3Q S3Q                                          SYS / # / OBS TYPES 
+C    8 C1I L1I D1I S1I C7I L7I D7I S7I                      SYS / # / OBS TYPES 
+J   12 C1C L1C D1C S1C C2L L2L D2L S2L C5Q L5Q D5Q S5Q      SYS / # / OBS TYPES 
+SEPTENTRIO RECEIVERS OUTPUT ALIGNED CARRIER PHASES.         COMMENT             
+NO FURTHER PHASE SHIFT APPLIED IN THE RINEX ENCODER.        COMMENT             
+G 1C                                                        SYS / PHASE SHIFT   
+G 2W                                                        SYS / PHASE SHIFT   
+G 2L   0.00000                                              SYS / PHASE SHIFT   
+G 5Q   0.00000                                              SYS / PHASE SHIFT   
+E 1C   0.00000                                              SYS / PHASE SHIFT   
+E 5Q   0.00000                                              SYS / PHASE SHIFT   
+E 7Q   0.00000                                              SYS / PHASE SHIFT   
+E 8Q   0.00000                                              SYS / PHASE SHIFT   
+S 1C                                                        SYS / PHASE SHIFT   
+S 5I                                                        SYS / PHASE SHIFT   
+R 1C                                                        SYS / PHASE SHIFT   
+R 2P   0.00000                                              SYS / PHASE SHIFT   
+R 2C                                                        SYS / PHASE SHIFT   
+R 3Q   0.00000                                              SYS / PHASE SHIFT   
+C 1I                                                        SYS / PHASE SHIFT   
+C 7I                                                        SYS / PHASE SHIFT   
+J 1C                                                        SYS / PHASE SHIFT   
+J 2L   0.00000                                              SYS / PHASE SHIFT   
+J 5Q   0.00000                                              SYS / PHASE SHIFT   
+  2019     1    10     0     0    0.0000000     GPS         TIME OF FIRST OBS   
+ C1C    0.000 C2C    0.000 C2P    0.000                     GLONASS COD/PHS/BIS 
+DBHZ                                                        SIGNAL STRENGTH UNIT
+                                                            END OF HEADER       
+> 2019 01 10 00 00  0.0000000  0 25
+S22  36968522.053 7 194271247.78607       -39.024 7        43.970
+S37  36925330.673 6 194043956.65206         4.286 6        40.205
+R20  21470076.145 6 114810173.20606      3090.169 6        36.450    21470085.162 7  89296810.30607      2403.428 7        43.482    21470085.883 7  89296817.31207      2403.475 7        42.724
+G24  23660058.191 5 124334441.97205      3536.009 5        35.948    23660058.183 3        22.609    23660062.087 3  96883999.47003      2755.327 3        22.609    23660061.636 6  96884000.48606      2755.368 6        39.024
+R05  21053486.921 7 112542934.51107       518.143 7        43.007    21053494.583 7  87533419.24207       403.061 7        43.788    21053495.148 7  87533425.25907       402.968 7        43.469
+S28  37768235.394 7 198474690.61107         0.286 7        43.412
+G29  21745189.830 7 114271733.77807      -625.538 7        47.307    21745189.759 5        35.257    21745189.555 5  89042902.21605      -487.428 5        35.257    21745189.917 6  89042904.21006      -487.383 6        41.108
+S32  37120920.343 6 195071749.62306         1.694 6        38.541
+G21  25068559.482 5 131736161.07005      3023.889 5        33.245    25068558.628 2        15.190    25068558.065 2 102651550.01902      2356.263 2        15.190
+R18  21837707.116 6 116571123.61506     -4111.833 6        41.023    21837715.505 7  90666468.54207     -3198.123 7        43.264    21837715.794 7  90666472.53407     -3198.009 7        42.919
+S26  39258577.813 5 206304606.82705       -43.680 5        34.733
+J02  37022437.538 6 194554238.77606       284.251 6        40.687    37022440.522 6 151600740.00006       221.410 6        41.449
+G05  21557855.617 8 113287291.64908      -936.262 8        48.876    21557855.670 6        39.396    21557855.238 6  88275809.25406      -729.553 6        39.396    21557855.214 7  88275811.25007      -729.607 7        43.075
+S29  36925306.276 6 194043821.67406         4.452 6        39.069
+G02  21368724.778 7 112293405.61407     -1893.303 7        46.782    21368724.121 6        38.420    21368722.575 6  87501332.34006     -1475.296 6        38.420
+S40  37094099.434 7 194931031.85707        -9.025 7        45.035
+R04  20716420.381 7 110935479.03307     -1576.407 7        42.663    20716426.919 7  86283173.89207     -1226.096 7        44.579    20716427.020 7  86283171.89907     -1226.190 7        44.126
+S30-262832343.036 7-381193270.63107        60.308 7        46.056
+R19  19302375.961 6 103254681.43606     -1155.669 6        40.784    19302382.279 7  80309222.10807      -898.879 7        45.609    19302382.910 7  80309229.10807      -898.926 7        45.169
+J03  36170763.840 6 190078651.44206      -234.439 6        41.800    36170766.211 7 148113245.64007      -182.668 7        42.427
+G15  20565309.840 8 108071421.78108      1175.407 8        50.805    20565309.889 7        42.476    20565309.469 7  84211487.59607       915.903 7        42.476    20565309.613 7  84211485.59107       915.948 7        45.279
+G13  20204184.687 8 106173705.70908      -996.799 8        48.676    20204184.459 7        43.185    20204184.090 7  82732742.84807      -776.727 7        43.185
+J01  39393044.929 7 207011852.97307        23.584 7        45.907    39393045.278 7 161307966.81407        18.388 7        44.650
+R14  22698361.677 6 120995042.36406      2057.955 6        40.217    22698370.128 7  94107307.11907      1600.686 7        42.002    22698370.731 6  94107298.10406      1600.647 6        41.402
+G30  24083967.488 5 126562091.90505     -1573.881 5        35.905    24083967.037 3        20.762    24083970.512 3  98619811.62103     -1226.399 3        20.762    24083970.019 6  98619814.63006     -1226.374 6        36.934`
+
+func TestParseV2(t *testing.T) {
+	r := bytes.NewReader([]byte(rinexV2Example))
 
 	c := checker{
 		r: []expectation{
@@ -167,78 +248,7 @@ A 9080                                                      MARKER NAME
 }
 
 func TestParseV3(t *testing.T) {
-	r := bytes.NewReader([]byte(
-		`     3.02           OBSERVATION DATA    M                   RINEX VERSION / TYPE
-ssrcrin-10.1.1x                         20190110 000000 LCL PGM / RUN BY / DATE 
-(0930225631113) Septentrio specific, please ignore.         COMMENT             
-TWTF                                                        MARKER NAME         
-Septentrio                                                  MARKER NUMBER       
-PolaRx4Pro                                                  MARKER TYPE         
-Pseudonym Doe       TL                                      OBSERVER / AGENCY   
-3008040             SEPT POLARX4        2.9.0               REC # / TYPE / VERS 
-CR620012101         ASH701945C_M    SCIS                    ANT # / TYPE        
- -2994427.6478  4951307.5755  2674496.0997                  APPROX POSITION XYZ 
-        0.0000        0.0000        0.0000                  ANTENNA: DELTA H/E/N
-G   18 C1C L1C D1C S1C C1W S1W C2W L2W D2W S2W C2L L2L D2L  SYS / # / OBS TYPES 
-       S2L C5Q L5Q D5Q S5Q                                  SYS / # / OBS TYPES 
-E   16 C1C L1C D1C S1C C5Q L5Q D5Q S5Q C7Q L7Q D7Q S7Q C8Q  SYS / # / OBS TYPES 
-       L8Q D8Q S8Q                                          SYS / # / OBS TYPES 
-S    8 C1C L1C D1C S1C C5I L5I D5I S5I                      SYS / # / OBS TYPES 
-R   16 C1C L1C D1C S1C C2P L2P D2P S2P C2C L2C D2C S2C C3Q  SYS / # / OBS TYPES 
-       L3Q D3Q S3Q                                          SYS / # / OBS TYPES 
-C    8 C1I L1I D1I S1I C7I L7I D7I S7I                      SYS / # / OBS TYPES 
-J   12 C1C L1C D1C S1C C2L L2L D2L S2L C5Q L5Q D5Q S5Q      SYS / # / OBS TYPES 
-SEPTENTRIO RECEIVERS OUTPUT ALIGNED CARRIER PHASES.         COMMENT             
-NO FURTHER PHASE SHIFT APPLIED IN THE RINEX ENCODER.        COMMENT             
-G 1C                                                        SYS / PHASE SHIFT   
-G 2W                                                        SYS / PHASE SHIFT   
-G 2L   0.00000                                              SYS / PHASE SHIFT   
-G 5Q   0.00000                                              SYS / PHASE SHIFT   
-E 1C   0.00000                                              SYS / PHASE SHIFT   
-E 5Q   0.00000                                              SYS / PHASE SHIFT   
-E 7Q   0.00000                                              SYS / PHASE SHIFT   
-E 8Q   0.00000                                              SYS / PHASE SHIFT   
-S 1C                                                        SYS / PHASE SHIFT   
-S 5I                                                        SYS / PHASE SHIFT   
-R 1C                                                        SYS / PHASE SHIFT   
-R 2P   0.00000                                              SYS / PHASE SHIFT   
-R 2C                                                        SYS / PHASE SHIFT   
-R 3Q   0.00000                                              SYS / PHASE SHIFT   
-C 1I                                                        SYS / PHASE SHIFT   
-C 7I                                                        SYS / PHASE SHIFT   
-J 1C                                                        SYS / PHASE SHIFT   
-J 2L   0.00000                                              SYS / PHASE SHIFT   
-J 5Q   0.00000                                              SYS / PHASE SHIFT   
-  2019     1    10     0     0    0.0000000     GPS         TIME OF FIRST OBS   
- C1C    0.000 C2C    0.000 C2P    0.000                     GLONASS COD/PHS/BIS 
-DBHZ                                                        SIGNAL STRENGTH UNIT
-                                                            END OF HEADER       
-> 2019 01 10 00 00  0.0000000  0 25
-S22  36968522.053 7 194271247.78607       -39.024 7        43.970
-S37  36925330.673 6 194043956.65206         4.286 6        40.205
-R20  21470076.145 6 114810173.20606      3090.169 6        36.450    21470085.162 7  89296810.30607      2403.428 7        43.482    21470085.883 7  89296817.31207      2403.475 7        42.724
-G24  23660058.191 5 124334441.97205      3536.009 5        35.948    23660058.183 3        22.609    23660062.087 3  96883999.47003      2755.327 3        22.609    23660061.636 6  96884000.48606      2755.368 6        39.024
-R05  21053486.921 7 112542934.51107       518.143 7        43.007    21053494.583 7  87533419.24207       403.061 7        43.788    21053495.148 7  87533425.25907       402.968 7        43.469
-S28  37768235.394 7 198474690.61107         0.286 7        43.412
-G29  21745189.830 7 114271733.77807      -625.538 7        47.307    21745189.759 5        35.257    21745189.555 5  89042902.21605      -487.428 5        35.257    21745189.917 6  89042904.21006      -487.383 6        41.108
-S32  37120920.343 6 195071749.62306         1.694 6        38.541
-G21  25068559.482 5 131736161.07005      3023.889 5        33.245    25068558.628 2        15.190    25068558.065 2 102651550.01902      2356.263 2        15.190
-R18  21837707.116 6 116571123.61506     -4111.833 6        41.023    21837715.505 7  90666468.54207     -3198.123 7        43.264    21837715.794 7  90666472.53407     -3198.009 7        42.919
-S26  39258577.813 5 206304606.82705       -43.680 5        34.733
-J02  37022437.538 6 194554238.77606       284.251 6        40.687    37022440.522 6 151600740.00006       221.410 6        41.449
-G05  21557855.617 8 113287291.64908      -936.262 8        48.876    21557855.670 6        39.396    21557855.238 6  88275809.25406      -729.553 6        39.396    21557855.214 7  88275811.25007      -729.607 7        43.075
-S29  36925306.276 6 194043821.67406         4.452 6        39.069
-G02  21368724.778 7 112293405.61407     -1893.303 7        46.782    21368724.121 6        38.420    21368722.575 6  87501332.34006     -1475.296 6        38.420
-S40  37094099.434 7 194931031.85707        -9.025 7        45.035
-R04  20716420.381 7 110935479.03307     -1576.407 7        42.663    20716426.919 7  86283173.89207     -1226.096 7        44.579    20716427.020 7  86283171.89907     -1226.190 7        44.126
-S30-262832343.036 7-381193270.63107        60.308 7        46.056
-R19  19302375.961 6 103254681.43606     -1155.669 6        40.784    19302382.279 7  80309222.10807      -898.879 7        45.609    19302382.910 7  80309229.10807      -898.926 7        45.169
-J03  36170763.840 6 190078651.44206      -234.439 6        41.800    36170766.211 7 148113245.64007      -182.668 7        42.427
-G15  20565309.840 8 108071421.78108      1175.407 8        50.805    20565309.889 7        42.476    20565309.469 7  84211487.59607       915.903 7        42.476    20565309.613 7  84211485.59107       915.948 7        45.279
-G13  20204184.687 8 106173705.70908      -996.799 8        48.676    20204184.459 7        43.185    20204184.090 7  82732742.84807      -776.727 7        43.185
-J01  39393044.929 7 207011852.97307        23.584 7        45.907    39393045.278 7 161307966.81407        18.388 7        44.650
-R14  22698361.677 6 120995042.36406      2057.955 6        40.217    22698370.128 7  94107307.11907      1600.686 7        42.002    22698370.731 6  94107298.10406      1600.647 6        41.402
-G30  24083967.488 5 126562091.90505     -1573.881 5        35.905    24083967.037 3        20.762    24083970.512 3  98619811.62103     -1226.399 3        20.762    24083970.019 6  98619814.63006     -1226.374 6        36.934`))
+	r := bytes.NewReader([]byte(rinexV3Example))
 
 	c := checker{
 		r: []expectation{
@@ -303,6 +313,240 @@ G30  24083967.488 5 126562091.90505     -1573.881 5        35.905    24083967.03
 	}
 }
 
+func TestParseV3Records(t *testing.T) {
+	// Each complete RINEX 3 record is passed to ObsFunc exactly once.
+	c, _, err := collect(rinexV3Example)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.records) != 1 {
+		t.Fatalf("Got %d records, expected 1", len(c.records))
+	}
+	rec := c.records[0]
+	if len(rec.Sat) != 25 || rec.Sat[0].PRN.String() != "S22" ||
+		rec.Sat[24].PRN.String() != "G30" {
+		t.Errorf("Bad satellites in %v", rec.Sat)
+	}
+	if v := rec.Sat[24].Obs[0].Value; v != 24083967.488 {
+		t.Errorf("G30 C1C = %v", v)
+	}
+
+	// A truncated record is not reported.
+	cut := rinexV3Example[:strings.LastIndex(rinexV3Example, "\nG30")]
+	c, _, err = collect(cut)
+	if err == nil || len(c.records) != 0 {
+		t.Errorf("Truncated record gave %d records, error %v", len(c.records), err)
+	}
+}
+
+// rinexV4Example is a RINEX 4.01 file with the DOI, LICENSE OF USE and
+// STATION INFORMATION headers that RINEX 4 added, and an event record.
+const rinexV4Example = `     4.01           OBSERVATION DATA    M                   RINEX VERSION / TYPE
+sbf2rin-15.4.1                          20230315 000152 UTC PGM / RUN BY / DATE 
+GEOD                                                        MARKER NAME         
+10002M006                                                   MARKER NUMBER       
+GEODETIC                                                    MARKER TYPE         
+Operator            Example Agency                          OBSERVER / AGENCY   
+3001234             SEPT POLARX5        5.5.0               REC # / TYPE / VERS 
+12345               LEIAR25.R4      LEIT                    ANT # / TYPE        
+  4201575.8940   189860.1720  4779065.9440                  APPROX POSITION XYZ 
+        0.0000        0.0000        0.0000                  ANTENNA: DELTA H/E/N
+https://doi.org/10.5072/example-geod                        DOI                 
+CC BY 4.0                                                   LICENSE OF USE      
+https://network.example.org/stations/GEOD                   STATION INFORMATION 
+G    4 C1C L1C D1C S1C                                      SYS / # / OBS TYPES 
+R    4 C1C L1C D1C S1C                                      SYS / # / OBS TYPES 
+E    4 C1C L1C D1C S1C                                      SYS / # / OBS TYPES 
+DBHZ                                                        SIGNAL STRENGTH UNIT
+    30.000                                                  INTERVAL            
+  2023     3    14     0     0    0.0000000     GPS         TIME OF FIRST OBS   
+  2023     3    14     0     1    0.0000000     GPS         TIME OF LAST OBS    
+    18    18  2253     2GPS                                 LEAP SECONDS        
+  2 R01  1 R02 -4                                           GLONASS SLOT / FRQ #
+ C1C    0.000 C1P    0.000 C2C    0.000 C2P    0.000        GLONASS COD/PHS/BIS 
+                                                            END OF HEADER       
+> 2023 03 14 00 00  0.0000000  0  3
+G05  22093514.170 7 116100955.288 7     -1234.567          45.250
+R01  21476155.371 6 114869002.563 6      2345.678          42.000
+E11  24587124.031 5 129205236.74515                        39.500
+>                              4  1
+Antenna changed to spare unit                               COMMENT             
+> 2023 03 14 00 00 30.0000000  0  2
+G05  22093279.250 7 116099720.771 7     -1234.321          45.500
+R02  19954210.875 8 106624981.004 8      -876.543          48.750
+`
+
+func TestParseV4(t *testing.T) {
+	var labels []string
+	c := &collector{}
+	or := &ObsReader{
+		HeaderFunc: func(label, value string) error {
+			labels = append(labels, strings.TrimSpace(label))
+			return c.onHeader(label, value)
+		},
+		ObsFunc: c.onObs,
+	}
+	if err := or.Parse(strings.NewReader(rinexV4Example)); err != nil {
+		t.Fatal(err)
+	}
+	if or.version != 4 {
+		t.Fatalf("Bad RINEX 4 version %d", or.version)
+	}
+
+	// The new RINEX 4 headers go to HeaderFunc like any other.
+	for _, want := range []string{"DOI", "LICENSE OF USE", "STATION INFORMATION"} {
+		found := false
+		for _, label := range labels {
+			found = found || label == want
+		}
+		if !found {
+			t.Errorf("HeaderFunc did not see %s", want)
+		}
+	}
+	h := &or.Header
+	if h.MarkerName != "GEOD" || h.LeapSeconds != 18 || h.Interval != 30 ||
+		h.GLONASSSlots[[3]byte{'R', '0', '2'}] != -4 || h.TimeSystem != "GPS" {
+		t.Errorf("Bad RINEX 4 header %+v", h)
+	}
+
+	if len(c.records) != 3 || len(c.events) != 1 {
+		t.Fatalf("Got %d records and %d events, want 3 and 1",
+			len(c.records), len(c.events))
+	}
+	if ev := c.events[0]; ev.rec.EpochFlag != 4 || len(ev.lines) != 1 {
+		t.Errorf("Bad event %+v", ev)
+	}
+	rec := c.records[0]
+	if len(rec.Sat) != 3 || rec.Sat[2].PRN != [3]byte{'E', '1', '1'} {
+		t.Fatalf("Bad first epoch %+v", rec)
+	}
+	if obs := rec.Sat[2].Obs; obs[1].Value != 129205236.745 || obs[1].LLI != 1 ||
+		obs[1].SignalStrength != 5 || obs[2].Present || obs[3].Value != 39.5 {
+		t.Errorf("Bad E11 observations %+v", obs)
+	}
+	if rec = c.records[2]; rec.Second() != 30 || len(rec.Sat) != 2 ||
+		rec.Sat[1].Obs[0].Value != 19954210.875 {
+		t.Errorf("Bad last epoch %+v", rec)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	var events []Event
+	var moving []bool
+	or := ObsReader{}
+	or.EventFunc = func(ev Event) error {
+		events = append(events, ev)
+		moving = append(moving, or.Moving)
+		return nil
+	}
+	if err := or.Parse(strings.NewReader(rinexV2Example)); err != nil {
+		t.Fatal(err)
+	}
+
+	wantFlags := []byte{4, 2, 3, 5, 4, 4, 4, 4}
+	if len(events) != len(wantFlags) {
+		t.Fatalf("Got %d events, want %d", len(events), len(wantFlags))
+	}
+	for i, ev := range events {
+		if ev.Flag != wantFlags[i] {
+			t.Errorf("Event %d has flag %d, want %d", i, ev.Flag, wantFlags[i])
+		}
+	}
+	if len(events[0].Lines) != 4 ||
+		strings.TrimSpace(events[0].Lines[0].Label) != "WAVELENGTH FACT L1/2" {
+		t.Errorf("Bad header change lines %q", events[0].Lines)
+	}
+	if !moving[1] || moving[2] || !events[2].Time.IsZero() ||
+		len(events[2].Lines) != 4 {
+		t.Errorf("Bad kinematic events %+v, moving %v", events[1:3], moving)
+	}
+	when := time.Date(2005, 3, 24, 13, 13, 1, 234567800, time.UTC)
+	if d := events[3].Time.Sub(when); d < -time.Microsecond || d > time.Microsecond ||
+		len(events[3].Lines) != 0 {
+		t.Errorf("Bad external event %+v", events[3])
+	}
+	for _, ev := range events {
+		if ev.ObsTypesChanged {
+			t.Errorf("Event at %v changed observation types", ev.Time)
+		}
+	}
+
+	// A header change event replaces the list of observation types.
+	input := strings.Join([]string{
+		"     2.11           OBSERVATION DATA    G (GPS)             RINEX VERSION / TYPE",
+		"     2    C1    L1                                          # / TYPES OF OBSERV ",
+		"  2005     3    24    13    10   36.0000000                 TIME OF FIRST OBS   ",
+		"                                                            END OF HEADER       ",
+		" 05  3 24 13 10 36.0000000  0  1G12",
+		"  23629347.915    23629364.158  ",
+		" 05  3 24 13 10 40.0000000  4  1",
+		"     3    C1    S1    L1                                    # / TYPES OF OBSERV ",
+		" 05  3 24 13 10 42.0000000  0  1G12",
+		"  23629347.915          45.000    23629364.158  ",
+		"",
+	}, "\n")
+	events = nil
+	var recs []ObservationRecord
+	or.ObsFunc = func(rec ObservationRecord) error {
+		if rec.EpochFlag == 0 {
+			recs = append(recs, rec)
+		}
+		return nil
+	}
+	if err := or.Parse(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	want := [][3]byte{{'C', '1', ' '}, {'S', '1', ' '}, {'L', '1', ' '}}
+	if !reflect.DeepEqual(or.Observations[' '], want) {
+		t.Errorf("Bad observation types %q", or.Observations[' '])
+	}
+	if len(events) != 1 || !events[0].ObsTypesChanged {
+		t.Errorf("Bad events %+v", events)
+	}
+	if len(recs) != 2 || len(recs[1].Sat[0].Obs) != 3 ||
+		recs[1].Sat[0].Obs[1].Value != 45 {
+		t.Errorf("Bad records %+v", recs)
+	}
+}
+
+func TestInstant(t *testing.T) {
+	tests := []struct {
+		text   string
+		system byte
+		scale  gnsstime.Scale
+	}{
+		{rinexV2Example, 'M', gnsstime.GPS},
+		{strings.Replace(rinexV2Example, "M (MIXED)", "R        ", 1), 'R', gnsstime.GLO},
+		{rinexHighRateExample, 'G', gnsstime.GPS},
+		{rinexV3Example, 'M', gnsstime.GPS},
+	}
+	for i, test := range tests {
+		c, or, err := collect(test.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scale, err := or.Header.Scale()
+		if or.Header.SatelliteSystem != test.system || scale != test.scale || err != nil {
+			t.Errorf("%d: system %c, scale %v, %v", i, or.Header.SatelliteSystem, scale, err)
+		}
+		rec := c.records[0]
+		if want := scale.ToGPS(rec.Time()); !rec.Instant(scale).Equal(want) {
+			t.Errorf("%d: Instant = %v, want %v", i, rec.Instant(scale), want)
+		}
+	}
+
+	// RINEX GLONASS epochs are in UTC(SU), which was 13 seconds
+	// behind GPS time in 2005.
+	rec := ObservationRecord{Year: 2005, Month: 3, Day: 24, Hour: 16, Minute: 10, Nanoseconds: 36e9}
+	want := time.Date(2005, 3, 24, 16, 10, 49, 0, time.UTC)
+	if got := rec.Instant(gnsstime.GLO); !got.Equal(want) {
+		t.Errorf("GLONASS Instant = %v, want %v", got, want)
+	}
+	if _, err := (&ObsHeader{TimeSystem: "XYZ"}).Scale(); err == nil {
+		t.Errorf("Expected an error for an unknown time system")
+	}
+}
+
 /********************* CONCRETE EXPECTATION TYPES *********************/
 
 type expectHeader struct {
@@ -351,3 +595,26 @@ func (c *checker) checkObs(rec ObservationRecord) error {
 	c.r = c.r[1:]
 	return expect.checkObs(rec)
 }
+
+func FuzzParse(f *testing.F) {
+	f.Add(rinexV2Example)
+	f.Add(rinexV3Example)
+	f.Add(crinexV1Example)
+	f.Add(crinexV3Example)
+	f.Add(strings.Replace(crinexV1Example, "3&-353", "A&100", 1))
+	f.Add(strings.Replace(crinexV3Example, "3844629", "38446x9", 1))
+	f.Add(strings.TrimSuffix(crinexV3Example, "362  0\n"))
+	f.Fuzz(func(t *testing.T, text string) {
+		// Neither mode may panic, whatever the input.
+		or := &ObsReader{}
+		or.Parse(strings.NewReader(text))
+		or.Lenient = true
+		or.DiagFunc = func(d Diagnostic) error {
+			if d.Err == nil || d.FirstLine < 1 || d.LastLine < d.FirstLine {
+				t.Errorf("Bad diagnostic %v", d)
+			}
+			return nil
+		}
+		or.Parse(strings.NewReader(text))
+	})
+}
@@ -0,0 +1,82 @@
+package rinex
+
+import (
+	"io"
+)
+
+// ObsScanner reads observation records one at a time, as a pull-style
+// alternative to ObsReader.Parse.  This makes it easy to stop early or
+// to step through several files in parallel.  A typical loop is:
+//
+//	sc := rinex.NewObsScanner(r)
+//	for sc.Next() {
+//		rec := sc.Record()
+//		...
+//	}
+//	if err := sc.Err(); err != nil {
+//		...
+//	}
+type ObsScanner struct {
+	// or holds the parser state.
+	or *ObsReader
+
+	// err is the first error that occurred, if any.
+	err error
+
+	// done is set when the end of input or an error is reached.
+	done bool
+}
+
+// NewObsScanner creates an ObsScanner that reads RINEX or Compact
+// RINEX observation data from r.
+func NewObsScanner(r io.Reader) *ObsScanner {
+	or := &ObsReader{}
+	or.start(r)
+	return &ObsScanner{or: or}
+}
+
+// Header returns the parsed header.  It is filled in by the first call
+// to Next, and updated by later special event records.
+func (sc *ObsScanner) Header() *ObsHeader {
+	return &sc.or.Header
+}
+
+// Observations returns the types of observations for each GNSS, laid
+// out as ObsReader.Observations.  It is filled in by the first call to
+// Next, and may change after a special event record.
+func (sc *ObsScanner) Observations() map[byte][][3]byte {
+	return sc.or.Observations
+}
+
+// Next reads the next observation record, including special event
+// records, so that it is available from Record.  It returns false when
+// it reaches the end of the input or an error.
+func (sc *ObsScanner) Next() bool {
+	if sc.done {
+		return false
+	}
+
+	sc.or.ready = false
+	for !sc.or.ready {
+		more, err := sc.or.scanLine()
+		if err != nil || !more {
+			sc.err = err
+			sc.done = true
+			return false
+		}
+	}
+
+	return true
+}
+
+// Record returns the observation record that was read by the last call
+// to Next.  The next call to Next reuses rec.Sat and its Obs slices.
+func (sc *ObsScanner) Record() ObservationRecord {
+	return sc.or.obsRec
+}
+
+// Err returns the first error that Next encountered, or nil if it
+// reached the end of the input normally.
+func (sc *ObsScanner) Err() error {
+	return sc.err
+}
@@ -0,0 +1,62 @@
+package rinex
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestObsScanner(t *testing.T) {
+	for _, text := range []string{rinexV2Example, rinexV3Example, crinexV3Example} {
+		c, or, err := collect(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The scanner should report the same records and headers.
+		sc := NewObsScanner(strings.NewReader(text))
+		c2 := &collector{}
+		for sc.Next() {
+			c2.onObs(sc.Record())
+		}
+		if err := sc.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if len(c2.records) == 0 || !reflect.DeepEqual(c.records, c2.records) {
+			t.Errorf("Scanner records differ from Parse")
+		}
+		if !reflect.DeepEqual(or.Header, *sc.Header()) ||
+			!reflect.DeepEqual(or.Observations, sc.Observations()) {
+			t.Errorf("Scanner header differs from Parse")
+		}
+		if sc.Next() {
+			t.Errorf("Next succeeded after end of input")
+		}
+	}
+}
+
+func TestObsScannerEarlyStop(t *testing.T) {
+	sc := NewObsScanner(strings.NewReader(rinexV2Example))
+	if !sc.Next() {
+		t.Fatal(sc.Err())
+	}
+	rec := sc.Record()
+	if rec.Minute != 10 || rec.Second() != 36 || len(rec.Sat) != 4 {
+		t.Errorf("Bad first record %v", rec)
+	}
+	if sc.Header().Interval != 18 || len(sc.Observations()[' ']) != 5 {
+		t.Errorf("Header was not parsed before the first record")
+	}
+
+	// The first record is still reported before a later error.
+	bad := strings.Replace(rinexV2Example, "23619095.450", "2361909x.450", 1)
+	sc = NewObsScanner(strings.NewReader(bad))
+	count := 0
+	for sc.Next() {
+		count++
+	}
+	if count != 2 || sc.Err() == nil {
+		t.Errorf("Expected two records and an error, got %d and %v",
+			count, sc.Err())
+	}
+}
@@ -0,0 +1,421 @@
+package rinex
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// HeaderLine is one RINEX header line, split the same way that
+// ObsReader.HeaderFunc receives it: Value holds columns 1-60 and Label
+// holds columns 61-80.  Either may be shorter than its column range;
+// the writer pads them with spaces.
+type HeaderLine struct {
+	Label, Value string
+}
+
+// ObsWriter writes RINEX observation data in either the RINEX 2.11 or
+// the RINEX 3.04 format.  The caller first writes the header with
+// WriteHeader, and then writes each observation record with
+// WriteRecord or WriteEvent.  Output is buffered; Flush must be called
+// after the last record.
+type ObsWriter struct {
+	// Version selects the output format: 2 for RINEX 2.11, or 3 for
+	// RINEX 3.04.
+	Version int
+
+	// System is the satellite system character for the RINEX VERSION
+	// / TYPE header: 'G', 'R', 'E', etc., or 'M' for mixed data.  If
+	// it is zero, 'M' is used.
+	System byte
+
+	// Observations lists the types of observations for each GNSS, in
+	// the same form as ObsReader.Observations.  RINEX 2 output uses
+	// the list with map index ' ' for every satellite; RINEX 3 output
+	// writes one SYS / # / OBS TYPES header per map entry.
+	Observations map[byte][][3]byte
+
+	// w receives the formatted output.
+	w *bufio.Writer
+
+	// lineBuf holds the line currently being formatted.
+	lineBuf []byte
+}
+
+// NewObsWriter creates an ObsWriter that writes to w.  The caller must
+// set Version and Observations before calling WriteHeader.
+func NewObsWriter(w io.Writer) *ObsWriter {
+	return &ObsWriter{
+		w:       bufio.NewWriter(w),
+		lineBuf: make([]byte, 0, 80),
+	}
+}
+
+/************************ TOP LEVEL FUNCTIONS ************************/
+
+// WriteHeader writes a complete RINEX header.  The RINEX VERSION /
+// TYPE line and the observation type lines are generated from
+// ow.Version, ow.System and ow.Observations; lines that use those labels
+// (or END OF HEADER) are skipped, so header lines collected from an
+// ObsReader can be passed through unchanged.  The other lines are
+// written in the order given, followed by END OF HEADER.
+func (ow *ObsWriter) WriteHeader(lines []HeaderLine) error {
+	if ow.Version != 2 && ow.Version != 3 {
+		return fmt.Errorf("Invalid RINEX version %d", ow.Version)
+	}
+
+	system := ow.System
+	if system == 0 {
+		system = 'M'
+	}
+	version := "2.11"
+	if ow.Version == 3 {
+		version = "3.04"
+	}
+	ow.writeHeaderLine("RINEX VERSION / TYPE", fmt.Sprintf("%9s%11s%-20s%c",
+		version, "", "OBSERVATION DATA", system))
+
+	for _, line := range lines {
+		if generatedHeaders[strings.TrimSpace(line.Label)] {
+			continue
+		}
+		ow.writeHeaderLine(line.Label, line.Value)
+	}
+
+	var err error
+	if ow.Version == 2 {
+		err = ow.writeNumTypesOfObserv()
+	} else {
+		err = ow.writeSysNumObsTypes()
+	}
+	if err != nil {
+		return err
+	}
+
+	ow.writeHeaderLine("END OF HEADER", "")
+	return nil
+}
+
+// WriteRecord writes an observation record.  rec.EpochFlag must be 0,
+// 1 or 6; special events use WriteEvent instead.  Each SVObservation
+// in rec.Sat must have no more observations than ow.Observations lists
+// for its GNSS.  If rec cannot be written, WriteRecord returns an
+// error without writing any of it.
+func (ow *ObsWriter) WriteRecord(rec ObservationRecord) error {
+	switch rec.EpochFlag {
+	case 0, 1, 6:
+	default:
+		return fmt.Errorf("Epoch flag %d requires WriteEvent", rec.EpochFlag)
+	}
+	if err := ow.checkRecord(rec); err != nil {
+		return err
+	}
+
+	if ow.Version == 2 {
+		ow.writeV2Record(rec)
+	} else {
+		ow.writeV3Record(rec)
+	}
+	return nil
+}
+
+// WriteEvent writes a special event record: rec.EpochFlag must be
+// between 2 and 5.  lines holds the header lines (often just COMMENT
+// lines) that follow the event.  If rec.Year is zero, the epoch fields
+// are left blank.
+func (ow *ObsWriter) WriteEvent(rec ObservationRecord, lines []HeaderLine) error {
+	if rec.EpochFlag < 2 || rec.EpochFlag > 5 {
+		return fmt.Errorf("Epoch flag %d is not a special event", rec.EpochFlag)
+	}
+	if len(lines) > 999 {
+		return errors.New("Too many header lines for one event")
+	}
+
+	if ow.Version == 2 {
+		ow.formatV2Epoch(rec, len(lines))
+	} else {
+		ow.formatV3Epoch(rec, len(lines))
+	}
+	ow.writeLine()
+
+	for _, line := range lines {
+		ow.writeHeaderLine(line.Label, line.Value)
+	}
+
+	return nil
+}
+
+// Flush writes any buffered data to the underlying io.Writer.
+func (ow *ObsWriter) Flush() error {
+	return ow.w.Flush()
+}
+
+/************************** HELPER FUNCTIONS **************************/
+
+// writeLine writes ow.lineBuf, without trailing blanks, as one line.
+func (ow *ObsWriter) writeLine() {
+	line := ow.lineBuf
+	for len(line) > 0 && line[len(line)-1] == ' ' {
+		line = line[:len(line)-1]
+	}
+	ow.w.Write(line)
+	ow.w.WriteByte('\n')
+	ow.lineBuf = ow.lineBuf[:0]
+}
+
+// writeHeaderLine writes a header line, padding value to 60 columns
+// and label to 20 columns.
+func (ow *ObsWriter) writeHeaderLine(label, value string) {
+	ow.lineBuf = append(ow.lineBuf[:0], value...)
+	for len(ow.lineBuf) < 60 {
+		ow.lineBuf = append(ow.lineBuf, ' ')
+	}
+	ow.lineBuf = append(ow.lineBuf[:60], label...)
+	for len(ow.lineBuf) < 80 {
+		ow.lineBuf = append(ow.lineBuf, ' ')
+	}
+	ow.writeLine()
+}
+
+// appendObservation appends one F14.3,I1,I1 observation field to
+// ow.lineBuf.  A zero Value is written as a blank field unless Present
+// is set, and a zero LLI is written as a blank unless LLIPresent is
+// set.  A zero SignalStrength is written as a blank.
+func (ow *ObsWriter) appendObservation(o Observation) {
+	if o.Value == 0 && !o.Present {
+		ow.lineBuf = append(ow.lineBuf, "              "...)
+	} else {
+		ow.lineBuf = append(ow.lineBuf, fmt.Sprintf("%14.3f", o.Value)...)
+	}
+	ow.lineBuf = append(ow.lineBuf, lliChar(o), flagChar(o.SignalStrength))
+}
+
+// lliChar converts the LLI of o to its character.
+func lliChar(o Observation) byte {
+	if o.LLIPresent {
+		return '0' + o.LLI%10
+	}
+	return flagChar(o.LLI)
+}
+
+// flagChar converts an LLI or signal strength value to its character.
+func flagChar(v byte) byte {
+	if v == 0 {
+		return ' '
+	}
+	return '0' + v%10
+}
+
+// appendClockOffset appends an optional receiver clock offset field.
+func (ow *ObsWriter) appendClockOffset(offset float64, width, prec int) {
+	ow.lineBuf = append(ow.lineBuf, fmt.Sprintf("%*.*f", width, prec, offset)...)
+}
+
+// obsTypes returns the list of observation types for satellite prn.
+func (ow *ObsWriter) obsTypes(prn [3]byte) ([][3]byte, error) {
+	if ow.Version == 2 {
+		obsList, ok := ow.Observations[' ']
+		if !ok {
+			return nil, errors.New("RINEX 2 output requires Observations[' ']")
+		}
+		return obsList, nil
+	}
+	obsList, ok := ow.Observations[prn[0]]
+	if !ok {
+		return nil, fmt.Errorf("No observation types for %s", prn[:])
+	}
+	return obsList, nil
+}
+
+// checkRecord checks that every part of rec fits the output format:
+// the satellite count, the receiver clock offset, and each satellite's
+// observation types and values.  The writers call it before writing
+// anything, so that an error never leaves a partial record behind.
+func (ow *ObsWriter) checkRecord(rec ObservationRecord) error {
+	if len(rec.Sat) > 999 {
+		return errors.New("Too many satellites for one epoch")
+	}
+	if rec.Offset != 0 {
+		width, prec := 12, 9
+		if ow.Version == 3 {
+			width, prec = 15, 12
+		}
+		if _, err := fixedPoint(rec.Offset, width, prec); err != nil {
+			return fmt.Errorf("Receiver clock offset %g is too large", rec.Offset)
+		}
+	}
+
+	for _, sv := range rec.Sat {
+		obsList, err := ow.obsTypes(sv.PRN)
+		if err != nil {
+			return err
+		}
+		if len(sv.Obs) > len(obsList) {
+			return fmt.Errorf("%s has %d observations, expected %d",
+				sv.PRN[:], len(sv.Obs), len(obsList))
+		}
+		for _, o := range sv.Obs {
+			if o.Value == 0 && !o.Present {
+				continue
+			}
+			if _, err := fixedPoint(o.Value, 14, 3); err != nil {
+				return fmt.Errorf("Observation value %.3f is too large", o.Value)
+			}
+		}
+	}
+
+	return nil
+}
+
+/************************* RINEX v2 FUNCTIONS *************************/
+
+// formatV2Epoch formats the first 32 columns of an EPOCH/SAT or EVENT
+// FLAG line into ow.lineBuf.
+func (ow *ObsWriter) formatV2Epoch(rec ObservationRecord, count int) {
+	if rec.Year == 0 {
+		ow.lineBuf = append(ow.lineBuf[:0], fmt.Sprintf("%28s%c%3d",
+			"", '0'+rec.EpochFlag, count)...)
+		return
+	}
+	ow.lineBuf = append(ow.lineBuf[:0], fmt.Sprintf(
+		" %02d %2d %2d %2d %2d%s  %c%3d", rec.Year%100, rec.Month,
+		rec.Day, rec.Hour, rec.Minute, formatSecond(rec.Nanoseconds), '0'+rec.EpochFlag,
+		count)...)
+}
+
+// writeV2Record writes a RINEX 2.11 observation record that
+// checkRecord has accepted.
+func (ow *ObsWriter) writeV2Record(rec ObservationRecord) {
+	obsList := ow.Observations[' ']
+
+	// Write the EPOCH/SAT line and any PRN continuation lines.
+	ow.formatV2Epoch(rec, len(rec.Sat))
+	for i, sv := range rec.Sat {
+		if i > 0 && i%12 == 0 {
+			ow.writeLine()
+			ow.lineBuf = append(ow.lineBuf, "                                "...)
+		}
+		ow.lineBuf = append(ow.lineBuf, sv.PRN[:]...)
+		if i == 11 && rec.Offset != 0 {
+			ow.appendClockOffset(rec.Offset, 12, 9)
+		}
+	}
+	if len(rec.Sat) < 12 && rec.Offset != 0 {
+		for len(ow.lineBuf) < 68 {
+			ow.lineBuf = append(ow.lineBuf, ' ')
+		}
+		ow.appendClockOffset(rec.Offset, 12, 9)
+	}
+	ow.writeLine()
+
+	// Write the observations for each satellite, five per line.
+	for _, sv := range rec.Sat {
+		for i := range obsList {
+			if i > 0 && i%5 == 0 {
+				ow.writeLine()
+			}
+			var o Observation
+			if i < len(sv.Obs) {
+				o = sv.Obs[i]
+			}
+			ow.appendObservation(o)
+		}
+		ow.writeLine()
+	}
+}
+
+/************************* RINEX v3 FUNCTIONS *************************/
+
+// formatV3Epoch formats the first 35 columns of an EPOCH record into
+// ow.lineBuf.
+func (ow *ObsWriter) formatV3Epoch(rec ObservationRecord, count int) {
+	if rec.Year == 0 {
+		ow.lineBuf = append(ow.lineBuf[:0], fmt.Sprintf(">%30s%c%3d",
+			"", '0'+rec.EpochFlag, count)...)
+		return
+	}
+	ow.lineBuf = append(ow.lineBuf[:0], fmt.Sprintf(
+		"> %04d %02d %02d %02d %02d%s  %c%3d", rec.Year, rec.Month,
+		rec.Day, rec.Hour, rec.Minute, formatSecond(rec.Nanoseconds), '0'+rec.EpochFlag,
+		count)...)
+}
+
+// writeV3Record writes a RINEX 3.04 observation record that
+// checkRecord has accepted.
+func (ow *ObsWriter) writeV3Record(rec ObservationRecord) {
+	ow.formatV3Epoch(rec, len(rec.Sat))
+	if rec.Offset != 0 {
+		ow.lineBuf = append(ow.lineBuf, "      "...)
+		ow.appendClockOffset(rec.Offset, 15, 12)
+	}
+	ow.writeLine()
+
+	for _, sv := range rec.Sat {
+		ow.lineBuf = append(ow.lineBuf, sv.PRN[:]...)
+		for _, o := range sv.Obs {
+			ow.appendObservation(o)
+		}
+		ow.writeLine()
+	}
+}
+
+/********************** HEADER WRITING FUNCTIONS **********************/
+
+// generatedHeaders lists the header labels that WriteHeader generates
+// itself, and so skips when the caller provides them.
+var generatedHeaders = map[string]bool{
+	"RINEX VERSION / TYPE": true,
+	"# / TYPES OF OBSERV":  true,
+	"SYS / # / OBS TYPES":  true,
+	"END OF HEADER":        true,
+}
+
+// writeNumTypesOfObserv writes the RINEX 2 # / TYPES OF OBSERV lines.
+func (ow *ObsWriter) writeNumTypesOfObserv() error {
+	obsList, ok := ow.Observations[' ']
+	if !ok {
+		return errors.New("RINEX 2 output requires Observations[' ']")
+	}
+
+	value := fmt.Sprintf("%6d", len(obsList))
+	for i, obs := range obsList {
+		if i > 0 && i%9 == 0 {
+			ow.writeHeaderLine("# / TYPES OF OBSERV", value)
+			value = "      "
+		}
+		value += "    " + string(obs[:2])
+	}
+	ow.writeHeaderLine("# / TYPES OF OBSERV", value)
+
+	return nil
+}
+
+// writeSysNumObsTypes writes the RINEX 3 SYS / # / OBS TYPES lines.
+func (ow *ObsWriter) writeSysNumObsTypes() error {
+	systems := make([]byte, 0, len(ow.Observations))
+	for system := range ow.Observations {
+		if system == ' ' {
+			return errors.New("RINEX 3 output requires per-GNSS Observations")
+		}
+		systems = append(systems, system)
+	}
+	sort.Slice(systems, func(i, j int) bool { return systems[i] < systems[j] })
+
+	for _, system := range systems {
+		obsList := ow.Observations[system]
+		value := fmt.Sprintf("%c  %3d", system, len(obsList))
+		for i, obs := range obsList {
+			if i > 0 && i%13 == 0 {
+				ow.writeHeaderLine("SYS / # / OBS TYPES", value)
+				value = "      "
+			}
+			value += " " + string(obs[:])
+		}
+		ow.writeHeaderLine("SYS / # / OBS TYPES", value)
+	}
+
+	return nil
+}
@@ -0,0 +1,286 @@
+package rinex
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// event is a special event record with the header lines that follow it.
+type event struct {
+	rec   ObservationRecord
+	lines []HeaderLine
+}
+
+// collector gathers everything that an ObsReader reports.
+type collector struct {
+	header  []HeaderLine
+	records []ObservationRecord
+	events  []*event
+	last    *event
+	done    bool
+}
+
+func (c *collector) onHeader(label, value string) error {
+	line := HeaderLine{Label: label, Value: value}
+	if c.last != nil {
+		c.last.lines = append(c.last.lines, line)
+	} else if !c.done {
+		c.header = append(c.header, line)
+	}
+	if strings.TrimSpace(label) == "END OF HEADER" {
+		c.done = true
+	}
+	return nil
+}
+
+func (c *collector) onObs(rec ObservationRecord) error {
+	// The reader reuses rec.Sat and its Obs slices, so copy them.
+	sat := make([]SVObservation, len(rec.Sat))
+	for i, sv := range rec.Sat {
+		sat[i].PRN = sv.PRN
+		sat[i].Obs = append([]Observation(nil), sv.Obs...)
+	}
+	rec.Sat = sat
+
+	c.last = nil
+	if rec.EpochFlag >= 2 && rec.EpochFlag <= 5 {
+		c.last = &event{rec: rec}
+		c.events = append(c.events, c.last)
+	}
+	c.records = append(c.records, rec)
+	return nil
+}
+
+func collect(text string) (*collector, *ObsReader, error) {
+	c := &collector{}
+	or := &ObsReader{HeaderFunc: c.onHeader, ObsFunc: c.onObs}
+	err := or.Parse(strings.NewReader(text))
+	return c, or, err
+}
+
+// recordWriter is implemented by ObsWriter and HatanakaWriter.
+type recordWriter interface {
+	WriteHeader(lines []HeaderLine) error
+	WriteRecord(rec ObservationRecord) error
+	WriteEvent(rec ObservationRecord, lines []HeaderLine) error
+	Flush() error
+}
+
+// writeAll writes everything that c collected to w.
+func writeAll(w recordWriter, c *collector) error {
+	if err := w.WriteHeader(c.header); err != nil {
+		return err
+	}
+	events := c.events
+	for _, rec := range c.records {
+		var err error
+		if rec.EpochFlag >= 2 && rec.EpochFlag <= 5 {
+			err = w.WriteEvent(rec, events[0].lines)
+			events = events[1:]
+		} else {
+			err = w.WriteRecord(rec)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func rewrite(c *collector, or *ObsReader, version int) (string, error) {
+	bb := &bytes.Buffer{}
+	ow := NewObsWriter(bb)
+	ow.Version = version
+	ow.Observations = or.Observations
+	if err := writeAll(ow, c); err != nil {
+		return "", err
+	}
+	return bb.String(), nil
+}
+
+func testRoundTrip(t *testing.T, text string, version, records int) {
+	c1, or1, err := collect(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c1.records) != records {
+		t.Fatalf("Read %d records, expected %d", len(c1.records), records)
+	}
+	out, err := rewrite(c1, or1, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, or2, err := collect(out)
+	if err != nil {
+		t.Fatalf("%s\n%s", err, out)
+	}
+
+	if !reflect.DeepEqual(or1.Observations, or2.Observations) {
+		t.Errorf("Observations mismatch: %v vs %v", or1.Observations, or2.Observations)
+	}
+	if len(c1.records) != len(c2.records) {
+		t.Fatalf("Record count mismatch: %d vs %d", len(c1.records), len(c2.records))
+	}
+	for i := range c1.records {
+		if !reflect.DeepEqual(c1.records[i], c2.records[i]) {
+			t.Errorf("Record %d mismatch:\n%v\n%v", i, c1.records[i], c2.records[i])
+		}
+	}
+	for i := range c1.events {
+		if !reflect.DeepEqual(c1.events[i].lines, c2.events[i].lines) {
+			t.Errorf("Event %d header mismatch", i)
+		}
+	}
+}
+
+func TestObsWriterRoundTripV2(t *testing.T) {
+	testRoundTrip(t, rinexV2Example, 2, 15)
+}
+
+func TestObsWriterRoundTripV3(t *testing.T) {
+	testRoundTrip(t, rinexV3Example, 3, 1)
+}
+
+func TestObsWriterFormat(t *testing.T) {
+	rec := ObservationRecord{
+		Year: 2019, Month: 1, Day: 10, Hour: 0, Minute: 0, Nanoseconds: 30e9,
+		Offset: -0.000123456789,
+		Sat: []SVObservation{{
+			PRN: [3]byte{'G', '0', '5'},
+			Obs: []Observation{
+				{Value: 21557855.617, SignalStrength: 8},
+				{Value: 113287291.649, LLI: 1, SignalStrength: 8},
+				{},
+			},
+		}},
+	}
+
+	expected := map[int]string{
+		2: `     2.11           OBSERVATION DATA    G                   RINEX VERSION / TYPE
+     3    C1    L1    S1                                    # / TYPES OF OBSERV
+                                                            END OF HEADER
+ 19  1 10  0  0 30.0000000  0  1G05                                 -0.000123457
+  21557855.617 8 113287291.64918
+`,
+		3: `     3.04           OBSERVATION DATA    G                   RINEX VERSION / TYPE
+G    3 C1C L1C S1C                                          SYS / # / OBS TYPES
+                                                            END OF HEADER
+> 2019 01 10 00 00 30.0000000  0  1      -0.000123456789
+G05  21557855.617 8 113287291.64918
+`,
+	}
+	obs := map[int]map[byte][][3]byte{
+		2: {' ': {{'C', '1', ' '}, {'L', '1', ' '}, {'S', '1', ' '}}},
+		3: {'G': {{'C', '1', 'C'}, {'L', '1', 'C'}, {'S', '1', 'C'}}},
+	}
+
+	for version, text := range expected {
+		bb := &bytes.Buffer{}
+		ow := NewObsWriter(bb)
+		ow.Version = version
+		ow.System = 'G'
+		ow.Observations = obs[version]
+		if err := ow.WriteHeader(nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := ow.WriteRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+		if err := ow.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if bb.String() != text {
+			t.Errorf("RINEX %d output mismatch:\n%s", version,
+				fmt.Sprintf("%q\n%q", bb.String(), text))
+		}
+	}
+}
+
+func TestObsWriterRecordError(t *testing.T) {
+	good := SVObservation{
+		PRN: [3]byte{'G', '0', '5'},
+		Obs: []Observation{{Value: 21557855.617}},
+	}
+	bad := []SVObservation{
+		{PRN: [3]byte{'G', '0', '7'}, Obs: []Observation{{Value: 1e12}}},
+		{PRN: [3]byte{'G', '0', '7'}, Obs: make([]Observation, 4)},
+		{PRN: [3]byte{'E', '1', '1'}, Obs: []Observation{{Value: 1}}},
+	}
+	obs := map[int]map[byte][][3]byte{
+		2: {' ': {{'C', '1', ' '}, {'L', '1', ' '}, {'S', '1', ' '}}},
+		3: {'G': {{'C', '1', 'C'}, {'L', '1', 'C'}, {'S', '1', 'C'}}},
+	}
+
+	for version := 2; version <= 3; version++ {
+		for i, sv := range bad {
+			if version == 2 && sv.PRN[0] != 'G' {
+				continue
+			}
+			// Put the bad satellite after enough good ones that
+			// RINEX 2 needs a PRN continuation line.
+			rec := ObservationRecord{Year: 2019, Month: 1, Day: 10, Nanoseconds: 30e9}
+			for j := 0; j < 12; j++ {
+				rec.Sat = append(rec.Sat, good)
+			}
+			rec.Sat = append(rec.Sat, sv)
+
+			bb := &bytes.Buffer{}
+			ow := NewObsWriter(bb)
+			ow.Version = version
+			ow.Observations = obs[version]
+			if err := ow.WriteHeader(nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := ow.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			header := bb.String()
+
+			if err := ow.WriteRecord(rec); err == nil {
+				t.Errorf("RINEX %d case %d: expected an error", version, i)
+			}
+			if err := ow.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if bb.String() != header {
+				t.Errorf("RINEX %d case %d: failed record wrote %q", version, i,
+					bb.String()[len(header):])
+			}
+		}
+	}
+}
+
+// rinexZeroExample has a genuine zero observation, a blank one and an
+// explicit zero LLI.
+const rinexZeroExample = `     3.04           OBSERVATION DATA    G                   RINEX VERSION / TYPE
+G    3 C1C L1C D1C                                          SYS / # / OBS TYPES
+  2019     1    10     0     0    0.0000000     GPS         TIME OF FIRST OBS
+                                                            END OF HEADER
+> 2019 01 10 00 00 30.0000000  0  2
+G05  21557855.617 8 113287291.6490          0.000
+G07  23456789.012 7                         1.250 5
+`
+
+func TestObservationPresence(t *testing.T) {
+	c, _, err := collect(rinexZeroExample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.records) != 1 {
+		t.Fatalf("Got %d records", len(c.records))
+	}
+	g05 := c.records[0].Sat[0].Obs
+	g07 := c.records[0].Sat[1].Obs
+	if !g05[1].LLIPresent || g05[1].LLI != 0 || g05[0].LLIPresent {
+		t.Errorf("Bad LLI presence for G05: %+v", g05)
+	}
+	if !g05[2].Present || g05[2].Value != 0 || g07[1].Present || !g07[2].Present {
+		t.Errorf("Bad observation presence: %+v %+v", g05, g07)
+	}
+
+	testRoundTrip(t, rinexZeroExample, 3, 1)
+	testHatanakaRoundTrip(t, rinexZeroExample, 3)
+}
@@ -0,0 +1,165 @@
+package rinex
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// Compression identifies a general-purpose compression format.
+type Compression int
+
+const (
+	// Uncompressed means that no compression was found.
+	Uncompressed Compression = iota
+
+	// Gzip is the gzip format (.gz).
+	Gzip
+
+	// Bzip2 is the bzip2 format (.bz2).
+	Bzip2
+
+	// UnixCompress is the format of the Unix compress program (.Z).
+	UnixCompress
+
+	// Zip is a zip archive (.zip).
+	Zip
+)
+
+// String returns the usual name of c.
+func (c Compression) String() string {
+	switch c {
+	case Uncompressed:
+		return "uncompressed"
+	case Gzip:
+		return "gzip"
+	case Bzip2:
+		return "bzip2"
+	case UnixCompress:
+		return "compress"
+	case Zip:
+		return "zip"
+	}
+	return "unknown"
+}
+
+// Format describes the layers of encoding that Open found in a file.
+type Format struct {
+	// Compression is the general-purpose compression of the file.
+	Compression Compression
+
+	// Hatanaka is true if the file holds Compact RINEX data.
+	Hatanaka bool
+}
+
+// String returns a description of f, such as "gzip+crinex".
+func (f Format) String() string {
+	s := f.Compression.String()
+	if f.Hatanaka {
+		if f.Compression == Uncompressed {
+			return "crinex"
+		}
+		s += "+crinex"
+	}
+	return s
+}
+
+// readCloser closes a stack of readers when the outermost one is
+// closed.
+type readCloser struct {
+	io.Reader
+
+	// closers lists the readers to close, innermost first.
+	closers []io.Closer
+}
+
+// Close closes each of the underlying readers, and returns the first
+// error.
+func (rc *readCloser) Close() error {
+	var err error
+	for i := len(rc.closers) - 1; i >= 0; i-- {
+		if err2 := rc.closers[i].Close(); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// Open opens the named file and returns a reader for its RINEX text.
+// It detects gzip, bzip2, Unix compress and zip compression from the
+// file's contents rather than its name, and expands Compact RINEX
+// (Hatanaka-compressed) data.  For a zip archive, it reads the first
+// file in the archive.  The caller must close the returned reader.
+func Open(path string) (io.ReadCloser, Format, error) {
+	var format Format
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, format, err
+	}
+	rc := &readCloser{closers: []io.Closer{f}}
+
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(4)
+	var r io.Reader = br
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		format.Compression = Gzip
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(br); err == nil {
+			r = gz
+			rc.closers = append(rc.closers, gz)
+		}
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		format.Compression = Bzip2
+		r = bzip2.NewReader(br)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x9d}):
+		format.Compression = UnixCompress
+		r, err = newLZWReader(br)
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		format.Compression = Zip
+		var member io.ReadCloser
+		if member, err = openZipMember(f); err == nil {
+			r = member
+			rc.closers = append(rc.closers, member)
+		}
+	}
+	if err != nil {
+		rc.Close()
+		return nil, format, err
+	}
+
+	// Is there a Compact RINEX layer?
+	br = bufio.NewReader(r)
+	head, _ := br.Peek(80)
+	if isCRINEX(head) {
+		format.Hatanaka = true
+		rc.Reader = NewHatanakaReader(br)
+	} else {
+		rc.Reader = br
+	}
+	return rc, format, nil
+}
+
+// openZipMember opens the first regular file in the zip archive f.
+func openZipMember(f *os.File) (io.ReadCloser, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	for _, zf := range zr.File {
+		if !strings.HasSuffix(zf.Name, "/") {
+			return zf.Open()
+		}
+	}
+	return nil, errors.New("Zip archive has no files")
+}
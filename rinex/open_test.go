@@ -0,0 +1,155 @@
+package rinex
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// compressV2Example is rinexV2Example as written by compress with a
+// 10-bit code limit, so that it has both code width changes and clear
+// codes.
+const compressV2Example = `H52KIAIGlOEiRgyBCBE+ETKliBQrQagkeeIEBJGIQRA2AYGiSRIsRYikSEhSShInRbCAsOJw
+ysSKL0BQyQKliAIhTII4WQLiiRQQR0D0AAplCouAP4ceYUIxyBSjAYsIBRqESRImRZ4cBbFx
+qEeQRBAOedKkSREnVBRgwWISJZYnK3O4yEESRJAkVYTU3QtCBo0WTYJIaQHjYAwaOmjMSAjl
+yMaYUqpUFJIFRMyLVGymDNIECtaeRuxy/RgSRNuUIIxclYrCCVwjRSJWkVJkCuiVfQvGGEly
+bNmzaTPmgIEDBt/jyJMnDyxliUMQTjjbHF68oIsZNJRr386XufOfTqo0EeLw5lUmIKZ4pILk
+eBAhQ0CcnCKRSpXM3JEvbPjwecwgR5w1RBZqxSADdkEYuNhxa+UHghYQ5kdbfCNYJhNNUsXE
+khRTKHBgdtxlUZmDJOpEBQgVxjRTTQoEpNgNNchwAw0u1FUDDjPaYEONCdFw43A51MAjiXZB
+AYUUT6gExRMuSUQRCFhkoUWLe80FwwzGVQnDllnW5QKXXW5n4lnR6WBREUxQkRESLxTxghNU
+CnQQQnMSaWdCVwTBElZOHMFeakEMcSITMbwgQ5wB1TlQQjYIdMRhjsZQQ6SNBvToDZHiECld
+eOqJ5ll+tmdEoIMWemhCYd6pakJSDGEFCEMwNQRPTxhhhG1BGMkEoiBMKhAUihKKEBMyIARF
+sQIx4eudKV5Yk2219sSQQ1YgGgMOX2656rbyoUVtVVTKsOWyAS04EIiJmgtCDF3OsCOYXOYn
+kVm3qcbhifvxyu2+UTkRVrRIxEZEeSDA4Otifq27GLsguJstvAFlScOjMhwBQw4W21CEQdu2
+UNCHNdhwAw45hOuuDDlgd8NckiZ3nbaa7uXxDDWoe6ANKLtLo6Q4hEtcDpJi54INNMTMl8cG
+GsdpXTPfiJC4JEtKQwwuoHxqXzDYAMMNWsNQEMnIeayY0sddlwO6WGvNtcEu4FC0vkRel7DR
+/CJX0Mgg3NCiweX2lV0MCxtXg9fwagsCiDTwqmhfCSELFAhLU1w3CHnuCeqfowoKAqGGUqnC
+55R7ymeogArqE6xI6BRgWEacXuwUEaFpVWa2fa7CXr6ZhZa+rl0BgqawZ8bE7LWBkDpLlKOX
++YkH6RSWDCFwlztwcE+u6vS7F3yw3wqvKziNhWeZpQ0UW4xx1lLIEEP6Mmy8uHZIgywyySbb
+EAOQQvqYKggt1IyjkAABAAAAAAAAAAA2ZsgAgQOEQRAtaMTIgSOGixk3ahwEIWOGjYUxYshw
+AQMGDgUGZXjEYQNGRBc2bNyYaLCFDJI4cHCUsTLHRJcVa9BwUWOGxIMiY5bEsTEGjBggKcK4
+aPTGRhs4bLIEEYMojRsubkQFIfVgDBoyFma9AWOiSKYmZSqEkTTsDBo1VMqsccPGVKoV4fJU
+CaJG27B542bdOjWwjcE2/d5dPNWGToEuNM7oy7iy5bs0hDqsQRLEDZAwfk6WQYPq5IwGYXDs
+yLosRYNIL6uYDcKIlCdNQDh5cgXEEycgliRxUqRJECpJhoAgcjxICBCzVVwGMQR3kyJOqIAW
+TbF0jNMxQGRe3dq1axpHYthIL+MIjBzu7U633CKyYa052mY0miOHw4jysaReRjm4wFBZK02k
+UVyq1WCUWfu9x5ALNCjUlk8xgbXTDAyx5FIMNYTWIF0gBNiCehHd8N9bZmGIAw0c8kRWWx7Z
+wJBWWeXg2kEnfocDDDNEhsNKBfEo1A1Y5SBDgEqRxNCLBs7wUUhLhWUDjFnRdNcNEOWAFQ02
+DJTgQXSFWENkSJpVpXo0FEiTDUnNd9lk4ikQBFce7SjnnnweZJwUSxQhhW5BXKeAjj9G9lBp
+fTY63Z+BDupEFU0IIWicUxkIpJ4skcfpRJ72GUR22DkRhA7LFcEEFXci8UIRLziB6VQttOAD
+CFQgkcQUIOyKKxJFgDAFq1JQ4ZsRINxJXG9TJEFFsDzUall1TVyX3XYGjebdaQOV6ClrqRmE
+nnrsxedeV462ZN9bcd2oX0YycFaglIyyRJqDZw65EpNU+QfDRnVNRmdY8NaQw2FVfRaST1rJ
+8NJDUnq4JEmkuZCXZzyKlMMMMWD1UmmM5tRwhQ8xRGOYNYQl00h3KcmZQyKtNKZBTn3l0It2
+hVxlyhXlqFiTV/ZUoI108mgzDpy5gINFBBltlNAU+kQZ0HDplQOM2IKgrWlcU2Uxu3IZ9BNb
+6WIG26wHoTAqCEVYgZ2xRjARxBEgXOEsEsImcYQTSRiR3KjGFgHFE0MgkcJ81FqrHQihZdsd
+11/h9W1H4YqX3noanRtf2TyuC1dJGr1L2sFnflf0RFHV4OBD/6rHko1IDr2kTiHB26ZTFkdE
+w4UhOoU7RN1mrNFFCIeIcUvqxXXDTvhNnZNJL6kVFo1E/WjUQ7TfNMP2RKn23mQmLnmUWBxK
+9FNQLx2FlUb5UenYeyTrGDxQNCFZ+gwIKohkZlg5Fp7OAAEAAAAAAAAAADZqwMgh0MUMGzMU
+gFjIsKHDhxBpLIyhECJDFRhBBHFCJckUKE+eGEni5AiIJ05AmIxhQ6MTIiCYPJlCJeaTIUss
+MhzypEmTIhwVwqixcAYIGRJjGI2RVIYLGFCjwljY8ugRlkcGVtT5MIaMGTRq2LiBI8fThy1y
+4LgRkMYMFzW2cp0LAsbZuS3sxqXLl6HEo3IhthjsA8SQLEOYFAExhUkSKFNAUEESpCZlK4uF
+FAGqEQoUx0VgUnkCkadPoFQCz528+ImQKUWkWKmcBGXkvnxN/wxalygIo0hBKBUukQaOp1Kh
+LpwKgsZVG1dlZM2RtSpuiy1ceAUr9iuNijJieMVBnob2Gjm6xoDB9C1TiTcc4qiBQ0YN7fPD
+Lgw/vqzdG2x9tx9YaykVw1P6NdTCDDHgQANCLsiQQ3zxLdQCUjjEUINTN8hQ31/eFfigdjTk
+AB4MGZJFg3k3uOXQgmsh5VQNN8QAgnULstfiDRFKaN9+KMZAVg0HziCDDCcGNF8MZpXlW0My
+jEXfW2OlCCWKAvEYpXdAKrnhfTjYYOJ1dP2VkE4YqcDQFJUVwYRjVCxmUnoLTeYRCEWANAQS
+J6V0BRNGBDFETTaiwIQMKSykG2qqXZSRSS3JRJNNOLkkGhJ35nkTnyiB8Geggx4FgqGIKtrT
+bqldh8ITns2URJySkQaFFEVYUVsVkcFGRUclTZEoXYtyBA==`
+
+// bzip2V1Example is crinexV1Example compressed by bzip2.
+const bzip2V1Example = `QlpoOTFBWSZTWRG6IT8AALb/gGy2SABJY//wP+fdYAoAFQAwAZrZptoamSn6SepsKYmgNAAN
+GhoNBqZNGkaIAMgaDIADQBoBEyCJ6E2ppkNAaGgaaMbQRGDA0DeFzZcfn4d8LOurLWNx9rzW
+6QABDdQxZvJkkSMTYU0XkcQPzFj0J7GkvZAwHSPWxRBTe8pp1sZVAHXNdSxyCXI1IteFCqqF
+eJkbD5+TPGVPTK9ysNijdkgO68tZgg1FdU5qioemXQgz88YAMtJsoVQfqc2RtQMmdCQ/Y3Mh
+JMNlswotisyo0iW6GMbA3lIcfR5qAwLPe87KhMRxD5JKYkrvsxoGwrOrCEerA28tyYCKNcq0
+jVtqLRilc0kAYgYF50Ad3uqEgPMom1j5GY0PYIRrwcGL3uCVcazLIxnm9XJQ7IHwsWBhFxAk
+75pYBzynkAbE4BJEIgoIw+D2VW0V6xytZT2o7u7dJaAuEBCoJbqxTzjcGvAoU0EUaZ1xsAad
+c2MJ7tcWpQGoPsyTgwp315FLhRJilfHTAtMRya9szBRtxkg+E9SAhfAPLLSqF11zf4u5Ipwo
+SAjdEJ+A`
+
+// decodeBase64 decodes a base64 string that may contain line breaks.
+func decodeBase64(t *testing.T, text string) []byte {
+	b, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(text, "\n", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// testOpen writes data to a file, opens it, and checks the format and
+// text that Open returns.
+func testOpen(t *testing.T, data []byte, format Format, expected string) {
+	path := filepath.Join(t.TempDir(), "test")
+	if err := os.WriteFile(path, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	rc, f, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if f != format {
+		t.Errorf("Expected format %s, got %s", format, f)
+	}
+	text, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(text) != expected {
+		t.Errorf("Wrong text for %s:\n%s", format, text)
+	}
+}
+
+func TestOpenPlain(t *testing.T) {
+	testOpen(t, []byte(rinexV2Example), Format{}, rinexV2Example)
+	testOpen(t, []byte(crinexV3Example), Format{Hatanaka: true}, crinexV3Expanded)
+}
+
+func TestOpenGzip(t *testing.T) {
+	bb := &bytes.Buffer{}
+	gz := gzip.NewWriter(bb)
+	gz.Write([]byte(crinexV3Example))
+	gz.Close()
+	testOpen(t, bb.Bytes(), Format{Compression: Gzip, Hatanaka: true},
+		crinexV3Expanded)
+}
+
+func TestOpenBzip2(t *testing.T) {
+	testOpen(t, decodeBase64(t, bzip2V1Example),
+		Format{Compression: Bzip2, Hatanaka: true}, crinexV1Expanded)
+}
+
+func TestOpenCompress(t *testing.T) {
+	testOpen(t, decodeBase64(t, compressV2Example),
+		Format{Compression: UnixCompress}, rinexV2Example)
+}
+
+func TestOpenZip(t *testing.T) {
+	bb := &bytes.Buffer{}
+	zw := zip.NewWriter(bb)
+	zw.Create("data/")
+	w, _ := zw.Create("data/example.rnx")
+	w.Write([]byte(rinexV3Example))
+	zw.Close()
+	testOpen(t, bb.Bytes(), Format{Compression: Zip}, rinexV3Example)
+}
+
+func TestOpenErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test")
+	data := decodeBase64(t, compressV2Example)
+	data[2] = 0x9f // unsupported code width
+	if err := os.WriteFile(path, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Open(path); err == nil {
+		t.Errorf("Expected an error for a bad compress header")
+	}
+	if _, _, err := Open(path + ".missing"); err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+}
@@ -0,0 +1,249 @@
+package rinex
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// System identifies a GNSS by the letter that RINEX uses for it.
+type System byte
+
+// These are the GNSSes that RINEX 3.04 lists in Figure 1.
+const (
+	GPS     System = 'G'
+	GLONASS System = 'R'
+	Galileo System = 'E'
+	BeiDou  System = 'C'
+	QZSS    System = 'J'
+	IRNSS   System = 'I'
+	SBAS    System = 'S'
+
+	// Mixed is used in the RINEX VERSION / TYPE header of files that
+	// have data from more than one GNSS.  It is not a satellite's
+	// system.
+	Mixed System = 'M'
+)
+
+// Systems lists the GNSSes that a SatID may belong to.
+var Systems = []System{GPS, GLONASS, Galileo, BeiDou, QZSS, IRNSS, SBAS}
+
+// SatID identifies a satellite as in RINEX: a system letter followed by
+// a two-digit number, such as "G05".  RINEX 2 allows a blank system
+// letter for GPS, and a blank in place of a leading zero.  ParseSatID
+// normalizes both; ObsReader replaces a blank system letter with 'G',
+// but keeps a blank tens digit as it found it.
+type SatID [3]byte
+
+// ObsCode is a RINEX observation code: a type letter, a band digit and
+// (except in RINEX 2) an attribute letter, such as "C1C" or "L2".
+// RINEX 2 codes have a NUL third byte.
+type ObsCode [3]byte
+
+// speedOfLight is the speed of light in vacuum (m/s).
+const speedOfLight = 299792458.0
+
+// bandFrequencies maps from a GNSS and RINEX band to the carrier
+// frequency (Hz).  GLONASS FDMA frequencies depend on the channel, so
+// they are handled separately.
+var bandFrequencies = map[System]map[byte]float64{
+	GPS:     {'1': 1575.42e6, '2': 1227.60e6, '5': 1176.45e6},
+	GLONASS: {'3': 1202.025e6, '4': 1600.995e6, '6': 1248.06e6},
+	Galileo: {'1': 1575.42e6, '5': 1176.45e6, '6': 1278.75e6, '7': 1207.14e6,
+		'8': 1191.795e6},
+	BeiDou: {'1': 1575.42e6, '2': 1561.098e6, '5': 1176.45e6, '6': 1268.52e6,
+		'7': 1207.14e6, '8': 1191.795e6},
+	QZSS:  {'1': 1575.42e6, '2': 1227.60e6, '5': 1176.45e6, '6': 1278.75e6},
+	IRNSS: {'5': 1176.45e6, '9': 2492.028e6},
+	SBAS:  {'1': 1575.42e6, '5': 1176.45e6},
+}
+
+/************************ TOP LEVEL FUNCTIONS ************************/
+
+// String returns the name of s, such as "GPS" or "Galileo".
+func (s System) String() string {
+	switch s {
+	case GPS:
+		return "GPS"
+	case GLONASS:
+		return "GLONASS"
+	case Galileo:
+		return "Galileo"
+	case BeiDou:
+		return "BeiDou"
+	case QZSS:
+		return "QZSS"
+	case IRNSS:
+		return "IRNSS"
+	case SBAS:
+		return "SBAS"
+	case Mixed:
+		return "Mixed"
+	}
+	return "System(" + strconv.QuoteRune(rune(s)) + ")"
+}
+
+// NewSatID returns the SatID for satellite number n of sys.
+func NewSatID(sys System, n int) SatID {
+	return SatID{byte(sys), byte('0' + n/10%10), byte('0' + n%10)}
+}
+
+// ParseSatID parses a satellite ID such as "G05", "G 5" or " 5", and
+// returns it in the normal form "G05".  A blank system letter, or a
+// bare number, means GPS.
+func ParseSatID(text string) (SatID, error) {
+	text = strings.TrimRight(text, " ")
+	sys := GPS
+	num := text
+	if len(text) == 3 {
+		if text[0] != ' ' {
+			sys = System(text[0])
+		}
+		num = text[1:]
+	}
+	n, err := strconv.ParseUint(strings.TrimLeft(num, " "), 10, 8)
+	if err != nil || len(num) > 2 || n < 1 || !sys.valid() {
+		return SatID{}, errors.New("Invalid satellite ID " + strconv.Quote(text))
+	}
+	return NewSatID(sys, int(n)), nil
+}
+
+// System returns the GNSS of id.  A blank system letter means GPS.
+func (id SatID) System() System {
+	if id[0] == ' ' {
+		return GPS
+	}
+	return System(id[0])
+}
+
+// Number returns the satellite number of id, such as 5 for "G05", or
+// -1 if id does not have a valid number.
+func (id SatID) Number() int {
+	tens := id[1]
+	if tens == ' ' {
+		tens = '0'
+	}
+	if tens < '0' || tens > '9' || id[2] < '0' || id[2] > '9' {
+		return -1
+	}
+	return int(tens-'0')*10 + int(id[2]-'0')
+}
+
+// String returns id as text.
+func (id SatID) String() string {
+	return string(id[:])
+}
+
+// ParseObsCode parses a two-character (RINEX 2) or three-character
+// (RINEX 3 and later) observation code.  Surrounding blanks are
+// ignored.
+func ParseObsCode(text string) (ObsCode, error) {
+	text = strings.TrimSpace(text)
+	if len(text) < 2 || len(text) > 3 || text[1] < '0' || text[1] > '9' {
+		return ObsCode{}, errors.New("Invalid observation code " + strconv.Quote(text))
+	}
+	var code ObsCode
+	copy(code[:], text)
+	return code, nil
+}
+
+// Type returns the observation type letter of c: 'C' for code, 'L'
+// for phase, 'D' for Doppler, 'S' for signal strength, or (in RINEX 2)
+// 'P' for P-code pseudorange.
+func (c ObsCode) Type() byte {
+	return c[0]
+}
+
+// Band returns the band or frequency digit of c, such as '1'.
+func (c ObsCode) Band() byte {
+	return c[1]
+}
+
+// Attribute returns the attribute letter of c, such as 'C' for the
+// C/A code, or 0 for a RINEX 2 code.
+func (c ObsCode) Attribute() byte {
+	return c[2]
+}
+
+// String returns c as text, without any NUL byte.
+func (c ObsCode) String() string {
+	if c[2] == 0 {
+		return string(c[:2])
+	}
+	return string(c[:])
+}
+
+// Frequency returns the carrier frequency (Hz) of band for a satellite
+// of sys, or 0 if it is not known.  channel is the frequency number of
+// a GLONASS satellite, which is needed for its FDMA bands 1 and 2.
+func Frequency(sys System, band byte, channel int) float64 {
+	if sys == GLONASS {
+		switch band {
+		case '1':
+			return 1602e6 + float64(channel)*562.5e3
+		case '2':
+			return 1246e6 + float64(channel)*437.5e3
+		}
+	}
+	return bandFrequencies[sys][band]
+}
+
+// Frequency returns the carrier frequency (Hz) of the band of code for
+// sat, or 0 if it is not known.  For the GLONASS FDMA bands, it finds
+// the satellite's frequency number in GLONASSSlots.
+func (h *ObsHeader) Frequency(sat SatID, code ObsCode) float64 {
+	sys := sat.System()
+	band := code.Band()
+	channel := 0
+	if sys == GLONASS && (band == '1' || band == '2') {
+		var ok bool
+		if channel, ok = h.GLONASSSlots[NewSatID(sys, sat.Number())]; !ok {
+			return 0
+		}
+	}
+	return Frequency(sys, band, channel)
+}
+
+// Wavelength returns the carrier wavelength (m) of the band of code
+// for sat, or 0 if it is not known.
+func (h *ObsHeader) Wavelength(sat SatID, code ObsCode) float64 {
+	if f := h.Frequency(sat, code); f > 0 {
+		return speedOfLight / f
+	}
+	return 0
+}
+
+// ObsCodes returns the observation codes for sat's GNSS from
+// observations, which is laid out as ObsReader.Observations.  For
+// RINEX 2 data, whose codes are stored under ' ', it returns those,
+// with the NUL attribute of ObsCode in place of the reader's blank.
+// The result does not share memory with observations.
+func ObsCodes(observations map[byte][][3]byte, sat SatID) []ObsCode {
+	raw, ok := observations[sat[0]]
+	if !ok {
+		raw = observations[' ']
+	}
+	if raw == nil {
+		return nil
+	}
+	codes := make([]ObsCode, len(raw))
+	for i, c := range raw {
+		codes[i] = ObsCode(c)
+		if codes[i][2] == ' ' {
+			codes[i][2] = 0
+		}
+	}
+	return codes
+}
+
+/************************** HELPER FUNCTIONS **************************/
+
+// valid returns true if s is one of Systems.
+func (s System) valid() bool {
+	for _, sys := range Systems {
+		if s == sys {
+			return true
+		}
+	}
+	return false
+}
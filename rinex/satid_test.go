@@ -0,0 +1,99 @@
+package rinex
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSatID(t *testing.T) {
+	for text, want := range map[string]SatID{
+		"G05": {'G', '0', '5'},
+		"G 5": {'G', '0', '5'},
+		" 5":  {'G', '0', '5'},
+		" 12": {'G', '1', '2'},
+		"R24": {'R', '2', '4'},
+		"E36": {'E', '3', '6'},
+	} {
+		id, err := ParseSatID(text)
+		if err != nil || id != want {
+			t.Errorf("ParseSatID(%q) = %v, %v", text, id, err)
+		}
+	}
+	for _, text := range []string{"", "X05", "G00", "G5", "G123", "G+5"} {
+		if id, err := ParseSatID(text); err == nil {
+			t.Errorf("ParseSatID(%q) = %v", text, id)
+		}
+	}
+
+	id := SatID{' ', ' ', '7'}
+	if id.System() != GPS || id.Number() != 7 || id.String() != "  7" {
+		t.Errorf("Bad RINEX 2 satellite ID %v", id)
+	}
+	if id = NewSatID(BeiDou, 46); id.String() != "C46" || id.System() != BeiDou {
+		t.Errorf("NewSatID gave %v", id)
+	}
+	if (SatID{'G', 'x', '1'}).Number() != -1 {
+		t.Errorf("Bad number for invalid ID")
+	}
+	if GLONASS.String() != "GLONASS" || System('X').String() != "System('X')" {
+		t.Errorf("Bad system names")
+	}
+}
+
+func TestObsCode(t *testing.T) {
+	code, err := ParseObsCode("C1C")
+	if err != nil || code.Type() != 'C' || code.Band() != '1' || code.Attribute() != 'C' {
+		t.Errorf("ParseObsCode(C1C) = %v, %v", code, err)
+	}
+	code, err = ParseObsCode("  L2")
+	if err != nil || code != (ObsCode{'L', '2', 0}) || code.String() != "L2" {
+		t.Errorf("ParseObsCode(L2) = %v, %v", code, err)
+	}
+	for _, text := range []string{"", "C", "CXC", "C1CX"} {
+		if _, err = ParseObsCode(text); err == nil {
+			t.Errorf("ParseObsCode(%q) succeeded", text)
+		}
+	}
+
+	h := ObsHeader{GLONASSSlots: map[[3]byte]int{{'R', '0', '1'}: -2}}
+	tests := []struct {
+		sat  string
+		code string
+		freq float64
+	}{
+		{"G05", "L1C", 1575.42e6},
+		{"  5", "L2", 1227.60e6},
+		{"E11", "C7Q", 1207.14e6},
+		{"C19", "L2I", 1561.098e6},
+		{"R01", "L1C", 1602e6 - 2*562.5e3},
+		{"R 1", "L2P", 1246e6 - 2*437.5e3},
+		{"R02", "L1C", 0},
+		{"R02", "L3Q", 1202.025e6},
+		{"G05", "L7Q", 0},
+	}
+	for _, test := range tests {
+		var sat SatID
+		copy(sat[:], test.sat)
+		code, err = ParseObsCode(test.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f := h.Frequency(sat, code); f != test.freq {
+			t.Errorf("%s %s: frequency %v, want %v", test.sat, test.code, f, test.freq)
+		}
+		wl := h.Wavelength(sat, code)
+		if (test.freq == 0 && wl != 0) ||
+			(test.freq != 0 && math.Abs(wl*test.freq-speedOfLight) > 1e-6) {
+			t.Errorf("%s %s: wavelength %v", test.sat, test.code, wl)
+		}
+	}
+
+	observations := map[byte][][3]byte{' ': {{'C', '1', ' '}}, 'E': {{'C', '1', 'X'}}}
+	if codes := ObsCodes(observations, SatID{'G', '0', '5'}); len(codes) != 1 ||
+		codes[0] != (ObsCode{'C', '1', 0}) || codes[0].String() != "C1" {
+		t.Errorf("ObsCodes(G05) = %v", codes)
+	}
+	if codes := ObsCodes(observations, SatID{'E', '0', '5'}); len(codes) != 1 || codes[0].Attribute() != 'X' {
+		t.Errorf("ObsCodes(E05) = %v", codes)
+	}
+}
@@ -0,0 +1,424 @@
+package rtcm3
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/entrope/gnss/gnsstime"
+	"github.com/entrope/gnss/rinex"
+)
+
+// speedOfLight is the speed of light in vacuum (m/s).
+const speedOfLight = 299792458.0
+
+// rangeMs is the distance that light travels in one millisecond (m).
+const rangeMs = speedOfLight / 1000
+
+// msmSystems maps from the first three digits of an MSM message number
+// to the GNSS it describes.
+var msmSystems = map[int]byte{
+	107: 'G',
+	108: 'R',
+	109: 'E',
+	111: 'J',
+	112: 'C',
+}
+
+// msmSignals maps from a GNSS to the RINEX band and attribute of each
+// MSM signal ID, from RTCM 10403.3 tables 3.5-91 and following.
+var msmSignals = map[byte]map[int]string{
+	'G': {
+		2: "1C", 3: "1P", 4: "1W", 8: "2C", 9: "2P", 10: "2W",
+		15: "2S", 16: "2L", 17: "2X", 22: "5I", 23: "5Q", 24: "5X",
+		30: "1S", 31: "1L", 32: "1X",
+	},
+	'R': {
+		2: "1C", 3: "1P", 8: "2C", 9: "2P",
+	},
+	'E': {
+		2: "1C", 3: "1A", 4: "1B", 5: "1X", 6: "1Z", 8: "6C", 9: "6A",
+		10: "6B", 11: "6X", 12: "6Z", 14: "7I", 15: "7Q", 16: "7X",
+		18: "8I", 19: "8Q", 20: "8X", 22: "5I", 23: "5Q", 24: "5X",
+	},
+	'J': {
+		2: "1C", 9: "6S", 10: "6L", 11: "6X", 15: "2S", 16: "2L",
+		17: "2X", 22: "5I", 23: "5Q", 24: "5X", 30: "1S", 31: "1L",
+		32: "1X",
+	},
+	'C': {
+		2: "2I", 3: "2Q", 4: "2X", 8: "6I", 9: "6Q", 10: "6X", 14: "7I",
+		15: "7Q", 16: "7X", 22: "5D", 23: "5P", 24: "5X", 25: "7D",
+		30: "1D", 31: "1P", 32: "1X",
+	},
+}
+
+// msmFormat describes the field sizes of one level of MSM message.
+type msmFormat struct {
+	// prBits and prScale give the size and scale (ms) of the fine
+	// pseudorange field.
+	prBits  int
+	prScale float64
+
+	// phBits and phScale give the size and scale (ms) of the fine
+	// phase range field.
+	phBits  int
+	phScale float64
+
+	// lockBits is the size of the lock time indicator.
+	lockBits int
+
+	// cnrBits and cnrScale give the size and scale (dB-Hz) of the
+	// CNR field.
+	cnrBits  int
+	cnrScale float64
+
+	// rates is true if the message has phase range rates.
+	rates bool
+}
+
+// msmFormats maps from an MSM level to its format.
+var msmFormats = map[int]msmFormat{
+	4: {15, 0x1p-24, 22, 0x1p-29, 4, 6, 1, false},
+	5: {15, 0x1p-24, 22, 0x1p-29, 4, 6, 1, true},
+	7: {20, 0x1p-29, 24, 0x1p-31, 10, 10, 0x1p-4, true},
+}
+
+// lockKey identifies one signal from one satellite.
+type lockKey struct {
+	prn  [3]byte
+	code [2]byte
+}
+
+// cellObs is one observation for a pending satellite.
+type cellObs struct {
+	obsType [3]byte
+	obs     rinex.Observation
+}
+
+// epoch holds the observations that have been decoded for one epoch.
+type epoch struct {
+	// t is the epoch's time, in GPS time.
+	t time.Time
+
+	// sats maps from satellite ID to its observations.
+	sats map[[3]byte][]cellObs
+}
+
+// lockTime converts a lock time indicator to the minimum lock time
+// (ms), following RTCM 10403.3 tables 3.5-74 and 3.5-75.
+func lockTime(indicator uint64, bits int) int64 {
+	i := int64(indicator)
+	if bits == 4 {
+		if i == 0 {
+			return 0
+		}
+		return 16 << i
+	}
+	if i < 64 {
+		return i
+	} else if i > 704 {
+		i = 704
+	}
+	k := (i-64)/32 + 1
+	return 1<<(k+5) + (i-32*(k+1))<<k
+}
+
+// signalStrength maps a CNR (dB-Hz) to a RINEX signal strength
+// indicator.
+func signalStrength(cnr float64) byte {
+	ssi := int(cnr / 6)
+	if ssi < 1 {
+		ssi = 1
+	} else if ssi > 9 {
+		ssi = 9
+	}
+	return byte(ssi)
+}
+
+/**************************** EPOCH TIMES ****************************/
+
+// nearest returns whichever of t-period, t and t+period is nearest to
+// ref.
+func nearest(t, ref time.Time, period time.Duration) time.Time {
+	if diff := t.Sub(ref); diff > period/2 {
+		return t.Add(-period)
+	} else if diff < -period/2 {
+		return t.Add(period)
+	}
+	return t
+}
+
+// epochTime converts the epoch time field of an MSM message for sys to
+// GPS time.
+func (d *Decoder) epochTime(sys byte, field uint64) (time.Time, error) {
+	switch sys {
+	case 'R':
+		// GLONASS gives the day of week and the time of day in Moscow
+		// time (UTC + 3 hours).  Ignore the day, which may be unknown.
+		tod := time.Duration(field&(1<<27-1)) * time.Millisecond
+		if tod >= 24*time.Hour {
+			return time.Time{}, errors.New("Invalid GLONASS epoch time")
+		}
+		leap := time.Duration(d.LeapSeconds) * time.Second
+		if leap == 0 {
+			leap = gnsstime.GPSMinusUTC(gnsstime.UTC.FromGPS(d.ref))
+		}
+		offset := 3*time.Hour - leap
+		local := d.ref.Add(offset)
+		t := local.Truncate(24 * time.Hour).Add(tod)
+		return nearest(t, local, 24*time.Hour).Add(-offset), nil
+
+	case 'C':
+		tow := time.Duration(field) * time.Millisecond
+		if tow >= gnsstime.Week {
+			return time.Time{}, errors.New("Invalid BeiDou epoch time")
+		}
+		ref := gnsstime.BDT.FromGPS(d.ref)
+		return gnsstime.BDT.ToGPS(gnsstime.BDT.ResolveTOW(tow, ref)), nil
+	}
+
+	tow := time.Duration(field) * time.Millisecond
+	if tow >= gnsstime.Week {
+		return time.Time{}, errors.New("Invalid epoch time")
+	}
+	return gnsstime.GPS.ResolveTOW(tow, d.ref), nil
+}
+
+/**************************** MSM DECODING ****************************/
+
+// decodeMSM decodes an MSM4, MSM5 or MSM7 message for sys.
+func (d *Decoder) decodeMSM(br *bitReader, sys byte, level int) error {
+	format := msmFormats[level]
+	br.uint(12)
+	d.StationID = int(br.uint(12))
+	epochField := br.uint(30)
+	multiple := br.uint(1) == 1
+	br.uint(3 + 7 + 2 + 2 + 1 + 3) // IODS through smoothing interval
+	satMask := br.uint(64)
+	sigMask := br.uint(32)
+
+	var sats, sigs []int
+	for i := 0; i < 64; i++ {
+		if satMask&(1<<(63-i)) != 0 {
+			sats = append(sats, i+1)
+		}
+	}
+	for i := 0; i < 32; i++ {
+		if sigMask&(1<<(31-i)) != 0 {
+			sigs = append(sigs, i+1)
+		}
+	}
+	if len(sats)*len(sigs) > 64 {
+		return errors.New("MSM cell mask is too large")
+	}
+	var cells [][2]int
+	for i := range sats {
+		for j := range sigs {
+			if br.uint(1) == 1 {
+				cells = append(cells, [2]int{i, j})
+			}
+		}
+	}
+
+	// Satellite data.
+	nSat := len(sats)
+	rough := make([]float64, nSat)
+	extInfo := make([]uint64, nSat)
+	roughRate := make([]float64, nSat)
+	for i := range rough {
+		if ms := br.uint(8); ms == 255 {
+			rough[i] = math.NaN()
+		} else {
+			rough[i] = float64(ms)
+		}
+	}
+	if format.rates {
+		for i := range extInfo {
+			extInfo[i] = br.uint(4)
+		}
+	}
+	for i := range rough {
+		rough[i] += float64(br.uint(10)) / 1024
+	}
+	if format.rates {
+		for i := range roughRate {
+			if rate := br.int(14); rate == -1<<13 {
+				roughRate[i] = math.NaN()
+			} else {
+				roughRate[i] = float64(rate)
+			}
+		}
+	}
+
+	// Signal data.
+	nCell := len(cells)
+	pr := make([]float64, nCell)
+	ph := make([]float64, nCell)
+	lock := make([]uint64, nCell)
+	half := make([]uint64, nCell)
+	cnr := make([]float64, nCell)
+	rate := make([]float64, nCell)
+	readFine(br, pr, format.prBits, format.prScale)
+	readFine(br, ph, format.phBits, format.phScale)
+	for i := range lock {
+		lock[i] = br.uint(format.lockBits)
+	}
+	for i := range half {
+		half[i] = br.uint(1)
+	}
+	for i := range cnr {
+		cnr[i] = float64(br.uint(format.cnrBits)) * format.cnrScale
+	}
+	if format.rates {
+		readFine(br, rate, 15, 1e-4)
+	}
+	if br.short {
+		return errTruncated
+	}
+
+	t, err := d.epochTime(sys, epochField)
+	if err != nil {
+		return err
+	}
+	if d.pending.sats != nil && !d.pending.t.Equal(t) {
+		if err := d.flush(); err != nil {
+			return err
+		}
+	}
+	if d.pending.sats == nil {
+		d.pending = epoch{t: t, sats: make(map[[3]byte][]cellObs)}
+	}
+
+	// GLONASS MSM5 and MSM7 give the frequency channel.
+	if sys == 'R' && format.rates {
+		for i, n := range sats {
+			if extInfo[i] <= 13 {
+				d.Header.GLONASSSlots[satID(sys, n)] = int(extInfo[i]) - 7
+			}
+		}
+	}
+
+	for k, cell := range cells {
+		code, ok := msmSignals[sys][sigs[cell[1]]]
+		if !ok {
+			continue
+		}
+		prn := satID(sys, sats[cell[0]])
+		wl := d.Header.Wavelength(prn, rinex.ObsCode{'L', code[0], code[1]})
+		key := lockKey{prn: prn, code: [2]byte{code[0], code[1]}}
+		lockMs := lockTime(lock[k], format.lockBits)
+		var lli byte
+		if prev, ok := d.locks[key]; ok && lockMs < prev {
+			lli |= 1
+		}
+		if half[k] == 1 {
+			lli |= 2
+		}
+		d.locks[key] = lockMs
+		ssi := byte(0)
+		if cnr[k] > 0 {
+			ssi = signalStrength(cnr[k])
+		}
+
+		base := rough[cell[0]]
+		obs := d.pending.sats[prn]
+		if v := (base + pr[k]) * rangeMs; !math.IsNaN(v) {
+			obs = d.addObs(obs, sys, 'C', code, v, 0, ssi)
+		}
+		if v := (base + ph[k]) * rangeMs; !math.IsNaN(v) && wl > 0 {
+			obs = d.addObs(obs, sys, 'L', code, v/wl, lli, ssi)
+		}
+		if format.rates {
+			if v := roughRate[cell[0]] + rate[k]; !math.IsNaN(v) && wl > 0 {
+				obs = d.addObs(obs, sys, 'D', code, -v/wl, 0, 0)
+			}
+		}
+		if cnr[k] > 0 {
+			obs = d.addObs(obs, sys, 'S', code, cnr[k], 0, 0)
+		}
+		d.pending.sats[prn] = obs
+	}
+
+	d.ref = t
+	if !multiple {
+		return d.flush()
+	}
+	return nil
+}
+
+// readFine reads one signed fine field per cell into values, scaled by
+// scale, with NaN for the invalid value.
+func readFine(br *bitReader, values []float64, bits int, scale float64) {
+	invalid := int64(-1) << (bits - 1)
+	for i := range values {
+		if v := br.int(bits); v == invalid {
+			values[i] = math.NaN()
+		} else {
+			values[i] = float64(v) * scale
+		}
+	}
+}
+
+// satID returns the RINEX satellite ID for satellite n of sys.
+func satID(sys byte, n int) [3]byte {
+	return [3]byte{sys, byte('0' + n/10), byte('0' + n%10)}
+}
+
+// addObs appends an observation to obs, and registers its type for sys.
+func (d *Decoder) addObs(obs []cellObs, sys, kind byte, code string, value float64, lli, ssi byte) []cellObs {
+	obsType := [3]byte{kind, code[0], code[1]}
+	index := d.obsIndex[sys]
+	if index == nil {
+		index = make(map[[3]byte]int)
+		d.obsIndex[sys] = index
+	}
+	if _, ok := index[obsType]; !ok {
+		index[obsType] = len(d.Observations[sys])
+		d.Observations[sys] = append(d.Observations[sys], obsType)
+	}
+	return append(obs, cellObs{
+		obsType: obsType,
+		obs: rinex.Observation{
+			Value:          math.Round(value*1000) / 1000,
+			Present:        true,
+			LLI:            lli,
+			SignalStrength: ssi,
+		},
+	})
+}
+
+// flush reports the pending epoch, if there is one.
+func (d *Decoder) flush() error {
+	ep := d.pending
+	d.pending = epoch{}
+	if ep.sats == nil {
+		return nil
+	}
+
+	var rec rinex.ObservationRecord
+	rec.SetTime(ep.t)
+	for prn, cells := range ep.sats {
+		sv := rinex.SVObservation{
+			PRN: prn,
+			Obs: make([]rinex.Observation, len(d.Observations[prn[0]])),
+		}
+		for _, c := range cells {
+			sv.Obs[d.obsIndex[prn[0]][c.obsType]] = c.obs
+		}
+		rec.Sat = append(rec.Sat, sv)
+	}
+	sort.Slice(rec.Sat, func(i, j int) bool {
+		return string(rec.Sat[i].PRN[:]) < string(rec.Sat[j].PRN[:])
+	})
+
+	if d.Header.FirstObs.IsZero() {
+		d.Header.FirstObs = ep.t
+	}
+	d.Header.LastObs = ep.t
+	if d.ObsFunc != nil {
+		return d.ObsFunc(rec)
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+// Package rtcm3 decodes RTCM 3 streams into the same observation
+// records that rinex.ObsReader produces.
+//
+// It checks the framing and CRC-24Q of each message, and decodes the
+// MSM4, MSM5 and MSM7 observation messages for GPS, GLONASS, Galileo,
+// QZSS and BeiDou, and the 1005, 1006 and 1033 station description
+// messages.  Other messages are skipped.  Observation records are in
+// GPS time, whatever the GNSS of the messages.
+package rtcm3
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/entrope/gnss/rinex"
+)
+
+// preamble is the first byte of each RTCM 3 frame.
+const preamble = 0xD3
+
+// Decoder decodes RTCM 3 streams.
+type Decoder struct {
+	// ObsFunc is a function that is called for each observation record,
+	// after all the MSM messages for its epoch.  If it returns non-nil,
+	// decoding stops.
+	ObsFunc func(rec rinex.ObservationRecord) error
+
+	// StationFunc, if not nil, is called after each 1005, 1006 or 1033
+	// message has updated Header.  msgType is the message number.  If
+	// it returns non-nil, decoding stops.
+	StationFunc func(msgType int) error
+
+	// Header holds the station information from 1005, 1006 and 1033
+	// messages: the antenna reference point in ApproxPosition, the
+	// antenna height in AntennaDelta[0], and the antenna and receiver
+	// descriptions.  It also holds the GLONASS frequency channels
+	// (GLONASSSlots) from MSM5 and MSM7 messages; a caller may fill
+	// them in beforehand so that MSM4 GLONASS phases can be converted
+	// to cycles.
+	Header rinex.ObsHeader
+
+	// StationID is the reference station ID of the most recent
+	// message.
+	StationID int
+
+	// Observations lists the types of observations for each GNSS, as
+	// in rinex.ObsReader.  The decoder adds types as it sees new
+	// signals, so an observation record may have fewer observations
+	// for a satellite than its GNSS has types; the missing ones are
+	// not present.
+	Observations map[byte][][3]byte
+
+	// RefTime is an approximate time (within a few days) of the start
+	// of the stream, which is needed because MSM messages only give
+	// the time within a week or day.  If it is zero, Parse uses the
+	// current time.
+	RefTime time.Time
+
+	// LeapSeconds is the difference between GPS time and UTC, which is
+	// needed for GLONASS times.  If it is zero, Parse uses the number
+	// of leap seconds at the reference time.
+	LeapSeconds int
+
+	// ref is the approximate GPS time of the next message.
+	ref time.Time
+
+	// obsIndex maps from a GNSS and observation type to its index in
+	// Observations.
+	obsIndex map[byte]map[[3]byte]int
+
+	// locks holds the lock time (ms) of each signal in the previous
+	// epoch, to detect loss of lock.
+	locks map[lockKey]int64
+
+	// pending holds the epoch being assembled from MSM messages.
+	pending epoch
+
+	// payload holds the current message.
+	payload []byte
+}
+
+/************************ TOP LEVEL FUNCTIONS ************************/
+
+// Parse reads RTCM 3 messages from r and runs the callback functions
+// in d.  It skips bytes that are not part of a frame with a valid CRC,
+// so it ignores a truncated frame at the end of the input.
+func (d *Decoder) Parse(r io.Reader) error {
+	d.Observations = make(map[byte][][3]byte)
+	d.obsIndex = make(map[byte]map[[3]byte]int)
+	d.locks = make(map[lockKey]int64)
+	d.pending = epoch{}
+	d.ref = d.RefTime
+	if d.ref.IsZero() {
+		d.ref = time.Now().UTC()
+	}
+	d.Header.TimeSystem = "GPS"
+	d.Header.SignalStrengthUnit = "DBHZ"
+	if d.Header.GLONASSSlots == nil {
+		d.Header.GLONASSSlots = make(map[[3]byte]int)
+	}
+
+	br := bufio.NewReaderSize(r, 4096)
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return d.flush()
+		} else if err != nil {
+			return err
+		}
+		if b != preamble {
+			continue
+		}
+
+		// The six bits after the preamble are reserved, and zero.
+		head, err := br.Peek(2)
+		if err == io.EOF {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if head[0]&0xFC != 0 {
+			continue
+		}
+		n := int(head[0]&3)<<8 | int(head[1])
+		frame, err := br.Peek(2 + n + 3)
+		if err == io.EOF {
+			continue
+		} else if err != nil {
+			return err
+		}
+		crc := crc24q(crc24q(0, []byte{preamble}), frame[:2+n])
+		if crc != uint32(frame[2+n])<<16|uint32(frame[3+n])<<8|uint32(frame[4+n]) {
+			continue
+		}
+
+		d.payload = append(d.payload[:0], frame[2:2+n]...)
+		br.Discard(2 + n + 3)
+		if err := d.handleMessage(d.payload); err != nil {
+			return err
+		}
+	}
+}
+
+// Detect returns true if head holds a complete RTCM 3 frame with a
+// valid CRC.
+func Detect(head []byte) bool {
+	for i := 0; i+6 <= len(head); i++ {
+		if head[i] != preamble || head[i+1]&0xFC != 0 {
+			continue
+		}
+		n := int(head[i+1]&3)<<8 | int(head[i+2])
+		if n == 0 || i+3+n+3 > len(head) {
+			continue
+		}
+		crc := crc24q(0, head[i:i+3+n])
+		if crc == uint32(head[i+3+n])<<16|uint32(head[i+4+n])<<8|uint32(head[i+5+n]) {
+			return true
+		}
+	}
+	return false
+}
+
+// crc24q extends crc with the CRC-24Q of data.
+func crc24q(crc uint32, data []byte) uint32 {
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= 0x1864CFB
+			}
+		}
+	}
+	return crc & 0xFFFFFF
+}
+
+// handleMessage decodes one message payload.
+func (d *Decoder) handleMessage(payload []byte) error {
+	br := &bitReader{buf: payload}
+	msgType := int(br.uint(12))
+	br.pos = 0
+
+	var err error
+	switch {
+	case msgType == 1005 || msgType == 1006:
+		err = d.decodeStation(br, msgType)
+	case msgType == 1033:
+		err = d.decodeDescriptors(br)
+	default:
+		sys, ok := msmSystems[msgType/10]
+		if !ok || msgType < 1000 {
+			return nil
+		}
+		switch level := msgType % 10; level {
+		case 4, 5, 7:
+			return d.decodeMSM(br, sys, level)
+		}
+		return nil
+	}
+	if err == nil && d.StationFunc != nil {
+		err = d.StationFunc(msgType)
+	}
+	return err
+}
+
+/************************** HELPER FUNCTIONS **************************/
+
+// bitReader reads big-endian bit fields from a message.
+type bitReader struct {
+	// buf is the message payload.
+	buf []byte
+
+	// pos is the index of the next bit to read.
+	pos int
+
+	// short is true if a read went past the end of buf.
+	short bool
+}
+
+// uint reads an n-bit unsigned field.  Bits past the end of the
+// message read as zero, and set br.short.
+func (br *bitReader) uint(n int) uint64 {
+	var v uint64
+	for i := 0; i < n; i++ {
+		v <<= 1
+		if br.pos/8 < len(br.buf) {
+			v |= uint64(br.buf[br.pos/8]>>(7-br.pos%8)) & 1
+		} else {
+			br.short = true
+		}
+		br.pos++
+	}
+	return v
+}
+
+// int reads an n-bit two's complement field.
+func (br *bitReader) int(n int) int64 {
+	return int64(br.uint(n)<<(64-n)) >> (64 - n)
+}
+
+// string reads n bytes as a string.
+func (br *bitReader) string(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(br.uint(8))
+	}
+	return string(b)
+}
+
+// errTruncated indicates that a message is shorter than its contents
+// require.
+var errTruncated = errors.New("Truncated RTCM message")
+
+/************************** STATION MESSAGES **************************/
+
+// decodeStation decodes a 1005 or 1006 message.
+func (d *Decoder) decodeStation(br *bitReader, msgType int) error {
+	br.uint(12)
+	d.StationID = int(br.uint(12))
+	br.uint(6 + 4) // ITRF realization year and system indicators
+	x := br.int(38)
+	br.uint(2)
+	y := br.int(38)
+	br.uint(2)
+	z := br.int(38)
+	var height uint64
+	if msgType == 1006 {
+		height = br.uint(16)
+	}
+	if br.short {
+		return errTruncated
+	}
+	d.Header.ApproxPosition = [3]float64{float64(x) * 1e-4, float64(y) * 1e-4,
+		float64(z) * 1e-4}
+	if msgType == 1006 {
+		d.Header.AntennaDelta[0] = float64(height) * 1e-4
+	}
+	return nil
+}
+
+// decodeDescriptors decodes a 1033 message.
+func (d *Decoder) decodeDescriptors(br *bitReader) error {
+	br.uint(12)
+	d.StationID = int(br.uint(12))
+	antType := br.string(int(br.uint(8)))
+	br.uint(8) // antenna setup ID
+	antSerial := br.string(int(br.uint(8)))
+	rxType := br.string(int(br.uint(8)))
+	rxVersion := br.string(int(br.uint(8)))
+	rxSerial := br.string(int(br.uint(8)))
+	if br.short {
+		return errTruncated
+	}
+	d.Header.AntennaType = antType
+	d.Header.AntennaNumber = antSerial
+	d.Header.ReceiverType = rxType
+	d.Header.ReceiverVersion = rxVersion
+	d.Header.ReceiverNumber = rxSerial
+	return nil
+}
@@ -0,0 +1,348 @@
+package rtcm3
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/entrope/gnss/rinex"
+)
+
+// bitWriter builds message payloads for tests.
+type bitWriter struct {
+	buf []byte
+	n   int
+}
+
+func (bw *bitWriter) put(bits int, v int64) {
+	for i := bits - 1; i >= 0; i-- {
+		if bw.n%8 == 0 {
+			bw.buf = append(bw.buf, 0)
+		}
+		if v>>i&1 == 1 {
+			bw.buf[bw.n/8] |= 0x80 >> (bw.n % 8)
+		}
+		bw.n++
+	}
+}
+
+func (bw *bitWriter) putString(s string) {
+	bw.put(8, int64(len(s)))
+	for i := 0; i < len(s); i++ {
+		bw.put(8, int64(s[i]))
+	}
+}
+
+// frame wraps payload in an RTCM 3 frame.
+func frame(payload []byte) []byte {
+	out := []byte{preamble, byte(len(payload) >> 8), byte(len(payload))}
+	out = append(out, payload...)
+	crc := crc24q(0, out)
+	return append(out, byte(crc>>16), byte(crc>>8), byte(crc))
+}
+
+type msmSat struct {
+	id           int
+	ms, mod, ext int64
+	roughRate    int64
+}
+
+type msmCell struct {
+	sat, sig                      int
+	pr, ph, lock, half, cnr, rate int64
+}
+
+// encodeMSM builds an MSM payload.  cells must be in satellite-major,
+// signal-minor order.
+func encodeMSM(msg int, epochField int64, multiple bool, sats []msmSat, sigs []int, cells []msmCell) []byte {
+	format := msmFormats[msg%10]
+	bw := &bitWriter{}
+	bw.put(12, int64(msg))
+	bw.put(12, 42)
+	bw.put(30, epochField)
+	if multiple {
+		bw.put(1, 1)
+	} else {
+		bw.put(1, 0)
+	}
+	bw.put(3+7+2+2+1+3, 0)
+	var satMask, sigMask int64
+	for _, s := range sats {
+		satMask |= 1 << (64 - s.id)
+	}
+	for _, s := range sigs {
+		sigMask |= 1 << (32 - s)
+	}
+	bw.put(64, satMask)
+	bw.put(32, sigMask)
+	k := 0
+	for _, sat := range sats {
+		for _, sig := range sigs {
+			if k < len(cells) && cells[k].sat == sat.id && cells[k].sig == sig {
+				bw.put(1, 1)
+				k++
+			} else {
+				bw.put(1, 0)
+			}
+		}
+	}
+	for _, s := range sats {
+		bw.put(8, s.ms)
+	}
+	if format.rates {
+		for _, s := range sats {
+			bw.put(4, s.ext)
+		}
+	}
+	for _, s := range sats {
+		bw.put(10, s.mod)
+	}
+	if format.rates {
+		for _, s := range sats {
+			bw.put(14, s.roughRate)
+		}
+	}
+	for _, c := range cells {
+		bw.put(format.prBits, c.pr)
+	}
+	for _, c := range cells {
+		bw.put(format.phBits, c.ph)
+	}
+	for _, c := range cells {
+		bw.put(format.lockBits, c.lock)
+	}
+	for _, c := range cells {
+		bw.put(1, c.half)
+	}
+	for _, c := range cells {
+		bw.put(format.cnrBits, c.cnr)
+	}
+	if format.rates {
+		for _, c := range cells {
+			bw.put(15, c.rate)
+		}
+	}
+	return bw.buf
+}
+
+func round(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+func TestCRC(t *testing.T) {
+	// The 1005 example message from RTCM 10403.
+	msg, _ := hex.DecodeString("d300133ed7d30202980edeef34b4bd62ac0941986f33360b98")
+	var d Decoder
+	var types []int
+	d.StationFunc = func(msgType int) error {
+		types = append(types, msgType)
+		return nil
+	}
+	if err := d.Parse(bytes.NewReader(msg)); err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 1 || types[0] != 1005 || d.StationID != 2003 {
+		t.Fatalf("got messages %v from station %d", types, d.StationID)
+	}
+	want := [3]float64{1114104.5999, -4850729.7108, 3975521.4643}
+	for i := range want {
+		if math.Abs(d.Header.ApproxPosition[i]-want[i]) > 1e-6 {
+			t.Errorf("ApproxPosition = %v, want %v", d.Header.ApproxPosition, want)
+		}
+	}
+
+	// Corrupt the message, so that it is skipped.
+	msg[10] ^= 1
+	types = nil
+	if err := d.Parse(bytes.NewReader(msg)); err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 0 {
+		t.Errorf("decoded corrupted message: %v", types)
+	}
+}
+
+func TestStation(t *testing.T) {
+	bw := &bitWriter{}
+	bw.put(12, 1006)
+	bw.put(12, 7)
+	bw.put(6+4, 0)
+	bw.put(38, 12345678901)
+	bw.put(2, 0)
+	bw.put(38, -23456789012)
+	bw.put(2, 0)
+	bw.put(38, 34567890123)
+	bw.put(16, 15000)
+	input := frame(bw.buf)
+
+	bw = &bitWriter{}
+	bw.put(12, 1033)
+	bw.put(12, 7)
+	bw.putString("TRM59800.00     SCIS")
+	bw.put(8, 0)
+	bw.putString("5000118529")
+	bw.putString("SEPT POLARX5")
+	bw.putString("5.4.0")
+	bw.putString("3047512")
+	input = append(input, frame(bw.buf)...)
+
+	var d Decoder
+	if err := d.Parse(bytes.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	h := d.Header
+	if h.ApproxPosition != [3]float64{1234567.8901, -2345678.9012, 3456789.0123} ||
+		h.AntennaDelta[0] != 1.5 {
+		t.Errorf("got position %v, height %v", h.ApproxPosition, h.AntennaDelta[0])
+	}
+	if h.AntennaType != "TRM59800.00     SCIS" || h.AntennaNumber != "5000118529" ||
+		h.ReceiverType != "SEPT POLARX5" || h.ReceiverVersion != "5.4.0" ||
+		h.ReceiverNumber != "3047512" {
+		t.Errorf("got descriptors %q %q %q %q %q", h.AntennaType, h.AntennaNumber,
+			h.ReceiverType, h.ReceiverVersion, h.ReceiverNumber)
+	}
+}
+
+func TestMSM(t *testing.T) {
+	// 2024-01-11 00:00:00 GPS time is 345600 s into GPS week 2296.
+	epoch0 := time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC)
+	gpsSats := []msmSat{
+		{id: 5, ms: 70, mod: 512, roughRate: -500},
+		{id: 12, ms: 80, mod: 256, roughRate: 300},
+	}
+	gpsCells := []msmCell{
+		{sat: 5, sig: 2, pr: 1000, ph: 2000, lock: 100, cnr: 720, rate: 1234},
+		{sat: 5, sig: 10, pr: -1000, ph: -2000, lock: 100, cnr: 560, rate: -1234},
+		{sat: 12, sig: 2, pr: 3000, ph: 4000, lock: 100, cnr: 640, rate: 0},
+	}
+	input := frame(encodeMSM(1077, 345600000, true, gpsSats, []int{2, 10}, gpsCells))
+
+	// GLONASS time of day is in Moscow time, and Thursday is day 4.
+	moscow := epoch0.Add(-18*time.Second + 3*time.Hour)
+	tod := int64(moscow.Sub(moscow.Truncate(24*time.Hour)) / time.Millisecond)
+	gloSats := []msmSat{{id: 7, ms: 65}}
+	gloCells := []msmCell{{sat: 7, sig: 2, pr: 100, ph: 200, lock: 5, half: 1, cnr: 40}}
+	input = append(input, frame(encodeMSM(1084, 4<<27|tod, false, gloSats, []int{2}, gloCells))...)
+
+	// A frame with a bad CRC, and some noise.
+	bad := frame(encodeMSM(1077, 345600500, false, gpsSats, []int{2, 10}, gpsCells))
+	bad[len(bad)-1] ^= 0x40
+	input = append(input, bad...)
+	input = append(input, 0xD3, 0x00, 0xD3, 0xFF)
+
+	// The next epoch has a shorter lock time for G05 L1C.
+	gpsCells[0].lock = 10
+	input = append(input, frame(encodeMSM(1077, 345601000, false, gpsSats, []int{2, 10}, gpsCells))...)
+
+	// A truncated frame at the end is ignored.
+	last := frame(encodeMSM(1077, 345602000, false, gpsSats, []int{2, 10}, gpsCells))
+	input = append(input, last[:len(last)-5]...)
+
+	d := Decoder{RefTime: time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)}
+	d.Header.GLONASSSlots = map[[3]byte]int{{'R', '0', '7'}: -2}
+	var recs []rinex.ObservationRecord
+	d.ObsFunc = func(rec rinex.ObservationRecord) error {
+		recs = append(recs, rec)
+		return nil
+	}
+	if err := d.Parse(bytes.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recs) != 2 {
+		t.Fatalf("got %d records, want 2", len(recs))
+	}
+	if tm := recs[0].Time(); !tm.Equal(epoch0) {
+		t.Errorf("first record at %v, want %v", tm, epoch0)
+	}
+	if tm := recs[1].Time(); !tm.Equal(epoch0.Add(time.Second)) {
+		t.Errorf("second record at %v", tm)
+	}
+	if d.StationID != 42 {
+		t.Errorf("StationID = %d", d.StationID)
+	}
+
+	wantTypes := map[byte]string{
+		'G': "C1C L1C D1C S1C C2W L2W D2W S2W ",
+		'R': "C1C L1C S1C ",
+	}
+	for sys, want := range wantTypes {
+		got := ""
+		for _, obsType := range d.Observations[sys] {
+			got += string(obsType[:]) + " "
+		}
+		if got != want {
+			t.Errorf("Observations[%c] = %q, want %q", sys, got, want)
+		}
+	}
+
+	sats := recs[0].Sat
+	if len(sats) != 3 || string(sats[0].PRN[:]) != "G05" ||
+		string(sats[1].PRN[:]) != "G12" || string(sats[2].PRN[:]) != "R07" {
+		t.Fatalf("got satellites %v", sats)
+	}
+
+	l1 := speedOfLight / 1575.42e6
+	l2 := speedOfLight / 1227.60e6
+	g05 := sats[0].Obs
+	want := []rinex.Observation{
+		{Value: round((70.5 + 1000*0x1p-29) * rangeMs), Present: true, SignalStrength: 7},
+		{Value: round((70.5 + 2000*0x1p-31) * rangeMs / l1), Present: true, SignalStrength: 7},
+		{Value: round((500 - 0.1234) / l1), Present: true},
+		{Value: 45, Present: true},
+		{Value: round((70.5 - 1000*0x1p-29) * rangeMs), Present: true, SignalStrength: 5},
+		{Value: round((70.5 - 2000*0x1p-31) * rangeMs / l2), Present: true, SignalStrength: 5},
+		{Value: round((500 + 0.1234) / l2), Present: true},
+		{Value: 35, Present: true},
+	}
+	for i := range want {
+		if g05[i] != want[i] {
+			t.Errorf("G05 obs %d = %+v, want %+v", i, g05[i], want[i])
+		}
+	}
+	if g12 := sats[1].Obs; g12[3].Value != 40 || g12[4].Value != 0 {
+		t.Errorf("G12 obs = %+v", g12)
+	}
+
+	lg := speedOfLight / (1602e6 - 2*562.5e3)
+	r07 := sats[2].Obs
+	wantR := []rinex.Observation{
+		{Value: round((65 + 100*0x1p-24) * rangeMs), Present: true, SignalStrength: 6},
+		{Value: round((65 + 200*0x1p-29) * rangeMs / lg), Present: true, LLI: 2, SignalStrength: 6},
+		{Value: 40, Present: true},
+	}
+	for i := range wantR {
+		if r07[i] != wantR[i] {
+			t.Errorf("R07 obs %d = %+v, want %+v", i, r07[i], wantR[i])
+		}
+	}
+
+	if lli := recs[1].Sat[0].Obs[1].LLI; lli != 1 {
+		t.Errorf("G05 L1C LLI after lock loss = %d, want 1", lli)
+	}
+	if lli := recs[1].Sat[0].Obs[5].LLI; lli != 0 {
+		t.Errorf("G05 L2W LLI = %d, want 0", lli)
+	}
+	if len(recs[1].Sat) != 2 {
+		t.Errorf("second record has %+v", recs[1].Sat)
+	}
+}
+
+func TestLockTime(t *testing.T) {
+	cases := []struct {
+		indicator uint64
+		bits      int
+		want      int64
+	}{
+		{0, 4, 0}, {1, 4, 32}, {15, 4, 524288},
+		{63, 10, 63}, {64, 10, 64}, {96, 10, 128}, {128, 10, 256},
+		{704, 10, 67108864},
+	}
+	for _, c := range cases {
+		if got := lockTime(c.indicator, c.bits); got != c.want {
+			t.Errorf("lockTime(%d, %d) = %d, want %d", c.indicator, c.bits, got, c.want)
+		}
+	}
+}
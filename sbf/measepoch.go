@@ -0,0 +1,285 @@
+package sbf
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/entrope/gnss/gnsstime"
+	"github.com/entrope/gnss/rinex"
+)
+
+// speedOfLight is the speed of light in vacuum (m/s).
+const speedOfLight = 299792458.0
+
+// Bits of the MeasEpoch ObsInfo field.
+const (
+	obsInfoHalfCycle = 1 << 2
+)
+
+// lockKey identifies one signal from one satellite.
+type lockKey struct {
+	prn  [3]byte
+	code [2]byte
+}
+
+// cellObs is one observation for a satellite in the current epoch.
+type cellObs struct {
+	obsType [3]byte
+	obs     rinex.Observation
+}
+
+// measurement holds the decoded values of one MeasEpoch signal.
+type measurement struct {
+	sig                int
+	pr, phase, doppler float64
+	cn0                float64
+	lockTime           int
+	obsInfo            byte
+	hasPR, hasL, hasD  bool
+}
+
+// signalNumber returns the signal number from a MeasEpoch Type field
+// and ObsInfo field.
+func signalNumber(typ, obsInfo byte) int {
+	if sig := int(typ & 0x1F); sig != 31 {
+		return sig
+	}
+	return 32 + int(obsInfo>>3)
+}
+
+// cn0Value converts a MeasEpoch CN0 field to dB-Hz, or 0 if it is not
+// available.
+func cn0Value(raw byte, sig int) float64 {
+	if raw == 255 {
+		return 0
+	}
+	if sig == 1 || sig == 2 {
+		return float64(raw) * 0.25
+	}
+	return float64(raw)*0.25 + 10
+}
+
+// wavelength returns the carrier wavelength (m) of sig from prn, or 0
+// if it is not known.
+func (d *Decoder) wavelength(prn [3]byte, sig int, obsInfo byte) float64 {
+	s, ok := signals[sig]
+	if !ok {
+		return 0
+	}
+	f := s.freq
+	if s.step != 0 {
+		k := int(obsInfo>>3) - 8
+		d.Header.GLONASSSlots[prn] = k
+		f += float64(k) * s.step
+	}
+	return speedOfLight / f
+}
+
+// decodeMeasEpoch decodes a MeasEpoch block.
+func (d *Decoder) decodeMeasEpoch(block []byte) error {
+	if len(block) < 20 {
+		return nil
+	}
+	tow := binary.LittleEndian.Uint32(block[8:])
+	wn := binary.LittleEndian.Uint16(block[12:])
+	if tow == math.MaxUint32 || wn == math.MaxUint16 {
+		return nil
+	}
+	n1 := int(block[14])
+	sb1Len := int(block[15])
+	sb2Len := int(block[16])
+	if sb1Len < 20 || sb2Len < 12 {
+		return nil
+	}
+	t := gnsstime.GPS.FromWeek(int(wn), time.Duration(tow)*time.Millisecond)
+
+	sats := make(map[[3]byte][]cellObs)
+	pos := 20
+	for i := 0; i < n1; i++ {
+		if pos+sb1Len > len(block) {
+			return nil
+		}
+		sb := block[pos : pos+sb1Len]
+		n2 := int(sb[19])
+		pos += sb1Len
+		if pos+n2*sb2Len > len(block) {
+			return nil
+		}
+		prn, ok := satID(sb[2])
+		if !ok || sb[1]>>5 != 0 {
+			pos += n2 * sb2Len
+			continue
+		}
+
+		// The Type1 sub-block has the first signal in full.
+		obsInfo := sb[18]
+		m := measurement{
+			sig:      signalNumber(sb[1], obsInfo),
+			lockTime: int(binary.LittleEndian.Uint16(sb[16:])),
+			obsInfo:  obsInfo,
+		}
+		m.cn0 = cn0Value(sb[15], m.sig)
+		codeMSB := uint64(sb[3] & 0x0F)
+		codeLSB := uint64(binary.LittleEndian.Uint32(sb[4:]))
+		if codeMSB != 0 || codeLSB != 0 {
+			m.pr = float64(codeMSB<<32|codeLSB) * 0.001
+			m.hasPR = true
+		}
+		doppler := int32(binary.LittleEndian.Uint32(sb[8:]))
+		if doppler != math.MinInt32 {
+			m.doppler = float64(doppler) * 0.0001
+			m.hasD = true
+		}
+		carrierLSB := binary.LittleEndian.Uint16(sb[12:])
+		carrierMSB := int8(sb[14])
+		wl := d.wavelength(prn, m.sig, obsInfo)
+		if m.hasPR && wl > 0 && (carrierMSB != -128 || carrierLSB != 0) {
+			m.phase = m.pr/wl + float64(int64(carrierMSB)<<16|int64(carrierLSB))*0.001
+			m.hasL = true
+		}
+		sats[prn] = d.addMeasurement(sats[prn], prn, m)
+		master := m
+		masterWl := wl
+
+		// Each Type2 sub-block has another signal, relative to the
+		// first.
+		for j := 0; j < n2; j++ {
+			sb2 := block[pos : pos+sb2Len]
+			pos += sb2Len
+			if sb2[0]>>5 != 0 {
+				continue
+			}
+			obsInfo := sb2[5]
+			m := measurement{
+				sig:      signalNumber(sb2[0], obsInfo),
+				lockTime: int(sb2[1]),
+				obsInfo:  obsInfo,
+			}
+			m.cn0 = cn0Value(sb2[2], m.sig)
+			codeOffMSB := int64(int8(sb2[3]<<5) >> 5)
+			dopOffMSB := int64(int8(sb2[3]) >> 3)
+			codeOffLSB := int64(binary.LittleEndian.Uint16(sb2[6:]))
+			carrierMSB := int8(sb2[4])
+			carrierLSB := binary.LittleEndian.Uint16(sb2[8:])
+			dopOffLSB := int64(binary.LittleEndian.Uint16(sb2[10:]))
+			if master.hasPR && (codeOffMSB != -4 || codeOffLSB != 0) {
+				m.pr = master.pr + float64(codeOffMSB<<16|codeOffLSB)*0.001
+				m.hasPR = true
+			}
+			wl := d.wavelength(prn, m.sig, obsInfo)
+			if m.hasPR && wl > 0 && (carrierMSB != -128 || carrierLSB != 0) {
+				m.phase = m.pr/wl + float64(int64(carrierMSB)<<16|int64(carrierLSB))*0.001
+				m.hasL = true
+			}
+			if master.hasD && wl > 0 && masterWl > 0 &&
+				(dopOffMSB != -16 || dopOffLSB != 0) {
+				m.doppler = master.doppler*masterWl/wl +
+					float64(dopOffMSB<<16|dopOffLSB)*0.0001
+				m.hasD = true
+			}
+			sats[prn] = d.addMeasurement(sats[prn], prn, m)
+		}
+	}
+
+	var rec rinex.ObservationRecord
+	rec.SetTime(t)
+	for prn, cells := range sats {
+		sv := rinex.SVObservation{
+			PRN: prn,
+			Obs: make([]rinex.Observation, len(d.Observations[prn[0]])),
+		}
+		for _, c := range cells {
+			sv.Obs[d.obsIndex[prn[0]][c.obsType]] = c.obs
+		}
+		rec.Sat = append(rec.Sat, sv)
+	}
+	sort.Slice(rec.Sat, func(i, j int) bool {
+		return string(rec.Sat[i].PRN[:]) < string(rec.Sat[j].PRN[:])
+	})
+
+	if d.Header.FirstObs.IsZero() {
+		d.Header.FirstObs = t
+	}
+	d.Header.LastObs = t
+	if d.ObsFunc != nil {
+		return d.ObsFunc(rec)
+	}
+	return nil
+}
+
+// addMeasurement appends the observations in m to obs.
+func (d *Decoder) addMeasurement(obs []cellObs, prn [3]byte, m measurement) []cellObs {
+	s, ok := signals[m.sig]
+	if !ok {
+		return obs
+	}
+	key := lockKey{prn: prn, code: [2]byte{s.code[0], s.code[1]}}
+	var lli byte
+	if prev, ok := d.locks[key]; ok && m.lockTime < prev {
+		lli |= 1
+	}
+	if m.obsInfo&obsInfoHalfCycle != 0 {
+		lli |= 2
+	}
+	d.locks[key] = m.lockTime
+	var ssi byte
+	if m.cn0 > 0 {
+		ssi = signalStrength(m.cn0)
+	}
+
+	if m.hasPR {
+		obs = d.addObs(obs, prn[0], 'C', s.code, m.pr, 0, ssi)
+	}
+	if m.hasL {
+		obs = d.addObs(obs, prn[0], 'L', s.code, m.phase, lli, ssi)
+	}
+	if m.hasD {
+		obs = d.addObs(obs, prn[0], 'D', s.code, m.doppler, 0, 0)
+	}
+	if m.cn0 > 0 {
+		obs = d.addObs(obs, prn[0], 'S', s.code, m.cn0, 0, 0)
+	}
+	return obs
+}
+
+// signalStrength maps a C/N0 (dB-Hz) to a RINEX signal strength
+// indicator.
+func signalStrength(cn0 float64) byte {
+	ssi := int(cn0 / 6)
+	if ssi < 1 {
+		ssi = 1
+	} else if ssi > 9 {
+		ssi = 9
+	}
+	return byte(ssi)
+}
+
+// addObs appends an observation to obs, and registers its type for sys.
+func (d *Decoder) addObs(obs []cellObs, sys, kind byte, code string, value float64, lli, ssi byte) []cellObs {
+	obsType := [3]byte{kind, code[0], code[1]}
+	for _, c := range obs {
+		if c.obsType == obsType {
+			return obs
+		}
+	}
+	index := d.obsIndex[sys]
+	if index == nil {
+		index = make(map[[3]byte]int)
+		d.obsIndex[sys] = index
+	}
+	if _, ok := index[obsType]; !ok {
+		index[obsType] = len(d.Observations[sys])
+		d.Observations[sys] = append(d.Observations[sys], obsType)
+	}
+	return append(obs, cellObs{
+		obsType: obsType,
+		obs: rinex.Observation{
+			Value:          math.Round(value*1000) / 1000,
+			Present:        true,
+			LLI:            lli,
+			SignalStrength: ssi,
+		},
+	})
+}
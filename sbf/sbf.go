@@ -0,0 +1,238 @@
+// Package sbf decodes Septentrio Binary Format (SBF) streams.
+//
+// It checks the framing and CRC of each block, and decodes MeasEpoch
+// (block 4027) into the same observation records that rinex.ObsReader
+// produces.  Other blocks are skipped.  In particular, the compressed
+// Meas3 blocks are not decoded, so receivers should be configured to
+// log MeasEpoch.
+package sbf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/entrope/gnss/rinex"
+)
+
+// Sync characters that start each SBF block.
+const (
+	sync1 = '$'
+	sync2 = '@'
+)
+
+// blockMeasEpoch is the block number of MeasEpoch.
+const blockMeasEpoch = 4027
+
+// Decoder decodes SBF streams.
+type Decoder struct {
+	// ObsFunc is a function that is called for each MeasEpoch block.
+	// If it returns non-nil, decoding stops.
+	ObsFunc func(rec rinex.ObservationRecord) error
+
+	// Header holds the observation header values that the stream
+	// implies: the time system, signal strength unit, first and last
+	// observation times, and GLONASS frequency channels.
+	Header rinex.ObsHeader
+
+	// Observations lists the types of observations for each GNSS, as
+	// in rinex.ObsReader.  The decoder adds types as it sees new
+	// signals, so an observation record may have fewer observations
+	// for a satellite than its GNSS has types; the missing ones are
+	// not present.
+	Observations map[byte][][3]byte
+
+	// obsIndex maps from a GNSS and observation type to its index in
+	// Observations.
+	obsIndex map[byte]map[[3]byte]int
+
+	// locks holds the lock time (s) of each signal in the previous
+	// epoch, to detect loss of lock.
+	locks map[lockKey]int
+
+	// block holds the current block.
+	block []byte
+}
+
+/************************ TOP LEVEL FUNCTIONS ************************/
+
+// Parse reads SBF blocks from r and runs the callback functions in d.
+// It skips bytes that are not part of a block with a valid CRC.
+func (d *Decoder) Parse(r io.Reader) error {
+	d.Observations = make(map[byte][][3]byte)
+	d.obsIndex = make(map[byte]map[[3]byte]int)
+	d.locks = make(map[lockKey]int)
+	d.Header.TimeSystem = "GPS"
+	d.Header.SignalStrengthUnit = "DBHZ"
+	if d.Header.GLONASSSlots == nil {
+		d.Header.GLONASSSlots = make(map[[3]byte]int)
+	}
+
+	br := bufio.NewReaderSize(r, 65536)
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if b != sync1 {
+			continue
+		}
+
+		head, err := br.Peek(7)
+		if err == io.EOF {
+			continue
+		} else if err != nil {
+			return err
+		}
+		n := int(binary.LittleEndian.Uint16(head[5:]))
+		if head[0] != sync2 || n < 8 || n%4 != 0 {
+			continue
+		}
+		block, err := br.Peek(n - 1)
+		if err == io.EOF {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if crc16(block[3:]) != binary.LittleEndian.Uint16(block[1:]) {
+			continue
+		}
+
+		d.block = append(append(d.block[:0], sync1), block...)
+		br.Discard(n - 1)
+		if err := d.handleBlock(d.block); err != nil {
+			return err
+		}
+	}
+}
+
+// Detect returns true if head holds a complete SBF block with a valid
+// CRC.
+func Detect(head []byte) bool {
+	for i := 0; i+8 <= len(head); i++ {
+		if head[i] != sync1 || head[i+1] != sync2 {
+			continue
+		}
+		n := int(binary.LittleEndian.Uint16(head[i+6:]))
+		if n < 8 || n%4 != 0 || i+n > len(head) {
+			continue
+		}
+		if crc16(head[i+4:i+n]) == binary.LittleEndian.Uint16(head[i+2:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// crc16 calculates the CRC-16-CCITT of data, with an initial value of
+// zero.
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// handleBlock decodes one block, including its header.
+func (d *Decoder) handleBlock(block []byte) error {
+	id := binary.LittleEndian.Uint16(block[4:]) & 0x1FFF
+	switch id {
+	case blockMeasEpoch:
+		return d.decodeMeasEpoch(block)
+	}
+	return nil
+}
+
+/************************** HELPER FUNCTIONS **************************/
+
+// satID returns the RINEX satellite ID for an SBF SVID, and false if it
+// is not a satellite that RINEX can identify.
+func satID(svid byte) ([3]byte, bool) {
+	var sys byte
+	n := int(svid)
+	switch {
+	case n >= 1 && n <= 37:
+		sys = 'G'
+	case n >= 38 && n <= 61:
+		sys, n = 'R', n-37
+	case n >= 63 && n <= 68:
+		sys, n = 'R', n-38
+	case n >= 71 && n <= 106:
+		sys, n = 'E', n-70
+	case n >= 120 && n <= 140:
+		sys, n = 'S', n-100
+	case n >= 141 && n <= 180:
+		sys, n = 'C', n-140
+	case n >= 181 && n <= 190:
+		sys, n = 'J', n-180
+	case n >= 191 && n <= 197:
+		sys, n = 'I', n-190
+	case n >= 198 && n <= 215:
+		sys, n = 'S', n-157
+	case n >= 216 && n <= 222:
+		sys, n = 'I', n-208
+	case n >= 223 && n <= 245:
+		sys, n = 'C', n-182
+	default:
+		return [3]byte{}, false
+	}
+	return [3]byte{sys, byte('0' + n/10), byte('0' + n%10)}, true
+}
+
+// signal describes one SBF signal type.
+type signal struct {
+	// code is the RINEX band and attribute.
+	code string
+
+	// freq is the carrier frequency (Hz).  For GLONASS FDMA signals it
+	// is the frequency of channel 0.
+	freq float64
+
+	// step is the GLONASS FDMA channel spacing (Hz), or 0.
+	step float64
+}
+
+// signals maps from an SBF signal number to its description.
+var signals = map[int]signal{
+	0:  {"1C", 1575.42e6, 0},
+	1:  {"1W", 1575.42e6, 0},
+	2:  {"2W", 1227.60e6, 0},
+	3:  {"2L", 1227.60e6, 0},
+	4:  {"5Q", 1176.45e6, 0},
+	5:  {"1L", 1575.42e6, 0},
+	6:  {"1C", 1575.42e6, 0},
+	7:  {"2L", 1227.60e6, 0},
+	8:  {"1C", 1602e6, 562.5e3},
+	9:  {"1P", 1602e6, 562.5e3},
+	10: {"2P", 1246e6, 437.5e3},
+	11: {"2C", 1246e6, 437.5e3},
+	12: {"3Q", 1202.025e6, 0},
+	13: {"1P", 1575.42e6, 0},
+	14: {"5P", 1176.45e6, 0},
+	15: {"5A", 1176.45e6, 0},
+	17: {"1C", 1575.42e6, 0},
+	19: {"6C", 1278.75e6, 0},
+	20: {"5Q", 1176.45e6, 0},
+	21: {"7Q", 1207.14e6, 0},
+	22: {"8Q", 1191.795e6, 0},
+	24: {"1C", 1575.42e6, 0},
+	25: {"5I", 1176.45e6, 0},
+	26: {"5Q", 1176.45e6, 0},
+	27: {"6L", 1278.75e6, 0},
+	28: {"2I", 1561.098e6, 0},
+	29: {"7I", 1207.14e6, 0},
+	30: {"6I", 1268.52e6, 0},
+	32: {"1L", 1575.42e6, 0},
+	33: {"1Z", 1575.42e6, 0},
+	34: {"7D", 1207.14e6, 0},
+}
@@ -0,0 +1,178 @@
+package sbf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/entrope/gnss/rinex"
+)
+
+// makeBlock builds an SBF block from its block number and the body
+// that follows the header.
+func makeBlock(id uint16, body []byte) []byte {
+	n := (8 + len(body) + 3) &^ 3
+	out := make([]byte, n)
+	out[0], out[1] = sync1, sync2
+	binary.LittleEndian.PutUint16(out[4:], id)
+	binary.LittleEndian.PutUint16(out[6:], uint16(n))
+	copy(out[8:], body)
+	binary.LittleEndian.PutUint16(out[2:], crc16(out[4:]))
+	return out
+}
+
+type type1 struct {
+	typ, svid byte
+	code      uint64
+	doppler   int32
+	carrier   int32
+	cn0       byte
+	lock      uint16
+	obsInfo   byte
+	sub       []type2
+}
+
+type type2 struct {
+	typ, lock, cn0 byte
+	codeOff        int32
+	carrier        int32
+	dopOff         int32
+	obsInfo        byte
+}
+
+func measEpoch(tow uint32, wn uint16, sats []type1) []byte {
+	body := make([]byte, 12)
+	binary.LittleEndian.PutUint32(body[0:], tow)
+	binary.LittleEndian.PutUint16(body[4:], wn)
+	body[6], body[7], body[8] = byte(len(sats)), 20, 12
+	for _, s := range sats {
+		sb := make([]byte, 20)
+		sb[0], sb[1], sb[2] = 0, s.typ, s.svid
+		sb[3] = byte(s.code >> 32)
+		binary.LittleEndian.PutUint32(sb[4:], uint32(s.code))
+		binary.LittleEndian.PutUint32(sb[8:], uint32(s.doppler))
+		binary.LittleEndian.PutUint16(sb[12:], uint16(s.carrier))
+		sb[14] = byte(s.carrier >> 16)
+		sb[15] = s.cn0
+		binary.LittleEndian.PutUint16(sb[16:], s.lock)
+		sb[18], sb[19] = s.obsInfo, byte(len(s.sub))
+		body = append(body, sb...)
+		for _, s2 := range s.sub {
+			sb := make([]byte, 12)
+			sb[0], sb[1], sb[2] = s2.typ, s2.lock, s2.cn0
+			sb[3] = byte(s2.codeOff>>16)&7 | byte(s2.dopOff>>16)<<3
+			sb[4], sb[5] = byte(s2.carrier>>16), s2.obsInfo
+			binary.LittleEndian.PutUint16(sb[6:], uint16(s2.codeOff))
+			binary.LittleEndian.PutUint16(sb[8:], uint16(s2.carrier))
+			binary.LittleEndian.PutUint16(sb[10:], uint16(s2.dopOff))
+			body = append(body, sb...)
+		}
+	}
+	return makeBlock(blockMeasEpoch, body)
+}
+
+func round(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+func TestMeasEpoch(t *testing.T) {
+	sats := []type1{
+		{typ: 0, svid: 12, code: 21234567890, doppler: -12345678,
+			carrier: 12345, cn0: 140, lock: 500, sub: []type2{
+				{typ: 3, lock: 200, cn0: 120, codeOff: 123456, carrier: -5500,
+					dopOff: 5000, obsInfo: obsInfoHalfCycle},
+			}},
+		{typ: 0x20, svid: 7, code: 22000000000, cn0: 100},
+		{typ: 8, svid: 42, code: 20000000000, doppler: 10000, cn0: 160,
+			lock: 60, obsInfo: 5 << 3},
+		{typ: 31, svid: 182, code: 38000000000, doppler: math.MinInt32,
+			carrier: -128 << 16, cn0: 255, obsInfo: 1 << 3},
+	}
+	input := measEpoch(345600000, 2296, sats)
+	bad := measEpoch(345600500, 2296, sats)
+	bad[20]++
+	input = append(input, bad...)
+	sats[0].lock = 100
+	input = append(input, measEpoch(345601000, 2296, sats)...)
+
+	if !Detect(input[:len(bad)]) || Detect(input[:len(bad)-1]) || Detect(bad) {
+		t.Errorf("Detect gave wrong results")
+	}
+
+	var d Decoder
+	var recs []rinex.ObservationRecord
+	d.ObsFunc = func(rec rinex.ObservationRecord) error {
+		recs = append(recs, rec)
+		return nil
+	}
+	if err := d.Parse(bytes.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("got %d records, want 2", len(recs))
+	}
+	if tm := recs[0].Time(); !tm.Equal(time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("first record at %v", tm)
+	}
+
+	wantTypes := map[byte]string{
+		'G': "C1C L1C D1C S1C C2L L2L D2L S2L ",
+		'R': "C1C L1C D1C S1C ",
+		'J': "C1Z ",
+	}
+	for sys, want := range wantTypes {
+		got := ""
+		for _, obsType := range d.Observations[sys] {
+			got += string(obsType[:]) + " "
+		}
+		if got != want {
+			t.Errorf("Observations[%c] = %q, want %q", sys, got, want)
+		}
+	}
+
+	sv := recs[0].Sat
+	if len(sv) != 3 || string(sv[0].PRN[:]) != "G12" ||
+		string(sv[1].PRN[:]) != "J02" || string(sv[2].PRN[:]) != "R05" {
+		t.Fatalf("got satellites %v", sv)
+	}
+
+	l1 := speedOfLight / 1575.42e6
+	l2 := speedOfLight / 1227.60e6
+	pr1 := 21234567.890
+	pr2 := pr1 + 123.456
+	want := []rinex.Observation{
+		{Value: pr1, Present: true, SignalStrength: 7},
+		{Value: round(pr1/l1 + 12.345), Present: true, SignalStrength: 7},
+		{Value: -1234.568, Present: true},
+		{Value: 45, Present: true},
+		{Value: round(pr2), Present: true, SignalStrength: 6},
+		{Value: round(pr2/l2 - 5.5), Present: true, LLI: 2, SignalStrength: 6},
+		{Value: round(-1234.5678*l1/l2 + 0.5), Present: true},
+		{Value: 40, Present: true},
+	}
+	for i := range want {
+		if sv[0].Obs[i] != want[i] {
+			t.Errorf("G12 obs %d = %+v, want %+v", i, sv[0].Obs[i], want[i])
+		}
+	}
+
+	lr := speedOfLight / (1602e6 - 3*562.5e3)
+	if got := sv[2].Obs[1].Value; got != round(20000000/lr) {
+		t.Errorf("R05 L1C = %v, want %v", got, round(20000000/lr))
+	}
+	if d.Header.GLONASSSlots[[3]byte{'R', '0', '5'}] != -3 {
+		t.Errorf("GLONASSSlots = %v", d.Header.GLONASSSlots)
+	}
+	if got := sv[1].Obs[0].Value; got != 38000000 {
+		t.Errorf("J02 C1Z = %v", got)
+	}
+
+	if lli := recs[1].Sat[0].Obs[1].LLI; lli != 1 {
+		t.Errorf("G12 L1C LLI after lock loss = %d, want 1", lli)
+	}
+	if lli := recs[1].Sat[0].Obs[5].LLI; lli != 2 {
+		t.Errorf("G12 L2L LLI = %d, want 2", lli)
+	}
+}
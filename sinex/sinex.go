@@ -0,0 +1,463 @@
+// Package sinex reads station information and coordinate solutions
+// from Solution (Software/technique) INdependent EXchange (SINEX)
+// files, such as IGS weekly solutions and NGS coordinate products.
+//
+// It reads the SITE/ID, SITE/RECEIVER, SITE/ANTENNA, SOLUTION/EPOCHS
+// and SOLUTION/ESTIMATE blocks, and ignores other blocks.  File.Position
+// propagates estimated station coordinates to another epoch using
+// their estimated velocities.
+package sinex
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Site describes a site from the SITE/ID block.
+type Site struct {
+	// Code is the four-character site code, such as "ALGO".
+	Code string
+
+	// Point is the point code, usually "A".
+	Point string
+
+	// DOMES is the site's DOMES number.
+	DOMES string
+
+	// Technique is the observation technique: 'P' for GNSS, 'R' for
+	// VLBI, 'L' for SLR, 'M' for LLR, 'D' for DORIS, or 'C' for a
+	// combination.
+	Technique byte
+
+	// Description is a free-form description of the site.
+	Description string
+
+	// Longitude and Latitude give the approximate position of the site
+	// (degrees), and Height its approximate height (m).
+	Longitude, Latitude, Height float64
+}
+
+// Equipment describes a receiver or antenna from the SITE/RECEIVER or
+// SITE/ANTENNA block.
+type Equipment struct {
+	// Site, Point and Solution identify the station solution that the
+	// equipment was used for.
+	Site, Point, Solution string
+
+	// Start and End bound the time when the equipment was used.  They
+	// are zero if they are not specified.
+	Start, End time.Time
+
+	// Type is the equipment type.  For antennas, it is the antenna
+	// model in the first 16 characters and the radome in the last 4,
+	// as in RINEX ANT # / TYPE headers.
+	Type string
+
+	// Serial is the serial number.
+	Serial string
+
+	// Firmware is the firmware version of a receiver.
+	Firmware string
+}
+
+// SolutionEpochs describes the data span of a station solution, from
+// the SOLUTION/EPOCHS block.
+type SolutionEpochs struct {
+	// Site, Point and Solution identify the station solution.
+	Site, Point, Solution string
+
+	// Start and End bound the data used for the solution, and Mean is
+	// the mean epoch of that data.
+	Start, End, Mean time.Time
+}
+
+// Estimate is one estimated parameter from the SOLUTION/ESTIMATE block.
+type Estimate struct {
+	// Index is the parameter's index in the solution.
+	Index int
+
+	// Type is the parameter type, such as "STAX" or "VELZ".
+	Type string
+
+	// Site, Point and Solution identify the station solution that the
+	// parameter belongs to.
+	Site, Point, Solution string
+
+	// RefEpoch is the epoch that the value applies to.
+	RefEpoch time.Time
+
+	// Unit is the unit of the value, such as "m" or "m/y".
+	Unit string
+
+	// Constraint is '0' for a fixed parameter, '1' for a significantly
+	// constrained one, and '2' for an unconstrained one.
+	Constraint byte
+
+	// Value and StdDev are the estimated value and its standard
+	// deviation.
+	Value, StdDev float64
+}
+
+// File holds the parts of a SINEX file that this package reads.
+type File struct {
+	// Version is the SINEX format version, such as "2.02".
+	Version string
+
+	// Agency is the agency that created the file.
+	Agency string
+
+	// Sites lists the sites in the SITE/ID block.
+	Sites []Site
+
+	// Receivers and Antennas list the entries of the SITE/RECEIVER
+	// and SITE/ANTENNA blocks.
+	Receivers, Antennas []Equipment
+
+	// Epochs lists the entries of the SOLUTION/EPOCHS block.
+	Epochs []SolutionEpochs
+
+	// Estimates lists the entries of the SOLUTION/ESTIMATE block.
+	Estimates []Estimate
+}
+
+// ErrNoSolution indicates that a File has no coordinate solution for
+// the requested station.
+var ErrNoSolution = errors.New("No coordinate solution for station")
+
+// daysPerYear is the length of the year that SINEX velocities use.
+const daysPerYear = 365.25
+
+/************************ TOP LEVEL FUNCTIONS ************************/
+
+// Read reads a SINEX file from r.
+func Read(r io.Reader) (*File, error) {
+	p := &parser{file: &File{}}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		p.lineNum++
+		line := s.Text()
+		if len(line) < 80 {
+			line += strings.Repeat(" ", 80-len(line))
+		}
+		if err := p.parseLine(line); err != nil {
+			return nil, fmt.Errorf("line %d: %w", p.lineNum, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if p.file.Version == "" {
+		return nil, errors.New("Not a SINEX file")
+	}
+	if p.block != "" {
+		return nil, errors.New("SINEX file ends inside block " + p.block)
+	}
+	return p.file, nil
+}
+
+// matches reports whether a station solution matches site and point.
+// An empty point matches any point.
+func matches(site, point, wantSite, wantPoint string) bool {
+	return site == wantSite && (wantPoint == "" || point == wantPoint)
+}
+
+// Position returns the ECEF position (m) of the station with the given
+// site and point code at t, propagated from the solution's reference
+// epoch using its estimated velocity (if any).  If point is empty, any
+// point code matches.
+//
+// When a site has more than one solution, such as after an equipment
+// change or an earthquake, Position uses the solution whose data span
+// (from SOLUTION/EPOCHS) contains t.  If none does, it uses the
+// solution with the reference epoch closest to t.
+func (f *File) Position(site, point string, t time.Time) ([3]float64, error) {
+	var pos [3]float64
+
+	// Choose the solution.
+	var soln *Estimate
+	var solnDiff time.Duration
+	for i := range f.Estimates {
+		est := &f.Estimates[i]
+		if est.Type != "STAX" || !matches(est.Site, est.Point, site, point) {
+			continue
+		}
+		if f.spans(est, t) {
+			soln = est
+			break
+		}
+		diff := t.Sub(est.RefEpoch)
+		if diff < 0 {
+			diff = -diff
+		}
+		if soln == nil || diff < solnDiff {
+			soln = est
+			solnDiff = diff
+		}
+	}
+	if soln == nil {
+		return pos, ErrNoSolution
+	}
+
+	// Collect its coordinates and velocities.
+	var have [3]bool
+	for _, est := range f.Estimates {
+		if est.Site != soln.Site || est.Point != soln.Point ||
+			est.Solution != soln.Solution || len(est.Type) != 4 {
+			continue
+		}
+		axis := strings.IndexByte("XYZ", est.Type[3])
+		if axis < 0 {
+			continue
+		}
+		switch est.Type[:3] {
+		case "STA":
+			pos[axis] += est.Value
+			have[axis] = true
+		case "VEL":
+			years := t.Sub(soln.RefEpoch).Hours() / 24 / daysPerYear
+			pos[axis] += est.Value * years
+		}
+	}
+	if !have[0] || !have[1] || !have[2] {
+		return pos, ErrNoSolution
+	}
+	return pos, nil
+}
+
+// spans reports whether the data span of est's station solution
+// contains t.  An unspecified start or end leaves that side open.
+func (f *File) spans(est *Estimate, t time.Time) bool {
+	for _, ep := range f.Epochs {
+		if ep.Site == est.Site && ep.Point == est.Point && ep.Solution == est.Solution {
+			return (ep.Start.IsZero() || !t.Before(ep.Start)) &&
+				(ep.End.IsZero() || !t.After(ep.End))
+		}
+	}
+	return false
+}
+
+/**************************** FILE PARSING ****************************/
+
+// parser holds the state of a SINEX file being read.
+type parser struct {
+	// file holds what has been read so far.
+	file *File
+
+	// lineNum is the one-based number of the current line.
+	lineNum int
+
+	// block is the name of the current block, or empty between blocks.
+	block string
+}
+
+// blockParsers maps each block that the parser reads to the function
+// that parses its data lines.
+var blockParsers = map[string]func(*parser, string) error{
+	"SITE/ID":           (*parser).parseSiteID,
+	"SITE/RECEIVER":     (*parser).parseReceiver,
+	"SITE/ANTENNA":      (*parser).parseAntenna,
+	"SOLUTION/EPOCHS":   (*parser).parseEpochs,
+	"SOLUTION/ESTIMATE": (*parser).parseEstimate,
+}
+
+// parseLine parses one line of a SINEX file.
+func (p *parser) parseLine(line string) error {
+	if p.lineNum == 1 {
+		if !strings.HasPrefix(line, "%=SNX ") {
+			return errors.New("Not a SINEX file")
+		}
+		p.file.Version = strings.TrimSpace(line[6:10])
+		p.file.Agency = strings.TrimSpace(line[11:14])
+		return nil
+	}
+
+	switch line[0] {
+	case '+':
+		if p.block != "" {
+			return errors.New("Block " + p.block + " is not closed")
+		}
+		p.block = strings.TrimSpace(line[1:])
+	case '-':
+		if name := strings.TrimSpace(line[1:]); name != p.block {
+			return errors.New("Unexpected end of block " + name)
+		}
+		p.block = ""
+	case ' ':
+		if parse := blockParsers[p.block]; parse != nil {
+			return parse(p, line)
+		}
+	}
+	return nil
+}
+
+// parseTime parses a SINEX time of the form YY:DDD:SSSSS or
+// YYYY:DDD:SSSSS.  A time of 00:000:00000 means the time is not
+// specified, and parses as a zero time.
+func parseTime(text string) (time.Time, error) {
+	parts := strings.Split(strings.TrimSpace(text), ":")
+	if len(parts) != 3 {
+		return time.Time{}, errors.New("Invalid SINEX time " + text)
+	}
+	var v [3]int
+	for i, part := range parts {
+		var err error
+		if v[i], err = strconv.Atoi(part); err != nil {
+			return time.Time{}, errors.New("Invalid SINEX time " + text)
+		}
+	}
+	if v[0] == 0 && v[1] == 0 && v[2] == 0 {
+		return time.Time{}, nil
+	}
+	if len(parts[0]) == 2 {
+		if v[0] < 50 {
+			v[0] += 2000
+		} else {
+			v[0] += 1900
+		}
+	}
+	return time.Date(v[0], 1, v[1], 0, 0, v[2], 0, time.UTC), nil
+}
+
+// parseFloat parses a floating-point field.
+func parseFloat(text string) (float64, error) {
+	return strconv.ParseFloat(strings.TrimSpace(text), 64)
+}
+
+// parseAngle parses an approximate longitude or latitude as degrees,
+// minutes and seconds.
+func parseAngle(text string) (float64, error) {
+	fields := strings.Fields(text)
+	if len(fields) != 3 {
+		return 0, errors.New("Invalid angle " + text)
+	}
+	var v [3]float64
+	for i, field := range fields {
+		var err error
+		if v[i], err = parseFloat(field); err != nil {
+			return 0, err
+		}
+	}
+	if strings.HasPrefix(fields[0], "-") {
+		return v[0] - v[1]/60 - v[2]/3600, nil
+	}
+	return v[0] + v[1]/60 + v[2]/3600, nil
+}
+
+// parseSiteID parses a line of the SITE/ID block.
+func (p *parser) parseSiteID(line string) error {
+	site := Site{
+		Code:        strings.TrimSpace(line[1:5]),
+		Point:       strings.TrimSpace(line[6:8]),
+		DOMES:       strings.TrimSpace(line[9:18]),
+		Technique:   line[19],
+		Description: strings.TrimSpace(line[21:43]),
+	}
+	var err error
+	if site.Longitude, err = parseAngle(line[44:55]); err != nil {
+		return err
+	}
+	if site.Latitude, err = parseAngle(line[56:67]); err != nil {
+		return err
+	}
+	if site.Height, err = parseFloat(line[68:75]); err != nil {
+		return err
+	}
+	p.file.Sites = append(p.file.Sites, site)
+	return nil
+}
+
+// parseEquipment parses the fields that SITE/RECEIVER and SITE/ANTENNA
+// lines share.
+func parseEquipment(line string) (Equipment, error) {
+	eq := Equipment{
+		Site:     strings.TrimSpace(line[1:5]),
+		Point:    strings.TrimSpace(line[6:8]),
+		Solution: strings.TrimSpace(line[9:13]),
+	}
+	var err error
+	if eq.Start, err = parseTime(line[16:28]); err != nil {
+		return eq, err
+	}
+	if eq.End, err = parseTime(line[29:41]); err != nil {
+		return eq, err
+	}
+	return eq, nil
+}
+
+// parseReceiver parses a line of the SITE/RECEIVER block.
+func (p *parser) parseReceiver(line string) error {
+	eq, err := parseEquipment(line)
+	if err != nil {
+		return err
+	}
+	eq.Type = strings.TrimSpace(line[42:62])
+	eq.Serial = strings.TrimSpace(line[63:68])
+	eq.Firmware = strings.TrimSpace(line[69:])
+	p.file.Receivers = append(p.file.Receivers, eq)
+	return nil
+}
+
+// parseAntenna parses a line of the SITE/ANTENNA block.
+func (p *parser) parseAntenna(line string) error {
+	eq, err := parseEquipment(line)
+	if err != nil {
+		return err
+	}
+	eq.Type = line[42:62]
+	eq.Serial = strings.TrimSpace(line[63:])
+	p.file.Antennas = append(p.file.Antennas, eq)
+	return nil
+}
+
+// parseEpochs parses a line of the SOLUTION/EPOCHS block.
+func (p *parser) parseEpochs(line string) error {
+	ep := SolutionEpochs{
+		Site:     strings.TrimSpace(line[1:5]),
+		Point:    strings.TrimSpace(line[6:8]),
+		Solution: strings.TrimSpace(line[9:13]),
+	}
+	var err error
+	if ep.Start, err = parseTime(line[16:28]); err != nil {
+		return err
+	}
+	if ep.End, err = parseTime(line[29:41]); err != nil {
+		return err
+	}
+	if ep.Mean, err = parseTime(line[42:54]); err != nil {
+		return err
+	}
+	p.file.Epochs = append(p.file.Epochs, ep)
+	return nil
+}
+
+// parseEstimate parses a line of the SOLUTION/ESTIMATE block.
+func (p *parser) parseEstimate(line string) error {
+	est := Estimate{
+		Type:       strings.TrimSpace(line[7:13]),
+		Site:       strings.TrimSpace(line[14:18]),
+		Point:      strings.TrimSpace(line[19:21]),
+		Solution:   strings.TrimSpace(line[22:26]),
+		Unit:       strings.TrimSpace(line[40:44]),
+		Constraint: line[45],
+	}
+	var err error
+	if est.Index, err = strconv.Atoi(strings.TrimSpace(line[1:6])); err != nil {
+		return err
+	}
+	if est.RefEpoch, err = parseTime(line[27:39]); err != nil {
+		return err
+	}
+	if est.Value, err = parseFloat(line[47:68]); err != nil {
+		return err
+	}
+	if est.StdDev, err = parseFloat(line[69:80]); err != nil {
+		return err
+	}
+	p.file.Estimates = append(p.file.Estimates, est)
+	return nil
+}
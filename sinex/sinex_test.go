@@ -0,0 +1,153 @@
+package sinex
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sinexExample is based on an IGS weekly combined solution, with an
+// extra site that has two solutions.
+const sinexExample = `%=SNX 2.02 IGS 19:010:00000 IGS 19:003:00000 19:009:86370 P 00012 2 S E
++FILE/REFERENCE
+ DESCRIPTION        Example based on an IGS weekly combination
+-FILE/REFERENCE
++SITE/ID
+*CODE PT __DOMES__ T _STATION DESCRIPTION__ APPROX_LON_ APPROX_LAT_ _APP_H_
+ ALGO  A 40104M002 P Algonquin Park, Canada 281 55 44.1  45 57 20.9   200.9
+ HOB2  A 50116M004 P Hobart, Australia      147 26 19.4 -42 48 16.9    41.1
+-SITE/ID
++SITE/RECEIVER
+*SITE PT SOLN T DATA_START__ DATA_END____ DESCRIPTION_________ S/N__ FIRMWARE___
+ ALGO  A    1 P 19:003:00000 19:009:86370 AOA BENCHMARK ACT    ----- 3.3.32.2N
+-SITE/RECEIVER
++SITE/ANTENNA
+*SITE PT SOLN T DATA_START__ DATA_END____ DESCRIPTION_________ S/N__
+ ALGO  A    1 P 19:003:00000 19:009:86370 AOAD/M_T        NONE -----
+-SITE/ANTENNA
++SOLUTION/EPOCHS
+*CODE PT SOLN T _DATA_START_ __DATA_END__ _MEAN_EPOCH_
+ ALGO  A    1 P 19:003:00000 19:009:86370 19:006:43185
+ HOB2  A    1 P 05:001:00000 12:001:00000 08:183:00000
+ HOB2  A    2 P 12:002:00000 00:000:00000 15:183:00000
+-SOLUTION/EPOCHS
++SOLUTION/ESTIMATE
+*INDEX _TYPE_ CODE PT SOLN _REF_EPOCH__ UNIT S ___ESTIMATED_VALUE___ __STD_DEV__
+     1 STAX   ALGO  A    1 10:001:00000 m    2  9.18129542000000E+05 1.20000E-03
+     2 STAY   ALGO  A    1 10:001:00000 m    2 -4.34607127000000E+06 1.20000E-03
+     3 STAZ   ALGO  A    1 10:001:00000 m    2  4.56197783400000E+06 1.20000E-03
+     4 VELX   ALGO  A    1 10:001:00000 m/y  2 -1.58000000000000E-02 1.20000E-03
+     5 VELY   ALGO  A    1 10:001:00000 m/y  2 -4.20000000000000E-03 1.20000E-03
+     6 VELZ   ALGO  A    1 10:001:00000 m/y  2  4.80000000000000E-03 1.20000E-03
+     7 STAX   HOB2  A    1 10:001:00000 m    2 -3.95007200000000E+06 1.50000E-03
+     8 STAY   HOB2  A    1 10:001:00000 m    2  2.52241500000000E+06 1.50000E-03
+     9 STAZ   HOB2  A    1 10:001:00000 m    2 -4.31163700000000E+06 1.50000E-03
+    10 STAX   HOB2  A    2 10:001:00000 m    2 -3.95007190000000E+06 1.50000E-03
+    11 STAY   HOB2  A    2 10:001:00000 m    2  2.52241510000000E+06 1.50000E-03
+    12 STAZ   HOB2  A    2 10:001:00000 m    2 -4.31163690000000E+06 1.50000E-03
+-SOLUTION/ESTIMATE
+%ENDSNX
+`
+
+func TestRead(t *testing.T) {
+	f, err := Read(strings.NewReader(sinexExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Version != "2.02" || f.Agency != "IGS" {
+		t.Errorf("Bad file header %q %q", f.Version, f.Agency)
+	}
+
+	if len(f.Sites) != 2 {
+		t.Fatalf("Expected 2 sites, got %d", len(f.Sites))
+	}
+	algo, hob2 := f.Sites[0], f.Sites[1]
+	if algo.Code != "ALGO" || algo.Point != "A" || algo.DOMES != "40104M002" ||
+		algo.Technique != 'P' || algo.Description != "Algonquin Park, Canada" ||
+		math.Abs(algo.Longitude-(281+55/60.0+44.1/3600)) > 1e-12 ||
+		algo.Height != 200.9 {
+		t.Errorf("Bad site %+v", algo)
+	}
+	if math.Abs(hob2.Latitude+(42+48/60.0+16.9/3600)) > 1e-12 {
+		t.Errorf("Bad southern latitude %g", hob2.Latitude)
+	}
+
+	start := time.Date(2019, 1, 3, 0, 0, 0, 0, time.UTC)
+	if len(f.Receivers) != 1 || f.Receivers[0].Type != "AOA BENCHMARK ACT" ||
+		f.Receivers[0].Firmware != "3.3.32.2N" || !f.Receivers[0].Start.Equal(start) ||
+		!f.Receivers[0].End.Equal(start.Add(6*24*time.Hour+86370*time.Second)) {
+		t.Errorf("Bad receivers %+v", f.Receivers)
+	}
+	if len(f.Antennas) != 1 || f.Antennas[0].Type != "AOAD/M_T        NONE" ||
+		f.Antennas[0].Serial != "-----" {
+		t.Errorf("Bad antennas %+v", f.Antennas)
+	}
+	if len(f.Epochs) != 3 || !f.Epochs[2].End.IsZero() ||
+		!f.Epochs[1].Mean.Equal(time.Date(2008, 7, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Bad solution epochs %+v", f.Epochs)
+	}
+
+	if len(f.Estimates) != 12 {
+		t.Fatalf("Expected 12 estimates, got %d", len(f.Estimates))
+	}
+	est := f.Estimates[3]
+	if est.Index != 4 || est.Type != "VELX" || est.Site != "ALGO" ||
+		est.Solution != "1" || est.Unit != "m/y" || est.Constraint != '2' ||
+		est.Value != -0.0158 || est.StdDev != 1.2e-3 ||
+		!est.RefEpoch.Equal(time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Bad estimate %+v", est)
+	}
+}
+
+func TestPosition(t *testing.T) {
+	f, err := Read(strings.NewReader(sinexExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Ten years of velocity from the reference epoch.
+	tm := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC).Add(3652.5 * 24 * time.Hour)
+	pos, err := f.Position("ALGO", "A", tm)
+	expected := [3]float64{918129.542 - 0.158, -4346071.270 - 0.042, 4561977.834 + 0.048}
+	for i := range pos {
+		if err != nil || math.Abs(pos[i]-expected[i]) > 1e-6 {
+			t.Fatalf("Bad ALGO position %v (%v)", pos, err)
+		}
+	}
+
+	// HOB2 has a different solution before and after 2012.
+	tests := []struct {
+		t time.Time
+		x float64
+	}{
+		{time.Date(2011, 6, 1, 0, 0, 0, 0, time.UTC), -3950072.0},
+		{time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC), -3950071.9},
+		{time.Date(2001, 6, 1, 0, 0, 0, 0, time.UTC), -3950072.0},
+	}
+	for _, test := range tests {
+		pos, err := f.Position("HOB2", "", test.t)
+		if err != nil || math.Abs(pos[0]-test.x) > 1e-6 {
+			t.Errorf("Bad HOB2 position at %v: %v (%v)", test.t, pos, err)
+		}
+	}
+
+	if _, err := f.Position("ALGO", "B", tm); err != ErrNoSolution {
+		t.Errorf("Expected ErrNoSolution, got %v", err)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	for _, text := range []string{
+		"",
+		strings.Replace(sinexExample, "%=SNX", "%=XYZ", 1),
+		strings.Replace(sinexExample, "-SITE/ID", "-SITE/XX", 1),
+		strings.Replace(sinexExample, "10:001:00000 m/y", "10:0x1:00000 m/y", 1),
+		strings.Replace(sinexExample, "-1.58000000000000E-02", "-1.58000000000000X-02", 1),
+		sinexExample[:strings.Index(sinexExample, "-SOLUTION/ESTIMATE")],
+	} {
+		if _, err := Read(strings.NewReader(text)); err == nil {
+			t.Errorf("Expected an error")
+		}
+	}
+}
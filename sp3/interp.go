@@ -0,0 +1,138 @@
+package sp3
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/entrope/gnss/ephemeris"
+)
+
+// DefaultPoints is the number of epochs that Compute interpolates
+// positions from when Orbit.Points is zero.  Ten points at the usual
+// 15-minute spacing give millimeter-level interpolation error.
+const DefaultPoints = 10
+
+// speedOfLight is the speed of light in vacuum (m/s).
+const speedOfLight = 299792458.0
+
+var (
+	// ErrNoData indicates that an Orbit has no records for the
+	// requested satellite around the requested time.
+	ErrNoData = errors.New("No orbit data for satellite and time")
+
+	// ErrGap indicates that there are too few evenly spaced records
+	// around the requested time to interpolate from.
+	ErrGap = errors.New("Gap in orbit data")
+)
+
+// points returns the number of epochs to interpolate from.
+func (o *Orbit) points() int {
+	if o.Points > 1 {
+		return o.Points
+	}
+	return DefaultPoints
+}
+
+// segment returns the bounds [lo, hi) of the run of evenly spaced
+// records in recs that contains recs[i-1] and recs[i].
+func segment(recs []Record, i int) (int, int) {
+	step := recs[i].Time.Sub(recs[i-1].Time)
+	even := func(j int) bool {
+		d := recs[j].Time.Sub(recs[j-1].Time)
+		return d <= step+step/2 && d >= step-step/2
+	}
+	lo, hi := i-1, i+1
+	for lo > 0 && even(lo) {
+		lo--
+	}
+	for hi < len(recs) && even(hi) {
+		hi++
+	}
+	return lo, hi
+}
+
+// Compute interpolates prn's position, velocity and clock at t (in GPS
+// time).  Positions use Lagrange interpolation over o.Points epochs
+// around t, and velocities are the derivative of that polynomial.
+// Clocks are interpolated linearly between the two epochs around t;
+// ClockBias and ClockDrift are NaN if either clock is unknown.
+//
+// SP3 clocks, like other precise clock products, leave out the periodic
+// relativistic correction.  Compute adds it to ClockBias (and reports
+// it as Relativistic) so that the result matches what
+// ephemeris.Store.Compute returns for broadcast ephemerides.
+func (o *Orbit) Compute(prn [3]byte, t time.Time) (ephemeris.State, error) {
+	var st ephemeris.State
+	recs := o.series[prn]
+	if len(recs) < 2 || t.Before(recs[0].Time) || t.After(recs[len(recs)-1].Time) {
+		return st, ErrNoData
+	}
+
+	// Find the records on either side of t.
+	i := sort.Search(len(recs), func(i int) bool {
+		return recs[i].Time.After(t)
+	})
+	if i == len(recs) {
+		i--
+	}
+	if i == 0 {
+		i++
+	}
+	lo, hi := segment(recs, i)
+	n := o.points()
+	if hi-lo < n {
+		return st, ErrGap
+	}
+	start := i - n/2
+	if start < lo {
+		start = lo
+	} else if start > hi-n {
+		start = hi - n
+	}
+
+	// Interpolate the position from seconds relative to the middle of
+	// the window, to keep the numbers small.
+	win := recs[start : start+n]
+	ref := win[n/2].Time
+	x := t.Sub(ref).Seconds()
+	xs := make([]float64, n)
+	for j := range win {
+		xs[j] = win[j].Time.Sub(ref).Seconds()
+	}
+	for j := range win {
+		l, dl := lagrange(xs, j, x)
+		for k := 0; k < 3; k++ {
+			st.Position[k] += l * win[j].Position[k]
+			st.Velocity[k] += dl * win[j].Position[k]
+		}
+	}
+
+	// Interpolate the clock linearly.
+	a, b := recs[i-1], recs[i]
+	dt := b.Time.Sub(a.Time).Seconds()
+	st.ClockDrift = (b.Clock - a.Clock) / dt
+	st.ClockBias = a.Clock + st.ClockDrift*t.Sub(a.Time).Seconds()
+
+	st.Relativistic = -2 * (st.Position[0]*st.Velocity[0] +
+		st.Position[1]*st.Velocity[1] +
+		st.Position[2]*st.Velocity[2]) / (speedOfLight * speedOfLight)
+	st.ClockBias += st.Relativistic
+	return st, nil
+}
+
+// lagrange returns the value and derivative at x of the j'th Lagrange
+// basis polynomial for the nodes xs.
+func lagrange(xs []float64, j int, x float64) (float64, float64) {
+	l, dl := 1.0, 0.0
+	for k := range xs {
+		if k == j {
+			continue
+		}
+		// Product rule: (l * f)' = l' * f + l * f'.
+		f := (x - xs[k]) / (xs[j] - xs[k])
+		dl = dl*f + l/(xs[j]-xs[k])
+		l *= f
+	}
+	return l, dl
+}
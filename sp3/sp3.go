@@ -0,0 +1,470 @@
+// Package sp3 reads precise orbit and clock products in the SP3-c and
+// SP3-d formats, and interpolates them to arbitrary times.
+//
+// An Orbit collects per-satellite time series of positions and clock
+// corrections from one or more SP3 files.  Files that cover adjacent
+// days may be read one after another; epochs that appear in more than
+// one file (such as the midnight between two daily files) are kept
+// from the first file that contained them.
+//
+// Times in an Orbit are in GPS time, labeled as UTC in the same way as
+// rinex.ObservationRecord.Time, whatever time system the SP3 header
+// names.  Positions are in meters in the coordinate frame that the
+// header names; this package does not transform between frames, so
+// every file read into one Orbit must use the same frame.
+package sp3
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/entrope/gnss/gnsstime"
+)
+
+// Header holds the header fields of an SP3 file.
+type Header struct {
+	// Version is the format version letter, 'c' or 'd'.
+	Version byte
+
+	// Velocities is true if the file has velocity records.
+	Velocities bool
+
+	// Start is the time of the first epoch, in the file's time system.
+	Start time.Time
+
+	// NumEpochs is the number of epochs that the file claims to have.
+	NumEpochs int
+
+	// DataUsed describes the data used to make the product, such as
+	// "ORBIT" or "u+U".
+	DataUsed string
+
+	// CoordinateSystem names the coordinate frame, such as "IGS14".
+	CoordinateSystem string
+
+	// OrbitType describes how the orbit was made, such as "FIT" or
+	// "HLM".
+	OrbitType string
+
+	// Agency names the agency that made the product.
+	Agency string
+
+	// Interval is the nominal time between epochs (s).
+	Interval float64
+
+	// TimeSystem is the time system of the file's epochs, such as
+	// "GPS" or "UTC".
+	TimeSystem string
+
+	// Satellites lists the satellites that the file describes.
+	Satellites [][3]byte
+
+	// Comments holds the text of the comment lines.
+	Comments []string
+}
+
+// Record is the state of one satellite at one epoch.
+type Record struct {
+	// Time is the epoch, in GPS time.
+	Time time.Time
+
+	// Position is the satellite position (m).
+	Position [3]float64
+
+	// Clock is the satellite clock correction (s), or NaN if it is not
+	// known.
+	Clock float64
+
+	// HasVelocity is true if the file gave Velocity and ClockRate.
+	HasVelocity bool
+
+	// Velocity is the satellite velocity (m/s).
+	Velocity [3]float64
+
+	// ClockRate is the rate of change of Clock (s/s), or NaN if it is
+	// not known.
+	ClockRate float64
+}
+
+// Orbit holds satellite positions and clock corrections from one or
+// more SP3 files.
+type Orbit struct {
+	// Header is the header of the first file that was read.
+	Header Header
+
+	// Points is the number of epochs that Compute interpolates
+	// positions from.  If it is less than two, Compute uses
+	// DefaultPoints.
+	Points int
+
+	// series maps from satellite ID to its records, in time order.
+	series map[[3]byte][]Record
+}
+
+// NewOrbit creates an empty Orbit.
+func NewOrbit() *Orbit {
+	return &Orbit{series: make(map[[3]byte][]Record)}
+}
+
+// badClock is the value that SP3 files use for unknown clock values.
+const badClock = 999999.999999
+
+// Satellites returns the IDs of the satellites that o has records for,
+// in sorted order.
+func (o *Orbit) Satellites() [][3]byte {
+	var res [][3]byte
+	for prn := range o.series {
+		res = append(res, prn)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return string(res[i][:]) < string(res[j][:])
+	})
+	return res
+}
+
+// Records returns o's records for prn, in time order.  The caller must
+// not modify the returned slice.
+func (o *Orbit) Records(prn [3]byte) []Record {
+	return o.series[prn]
+}
+
+// Read reads an SP3 file from r and adds its records to o.  If the file
+// cannot be read, o is not changed.
+func (o *Orbit) Read(r io.Reader) error {
+	p := &parser{series: make(map[[3]byte][]Record)}
+	if err := p.parse(r); err != nil {
+		return err
+	}
+	if o.Header.Version == 0 {
+		o.Header = p.header
+	} else if p.header.CoordinateSystem != o.Header.CoordinateSystem {
+		return fmt.Errorf("Coordinate system %s does not match %s",
+			p.header.CoordinateSystem, o.Header.CoordinateSystem)
+	}
+
+	for prn, recs := range p.series {
+		all := append(o.series[prn], recs...)
+		sort.SliceStable(all, func(i, j int) bool {
+			return all[i].Time.Before(all[j].Time)
+		})
+		// Keep the first record for each epoch.
+		n := 0
+		for i := range all {
+			if n > 0 && all[i].Time.Equal(all[n-1].Time) {
+				continue
+			}
+			all[n] = all[i]
+			n++
+		}
+		o.series[prn] = all[:n]
+	}
+	return nil
+}
+
+// parser holds the state of one SP3 file being read.
+type parser struct {
+	// header is the file's header.
+	header Header
+
+	// series maps from satellite ID to its records, in file order.
+	series map[[3]byte][]Record
+
+	// lineNum is the one-based number of the current line.
+	lineNum int
+
+	// epoch is the time of the current epoch, in GPS time.
+	epoch time.Time
+
+	// inEpoch is true after the first epoch line.
+	inEpoch bool
+
+	// seenTimeSystem is true after the first "%c" line.
+	seenTimeSystem bool
+}
+
+// parse reads an SP3 file from r.
+func (p *parser) parse(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		p.lineNum++
+		line := scanner.Text()
+		if len(line) < 80 {
+			line += strings.Repeat(" ", 80-len(line))
+		}
+		if p.lineNum == 1 {
+			if err := p.parseFirstLine(line); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var err error
+		switch {
+		case strings.HasPrefix(line, "EOF"):
+			return p.finish()
+		case strings.HasPrefix(line, "##"):
+			p.header.Interval, err = p.parseFloat(line, 24, 38, "epoch interval")
+		case strings.HasPrefix(line, "++"):
+			// Accuracy exponents are not used.
+		case strings.HasPrefix(line, "+"):
+			err = p.parseSatellites(line)
+		case strings.HasPrefix(line, "%c"):
+			if !p.seenTimeSystem {
+				p.header.TimeSystem = strings.TrimSpace(line[9:12])
+				p.seenTimeSystem = true
+			}
+		case strings.HasPrefix(line, "%"):
+			// Other "%f" and "%i" lines are not used.
+		case strings.HasPrefix(line, "/*"):
+			p.header.Comments = append(p.header.Comments,
+				strings.TrimSpace(line[2:]))
+		case line[0] == '*':
+			err = p.parseEpoch(line)
+		case line[0] == 'P':
+			err = p.parsePosition(line)
+		case line[0] == 'V':
+			err = p.parseVelocity(line)
+		case line[0] == 'E':
+			// Correlation records are not used.
+		case strings.TrimSpace(line) == "":
+		default:
+			err = p.lineError("Unrecognized line")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return p.finish()
+}
+
+// finish checks that the file had the required parts.
+func (p *parser) finish() error {
+	if p.lineNum == 0 {
+		return errors.New("Empty SP3 file")
+	}
+	if !p.inEpoch {
+		return errors.New("SP3 file has no epochs")
+	}
+	return nil
+}
+
+// lineError returns an error for the current line.
+func (p *parser) lineError(msg string) error {
+	return fmt.Errorf("line %d: %s", p.lineNum, msg)
+}
+
+// parseInt parses line[start:end] as an integer.
+func (p *parser) parseInt(line string, start, end int, field string) (int, error) {
+	text := strings.TrimSpace(line[start:end])
+	v, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("line %d, columns %d-%d: bad %s %q",
+			p.lineNum, start+1, end, field, text)
+	}
+	return v, nil
+}
+
+// parseFloat parses line[start:end] as a floating-point number.
+func (p *parser) parseFloat(line string, start, end int, field string) (float64, error) {
+	text := strings.TrimSpace(line[start:end])
+	v, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, fmt.Errorf("line %d, columns %d-%d: bad %s %q",
+			p.lineNum, start+1, end, field, text)
+	}
+	return v, nil
+}
+
+// parseTime parses the date and time in columns 4 to 31 of line, as
+// found in the first header line and in epoch lines.
+func (p *parser) parseTime(line string) (time.Time, error) {
+	var fields [5]int
+	for i, name := range []string{"year", "month", "day", "hour", "minute"} {
+		start, end := 5+3*i, 7+3*i
+		if i == 0 {
+			start = 3
+		}
+		v, err := p.parseInt(line, start, end, name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		fields[i] = v
+	}
+	sec, err := p.parseFloat(line, 20, 31, "second")
+	if err != nil {
+		return time.Time{}, err
+	}
+	iSec := math.Floor(sec)
+	nSec := int(math.Round(1e9 * (sec - iSec)))
+	return time.Date(fields[0], time.Month(fields[1]), fields[2],
+		fields[3], fields[4], int(iSec), nSec, time.UTC), nil
+}
+
+// parseFirstLine parses the first header line.
+func (p *parser) parseFirstLine(line string) error {
+	if line[0] != '#' || (line[1] != 'c' && line[1] != 'd') {
+		return errors.New("Not an SP3-c or SP3-d file")
+	}
+	p.header.Version = line[1]
+	switch line[2] {
+	case 'P':
+	case 'V':
+		p.header.Velocities = true
+	default:
+		return p.lineError("Bad position/velocity flag")
+	}
+	var err error
+	if p.header.Start, err = p.parseTime(line); err != nil {
+		return err
+	}
+	if p.header.NumEpochs, err = p.parseInt(line, 32, 39, "number of epochs"); err != nil {
+		return err
+	}
+	p.header.DataUsed = strings.TrimSpace(line[40:45])
+	p.header.CoordinateSystem = strings.TrimSpace(line[46:51])
+	p.header.OrbitType = strings.TrimSpace(line[52:55])
+	p.header.Agency = strings.TrimSpace(line[56:60])
+	return nil
+}
+
+// parseSatellites parses a "+" line that lists satellite IDs.  SP3-c
+// puts the number of satellites in columns 5-6 of the first line, and
+// SP3-d in columns 4-6.
+func (p *parser) parseSatellites(line string) error {
+	if p.header.Satellites == nil {
+		count, err := p.parseInt(line, 3, 6, "number of satellites")
+		if err != nil {
+			return err
+		}
+		if count < 0 {
+			return fmt.Errorf("line %d, columns 4-6: bad number of satellites %d",
+				p.lineNum, count)
+		}
+		p.header.Satellites = make([][3]byte, 0, count)
+	}
+	for i := 9; i+3 <= 60; i += 3 {
+		if len(p.header.Satellites) == cap(p.header.Satellites) {
+			break
+		}
+		id, ok := satelliteID(line[i : i+3])
+		if !ok {
+			break
+		}
+		p.header.Satellites = append(p.header.Satellites, id)
+	}
+	return nil
+}
+
+// satelliteID converts text to a satellite ID.  Old files leave the
+// system blank for GPS satellites.  It returns false for padding.
+func satelliteID(text string) ([3]byte, bool) {
+	id := [3]byte{text[0], text[1], text[2]}
+	if id[0] == ' ' {
+		id[0] = 'G'
+	}
+	if id[1] == ' ' {
+		id[1] = '0'
+	}
+	return id, id[1] != '0' || id[2] != '0'
+}
+
+// parseEpoch parses an epoch line.
+func (p *parser) parseEpoch(line string) error {
+	if !p.seenTimeSystem {
+		p.header.TimeSystem = "GPS"
+		p.seenTimeSystem = true
+	}
+	t, err := p.parseTime(line)
+	if err != nil {
+		return err
+	}
+	if p.epoch, err = toGPS(p.header.TimeSystem, t); err != nil {
+		return p.lineError(err.Error())
+	}
+	p.inEpoch = true
+	return nil
+}
+
+// parseValues parses the satellite ID and the four values of a position
+// or velocity record.
+func (p *parser) parseValues(line string) ([3]byte, [4]float64, error) {
+	var vals [4]float64
+	id, ok := satelliteID(line[1:4])
+	if !p.inEpoch {
+		return id, vals, p.lineError("Record before first epoch")
+	}
+	if !ok {
+		return id, vals, p.lineError("Bad satellite ID")
+	}
+	for i, name := range []string{"x", "y", "z", "clock"} {
+		v, err := p.parseFloat(line, 4+14*i, 18+14*i, string(id[:])+" "+name)
+		if err != nil {
+			return id, vals, err
+		}
+		vals[i] = v
+	}
+	return id, vals, nil
+}
+
+// parsePosition parses a position and clock record.  Records with an
+// unknown position (all zeros) are ignored.
+func (p *parser) parsePosition(line string) error {
+	id, vals, err := p.parseValues(line)
+	if err != nil {
+		return err
+	}
+	if vals[0] == 0 && vals[1] == 0 && vals[2] == 0 {
+		return nil
+	}
+	rec := Record{
+		Time:      p.epoch,
+		Position:  [3]float64{vals[0] * 1e3, vals[1] * 1e3, vals[2] * 1e3},
+		Clock:     math.NaN(),
+		ClockRate: math.NaN(),
+	}
+	if vals[3] < badClock*0.999 {
+		rec.Clock = vals[3] * 1e-6
+	}
+	p.series[id] = append(p.series[id], rec)
+	return nil
+}
+
+// parseVelocity parses a velocity and clock rate record, which belongs
+// to the preceding position record for the same satellite.
+func (p *parser) parseVelocity(line string) error {
+	id, vals, err := p.parseValues(line)
+	if err != nil {
+		return err
+	}
+	recs := p.series[id]
+	if len(recs) == 0 || !recs[len(recs)-1].Time.Equal(p.epoch) {
+		// The position was unknown, so there is nothing to attach to.
+		return nil
+	}
+	rec := &recs[len(recs)-1]
+	rec.HasVelocity = true
+	rec.Velocity = [3]float64{vals[0] * 0.1, vals[1] * 0.1, vals[2] * 0.1}
+	if vals[3] < badClock*0.999 {
+		rec.ClockRate = vals[3] * 1e-10
+	}
+	return nil
+}
+
+// toGPS converts t from the named time system to GPS time.
+func toGPS(system string, t time.Time) (time.Time, error) {
+	scale, err := gnsstime.ParseScale(system)
+	if err != nil {
+		return t, err
+	}
+	return scale.ToGPS(t), nil
+}
@@ -0,0 +1,230 @@
+package sp3
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sp3cExample = `#cP2019  1 10  0  0  0.00000000       2 ORBIT IGS14 HLM  IGS
+## 2035 345600.00000000   900.00000000 58493 0.0000000000000
++    2   G01G02  0  0  0  0  0  0  0  0  0  0  0  0  0  0  0
++          0  0  0  0  0  0  0  0  0  0  0  0  0  0  0  0  0
++          0  0  0  0  0  0  0  0  0  0  0  0  0  0  0  0  0
++          0  0  0  0  0  0  0  0  0  0  0  0  0  0  0  0  0
++          0  0  0  0  0  0  0  0  0  0  0  0  0  0  0  0  0
+++         2  2  0  0  0  0  0  0  0  0  0  0  0  0  0  0  0
+++         0  0  0  0  0  0  0  0  0  0  0  0  0  0  0  0  0
+++         0  0  0  0  0  0  0  0  0  0  0  0  0  0  0  0  0
+++         0  0  0  0  0  0  0  0  0  0  0  0  0  0  0  0  0
+++         0  0  0  0  0  0  0  0  0  0  0  0  0  0  0  0  0
+%c G  cc GPS ccc cccc cccc cccc cccc ccccc ccccc ccccc ccccc
+%c cc cc ccc ccc cccc cccc cccc cccc ccccc ccccc ccccc ccccc
+%f  1.2500000  1.025000000  0.00000000000  0.000000000000000
+%f  0.0000000  0.000000000  0.00000000000  0.000000000000000
+%i    0    0    0    0      0      0      0      0         0
+%i    0    0    0    0      0      0      0      0         0
+/* FINAL ORBIT COMBINATION FROM WEIGHTED AVERAGE OF:
+/* cod emr esa gfz grg jpl mit ngs sio
+*  2019  1 10  0  0  0.00000000
+PG01 -13987.397336  22367.640434   3935.193553    -36.396812
+PG02  -9985.436282 -12476.226720 -21311.178478    -40.128316
+*  2019  1 10  0 15  0.00000000
+PG01 -13617.186862  21969.983806   6587.926005    -36.398271
+PG02      0.000000      0.000000      0.000000 999999.999999
+EOF
+`
+
+func TestHeader(t *testing.T) {
+	o := NewOrbit()
+	if err := o.Read(strings.NewReader(sp3cExample)); err != nil {
+		t.Fatal(err)
+	}
+	h := o.Header
+	if h.Version != 'c' || h.Velocities || h.NumEpochs != 2 ||
+		h.DataUsed != "ORBIT" || h.CoordinateSystem != "IGS14" ||
+		h.OrbitType != "HLM" || h.Agency != "IGS" || h.Interval != 900 ||
+		h.TimeSystem != "GPS" || len(h.Comments) != 2 ||
+		!h.Start.Equal(time.Date(2019, 1, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Bad header %+v", h)
+	}
+	if len(h.Satellites) != 2 || h.Satellites[1] != [3]byte{'G', '0', '2'} {
+		t.Errorf("Bad satellites %q", h.Satellites)
+	}
+
+	g01 := o.Records([3]byte{'G', '0', '1'})
+	if len(g01) != 2 || g01[1].Position[2] != 6587926.005 ||
+		math.Abs(g01[1].Clock+36.398271e-6) > 1e-15 {
+		t.Errorf("Bad G01 records %+v", g01)
+	}
+	// The second G02 record has no position, so it is dropped.
+	if len(o.Records([3]byte{'G', '0', '2'})) != 1 {
+		t.Errorf("Expected one G02 record")
+	}
+}
+
+// circular returns the position (m) and clock (s) of a test satellite
+// in a circular orbit, t seconds after the test epoch.
+func circular(t float64) ([3]float64, float64) {
+	const radius = 26560e3
+	const inc = 55 * math.Pi / 180
+	w := 2 * math.Pi / 43082
+	s, c := math.Sincos(w * t)
+	pos := [3]float64{radius * c, radius * s * math.Cos(inc), radius * s * math.Sin(inc)}
+	return pos, 1e-4 + 1e-11*t
+}
+
+// testEpoch is the start of the test orbits, in GPS time.
+var testEpoch = time.Date(2019, 1, 10, 0, 0, 0, 0, time.UTC)
+
+// makeSP3 formats an SP3-d file with G01 in a circular orbit, starting
+// start seconds after testEpoch, with epochs at a 15-minute interval.
+// label converts GPS time to the file's time system.
+func makeSP3(start, epochs int, system, frame string, label func(time.Time) time.Time) string {
+	var sb strings.Builder
+	first := label(testEpoch.Add(time.Duration(start) * time.Second))
+	fmt.Fprintf(&sb, "#dP%4d %2d %2d %2d %2d %11.8f %7d ORBIT %-5s FIT  TST\n",
+		first.Year(), first.Month(), first.Day(), first.Hour(), first.Minute(),
+		float64(first.Second()), epochs, frame)
+	sb.WriteString("## 2035 345600.00000000   900.00000000 58493 0.0000000000000\n")
+	sb.WriteString("+    1   G01" + strings.Repeat("  0", 16) + "\n")
+	fmt.Fprintf(&sb, "%%c G  cc %s ccc cccc cccc cccc cccc ccccc ccccc ccccc ccccc\n", system)
+	for i := 0; i < epochs; i++ {
+		sec := start + 900*i
+		e := label(testEpoch.Add(time.Duration(sec) * time.Second))
+		fmt.Fprintf(&sb, "*  %4d %2d %2d %2d %2d %11.8f\n", e.Year(), e.Month(),
+			e.Day(), e.Hour(), e.Minute(), float64(e.Second()))
+		pos, clock := circular(float64(sec))
+		fmt.Fprintf(&sb, "PG01%14.6f%14.6f%14.6f%14.6f\n", pos[0]/1e3,
+			pos[1]/1e3, pos[2]/1e3, clock*1e6)
+	}
+	sb.WriteString("EOF\n")
+	return sb.String()
+}
+
+// gpsLabel labels times in GPS time.
+func gpsLabel(t time.Time) time.Time {
+	return t
+}
+
+// utcLabel labels times in UTC, at a time when GPS time was 18 seconds
+// ahead.
+func utcLabel(t time.Time) time.Time {
+	return t.Add(-18 * time.Second)
+}
+
+func TestConcatenate(t *testing.T) {
+	// Two daily files that share the midnight epoch, the second in UTC.
+	o := NewOrbit()
+	if err := o.Read(strings.NewReader(makeSP3(0, 97, "GPS", "IGS14", gpsLabel))); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Read(strings.NewReader(makeSP3(86400, 97, "UTC", "IGS14", utcLabel))); err != nil {
+		t.Fatal(err)
+	}
+	recs := o.Records([3]byte{'G', '0', '1'})
+	if len(recs) != 193 {
+		t.Fatalf("Expected 193 records, got %d", len(recs))
+	}
+	for i, rec := range recs {
+		if !rec.Time.Equal(testEpoch.Add(time.Duration(900*i) * time.Second)) {
+			t.Fatalf("Bad time for record %d: %v", i, rec.Time)
+		}
+	}
+
+	// Files in another frame cannot be mixed in.
+	if err := o.Read(strings.NewReader(makeSP3(2*86400, 97, "GPS", "IGb08", gpsLabel))); err == nil {
+		t.Errorf("Expected an error for a different frame")
+	}
+}
+
+func TestCompute(t *testing.T) {
+	o := NewOrbit()
+	o.Read(strings.NewReader(makeSP3(0, 97, "GPS", "IGS14", gpsLabel)))
+	o.Read(strings.NewReader(makeSP3(86400, 97, "GPS", "IGS14", gpsLabel)))
+	prn := [3]byte{'G', '0', '1'}
+
+	// Check times near the ends, near midnight and between epochs.
+	for _, sec := range []float64{0, 100, 4321.5, 86395, 86400, 86410.25, 172700, 172800} {
+		tm := testEpoch.Add(time.Duration(sec * float64(time.Second)))
+		st, err := o.Compute(prn, tm)
+		if err != nil {
+			t.Fatalf("%v: %v", tm, err)
+		}
+		pos, clock := circular(sec)
+		next, _ := circular(sec + 1e-3)
+		for k := 0; k < 3; k++ {
+			vel := (next[k] - pos[k]) / 1e-3
+			if math.Abs(st.Position[k]-pos[k]) > 0.01 ||
+				math.Abs(st.Velocity[k]-vel) > 1e-3 {
+				t.Errorf("%v: bad state %+v", tm, st)
+				break
+			}
+		}
+		// The orbit is circular, so the relativistic correction is 0.
+		if math.Abs(st.ClockBias-clock) > 1e-11 || math.Abs(st.ClockDrift-1e-11) > 1e-13 {
+			t.Errorf("%v: bad clock %g, %g", tm, st.ClockBias, st.ClockDrift)
+		}
+	}
+
+	// Times outside the data, and gaps in the data, are errors.
+	if _, err := o.Compute(prn, testEpoch.Add(-time.Second)); err != ErrNoData {
+		t.Errorf("Expected ErrNoData before start, got %v", err)
+	}
+	if _, err := o.Compute([3]byte{'G', '0', '2'}, testEpoch); err != ErrNoData {
+		t.Errorf("Expected ErrNoData for G02, got %v", err)
+	}
+	gap := NewOrbit()
+	gap.Read(strings.NewReader(makeSP3(0, 8, "GPS", "IGS14", gpsLabel)))
+	gap.Read(strings.NewReader(makeSP3(86400, 8, "GPS", "IGS14", gpsLabel)))
+	if _, err := gap.Compute(prn, testEpoch.Add(time.Hour)); err != ErrGap {
+		t.Errorf("Expected ErrGap, got %v", err)
+	}
+}
+
+func TestTimeSystems(t *testing.T) {
+	utc := time.Date(2019, 1, 10, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		system string
+		offset time.Duration
+	}{
+		{"GPS", 0},
+		{"GAL", 0},
+		{"BDT", 14 * time.Second},
+		{"TAI", -19 * time.Second},
+		{"UTC", 18 * time.Second},
+		{"GLO", 18 * time.Second},
+	}
+	for _, test := range tests {
+		gps, err := toGPS(test.system, utc)
+		if err != nil || gps.Sub(utc) != test.offset {
+			t.Errorf("%s: expected offset %v, got %v (%v)", test.system,
+				test.offset, gps.Sub(utc), err)
+		}
+	}
+	if _, err := toGPS("XYZ", utc); err == nil {
+		t.Errorf("Expected an error for an unknown time system")
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"#aP2019  1 10  0  0  0.00000000       2 ORBIT IGS14 HLM  IGS\n",
+		strings.Replace(sp3cExample, "-13617.186862", "-1361x.186862", 1),
+		strings.Replace(sp3cExample, "*  2019  1 10  0 15", "*  2019  x 10  0 15", 1),
+		strings.Replace(sp3cExample, "GPS ccc", "XYZ ccc", 1),
+		strings.Replace(sp3cExample, "+    2   G01", "+   -2   G01", 1),
+		sp3cExample[:strings.Index(sp3cExample, "*  2019")],
+	}
+	for _, text := range tests {
+		o := NewOrbit()
+		if err := o.Read(strings.NewReader(text)); err == nil {
+			t.Errorf("Expected an error for %.60q", text)
+		} else if len(o.Satellites()) != 0 {
+			t.Errorf("Failed read changed the orbit")
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package ubx
+
+import (
+	"math"
+	"time"
+
+	"github.com/entrope/gnss/gnsstime"
+	"github.com/entrope/gnss/rinex"
+)
+
+// lnavState holds the LNAV subframes 1 to 3 from one satellite.
+type lnavState struct {
+	// subframes holds the 24 data bits of each word of subframes 1, 2
+	// and 3, packed into bytes.
+	subframes [3][30]byte
+
+	// have has bit i set if subframes[i] has been received.
+	have byte
+
+	// iodc and toe identify the last ephemeris that was reported, if
+	// reported is true.
+	iodc     uint64
+	toe      uint64
+	reported bool
+}
+
+// uraMeters maps from a GPS URA index to the accuracy (m), as in
+// IS-GPS-200 section 20.3.3.3.1.3.
+var uraMeters = [16]float64{
+	2.4, 3.4, 4.85, 6.85, 9.65, 13.65, 24, 48,
+	96, 192, 384, 768, 1536, 3072, 6144, 6144,
+}
+
+// getBits returns the n-bit unsigned field starting at bit pos of buf.
+func getBits(buf []byte, pos, n int) uint64 {
+	var v uint64
+	for i := pos; i < pos+n; i++ {
+		v = v<<1 | uint64(buf[i/8]>>(7-i%8))&1
+	}
+	return v
+}
+
+// getSigned returns the n-bit two's complement field starting at bit
+// pos of buf, scaled by scale.
+func getSigned(buf []byte, pos, n int, scale float64) float64 {
+	v := int64(getBits(buf, pos, n)<<(64-n)) >> (64 - n)
+	return float64(v) * scale
+}
+
+// fitInterval returns the curve fit interval (hours) for a GPS fit
+// interval flag and IODC, following IS-GPS-200 table 20-XII.
+func fitInterval(flag, iodc uint64) float64 {
+	switch {
+	case flag == 0:
+		return 4
+	case iodc >= 240 && iodc <= 247:
+		return 8
+	case iodc >= 248 && iodc <= 255, iodc == 496:
+		return 14
+	case iodc >= 497 && iodc <= 503, iodc >= 1021 && iodc <= 1023:
+		return 26
+	case iodc >= 504 && iodc <= 510:
+		return 50
+	case iodc == 511, iodc >= 752 && iodc <= 756:
+		return 74
+	case iodc >= 757 && iodc <= 763:
+		return 98
+	}
+	return 6
+}
+
+// addLNAV records a GPS or QZSS LNAV subframe, and reports the
+// ephemeris when subframes 1 to 3 agree on a new issue of data.
+func (d *Decoder) addLNAV(sf Subframe) error {
+	var data [30]byte
+	for i, w := range sf.Words {
+		w >>= 6
+		data[3*i] = byte(w >> 16)
+		data[3*i+1] = byte(w >> 8)
+		data[3*i+2] = byte(w)
+	}
+	id := getBits(data[:], 43, 3)
+	if id < 1 || id > 3 {
+		return nil
+	}
+	st := d.lnav[sf.PRN]
+	if st == nil {
+		st = &lnavState{}
+		d.lnav[sf.PRN] = st
+	}
+	st.subframes[id-1] = data
+	st.have |= 1 << (id - 1)
+	if st.have != 7 {
+		return nil
+	}
+
+	sf1, sf2, sf3 := st.subframes[0][:], st.subframes[1][:], st.subframes[2][:]
+	iodc := getBits(sf1, 70, 2)<<8 | getBits(sf1, 168, 8)
+	iode := getBits(sf2, 48, 8)
+	toe := getBits(sf2, 216, 16)
+	if iode != getBits(sf3, 216, 8) || iode != iodc&0xFF {
+		return nil
+	}
+	if st.reported && st.iodc == iodc && st.toe == toe {
+		return nil
+	}
+	st.iodc, st.toe, st.reported = iodc, toe, true
+	if d.EphemerisFunc == nil {
+		return nil
+	}
+	return d.EphemerisFunc(d.decodeLNAV(sf.PRN, sf1, sf2, sf3))
+}
+
+// decodeLNAV converts LNAV subframes 1 to 3 to an ephemeris.
+func (d *Decoder) decodeLNAV(prn [3]byte, sf1, sf2, sf3 []byte) *rinex.GPSEphemeris {
+	// Resolve the 10-bit week number against the receiver's week.
+	ref := time.Now()
+	if d.week >= 0 {
+		ref = gnsstime.GPS.FromWeek(d.week, 0)
+	}
+	week := gnsstime.GPS.ResolveWeek(int(getBits(sf1, 48, 10)), 10, ref)
+
+	// The HOW time count is for the start of the next subframe.
+	tow := float64(getBits(sf1, 24, 17))*6 - 6
+	toe := float64(getBits(sf2, 216, 16)) * 16
+	toc := float64(getBits(sf1, 176, 16)) * 16
+	toeWeek, tocWeek := week, week
+	if toe-tow > 302400 {
+		toeWeek--
+	} else if toe-tow < -302400 {
+		toeWeek++
+	}
+	if toc-tow > 302400 {
+		tocWeek--
+	} else if toc-tow < -302400 {
+		tocWeek++
+	}
+
+	iodc := getBits(sf1, 70, 2)<<8 | getBits(sf1, 168, 8)
+	eph := &rinex.GPSEphemeris{
+		KeplerEphemeris: rinex.KeplerEphemeris{
+			PRN: prn,
+			TOC: gnsstime.GPS.FromWeek(tocWeek,
+				time.Duration(toc)*time.Second),
+			ClockBias:      getSigned(sf1, 216, 22, 0x1p-31),
+			ClockDrift:     getSigned(sf1, 200, 16, 0x1p-43),
+			ClockDriftRate: getSigned(sf1, 192, 8, 0x1p-55),
+			Crs:            getSigned(sf2, 56, 16, 0x1p-5),
+			DeltaN:         getSigned(sf2, 72, 16, 0x1p-43*math.Pi),
+			M0:             getSigned(sf2, 88, 32, 0x1p-31*math.Pi),
+			Cuc:            getSigned(sf2, 120, 16, 0x1p-29),
+			Ecc:            float64(getBits(sf2, 136, 32)) * 0x1p-33,
+			Cus:            getSigned(sf2, 168, 16, 0x1p-29),
+			SqrtA:          float64(getBits(sf2, 184, 32)) * 0x1p-19,
+			Toe:            toe,
+			Cic:            getSigned(sf3, 48, 16, 0x1p-29),
+			Omega0:         getSigned(sf3, 64, 32, 0x1p-31*math.Pi),
+			Cis:            getSigned(sf3, 96, 16, 0x1p-29),
+			I0:             getSigned(sf3, 112, 32, 0x1p-31*math.Pi),
+			Crc:            getSigned(sf3, 144, 16, 0x1p-5),
+			Omega:          getSigned(sf3, 160, 32, 0x1p-31*math.Pi),
+			OmegaDot:       getSigned(sf3, 192, 24, 0x1p-43*math.Pi),
+			IDot:           getSigned(sf3, 224, 14, 0x1p-43*math.Pi),
+			Week:           toeWeek,
+			Health:         int(getBits(sf1, 64, 6)),
+			TransmitTime:   tow,
+		},
+		IODE:        int(getBits(sf2, 48, 8)),
+		IODC:        int(iodc),
+		CodesOnL2:   int(getBits(sf1, 58, 2)),
+		L2PDataFlag: int(getBits(sf1, 72, 1)),
+		Accuracy:    uraMeters[getBits(sf1, 60, 4)],
+		TGD:         getSigned(sf1, 160, 8, 0x1p-31),
+	}
+	fit := getBits(sf2, 232, 1)
+	if prn[0] == 'J' {
+		eph.FitInterval = float64(fit)
+	} else {
+		eph.FitInterval = fitInterval(fit, iodc)
+	}
+	return eph
+}
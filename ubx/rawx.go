@@ -0,0 +1,181 @@
+package ubx
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/entrope/gnss/gnsstime"
+	"github.com/entrope/gnss/rinex"
+)
+
+// Bits of the RAWX receiver status field.
+const (
+	recStatLeapSec = 1 << 0
+)
+
+// Bits of the RAWX tracking status field.
+const (
+	trkStatPrValid = 1 << 0
+	trkStatCpValid = 1 << 1
+	trkStatHalfCyc = 1 << 2
+)
+
+// ubxSignals maps from a GNSS and u-blox signal identifier to the RINEX
+// band and attribute, from the u-blox F9 interface description.
+// Receivers before generation 9 report signal identifier 0, which is
+// the L1 or E1 or B1I signal.
+var ubxSignals = map[byte]map[byte]string{
+	'G': {0: "1C", 3: "2L", 4: "2S", 6: "5I", 7: "5Q"},
+	'S': {0: "1C"},
+	'E': {0: "1C", 1: "1B", 3: "5I", 4: "5Q", 5: "7I", 6: "7Q", 8: "6B",
+		9: "6C"},
+	'C': {0: "2I", 1: "2I", 2: "7I", 3: "7I", 5: "1P", 6: "1D", 7: "5P",
+		8: "5D"},
+	'J': {0: "1C", 1: "1Z", 4: "2S", 5: "2L", 8: "5I", 9: "5Q"},
+	'R': {0: "1C", 2: "2C"},
+	'I': {0: "5A"},
+}
+
+// lockKey identifies one signal from one satellite.
+type lockKey struct {
+	prn  [3]byte
+	code [2]byte
+}
+
+// cellObs is one observation for a satellite in the current epoch.
+type cellObs struct {
+	obsType [3]byte
+	obs     rinex.Observation
+}
+
+// signalStrength maps a C/N0 (dB-Hz) to a RINEX signal strength
+// indicator.
+func signalStrength(cno byte) byte {
+	ssi := cno / 6
+	if ssi < 1 {
+		ssi = 1
+	} else if ssi > 9 {
+		ssi = 9
+	}
+	return ssi
+}
+
+// decodeRAWX decodes a UBX-RXM-RAWX message.
+func (d *Decoder) decodeRAWX(payload []byte) error {
+	if len(payload) < 16 {
+		return nil
+	}
+	rcvTow := math.Float64frombits(binary.LittleEndian.Uint64(payload[0:]))
+	week := int(binary.LittleEndian.Uint16(payload[8:]))
+	leapS := int(int8(payload[10]))
+	numMeas := int(payload[11])
+	recStat := payload[12]
+	if len(payload) < 16+32*numMeas {
+		return nil
+	}
+
+	d.week = week
+	if recStat&recStatLeapSec != 0 {
+		d.Header.LeapSeconds = leapS
+	}
+	t := gnsstime.GPS.FromWeek(week, time.Duration(math.Round(rcvTow*1e9)))
+
+	sats := make(map[[3]byte][]cellObs)
+	for i := 0; i < numMeas; i++ {
+		m := payload[16+32*i : 48+32*i]
+		prn, ok := satID(m[20], m[21])
+		if !ok {
+			continue
+		}
+		code, ok := ubxSignals[prn[0]][m[22]]
+		if !ok {
+			continue
+		}
+		if prn[0] == 'R' && m[23] <= 13 {
+			d.Header.GLONASSSlots[prn] = int(m[23]) - 7
+		}
+		pr := math.Float64frombits(binary.LittleEndian.Uint64(m[0:]))
+		cp := math.Float64frombits(binary.LittleEndian.Uint64(m[8:]))
+		dop := float64(math.Float32frombits(binary.LittleEndian.Uint32(m[16:])))
+		lockTime := binary.LittleEndian.Uint16(m[24:])
+		cno := m[26]
+		trkStat := m[30]
+
+		key := lockKey{prn: prn, code: [2]byte{code[0], code[1]}}
+		var lli byte
+		if prev, ok := d.locks[key]; ok && lockTime < prev {
+			lli |= 1
+		}
+		if trkStat&trkStatHalfCyc == 0 {
+			lli |= 2
+		}
+		d.locks[key] = lockTime
+		var ssi byte
+		if cno > 0 {
+			ssi = signalStrength(cno)
+		}
+
+		obs := sats[prn]
+		if trkStat&trkStatPrValid != 0 {
+			obs = d.addObs(obs, prn[0], 'C', code, pr, 0, ssi)
+		}
+		if trkStat&trkStatCpValid != 0 {
+			obs = d.addObs(obs, prn[0], 'L', code, cp, lli, ssi)
+		}
+		obs = d.addObs(obs, prn[0], 'D', code, dop, 0, 0)
+		if cno > 0 {
+			obs = d.addObs(obs, prn[0], 'S', code, float64(cno), 0, 0)
+		}
+		sats[prn] = obs
+	}
+
+	var rec rinex.ObservationRecord
+	rec.SetTime(t)
+	for prn, cells := range sats {
+		sv := rinex.SVObservation{
+			PRN: prn,
+			Obs: make([]rinex.Observation, len(d.Observations[prn[0]])),
+		}
+		for _, c := range cells {
+			sv.Obs[d.obsIndex[prn[0]][c.obsType]] = c.obs
+		}
+		rec.Sat = append(rec.Sat, sv)
+	}
+	sort.Slice(rec.Sat, func(i, j int) bool {
+		return string(rec.Sat[i].PRN[:]) < string(rec.Sat[j].PRN[:])
+	})
+
+	if d.Header.FirstObs.IsZero() {
+		d.Header.FirstObs = t
+	}
+	d.Header.LastObs = t
+	if d.ObsFunc != nil {
+		return d.ObsFunc(rec)
+	}
+	return nil
+}
+
+// addObs appends an observation to obs, and registers its type for sys.
+func (d *Decoder) addObs(obs []cellObs, sys, kind byte, code string, value float64, lli, ssi byte) []cellObs {
+	obsType := [3]byte{kind, code[0], code[1]}
+	index := d.obsIndex[sys]
+	if index == nil {
+		index = make(map[[3]byte]int)
+		d.obsIndex[sys] = index
+	}
+	if _, ok := index[obsType]; !ok {
+		index[obsType] = len(d.Observations[sys])
+		d.Observations[sys] = append(d.Observations[sys], obsType)
+	}
+	return append(obs, cellObs{
+		obsType: obsType,
+		obs: rinex.Observation{
+			Value:          math.Round(value*1000) / 1000,
+			Present:        true,
+			LLI:            lli,
+			SignalStrength: ssi,
+		},
+	})
+}
@@ -0,0 +1,271 @@
+// Package ubx decodes u-blox UBX binary streams.
+//
+// It checks the framing and checksum of each message, and decodes
+// UBX-RXM-RAWX raw measurements into the same observation records that
+// rinex.ObsReader produces, and UBX-RXM-SFRBX navigation subframes.
+// GPS and QZSS LNAV subframes are also assembled into broadcast
+// ephemerides.  Other messages are skipped.
+package ubx
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/entrope/gnss/rinex"
+)
+
+// Sync characters that start each UBX frame.
+const (
+	sync1 = 0xB5
+	sync2 = 0x62
+)
+
+// Message classes and IDs that Decoder handles.
+const (
+	classRXM = 0x02
+	idRAWX   = 0x15
+	idSFRBX  = 0x13
+)
+
+// Subframe is one navigation message subframe (or string, or page)
+// from a UBX-RXM-SFRBX message.
+type Subframe struct {
+	// PRN identifies the satellite, as in rinex.SVObservation.PRN.
+	PRN [3]byte
+
+	// SignalID is the u-blox signal identifier, which distinguishes
+	// (for example) Galileo E1 I/NAV from E5b I/NAV pages.  Older
+	// receivers always report 0.
+	SignalID byte
+
+	// FrequencyChannel is the GLONASS frequency channel (-7 to +6).
+	// It is 0 for other GNSSes.
+	FrequencyChannel int
+
+	// Words holds the data words as the receiver reported them.  For
+	// GPS and QZSS LNAV, each word holds the 30 bits of one subframe
+	// word, with the parity in the low six bits; see the u-blox
+	// interface description for other signals.
+	Words []uint32
+}
+
+// Decoder decodes UBX streams.
+type Decoder struct {
+	// ObsFunc is a function that is called for each UBX-RXM-RAWX
+	// message.  If it returns non-nil, decoding stops.
+	ObsFunc func(rec rinex.ObservationRecord) error
+
+	// SubframeFunc, if not nil, is called for each UBX-RXM-SFRBX
+	// message.  If it returns non-nil, decoding stops.
+	SubframeFunc func(sf Subframe) error
+
+	// EphemerisFunc, if not nil, is called with a *rinex.GPSEphemeris
+	// each time a GPS or QZSS satellite broadcasts a complete LNAV
+	// ephemeris with a new issue of data.  If it returns non-nil,
+	// decoding stops.
+	EphemerisFunc func(eph rinex.Ephemeris) error
+
+	// Header holds the observation header values that the stream
+	// implies: the time system, signal strength unit, first and last
+	// observation times, GLONASS frequency channels, and leap seconds
+	// (once the receiver reports them as valid).
+	Header rinex.ObsHeader
+
+	// Observations lists the types of observations for each GNSS, as
+	// in rinex.ObsReader.  The decoder adds types as it sees new
+	// signals, so an observation record may have fewer observations
+	// for a satellite than its GNSS has types; the missing ones are
+	// not present.
+	Observations map[byte][][3]byte
+
+	// obsIndex maps from a GNSS and observation type to its index in
+	// Observations.
+	obsIndex map[byte]map[[3]byte]int
+
+	// locks holds the lock time (ms) of each signal in the previous
+	// epoch, to detect loss of lock.
+	locks map[lockKey]uint16
+
+	// week is the GPS week of the latest RAWX message, or -1.
+	week int
+
+	// lnav holds the LNAV subframes 1 to 3 for each satellite.
+	lnav map[[3]byte]*lnavState
+
+	// payload holds the current message.
+	payload []byte
+}
+
+/************************ TOP LEVEL FUNCTIONS ************************/
+
+// Parse reads UBX messages from r and runs the callback functions in
+// d.  It skips bytes that are not part of a frame with a valid
+// checksum, such as NMEA sentences that are mixed into the stream.
+func (d *Decoder) Parse(r io.Reader) error {
+	d.Observations = make(map[byte][][3]byte)
+	d.obsIndex = make(map[byte]map[[3]byte]int)
+	d.locks = make(map[lockKey]uint16)
+	d.lnav = make(map[[3]byte]*lnavState)
+	d.week = -1
+	d.Header.TimeSystem = "GPS"
+	d.Header.SignalStrengthUnit = "DBHZ"
+	if d.Header.GLONASSSlots == nil {
+		d.Header.GLONASSSlots = make(map[[3]byte]int)
+	}
+
+	br := bufio.NewReaderSize(r, 65536+8)
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if b != sync1 {
+			continue
+		}
+
+		head, err := br.Peek(5)
+		if err == io.EOF {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if head[0] != sync2 {
+			continue
+		}
+		n := int(binary.LittleEndian.Uint16(head[3:]))
+		frame, err := br.Peek(5 + n + 2)
+		if err == io.EOF {
+			continue
+		} else if err != nil {
+			return err
+		}
+		ckA, ckB := checksum(frame[1 : 5+n])
+		if ckA != frame[5+n] || ckB != frame[6+n] {
+			continue
+		}
+
+		class, id := frame[1], frame[2]
+		d.payload = append(d.payload[:0], frame[5:5+n]...)
+		br.Discard(5 + n + 2)
+		if err := d.handleMessage(class, id, d.payload); err != nil {
+			return err
+		}
+	}
+}
+
+// Detect returns true if head holds a complete UBX frame with a valid
+// checksum.
+func Detect(head []byte) bool {
+	for i := 0; i+8 <= len(head); i++ {
+		if head[i] != sync1 || head[i+1] != sync2 {
+			continue
+		}
+		n := int(binary.LittleEndian.Uint16(head[i+4:]))
+		if i+8+n > len(head) {
+			continue
+		}
+		ckA, ckB := checksum(head[i+2 : i+6+n])
+		if ckA == head[i+6+n] && ckB == head[i+7+n] {
+			return true
+		}
+	}
+	return false
+}
+
+// checksum calculates the 8-bit Fletcher checksum of data.
+func checksum(data []byte) (a, b byte) {
+	for _, c := range data {
+		a += c
+		b += a
+	}
+	return a, b
+}
+
+// handleMessage decodes one message payload.
+func (d *Decoder) handleMessage(class, id byte, payload []byte) error {
+	if class != classRXM {
+		return nil
+	}
+	switch id {
+	case idRAWX:
+		return d.decodeRAWX(payload)
+	case idSFRBX:
+		return d.decodeSFRBX(payload)
+	}
+	return nil
+}
+
+/************************** HELPER FUNCTIONS **************************/
+
+// gnssSystems maps from a u-blox GNSS identifier to the RINEX system
+// character.
+var gnssSystems = map[byte]byte{
+	0: 'G',
+	1: 'S',
+	2: 'E',
+	3: 'C',
+	5: 'J',
+	6: 'R',
+	7: 'I',
+}
+
+// satID returns the RINEX satellite ID for a u-blox GNSS and satellite
+// identifier, and false if the GNSS is not known.
+func satID(gnssID, svID byte) ([3]byte, bool) {
+	sys, ok := gnssSystems[gnssID]
+	if !ok {
+		return [3]byte{}, false
+	}
+	n := int(svID)
+	switch sys {
+	case 'S':
+		n -= 100
+	case 'J':
+		if n >= 193 {
+			n -= 192
+		}
+	}
+	if n < 1 || n > 99 {
+		return [3]byte{}, false
+	}
+	return [3]byte{sys, byte('0' + n/10), byte('0' + n%10)}, true
+}
+
+// decodeSFRBX decodes a UBX-RXM-SFRBX message.
+func (d *Decoder) decodeSFRBX(payload []byte) error {
+	if len(payload) < 8 {
+		return nil
+	}
+	numWords := int(payload[4])
+	if len(payload) < 8+4*numWords {
+		return nil
+	}
+	prn, ok := satID(payload[0], payload[1])
+	if !ok {
+		return nil
+	}
+	sf := Subframe{
+		PRN:      prn,
+		SignalID: payload[2],
+		Words:    make([]uint32, numWords),
+	}
+	if prn[0] == 'R' {
+		sf.FrequencyChannel = int(payload[3]) - 7
+	}
+	for i := range sf.Words {
+		sf.Words[i] = binary.LittleEndian.Uint32(payload[8+4*i:])
+	}
+
+	if d.SubframeFunc != nil {
+		if err := d.SubframeFunc(sf); err != nil {
+			return err
+		}
+	}
+	if (prn[0] == 'G' || prn[0] == 'J') && sf.SignalID == 0 && numWords == 10 {
+		return d.addLNAV(sf)
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package ubx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/entrope/gnss/rinex"
+)
+
+// frame wraps payload in a UBX frame.
+func frame(class, id byte, payload []byte) []byte {
+	out := []byte{sync1, sync2, class, id, byte(len(payload)), byte(len(payload) >> 8)}
+	out = append(out, payload...)
+	a, b := checksum(out[2:])
+	return append(out, a, b)
+}
+
+type rawxMeas struct {
+	pr, cp              float64
+	do                  float32
+	gnss, sv, sig, freq byte
+	lock                uint16
+	cno, trkStat        byte
+}
+
+func encodeRAWX(tow float64, week uint16, meas []rawxMeas) []byte {
+	buf := make([]byte, 16+32*len(meas))
+	binary.LittleEndian.PutUint64(buf[0:], math.Float64bits(tow))
+	binary.LittleEndian.PutUint16(buf[8:], week)
+	buf[10] = 18
+	buf[11] = byte(len(meas))
+	buf[12] = recStatLeapSec
+	buf[13] = 1
+	for i, m := range meas {
+		p := buf[16+32*i:]
+		binary.LittleEndian.PutUint64(p[0:], math.Float64bits(m.pr))
+		binary.LittleEndian.PutUint64(p[8:], math.Float64bits(m.cp))
+		binary.LittleEndian.PutUint32(p[16:], math.Float32bits(m.do))
+		p[20], p[21], p[22], p[23] = m.gnss, m.sv, m.sig, m.freq
+		binary.LittleEndian.PutUint16(p[24:], m.lock)
+		p[26] = m.cno
+		p[30] = m.trkStat
+	}
+	return frame(classRXM, idRAWX, buf)
+}
+
+func TestRAWX(t *testing.T) {
+	meas := []rawxMeas{
+		{pr: 21234567.8901, cp: 111589012.3456, do: -1234.5, gnss: 0, sv: 12,
+			sig: 0, lock: 5000, cno: 45, trkStat: 0x7},
+		{pr: 23456789.0123, cp: 91234567.891, do: 321.25, gnss: 0, sv: 12,
+			sig: 3, lock: 3000, cno: 38, trkStat: 0x3},
+		{pr: 20123456.789, cp: 107654321.123, do: 100, gnss: 6, sv: 3,
+			sig: 0, freq: 5, lock: 100, cno: 50, trkStat: 0x7},
+		{pr: 24000000, gnss: 2, sv: 11, sig: 0, cno: 0, trkStat: 0x1},
+		{pr: 22000000, gnss: 4, sv: 1, sig: 0, cno: 30, trkStat: 0x1},
+	}
+	input := []byte("$GPGGA,junk*00\r\n")
+	input = append(input, encodeRAWX(345600.0000001, 2296, meas)...)
+	bad := encodeRAWX(345600.5, 2296, meas)
+	bad[len(bad)-1]++
+	input = append(input, bad...)
+	meas[0].lock = 20
+	input = append(input, encodeRAWX(345601, 2296, meas)...)
+
+	var d Decoder
+	var recs []rinex.ObservationRecord
+	d.ObsFunc = func(rec rinex.ObservationRecord) error {
+		recs = append(recs, rec)
+		return nil
+	}
+	if err := d.Parse(bytes.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("got %d records, want 2", len(recs))
+	}
+	want := time.Date(2024, 1, 11, 0, 0, 0, 100, time.UTC)
+	if tm := d.Header.FirstObs; !tm.Equal(want) {
+		t.Errorf("FirstObs = %v, want %v", tm, want)
+	}
+	if d.Header.LeapSeconds != 18 || d.Header.GLONASSSlots[[3]byte{'R', '0', '3'}] != -2 {
+		t.Errorf("LeapSeconds = %d, GLONASSSlots = %v", d.Header.LeapSeconds,
+			d.Header.GLONASSSlots)
+	}
+
+	wantTypes := map[byte]string{
+		'G': "C1C L1C D1C S1C C2L L2L D2L S2L ",
+		'R': "C1C L1C D1C S1C ",
+		'E': "C1C D1C ",
+	}
+	for sys, want := range wantTypes {
+		got := ""
+		for _, obsType := range d.Observations[sys] {
+			got += string(obsType[:]) + " "
+		}
+		if got != want {
+			t.Errorf("Observations[%c] = %q, want %q", sys, got, want)
+		}
+	}
+
+	sats := recs[0].Sat
+	if len(sats) != 3 || string(sats[0].PRN[:]) != "E11" ||
+		string(sats[1].PRN[:]) != "G12" || string(sats[2].PRN[:]) != "R03" {
+		t.Fatalf("got satellites %v", sats)
+	}
+	g12 := sats[1].Obs
+	wantObs := []rinex.Observation{
+		{Value: 21234567.89, Present: true, SignalStrength: 7},
+		{Value: 111589012.346, Present: true, SignalStrength: 7},
+		{Value: -1234.5, Present: true},
+		{Value: 45, Present: true},
+		{Value: 23456789.012, Present: true, SignalStrength: 6},
+		{Value: 91234567.891, Present: true, LLI: 2, SignalStrength: 6},
+		{Value: 321.25, Present: true},
+		{Value: 38, Present: true},
+	}
+	for i := range wantObs {
+		if g12[i] != wantObs[i] {
+			t.Errorf("G12 obs %d = %+v, want %+v", i, g12[i], wantObs[i])
+		}
+	}
+
+	if lli := recs[1].Sat[1].Obs[1].LLI; lli != 1 {
+		t.Errorf("G12 L1C LLI after lock loss = %d, want 1", lli)
+	}
+	if lli := recs[1].Sat[2].Obs[1].LLI; lli != 0 {
+		t.Errorf("R03 L1C LLI = %d, want 0", lli)
+	}
+}
+
+// lnavWriter builds LNAV subframes for tests.
+type lnavWriter [30]byte
+
+func (w *lnavWriter) put(pos, n int, v int64) {
+	for i := 0; i < n; i++ {
+		if v>>(n-1-i)&1 == 1 {
+			w[(pos+i)/8] |= 0x80 >> ((pos + i) % 8)
+		}
+	}
+}
+
+// sfrbx wraps an LNAV subframe in a UBX-RXM-SFRBX frame.
+func (w *lnavWriter) sfrbx(sv byte) []byte {
+	buf := make([]byte, 8+40)
+	buf[0], buf[1], buf[4] = 0, sv, 10
+	for i := 0; i < 10; i++ {
+		word := uint32(w[3*i])<<16 | uint32(w[3*i+1])<<8 | uint32(w[3*i+2])
+		binary.LittleEndian.PutUint32(buf[8+4*i:], word<<6|0x15)
+	}
+	return frame(classRXM, idSFRBX, buf)
+}
+
+// scaled multiplies v by scale at run time, as the decoder does.
+func scaled(v, scale float64) float64 {
+	return v * scale
+}
+
+func TestLNAV(t *testing.T) {
+	const tow = 345600 / 6
+	var sf1, sf2, sf3 lnavWriter
+	sf1.put(24, 17, tow+1)
+	sf1.put(43, 3, 1)
+	sf1.put(48, 10, 2296%1024)
+	sf1.put(58, 2, 1)
+	sf1.put(60, 4, 2)
+	sf1.put(70, 2, 0)
+	sf1.put(160, 8, -17)
+	sf1.put(168, 8, 77)
+	sf1.put(176, 16, 345600/16)
+	sf1.put(192, 8, 0)
+	sf1.put(200, 16, -100)
+	sf1.put(216, 22, 123456)
+
+	sf2.put(24, 17, tow+2)
+	sf2.put(43, 3, 2)
+	sf2.put(48, 8, 77)
+	sf2.put(56, 16, -2000)
+	sf2.put(72, 16, 12000)
+	sf2.put(88, 32, 100000000)
+	sf2.put(120, 16, -1500)
+	sf2.put(136, 32, 80000000)
+	sf2.put(168, 16, 4000)
+	sf2.put(184, 32, 2702000000)
+	sf2.put(216, 16, 345600/16)
+
+	sf3.put(24, 17, tow+3)
+	sf3.put(43, 3, 3)
+	sf3.put(48, 16, 10)
+	sf3.put(64, 32, -500000000)
+	sf3.put(96, 16, -10)
+	sf3.put(112, 32, 650000000)
+	sf3.put(144, 16, 7000)
+	sf3.put(160, 32, 300000000)
+	sf3.put(192, 24, -8000)
+	sf3.put(216, 8, 77)
+	sf3.put(224, 14, -50)
+
+	// A RAWX message gives the week number.
+	input := encodeRAWX(345590, 2296, nil)
+	input = append(input, sf1.sfrbx(5)...)
+	input = append(input, sf2.sfrbx(5)...)
+	input = append(input, sf3.sfrbx(5)...)
+	// Repeats of the same ephemeris are not reported again.
+	input = append(input, sf1.sfrbx(5)...)
+
+	var d Decoder
+	var ephs []rinex.Ephemeris
+	nSubframes := 0
+	d.SubframeFunc = func(sf Subframe) error {
+		nSubframes++
+		return nil
+	}
+	d.EphemerisFunc = func(eph rinex.Ephemeris) error {
+		ephs = append(ephs, eph)
+		return nil
+	}
+	if err := d.Parse(bytes.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if nSubframes != 4 || len(ephs) != 1 {
+		t.Fatalf("got %d subframes, %d ephemerides", nSubframes, len(ephs))
+	}
+	eph, ok := ephs[0].(*rinex.GPSEphemeris)
+	if !ok {
+		t.Fatalf("got %T", ephs[0])
+	}
+	want := rinex.GPSEphemeris{
+		KeplerEphemeris: rinex.KeplerEphemeris{
+			PRN:          [3]byte{'G', '0', '5'},
+			TOC:          time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC),
+			ClockBias:    123456 * 0x1p-31,
+			ClockDrift:   -100 * 0x1p-43,
+			Crs:          -2000 * 0x1p-5,
+			DeltaN:       scaled(12000, 0x1p-43*math.Pi),
+			M0:           scaled(100000000, 0x1p-31*math.Pi),
+			Cuc:          -1500 * 0x1p-29,
+			Ecc:          80000000 * 0x1p-33,
+			Cus:          4000 * 0x1p-29,
+			SqrtA:        2702000000 * 0x1p-19,
+			Toe:          345600,
+			Cic:          10 * 0x1p-29,
+			Omega0:       scaled(-500000000, 0x1p-31*math.Pi),
+			Cis:          -10 * 0x1p-29,
+			I0:           scaled(650000000, 0x1p-31*math.Pi),
+			Crc:          7000 * 0x1p-5,
+			Omega:        scaled(300000000, 0x1p-31*math.Pi),
+			OmegaDot:     scaled(-8000, 0x1p-43*math.Pi),
+			IDot:         scaled(-50, 0x1p-43*math.Pi),
+			Week:         2296,
+			TransmitTime: 345600,
+		},
+		IODE:        77,
+		IODC:        77,
+		CodesOnL2:   1,
+		Accuracy:    4.85,
+		TGD:         -17 * 0x1p-31,
+		FitInterval: 4,
+	}
+	if *eph != want {
+		t.Errorf("got %+v\nwant %+v", *eph, want)
+	}
+}